@@ -0,0 +1,183 @@
+// Command pushcapture is a local stand-in for a browser's push service. It
+// plays the browser too: it generates subscription keys, prints the
+// PushSubscription JSON to register with the API, and for every push it
+// receives checks the VAPID token, decrypts the payload and logs it.
+//
+//	go run ./cmd/pushcapture -addr :8090 -dir ./pushbox
+//
+// POST the printed JSON to /forum/api/user/push/subscribe and set
+// PUSH_ALLOW_PRIVATE=true in .env so the API may reach localhost. -fail N
+// answers the first N pushes with 500 to exercise retries; -gone answers
+// every push with 410 to exercise subscription expiry.
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"forum/models"
+	"forum/push"
+)
+
+var b64 = base64.RawURLEncoding
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	host := flag.String("host", "localhost", "host name to put in the endpoint URL")
+	dir := flag.String("dir", "./pushbox", "directory to write decrypted pushes to")
+	fail := flag.Int("fail", 0, "answer this many pushes with 500 first")
+	gone := flag.Bool("gone", false, "answer every push with 410 Gone")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatalf("create pushbox: %v", err)
+	}
+	uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("generate keys: %v", err)
+	}
+	authSecret := make([]byte, 16)
+	if _, err := rand.Read(authSecret); err != nil {
+		log.Fatalf("generate auth secret: %v", err)
+	}
+
+	port := (*addr)[strings.LastIndex(*addr, ":")+1:]
+	origin := "http://" + *host + ":" + port
+	sub := models.PushSubscriptionJSON{
+		Endpoint: origin + "/push/" + b64.EncodeToString(authSecret[:8]),
+		Keys: models.PushKey{
+			P256dh: b64.EncodeToString(uaKey.PublicKey().Bytes()),
+			Auth:   b64.EncodeToString(authSecret),
+		},
+	}
+	subJSON, _ := json.Marshal(sub)
+	fmt.Println(string(subJSON))
+
+	var count, failures atomic.Int64
+	failures.Store(int64(*fail))
+	http.HandleFunc("/push/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := checkVAPID(r.Header.Get("Authorization"), origin); err != nil {
+			log.Printf("reject: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+			log.Printf("reject: missing Content-Encoding or TTL")
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 8192))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if *gone {
+			log.Printf("410 Gone for %s", r.URL.Path)
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if failures.Add(-1) >= 0 {
+			log.Printf("500 (simulated failure)")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		plain, err := push.Decrypt(body, uaKey, authSecret)
+		if err != nil {
+			log.Printf("reject: decrypt: %v", err)
+			http.Error(w, "decrypt failed", http.StatusBadRequest)
+			return
+		}
+		n := count.Add(1)
+		name := filepath.Join(*dir, fmt.Sprintf("%s-%04d.json", time.Now().Format("20060102-150405"), n))
+		if err := os.WriteFile(name, plain, 0644); err != nil {
+			log.Printf("write %s: %v", name, err)
+		}
+		log.Printf("push %d (TTL %s, Topic %q): %s", n, r.Header.Get("TTL"), r.Header.Get("Topic"), plain)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	log.Printf("pushcapture listening on %s, writing to %s", *addr, *dir)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// checkVAPID verifies the "vapid t=<jwt>, k=<key>" header: an ES256 JWT for
+// this origin, unexpired, signed by the key it names
+func checkVAPID(header, origin string) error {
+	var token, key string
+	for _, part := range strings.Split(strings.TrimPrefix(header, "vapid "), ",") {
+		part = strings.TrimSpace(part)
+		if v, ok := strings.CutPrefix(part, "t="); ok {
+			token = v
+		} else if v, ok := strings.CutPrefix(part, "k="); ok {
+			key = v
+		}
+	}
+	if !strings.HasPrefix(header, "vapid ") || token == "" || key == "" {
+		return errors.New("missing vapid authorization")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+
+	point, err := b64.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("decode key: %w", err)
+	}
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return fmt.Errorf("invalid key: %w", err)
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(point[1:33]), Y: new(big.Int).SetBytes(point[33:])}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return errors.New("malformed signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return errors.New("bad signature")
+	}
+
+	raw, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("malformed claims")
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return errors.New("malformed claims")
+	}
+	if claims.Aud != origin {
+		return fmt.Errorf("aud %q, want %q", claims.Aud, origin)
+	}
+	if time.Unix(claims.Exp, 0).Before(time.Now()) || time.Until(time.Unix(claims.Exp, 0)) > 24*time.Hour {
+		return errors.New("exp out of range")
+	}
+	if claims.Sub == "" {
+		return errors.New("missing sub")
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// Command s3local is a local stand-in for an S3-compatible bucket, in the
+// manner of MinIO. It speaks the path-style subset the storage package uses
+// (PUT, GET, HEAD and DELETE of objects and ListObjectsV2), keeps objects as
+// files under a directory, and checks the SigV4 signature of every request,
+// presigned URLs included.
+//
+//	go run ./cmd/s3local -addr :9000 -dir ./s3data
+//
+// Then set STORAGE_BACKEND=s3, S3_ENDPOINT=http://localhost:9000,
+// S3_BUCKET=forum, S3_ACCESS_KEY_ID=forum and S3_SECRET_ACCESS_KEY=forumsecret
+// in .env. Buckets are created on first use. -page N lists at most N keys
+// per page to exercise continuation.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const timeFormat = "20060102T150405Z"
+
+var (
+	dir       string
+	accessKey string
+	secretKey string
+	region    string
+	pageSize  int
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	flag.StringVar(&dir, "dir", "./s3data", "directory to keep buckets in")
+	flag.StringVar(&accessKey, "access-key", "forum", "access key ID clients must sign with")
+	flag.StringVar(&secretKey, "secret-key", "forumsecret", "secret access key clients must sign with")
+	flag.StringVar(&region, "region", "us-east-1", "region clients must sign for")
+	flag.IntVar(&pageSize, "page", 1000, "most keys to list per page")
+	flag.Parse()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("create data directory: %v", err)
+	}
+	http.HandleFunc("/", serve)
+	log.Printf("s3local listening on %s, keeping buckets in %s (access key %q)", *addr, dir, accessKey)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<20))
+	if err != nil {
+		s3Error(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if err := checkSignature(r, body); err != nil {
+		log.Printf("reject %s %s: %v", r.Method, r.URL.Path, err)
+		s3Error(w, r, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" || strings.ContainsAny(bucket, `\.`) {
+		s3Error(w, r, http.StatusBadRequest, "InvalidBucketName", "path-style requests only")
+		return
+	}
+	if key == "" {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			list(w, r, bucket)
+			return
+		}
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported on buckets")
+		return
+	}
+	file := objectPath(bucket, key)
+	if file == "" {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "bad key")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if err := writeFile(file, body); err != nil {
+			s3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		log.Printf("PUT %s/%s (%d bytes, %s)", bucket, key, len(body), r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		f, err := os.Open(file)
+		if err != nil {
+			s3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			s3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		log.Printf("%s %s/%s", r.Method, bucket, key)
+		http.ServeContent(w, r, key, info.ModTime(), f)
+	case http.MethodDelete:
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		log.Printf("DELETE %s/%s", bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// objectPath is where an object is kept, or "" if the key would leave its
+// bucket
+func objectPath(bucket, key string) string {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return ""
+	}
+	return filepath.Join(dir, bucket, filepath.FromSlash(clean))
+}
+
+func writeFile(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".s3local-tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+type listResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string   `xml:"Name"`
+	Prefix                string   `xml:"Prefix"`
+	KeyCount              int      `xml:"KeyCount"`
+	MaxKeys               int      `xml:"MaxKeys"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	ContinuationToken     string   `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	Contents              []object `xml:"Contents"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int64  `xml:"Size"`
+}
+
+// list answers ListObjectsV2. The continuation token is the last key of the
+// previous page.
+func list(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	prefix, after := q.Get("prefix"), q.Get("continuation-token")
+	var all []object
+	root := filepath.Join(dir, bucket)
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(p, ".s3local-tmp") {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		key := filepath.ToSlash(rel)
+		info, err := d.Info()
+		if err != nil || !strings.HasPrefix(key, prefix) || key <= after {
+			return nil
+		}
+		all = append(all, object{Key: key, LastModified: info.ModTime().UTC().Format(time.RFC3339Nano), Size: info.Size()})
+		return nil
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].Key < all[j].Key })
+
+	res := listResult{Name: bucket, Prefix: prefix, MaxKeys: pageSize, ContinuationToken: after, Contents: all}
+	if len(all) > pageSize {
+		res.Contents = all[:pageSize]
+		res.IsTruncated = true
+		res.NextContinuationToken = all[pageSize-1].Key
+	}
+	res.KeyCount = len(res.Contents)
+	log.Printf("LIST %s prefix %q: %d keys", bucket, prefix, res.KeyCount)
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(res)
+}
+
+func s3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource></Error>", xml.Header, code, message, r.URL.Path)
+}
+
+// checkSignature verifies a SigV4 Authorization header or presigned query
+// against the configured credentials, and the body against its declared
+// hash
+func checkSignature(r *http.Request, body []byte) error {
+	q := r.URL.Query()
+	var credential, signedHeaders, signature, date, payloadHash string
+	presigned := q.Get("X-Amz-Signature") != ""
+	if presigned {
+		if q.Get("X-Amz-Algorithm") != "AWS4-HMAC-SHA256" {
+			return errors.New("unsupported algorithm")
+		}
+		credential, signedHeaders, signature = q.Get("X-Amz-Credential"), q.Get("X-Amz-SignedHeaders"), q.Get("X-Amz-Signature")
+		date, payloadHash = q.Get("X-Amz-Date"), "UNSIGNED-PAYLOAD"
+		signedAt, err := time.Parse(timeFormat, date)
+		if err != nil {
+			return errors.New("bad X-Amz-Date")
+		}
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || expires < 1 || expires > 604800 {
+			return errors.New("bad X-Amz-Expires")
+		}
+		if time.Now().After(signedAt.Add(time.Duration(expires) * time.Second)) {
+			return errors.New("request has expired")
+		}
+	} else {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+		if !ok {
+			return errors.New("missing AWS4-HMAC-SHA256 authorization")
+		}
+		for _, part := range strings.Split(auth, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				signature = v
+			}
+		}
+		date, payloadHash = r.Header.Get("X-Amz-Date"), r.Header.Get("X-Amz-Content-Sha256")
+		signedAt, err := time.Parse(timeFormat, date)
+		if err != nil {
+			return errors.New("bad X-Amz-Date")
+		}
+		if d := time.Since(signedAt); d > 15*time.Minute || d < -15*time.Minute {
+			return errors.New("request time too skewed")
+		}
+		if payloadHash != "UNSIGNED-PAYLOAD" {
+			sum := sha256.Sum256(body)
+			if payloadHash != hex.EncodeToString(sum[:]) {
+				return errors.New("body does not match X-Amz-Content-Sha256")
+			}
+		}
+	}
+
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] != accessKey || parts[1] != date[:8] || parts[2] != region || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return fmt.Errorf("bad credential %q", credential)
+	}
+	names := strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(names) || !contains(names, "host") || (!presigned && !contains(names, "x-amz-date")) {
+		return errors.New("bad signed headers")
+	}
+
+	var headers strings.Builder
+	for _, name := range names {
+		v := r.Header.Get(name)
+		if name == "host" {
+			v = r.Host
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(v), " ") + "\n")
+	}
+	delete(q, "X-Amz-Signature")
+	canonical := strings.Join([]string{r.Method, r.URL.EscapedPath(), encodeQuery(q), headers.String(), signedHeaders, payloadHash}, "\n")
+	scope := strings.Join(parts[1:], "/")
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range parts[1:] {
+		key = mac(key, part)
+	}
+	want := hex.EncodeToString(mac(key, toSign))
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// encodeQuery is the SigV4 canonical query string
+func encodeQuery(q url.Values) string {
+	var pairs []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, escape(k)+"\x00"+escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	for i, p := range pairs {
+		pairs[i] = strings.Replace(p, "\x00", "=", 1)
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escape percent-encodes all but the unreserved characters
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func mac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
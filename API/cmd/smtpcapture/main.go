@@ -0,0 +1,167 @@
+// Command smtpcapture is a local SMTP stand-in for development. It accepts
+// every message, saves it as an .eml file and logs who it was for, so email
+// notifications can be checked without a real mail server.
+//
+//	go run ./cmd/smtpcapture -addr :1025 -dir ./mailbox
+//
+// Then set SMTP_HOST=localhost and SMTP_PORT=1025 in .env.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxMessageSize rejects anything bigger than a notification email could be
+const maxMessageSize = 10 << 20
+
+var counter atomic.Uint64
+
+func main() {
+	addr := flag.String("addr", ":1025", "address to listen on")
+	dir := flag.String("dir", "./mailbox", "directory to write captured .eml files to")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatalf("create mailbox: %v", err)
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	log.Printf("smtpcapture listening on %s, writing to %s", ln.Addr(), *dir)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("accept: %v", err)
+			continue
+		}
+		go serve(conn, *dir)
+	}
+}
+
+// session holds the envelope of the message being received
+type session struct {
+	from string
+	to   []string
+}
+
+func serve(conn net.Conn, dir string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format+"\r\n", args...)
+		w.Flush()
+	}
+
+	reply("220 smtpcapture ready")
+	var s session
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reply("250 smtpcapture")
+		case "EHLO":
+			reply("250-smtpcapture")
+			reply("250-8BITMIME")
+			reply("250 SIZE %d", maxMessageSize)
+		case "MAIL":
+			s = session{from: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			s.to = append(s.to, address(arg))
+			reply("250 OK")
+		case "DATA":
+			if s.from == "" || len(s.to) == 0 {
+				reply("503 need MAIL and RCPT first")
+				continue
+			}
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := readData(r)
+			if err != nil {
+				reply("552 %v", err)
+				return
+			}
+			path, err := save(dir, data)
+			if err != nil {
+				reply("451 %v", err)
+				continue
+			}
+			logMessage(s, data, path)
+			reply("250 OK queued as %s", filepath.Base(path))
+			s = session{}
+		case "RSET":
+			s = session{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// address pulls the mailbox out of "FROM:<a@b>" or "TO:<a@b> SIZE=1"
+func address(arg string) string {
+	if start := strings.Index(arg, "<"); start >= 0 {
+		if end := strings.Index(arg[start:], ">"); end >= 0 {
+			return arg[start+1 : start+end]
+		}
+	}
+	_, addr, _ := strings.Cut(arg, ":")
+	return strings.TrimSpace(addr)
+}
+
+// readData reads a DATA section up to the lone "." and undoes dot-stuffing
+func readData(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			return buf.Bytes(), nil
+		}
+		line = strings.TrimPrefix(line, ".")
+		if buf.Len()+len(line) > maxMessageSize {
+			return nil, fmt.Errorf("message too large")
+		}
+		buf.WriteString(line)
+	}
+}
+
+func save(dir string, data []byte) (string, error) {
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102-150405"), counter.Add(1))
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, data, 0644)
+}
+
+func logMessage(s session, data []byte, path string) {
+	subject := "(unparsed)"
+	if msg, err := mail.ReadMessage(bytes.NewReader(data)); err == nil {
+		subject, _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	}
+	log.Printf("captured %s -> %s %q (%s)", s.from, strings.Join(s.to, ", "), subject, path)
+}
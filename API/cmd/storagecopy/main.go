@@ -0,0 +1,143 @@
+// Command storagecopy copies uploads from one storage backend to another, to
+// move an existing forum from its local uploads directory to a bucket or
+// back. Keys are kept, so the database needs no change.
+//
+//	go run ./cmd/storagecopy -from local:./uploads -to s3
+//
+// A backend is local:<dir>, s3 with the S3_* settings of .env, or
+// s3://<bucket>/<prefix> to override their bucket and prefix. Files already
+// at the destination with the same size are skipped, so an interrupted copy
+// can simply be run again; it never deletes anything. Stop the API, or copy
+// again after switching STORAGE_BACKEND, to catch uploads made meanwhile.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"forum/storage"
+	"forum/utils"
+)
+
+func main() {
+	from := flag.String("from", "local:uploads", "backend to copy from")
+	to := flag.String("to", "s3", "backend to copy to")
+	prefix := flag.String("prefix", storage.ImagesDir+"/", "copy only keys starting with this")
+	workers := flag.Int("workers", 4, "files to copy at once")
+	dryRun := flag.Bool("dry-run", false, "list what would be copied without copying")
+	flag.Parse()
+
+	if err := utils.LoadEnv(".env"); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("load .env: %v", err)
+	}
+	src, err := open(*from)
+	if err != nil {
+		log.Fatalf("-from: %v", err)
+	}
+	dst, err := open(*to)
+	if err != nil {
+		log.Fatalf("-to: %v", err)
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	// What the destination already has, by key and size
+	existing := make(map[string]int64)
+	if err := dst.Walk(*prefix, func(f storage.File) error {
+		existing[f.Key] = f.Size
+		return nil
+	}); err != nil {
+		log.Fatalf("list %s: %v", dst, err)
+	}
+
+	var copied, skipped, failed atomic.Int64
+	var size atomic.Int64
+	files := make(chan storage.File)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				if *dryRun {
+					fmt.Printf("would copy %s (%d bytes)\n", f.Key, f.Size)
+					copied.Add(1)
+					size.Add(f.Size)
+					continue
+				}
+				if err := copyFile(src, dst, f.Key); err != nil {
+					log.Printf("copy %s: %v", f.Key, err)
+					failed.Add(1)
+					continue
+				}
+				copied.Add(1)
+				size.Add(f.Size)
+			}
+		}()
+	}
+	err = src.Walk(*prefix, func(f storage.File) error {
+		if strings.HasPrefix(f.Key[strings.LastIndex(f.Key, "/")+1:], ".") {
+			return nil // a half written upload
+		}
+		if size, ok := existing[f.Key]; ok && size == f.Size {
+			skipped.Add(1)
+			return nil
+		}
+		files <- f
+		return nil
+	})
+	close(files)
+	wg.Wait()
+	if err != nil {
+		log.Fatalf("list %s: %v", src, err)
+	}
+
+	verb := "copied"
+	if *dryRun {
+		verb = "would copy"
+	}
+	log.Printf("%s %d files (%d bytes) from %s to %s; %d already there, %d failed", verb, copied.Load(), size.Load(), src, dst, skipped.Load(), failed.Load())
+	if failed.Load() > 0 {
+		os.Exit(1)
+	}
+}
+
+// open turns a -from or -to value into a Blob
+func open(spec string) (storage.Blob, error) {
+	if dir, ok := strings.CutPrefix(spec, "local:"); ok {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		return storage.NewLocal(dir), nil
+	}
+	cfg := storage.S3ConfigFromEnv()
+	if spec == "s3" {
+		return storage.NewS3(cfg)
+	}
+	if rest, ok := strings.CutPrefix(spec, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		cfg.Bucket, cfg.Prefix = bucket, prefix
+		return storage.NewS3(cfg)
+	}
+	return nil, fmt.Errorf("%q is not local:<dir>, s3 or s3://<bucket>/<prefix>", spec)
+}
+
+func copyFile(src, dst storage.Blob, key string) error {
+	r, err := src.Get(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	return dst.Put(key, data)
+}
@@ -0,0 +1,23 @@
+// Command vapidkeys prints a new VAPID key pair for Web Push as .env lines.
+// Generate it once; browsers subscribed with a key stop receiving pushes if
+// it changes.
+//
+//	go run ./cmd/vapidkeys >> .env
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"forum/push"
+)
+
+func main() {
+	private, public, err := push.GenerateVAPID()
+	if err != nil {
+		log.Fatalf("generate VAPID keys: %v", err)
+	}
+	fmt.Printf("# Web Push; the public key is served to browsers by /forum/api/user/push/vapid-key\n")
+	fmt.Printf("VAPID_PRIVATE_KEY=%s\n", private)
+	fmt.Printf("# VAPID public key: %s\n", public)
+}
@@ -5,6 +5,7 @@ const IdxPostCategoriesPostID = `CREATE INDEX IF NOT EXISTS idx_post_categories_
 const IdxPostCategoriesCategoryID = `CREATE INDEX IF NOT EXISTS idx_post_categories_category_id ON post_categories(category_id);`
 const IdxCommentsPostID = `CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments(post_id);`
 const IdxCommentsUserID = `CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments(user_id);`
+const IdxCommentsParent = `CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments(parent_comment_id) WHERE parent_comment_id IS NOT NULL;`
 const IdxReactionsUserID = `CREATE INDEX IF NOT EXISTS idx_reactions_user_id ON reactions(user_id);`
 const IdxReactionsPostID = `CREATE INDEX IF NOT EXISTS idx_reactions_post_id ON reactions(post_id);`
 const IdxReactionsCommentID = `CREATE INDEX IF NOT EXISTS idx_reactions_comment_id ON reactions(comment_id);`
@@ -13,6 +14,65 @@ const IdxImagesPostID = `CREATE INDEX IF NOT EXISTS idx_images_post_id ON images
 // Composite index for faster retrieval of notifications by user and creation time
 const IdxNotificationsUserCreated = `CREATE INDEX IF NOT EXISTS idx_notifications_user_created ON notifications(user_id, created_at);`
 
+// Mention lookups by post, comment and mentioned user
+const IdxMentionsPostID = `CREATE INDEX IF NOT EXISTS idx_mentions_post_id ON mentions(post_id);`
+const IdxMentionsCommentID = `CREATE INDEX IF NOT EXISTS idx_mentions_comment_id ON mentions(comment_id);`
+const IdxMentionsUserID = `CREATE INDEX IF NOT EXISTS idx_mentions_user_id ON mentions(user_id);`
+
+// Index for listing a post's subscribers
+const IdxPostSubscriptionsPostID = `CREATE INDEX IF NOT EXISTS idx_post_subscriptions_post_id ON post_subscriptions(post_id, state);`
+
+// Indexes for checking whether a notification went out on a channel and
+// when a user's last digest was sent
+const IdxNotificationDeliveriesNotification = `CREATE INDEX IF NOT EXISTS idx_notification_deliveries_notification ON notification_deliveries(notification_id, channel, status);`
+const IdxNotificationDeliveriesUser = `CREATE INDEX IF NOT EXISTS idx_notification_deliveries_user ON notification_deliveries(user_id, channel, delivered_at);`
+
+// Indexes for listing a user's webhooks, a webhook's delivery log and the
+// deliveries still waiting for a retry
+const IdxWebhooksUserID = `CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks(user_id);`
+const IdxWebhookDeliveriesWebhook = `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at);`
+const IdxWebhookDeliveriesStatus = `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status, next_attempt_at);`
+
+// Index for finding the browsers to push a user's notifications to
+const IdxPushSubscriptionsUser = `CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user ON push_subscriptions(user_id, session_id);`
+
+// Indexes for the retention job's scans by age
+const IdxNotificationsCreated = `CREATE INDEX IF NOT EXISTS idx_notifications_created ON notifications(created_at);`
+const IdxNotificationsArchiveArchived = `CREATE INDEX IF NOT EXISTS idx_notifications_archive_archived ON notifications_archive(archived_at);`
+
+// Partial indexes for counting a user's unread notifications without touching
+// read, deleted or snoozed rows, and for finding snoozes that are due
+const IdxNotificationsUserUnread = `CREATE INDEX IF NOT EXISTS idx_notifications_user_unread ON notifications(user_id)
+	WHERE read_at IS NULL AND message IS NOT NULL AND snoozed_until IS NULL;`
+const IdxNotificationsSnoozed = `CREATE INDEX IF NOT EXISTS idx_notifications_snoozed ON notifications(snoozed_until) WHERE snoozed_until IS NOT NULL;`
+
+// Replaces idx_notifications_user_unread once deletion moved from clearing
+// message to setting deleted_at
+const IdxNotificationsUserUnreadVisible = `CREATE INDEX IF NOT EXISTS idx_notifications_user_unread_visible ON notifications(user_id)
+	WHERE read_at IS NULL AND deleted_at IS NULL AND snoozed_until IS NULL;`
+
+// Index for finding a user's open notification group
+const IdxNotificationsUserGroup = `CREATE INDEX IF NOT EXISTS idx_notifications_user_group ON notifications(user_id, group_key);`
+
+// Indexes for the feed sort orders, newest first within equal counts
+const IdxPostsCreated = `CREATE INDEX IF NOT EXISTS idx_posts_created ON posts(created_at, post_id);`
+const IdxPostsCommentCount = `CREATE INDEX IF NOT EXISTS idx_posts_comment_count ON posts(comment_count, created_at, post_id);`
+const IdxPostsLikeCount = `CREATE INDEX IF NOT EXISTS idx_posts_like_count ON posts(like_count, created_at, post_id);`
+
+// Indexes for a user's drafts and for the scheduled publisher
+const IdxPostsUserStatus = `CREATE INDEX IF NOT EXISTS idx_posts_user_status ON posts(user_id, status);`
+const IdxPostsScheduled = `CREATE INDEX IF NOT EXISTS idx_posts_scheduled ON posts(publish_at) WHERE status = 'scheduled';`
+
+// Index for listing a post's gallery in order
+const IdxImagesPostPosition = `CREATE INDEX IF NOT EXISTS idx_images_post_position ON images(post_id, position);`
+
+// Indexes for finding an earlier identical upload and for counting the rows
+// that still point at a stored file
+const IdxImagesContentHash = `CREATE INDEX IF NOT EXISTS idx_images_content_hash ON images(content_hash) WHERE content_hash IS NOT NULL;`
+const IdxImagesFilePath = `CREATE INDEX IF NOT EXISTS idx_images_file_path ON images(file_path);`
+const IdxImagesThumbnailPath = `CREATE INDEX IF NOT EXISTS idx_images_thumbnail_path ON images(thumbnail_path);`
+const IdxImageVariantsFilePath = `CREATE INDEX IF NOT EXISTS idx_image_variants_file_path ON image_variants(file_path);`
+
 // -- Index for faster lookups by provider and provider_user_id
 const CreateOAuthIndexes = `
 		CREATE INDEX IF NOT EXISTS idx_oauth_provider_user 
@@ -0,0 +1,53 @@
+package config
+
+// Feed counters. posts.comment_count, like_count and dislike_count are kept
+// by triggers so feeds can sort and show them without counting rows per post.
+// Deleted comments (content cleared) and removed reactions (type 3) don't count.
+const TriggerCommentCountInsert = `CREATE TRIGGER IF NOT EXISTS comments_count_insert AFTER INSERT ON comments
+WHEN NEW.content IS NOT NULL
+BEGIN
+    UPDATE posts SET comment_count = comment_count + 1 WHERE post_id = NEW.post_id;
+END;`
+
+const TriggerCommentCountUpdate = `CREATE TRIGGER IF NOT EXISTS comments_count_update AFTER UPDATE OF content ON comments
+WHEN (OLD.content IS NULL) <> (NEW.content IS NULL)
+BEGIN
+    UPDATE posts SET comment_count = comment_count + CASE WHEN NEW.content IS NULL THEN -1 ELSE 1 END
+        WHERE post_id = NEW.post_id;
+END;`
+
+const TriggerCommentCountDelete = `CREATE TRIGGER IF NOT EXISTS comments_count_delete AFTER DELETE ON comments
+WHEN OLD.content IS NOT NULL
+BEGIN
+    UPDATE posts SET comment_count = comment_count - 1 WHERE post_id = OLD.post_id;
+END;`
+
+const TriggerReactionCountInsert = `CREATE TRIGGER IF NOT EXISTS reactions_count_insert AFTER INSERT ON reactions
+WHEN NEW.post_id IS NOT NULL
+BEGIN
+    UPDATE posts SET like_count = like_count + (NEW.reaction_type = 1),
+        dislike_count = dislike_count + (NEW.reaction_type = 2)
+        WHERE post_id = NEW.post_id;
+END;`
+
+const TriggerReactionCountUpdate = `CREATE TRIGGER IF NOT EXISTS reactions_count_update AFTER UPDATE OF reaction_type ON reactions
+WHEN NEW.post_id IS NOT NULL
+BEGIN
+    UPDATE posts SET like_count = like_count + (NEW.reaction_type = 1) - (OLD.reaction_type = 1),
+        dislike_count = dislike_count + (NEW.reaction_type = 2) - (OLD.reaction_type = 2)
+        WHERE post_id = NEW.post_id;
+END;`
+
+const TriggerReactionCountDelete = `CREATE TRIGGER IF NOT EXISTS reactions_count_delete AFTER DELETE ON reactions
+WHEN OLD.post_id IS NOT NULL
+BEGIN
+    UPDATE posts SET like_count = like_count - (OLD.reaction_type = 1),
+        dislike_count = dislike_count - (OLD.reaction_type = 2)
+        WHERE post_id = OLD.post_id;
+END;`
+
+// BackfillPostCounts counts what existed before the triggers
+const BackfillPostCounts = `UPDATE posts SET
+    comment_count = (SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.post_id AND c.content IS NOT NULL),
+    like_count = (SELECT COUNT(*) FROM reactions r WHERE r.post_id = posts.post_id AND r.reaction_type = 1),
+    dislike_count = (SELECT COUNT(*) FROM reactions r WHERE r.post_id = posts.post_id AND r.reaction_type = 2);`
@@ -0,0 +1,18 @@
+package config
+
+// Rendered content. posts.content_html and comments.content_html cache the
+// Markdown of content as sanitized HTML, and html_version records the
+// renderer version that produced it. The repositories render on every write;
+// these triggers clear the cache when content changes without it, so stale
+// HTML is never served.
+const TriggerPostHTMLInvalidate = `CREATE TRIGGER IF NOT EXISTS posts_html_invalidate AFTER UPDATE OF content ON posts
+WHEN NEW.content IS NOT OLD.content AND NEW.content_html IS OLD.content_html
+BEGIN
+    UPDATE posts SET content_html = NULL, html_version = 0 WHERE post_id = NEW.post_id;
+END;`
+
+const TriggerCommentHTMLInvalidate = `CREATE TRIGGER IF NOT EXISTS comments_html_invalidate AFTER UPDATE OF content ON comments
+WHEN NEW.content IS NOT OLD.content AND NEW.content_html IS OLD.content_html
+BEGIN
+    UPDATE comments SET content_html = NULL, html_version = 0 WHERE comment_id = NEW.comment_id;
+END;`
@@ -124,3 +124,198 @@ const CreateNotificationsTable = `CREATE TABLE IF NOT EXISTS notifications (
     FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
     FOREIGN KEY (comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE
 );`
+
+// Notification preferences store per-user opt-outs by notification type and
+// delivery channel. A missing row means the channel is enabled.
+const CreateNotificationPreferencesTable = `CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    channel TEXT NOT NULL CHECK (channel IN ('in_app', 'email', 'webhook', 'push')),
+    enabled INTEGER NOT NULL DEFAULT 1 CHECK (enabled IN (0, 1)),
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, type, channel),
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// Notification actors lists everyone folded into a grouped notification, so
+// "alice and 4 others liked your post" can name its most recent actors
+const CreateNotificationActorsTable = `CREATE TABLE IF NOT EXISTS notification_actors (
+    notification_id TEXT NOT NULL,
+    actor_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (notification_id, actor_id),
+    FOREIGN KEY (notification_id) REFERENCES notifications(notification_id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// Mentions records each @username in a post or comment that resolved to a
+// user, with its UTF-16 offsets in the content
+const CreateMentionsTable = `CREATE TABLE IF NOT EXISTS mentions (
+    mention_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,
+    post_id TEXT,
+    comment_id TEXT,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
+    FOREIGN KEY (comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE,
+    CHECK (
+        (post_id IS NULL AND comment_id IS NOT NULL) OR
+        (post_id IS NOT NULL AND comment_id IS NULL)
+    )
+);`
+
+// Post subscriptions decide who hears about activity on a post. Authors,
+// commenters and reactors are subscribed automatically; a muted row stays
+// muted until the user changes it.
+const CreatePostSubscriptionsTable = `CREATE TABLE IF NOT EXISTS post_subscriptions (
+    user_id TEXT NOT NULL,
+    post_id TEXT NOT NULL,
+    state TEXT NOT NULL CHECK (state IN ('subscribed', 'muted')),
+    reason TEXT NOT NULL CHECK (reason IN ('author', 'comment', 'reaction', 'manual')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, post_id),
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);`
+
+// Notification email settings choose how each user receives email:
+// immediately or batched into hourly or daily digests
+const CreateNotificationEmailSettingsTable = `CREATE TABLE IF NOT EXISTS notification_email_settings (
+    user_id TEXT PRIMARY KEY,
+    mode TEXT NOT NULL CHECK (mode IN ('immediate', 'hourly', 'daily')),
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// Notification deliveries log every attempt to send a notification outside
+// the app. Digest deliveries share a digest_id, one row per notification.
+const CreateNotificationDeliveriesTable = `CREATE TABLE IF NOT EXISTS notification_deliveries (
+    delivery_id TEXT PRIMARY KEY,
+    notification_id TEXT,
+    user_id TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    digest_id TEXT,
+    status TEXT NOT NULL CHECK (status IN ('sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+    FOREIGN KEY (notification_id) REFERENCES notifications(notification_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// Webhooks post forum events to a user's own services. events is a
+// comma-separated filter of event names such as post.created.
+const CreateWebhooksTable = `CREATE TABLE IF NOT EXISTS webhooks (
+    webhook_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// Webhook deliveries log each event sent to a webhook. A pending row is
+// retried at next_attempt_at, including after a restart.
+const CreateWebhookDeliveriesTable = `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id TEXT PRIMARY KEY,
+    webhook_id TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    next_attempt_at TIMESTAMP,
+    delivered_at TIMESTAMP,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);`
+
+// Push subscriptions are browsers registered for Web Push, one row per
+// PushSubscription endpoint. A subscription belongs to the session that
+// registered it and stops receiving pushes once that session ends.
+const CreatePushSubscriptionsTable = `CREATE TABLE IF NOT EXISTS push_subscriptions (
+    subscription_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    session_id TEXT NOT NULL,
+    endpoint TEXT NOT NULL UNIQUE,
+    p256dh TEXT NOT NULL,
+    auth TEXT NOT NULL,
+    user_agent TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// Archived notifications are unread notifications moved out of the inbox by
+// the retention job. They keep their original columns plus archived_at.
+const CreateNotificationsArchiveTable = `CREATE TABLE IF NOT EXISTS notifications_archive (
+    notification_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    actor_id TEXT,
+    post_id TEXT,
+    comment_id TEXT,
+    type TEXT NOT NULL,
+    message TEXT,
+    group_key TEXT,
+    actor_count INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    read_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    archived_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// Retention runs record what each pass of the retention job removed
+const CreateNotificationRetentionRunsTable = `CREATE TABLE IF NOT EXISTS notification_retention_runs (
+    run_id TEXT PRIMARY KEY,
+    trigger TEXT NOT NULL CHECK (trigger IN ('schedule', 'manual')),
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    deleted_read INTEGER NOT NULL DEFAULT 0,
+    deleted_soft INTEGER NOT NULL DEFAULT 0,
+    archived INTEGER NOT NULL DEFAULT 0,
+    purged_archive INTEGER NOT NULL DEFAULT 0,
+    error TEXT
+);`
+
+// Revisions keep every version of a post or comment, numbered from 1 for the
+// original. The newest revision is the current text. Soft deletes remove them.
+const CreatePostRevisionsTable = `CREATE TABLE IF NOT EXISTS post_revisions (
+    post_id TEXT NOT NULL,
+    revision INTEGER NOT NULL CHECK (revision > 0),
+    title TEXT,
+    content TEXT,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (post_id, revision),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);`
+
+const CreateCommentRevisionsTable = `CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id TEXT NOT NULL,
+    revision INTEGER NOT NULL CHECK (revision > 0),
+    content TEXT,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (comment_id, revision),
+    FOREIGN KEY (comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE
+);`
+
+// Image variants are the resized and WebP copies made of an upload, listed
+// as the image's srcset. The original itself is not a row here.
+const CreateImageVariantsTable = `CREATE TABLE IF NOT EXISTS image_variants (
+    image_id TEXT NOT NULL,
+    width INTEGER NOT NULL CHECK (width > 0),
+    height INTEGER NOT NULL CHECK (height > 0),
+    content_type TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    PRIMARY KEY (image_id, width, content_type),
+    FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE
+);`
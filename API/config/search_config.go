@@ -0,0 +1,143 @@
+package config
+
+// Full-text search indexes. They keep their own copy of the text, keyed by
+// post_id or comment_id, because the rowids of posts and comments are not
+// stable across VACUUM. FTS5 requires building with -tags sqlite_fts5.
+const CreatePostsFTSTable = `CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+    post_id UNINDEXED,
+    title,
+    content,
+    tokenize = 'unicode61 remove_diacritics 2'
+);`
+
+const CreateCommentsFTSTable = `CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(
+    comment_id UNINDEXED,
+    post_id UNINDEXED,
+    content,
+    tokenize = 'unicode61 remove_diacritics 2'
+);`
+
+// Triggers keep the indexes in step with posts and comments. Soft deletes
+// clear the text, which takes the row out of the index.
+const TriggerPostsFTSInsert = `CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts
+WHEN NEW.title IS NOT NULL OR NEW.content IS NOT NULL
+BEGIN
+    INSERT INTO posts_fts (post_id, title, content) VALUES (NEW.post_id, COALESCE(NEW.title, ''), COALESCE(NEW.content, ''));
+END;`
+
+const TriggerPostsFTSUpdate = `CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts
+BEGIN
+    DELETE FROM posts_fts WHERE post_id = OLD.post_id;
+    INSERT INTO posts_fts (post_id, title, content)
+        SELECT NEW.post_id, COALESCE(NEW.title, ''), COALESCE(NEW.content, '')
+        WHERE NEW.title IS NOT NULL OR NEW.content IS NOT NULL;
+END;`
+
+const TriggerPostsFTSDelete = `CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts
+BEGIN
+    DELETE FROM posts_fts WHERE post_id = OLD.post_id;
+END;`
+
+const TriggerCommentsFTSInsert = `CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments
+WHEN NEW.content IS NOT NULL
+BEGIN
+    INSERT INTO comments_fts (comment_id, post_id, content) VALUES (NEW.comment_id, NEW.post_id, NEW.content);
+END;`
+
+const TriggerCommentsFTSUpdate = `CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content ON comments
+BEGIN
+    DELETE FROM comments_fts WHERE comment_id = OLD.comment_id;
+    INSERT INTO comments_fts (comment_id, post_id, content)
+        SELECT NEW.comment_id, NEW.post_id, NEW.content WHERE NEW.content IS NOT NULL;
+END;`
+
+const TriggerCommentsFTSDelete = `CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments
+BEGIN
+    DELETE FROM comments_fts WHERE comment_id = OLD.comment_id;
+END;`
+
+// Backfills index the content that existed before the triggers
+const BackfillPostsFTS = `INSERT INTO posts_fts (post_id, title, content)
+    SELECT post_id, COALESCE(title, ''), COALESCE(content, '') FROM posts
+    WHERE title IS NOT NULL OR content IS NOT NULL;`
+
+const BackfillCommentsFTS = `INSERT INTO comments_fts (comment_id, post_id, content)
+    SELECT comment_id, post_id, content FROM comments WHERE content IS NOT NULL;`
+
+// Migration 26 rebuilds the indexes above so that triggers find a row by
+// rowid instead of scanning the UNINDEXED id columns. The rowids come from
+// posts_fts_keys and comments_fts_keys, whose INTEGER PRIMARY KEY survives
+// VACUUM where the implicit rowids of posts and comments do not.
+const CreatePostsFTSKeysTable = `CREATE TABLE IF NOT EXISTS posts_fts_keys (
+    fts_rowid INTEGER PRIMARY KEY,
+    post_id TEXT NOT NULL UNIQUE
+);`
+
+const CreateCommentsFTSKeysTable = `CREATE TABLE IF NOT EXISTS comments_fts_keys (
+    fts_rowid INTEGER PRIMARY KEY,
+    comment_id TEXT NOT NULL UNIQUE
+);`
+
+// Every post and comment has a key row; soft deletes clear the text, which
+// takes the row out of the index but keeps its key.
+const TriggerPostsFTSInsertKeyed = `CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts
+BEGIN
+    INSERT OR IGNORE INTO posts_fts_keys (post_id) VALUES (NEW.post_id);
+    INSERT INTO posts_fts (rowid, post_id, title, content)
+        SELECT fts_rowid, NEW.post_id, COALESCE(NEW.title, ''), COALESCE(NEW.content, '')
+        FROM posts_fts_keys WHERE post_id = NEW.post_id
+        AND (NEW.title IS NOT NULL OR NEW.content IS NOT NULL);
+END;`
+
+const TriggerPostsFTSUpdateKeyed = `CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts
+BEGIN
+    DELETE FROM posts_fts WHERE rowid = (SELECT fts_rowid FROM posts_fts_keys WHERE post_id = OLD.post_id);
+    INSERT OR IGNORE INTO posts_fts_keys (post_id) VALUES (NEW.post_id);
+    INSERT INTO posts_fts (rowid, post_id, title, content)
+        SELECT fts_rowid, NEW.post_id, COALESCE(NEW.title, ''), COALESCE(NEW.content, '')
+        FROM posts_fts_keys WHERE post_id = NEW.post_id
+        AND (NEW.title IS NOT NULL OR NEW.content IS NOT NULL);
+END;`
+
+const TriggerPostsFTSDeleteKeyed = `CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts
+BEGIN
+    DELETE FROM posts_fts WHERE rowid = (SELECT fts_rowid FROM posts_fts_keys WHERE post_id = OLD.post_id);
+    DELETE FROM posts_fts_keys WHERE post_id = OLD.post_id;
+END;`
+
+const TriggerCommentsFTSInsertKeyed = `CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments
+BEGIN
+    INSERT OR IGNORE INTO comments_fts_keys (comment_id) VALUES (NEW.comment_id);
+    INSERT INTO comments_fts (rowid, comment_id, post_id, content)
+        SELECT fts_rowid, NEW.comment_id, NEW.post_id, NEW.content
+        FROM comments_fts_keys WHERE comment_id = NEW.comment_id AND NEW.content IS NOT NULL;
+END;`
+
+const TriggerCommentsFTSUpdateKeyed = `CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content ON comments
+BEGIN
+    DELETE FROM comments_fts WHERE rowid = (SELECT fts_rowid FROM comments_fts_keys WHERE comment_id = OLD.comment_id);
+    INSERT OR IGNORE INTO comments_fts_keys (comment_id) VALUES (NEW.comment_id);
+    INSERT INTO comments_fts (rowid, comment_id, post_id, content)
+        SELECT fts_rowid, NEW.comment_id, NEW.post_id, NEW.content
+        FROM comments_fts_keys WHERE comment_id = NEW.comment_id AND NEW.content IS NOT NULL;
+END;`
+
+const TriggerCommentsFTSDeleteKeyed = `CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments
+BEGIN
+    DELETE FROM comments_fts WHERE rowid = (SELECT fts_rowid FROM comments_fts_keys WHERE comment_id = OLD.comment_id);
+    DELETE FROM comments_fts_keys WHERE comment_id = OLD.comment_id;
+END;`
+
+const BackfillPostsFTSKeys = `INSERT INTO posts_fts_keys (post_id) SELECT post_id FROM posts;`
+
+const BackfillPostsFTSKeyed = `INSERT INTO posts_fts (rowid, post_id, title, content)
+    SELECT k.fts_rowid, p.post_id, COALESCE(p.title, ''), COALESCE(p.content, '')
+    FROM posts p JOIN posts_fts_keys k ON k.post_id = p.post_id
+    WHERE p.title IS NOT NULL OR p.content IS NOT NULL;`
+
+const BackfillCommentsFTSKeys = `INSERT INTO comments_fts_keys (comment_id) SELECT comment_id FROM comments;`
+
+const BackfillCommentsFTSKeyed = `INSERT INTO comments_fts (rowid, comment_id, post_id, content)
+    SELECT k.fts_rowid, c.comment_id, c.post_id, c.content
+    FROM comments c JOIN comments_fts_keys k ON k.comment_id = c.comment_id
+    WHERE c.content IS NOT NULL;`
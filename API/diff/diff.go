@@ -0,0 +1,219 @@
+// Package diff compares two versions of a text by lines or by words
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Op kinds
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// Op is a run of text that both versions share, or that only the new one
+// (Insert) or the old one (Delete) has. Joining the Equal and Delete texts
+// gives the old version back, and the Equal and Insert texts the new one.
+type Op struct {
+	Kind string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines diffs a and b line by line. Line breaks stay on their lines.
+func Lines(a, b string) []Op {
+	return compare(splitLines(a), splitLines(b))
+}
+
+// Words diffs a and b word by word. Runs of whitespace count as words, so
+// changed spacing shows up too.
+func Words(a, b string) []Op {
+	return compare(splitWords(a), splitWords(b))
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func splitWords(s string) []string {
+	var words []string
+	start, space := 0, false
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, s[start:i])
+			start = i
+		}
+		if i == start {
+			space = unicode.IsSpace(r)
+		}
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+// Past these, two versions are too far apart for a useful diff and compare
+// reports all of one replaced by all of the other. They bound the work and
+// memory an unauthenticated diff request can cost.
+const (
+	maxTokens = 20000 // tokens in both versions, after their shared ends
+	maxEdits  = 1000  // tokens deleted plus tokens inserted
+)
+
+// compare finds a shortest edit script from a to b with Myers' O(ND)
+// algorithm and reports it as equal, delete and insert runs, deletes first
+func compare(a, b []string) []Op {
+	// Shared ends need no search
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var out []Op
+	out = appendOp(out, Equal, a[:pre]...)
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if ops, ok := editScript(am, bm); ok {
+		out = append(out, ops...)
+	} else {
+		out = appendOp(out, Delete, am...)
+		out = appendOp(out, Insert, bm...)
+	}
+	out = appendOp(out, Equal, a[len(a)-suf:]...)
+	return reorder(out)
+}
+
+// editScript returns the ops turning a into b, or false when that takes
+// more than maxEdits edits or the inputs are over maxTokens
+func editScript(a, b []string) ([]Op, bool) {
+	n, m := len(a), len(b)
+	if n+m > maxTokens {
+		return nil, false
+	}
+	limit := min(n+m, maxEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k = x-y. trace[d]
+	// keeps v for diagonals -d..d after d edits, for walking back.
+	offset := limit + 1
+	v := make([]int32, 2*limit+3)
+	var trace [][]int32
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = int(v[offset+k+1]) // down: insert b[y-1]
+			} else {
+				x = int(v[offset+k-1]) + 1 // right: delete a[x-1]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = int32(x)
+			if x >= n && y >= m {
+				trace = append(trace, append([]int32(nil), v[offset-d:offset+d+1]...))
+				return backtrack(a, b, trace), true
+			}
+		}
+		trace = append(trace, append([]int32(nil), v[offset-d:offset+d+1]...))
+	}
+	return nil, false
+}
+
+// backtrack walks the trace of editScript from the end of both inputs to
+// their start, collecting the ops in reverse
+func backtrack(a, b []string, trace [][]int32) []Op {
+	var rev []Op
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1] // diagonals -(d-1)..d-1
+		at := func(k int) int { return int(prev[k+d-1]) }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			rev = append(rev, Op{Kind: Equal, Text: a[x-1]})
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			rev = append(rev, Op{Kind: Insert, Text: b[y-1]})
+		} else {
+			rev = append(rev, Op{Kind: Delete, Text: a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		rev = append(rev, Op{Kind: Equal, Text: a[x-1]})
+		x--
+		y--
+	}
+	var out []Op
+	for i := len(rev) - 1; i >= 0; i-- {
+		out = appendOp(out, rev[i].Kind, rev[i].Text)
+	}
+	return out
+}
+
+// appendOp adds tokens to out, merging them into the last op when it is of
+// the same kind
+func appendOp(out []Op, kind string, tokens ...string) []Op {
+	if len(tokens) == 0 {
+		return out
+	}
+	text := strings.Join(tokens, "")
+	if n := len(out); n > 0 && out[n-1].Kind == kind {
+		out[n-1].Text += text
+		return out
+	}
+	return append(out, Op{Kind: kind, Text: text})
+}
+
+// reorder puts each change's delete before its insert, so a replaced word
+// reads old then new, and merges the runs that brings together
+func reorder(ops []Op) []Op {
+	var out []Op
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == Equal {
+			out = appendOp(out, Equal, ops[i].Text)
+			i++
+			continue
+		}
+		var del, ins strings.Builder
+		for ; i < len(ops) && ops[i].Kind != Equal; i++ {
+			if ops[i].Kind == Delete {
+				del.WriteString(ops[i].Text)
+			} else {
+				ins.WriteString(ops[i].Text)
+			}
+		}
+		if del.Len() > 0 {
+			out = appendOp(out, Delete, del.String())
+		}
+		if ins.Len() > 0 {
+			out = appendOp(out, Insert, ins.String())
+		}
+	}
+	if out == nil {
+		out = []Op{}
+	}
+	return out
+}
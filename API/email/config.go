@@ -0,0 +1,58 @@
+package email
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds SMTP settings, read from the environment (see utils.LoadEnv)
+type Config struct {
+	Host     string // SMTP_HOST; email is disabled when empty
+	Port     int    // SMTP_PORT, default 25
+	Username string // SMTP_USERNAME; no AUTH when empty
+	Password string // SMTP_PASSWORD
+	From     string // SMTP_FROM, default "Forum <no-reply@localhost>"
+	BaseURL  string // APP_BASE_URL for links in emails, default the UI at http://localhost:8081
+
+	MaxAttempts int           // SMTP_MAX_ATTEMPTS, default 4
+	RetryDelay  time.Duration // SMTP_RETRY_DELAY, first backoff step, default 2s; doubles per attempt
+	Timeout     time.Duration // per-connection timeout, 30s
+}
+
+// ConfigFromEnv builds a Config from the environment. The second result is
+// false when SMTP_HOST is not set.
+func ConfigFromEnv() (Config, bool) {
+	cfg := Config{
+		Host:        os.Getenv("SMTP_HOST"),
+		Port:        envInt("SMTP_PORT", 25),
+		Username:    os.Getenv("SMTP_USERNAME"),
+		Password:    os.Getenv("SMTP_PASSWORD"),
+		From:        envString("SMTP_FROM", "Forum <no-reply@localhost>"),
+		BaseURL:     envString("APP_BASE_URL", "http://localhost:8081"),
+		MaxAttempts: envInt("SMTP_MAX_ATTEMPTS", 4),
+		RetryDelay:  2 * time.Second,
+		Timeout:     30 * time.Second,
+	}
+	if d, err := time.ParseDuration(os.Getenv("SMTP_RETRY_DELAY")); err == nil && d > 0 {
+		cfg.RetryDelay = d
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return cfg, cfg.Host != ""
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
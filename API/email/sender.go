@@ -0,0 +1,269 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"forum/models"
+	"forum/repository/notification"
+	"forum/utils"
+)
+
+const (
+	// queueSize bounds immediate emails waiting for the SMTP worker
+	queueSize = 256
+	// digestCheckInterval is how often users are checked for a due digest
+	digestCheckInterval = 5 * time.Minute
+	// maxDigestItems caps one digest; the rest go out in the next one
+	maxDigestItems = 50
+)
+
+var errClosed = errors.New("email sender closed")
+
+// digestPeriods maps digest modes to the time between two digests
+var digestPeriods = map[string]time.Duration{
+	models.EmailHourly: time.Hour,
+	models.EmailDaily:  24 * time.Hour,
+}
+
+// Sender is the email notification channel. Users in immediate mode get an
+// email per notification; the rest get digests of their unread notifications.
+// Every attempt is recorded in notification_deliveries.
+type Sender struct {
+	cfg        Config
+	users      notification.UserLookup
+	prefs      *notification.PreferenceRepository
+	deliveries *notification.DeliveryRepository
+
+	queue chan models.Notification
+	done  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// NewSender starts the SMTP worker and the digest loop
+func NewSender(cfg Config, users notification.UserLookup, prefs *notification.PreferenceRepository, deliveries *notification.DeliveryRepository) *Sender {
+	s := &Sender{
+		cfg:        cfg,
+		users:      users,
+		prefs:      prefs,
+		deliveries: deliveries,
+		queue:      make(chan models.Notification, queueSize),
+		done:       make(chan struct{}),
+	}
+	s.wg.Add(2)
+	go s.worker()
+	go s.digestLoop()
+	return s
+}
+
+func (s *Sender) Channel() string { return models.ChannelEmail }
+
+// Send queues an immediate email. Users in a digest mode are skipped here;
+// the digest loop picks the notification up from their inbox.
+func (s *Sender) Send(n models.Notification) error {
+	mode, err := s.prefs.EmailMode(n.UserID)
+	if err != nil {
+		return err
+	}
+	if mode != models.EmailImmediate {
+		return nil
+	}
+	select {
+	case <-s.done:
+		return errClosed
+	case s.queue <- n:
+		return nil
+	default:
+		return errors.New("email queue full")
+	}
+}
+
+// Close stops the worker and the digest loop. Queued emails are dropped;
+// the digest loop will not resend them because they were never recorded.
+func (s *Sender) Close() {
+	s.once.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
+
+func (s *Sender) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case n := <-s.queue:
+			if err := s.sendImmediate(n); err != nil {
+				log.Printf("Email [ERROR]: notification %s to %s: %v", n.ID, n.UserID, err)
+			}
+		}
+	}
+}
+
+func (s *Sender) sendImmediate(n models.Notification) error {
+	user, err := s.users.GetByID(n.UserID)
+	if err != nil {
+		return fmt.Errorf("load recipient: %w", err)
+	}
+	if user.Email == "" {
+		return nil
+	}
+	text, html, err := render(s.cfg, user.Username, "You have a new notification.", []models.Notification{n})
+	if err != nil {
+		return err
+	}
+	msg := Message{To: user.Email, Subject: "New notification: " + utils.DerefString(n.Message), Text: text, HTML: html}
+	attempts, sendErr := s.deliver(msg)
+	if n.ID != "" {
+		s.record(n.UserID, []string{n.ID}, nil, attempts, sendErr)
+	}
+	return sendErr
+}
+
+func (s *Sender) digestLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.SendDueDigests(now)
+		}
+	}
+}
+
+// SendDueDigests emails every digest user whose period has passed since their
+// last digest (or since their oldest pending notification, for a first one)
+func (s *Sender) SendDueDigests(now time.Time) {
+	users, err := s.deliveries.UsersWithPending(models.ChannelEmail)
+	if err != nil {
+		log.Printf("Email [ERROR]: list digest users: %v", err)
+		return
+	}
+	for _, userID := range users {
+		if err := s.sendDigest(userID, now); err != nil {
+			log.Printf("Email [ERROR]: digest for %s: %v", userID, err)
+		}
+	}
+}
+
+func (s *Sender) sendDigest(userID string, now time.Time) error {
+	mode, err := s.prefs.EmailMode(userID)
+	if err != nil {
+		return err
+	}
+	period, ok := digestPeriods[mode]
+	if !ok {
+		return nil
+	}
+	pending, err := s.deliveries.Pending(userID, models.ChannelEmail, maxDigestItems)
+	if err != nil {
+		return err
+	}
+	var items []models.Notification
+	for _, n := range pending {
+		if s.prefs.Allows(userID, n.Type, models.ChannelEmail) {
+			items = append(items, n)
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	since := items[0].CreatedAt
+	if last, ok, err := s.deliveries.LastDigestAt(userID, models.ChannelEmail); err != nil {
+		return err
+	} else if ok {
+		since = last
+	}
+	if now.Sub(since) < period {
+		return nil
+	}
+
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("load recipient: %w", err)
+	}
+	if user.Email == "" {
+		return nil
+	}
+	noun := "notifications"
+	if len(items) == 1 {
+		noun = "notification"
+	}
+	intro := fmt.Sprintf("Here is your %s digest with %d unread %s.", mode, len(items), noun)
+	text, html, err := render(s.cfg, user.Username, intro, items)
+	if err != nil {
+		return err
+	}
+	msg := Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("Your %s digest: %d new %s", mode, len(items), noun),
+		Text:    text,
+		HTML:    html,
+	}
+	attempts, sendErr := s.deliver(msg)
+
+	ids := make([]string, len(items))
+	for i, n := range items {
+		ids[i] = n.ID
+	}
+	digestID := utils.GenerateUUID()
+	s.record(userID, ids, &digestID, attempts, sendErr)
+	return sendErr
+}
+
+// deliver sends msg, retrying with exponential backoff. It returns how many
+// attempts were made and the last error.
+func (s *Sender) deliver(msg Message) (int, error) {
+	delay := s.cfg.RetryDelay
+	var err error
+	for attempt := 1; attempt <= s.cfg.MaxAttempts; attempt++ {
+		if err = send(s.cfg, msg); err == nil {
+			return attempt, nil
+		}
+		if attempt == s.cfg.MaxAttempts {
+			return attempt, err
+		}
+		log.Printf("Email [WARN]: attempt %d to %s failed, retrying in %s: %v", attempt, msg.To, delay, err)
+		select {
+		case <-s.done:
+			return attempt, errClosed
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return s.cfg.MaxAttempts, err
+}
+
+// record logs the outcome for each notification in one email
+func (s *Sender) record(userID string, notificationIDs []string, digestID *string, attempts int, sendErr error) {
+	now := time.Now()
+	for _, id := range notificationIDs {
+		id := id
+		d := models.NotificationDelivery{
+			NotificationID: &id,
+			UserID:         userID,
+			Channel:        models.ChannelEmail,
+			DigestID:       digestID,
+			Status:         models.DeliverySent,
+			Attempts:       attempts,
+			CreatedAt:      now,
+			DeliveredAt:    &now,
+		}
+		if sendErr != nil {
+			msg := sendErr.Error()
+			d.Status = models.DeliveryFailed
+			d.LastError = &msg
+			d.DeliveredAt = nil
+		}
+		if err := s.deliveries.Record(d); err != nil {
+			log.Printf("Email [ERROR]: record delivery of %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package email
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/models"
+	"forum/repository/notification"
+)
+
+// users is a notification.UserLookup over fixed users
+type users map[string]*models.User
+
+func (u users) GetByID(id string) (*models.User, error) {
+	if user, ok := u[id]; ok {
+		return user, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// openTestDB builds a fresh database in a temporary working directory
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	db, err := models.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDigestsGroupPendingNotificationsPerUser(t *testing.T) {
+	db := openTestDB(t)
+	people := users{
+		"ada":   {ID: "ada", Username: "ada", Email: "ada@example.com"},
+		"bob":   {ID: "bob", Username: "bob", Email: "bob@example.com"},
+		"carol": {ID: "carol", Username: "carol", Email: "carol@example.com"},
+	}
+	for _, u := range people {
+		if _, err := db.Exec(`INSERT INTO user (user_id, username, email) VALUES (?, ?, ?)`, u.ID, u.Username, u.Email); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prefs := notification.NewPreferenceRepository(db)
+	deliveries := notification.NewDeliveryRepository(db)
+	store := notification.NewRepository(db)
+	if err := prefs.SetEmailMode("ada", models.EmailHourly); err != nil {
+		t.Fatal(err)
+	}
+	if err := prefs.SetEmailMode("bob", models.EmailDaily); err != nil {
+		t.Fatal(err)
+	}
+	// ada wants no reaction emails, so those stay out of her digest
+	if err := prefs.Set("ada", []models.NotificationPreference{
+		{Type: models.NotificationReaction, Channels: map[string]bool{models.ChannelEmail: false}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	notify := func(userID, actorID, typ, target string) {
+		t.Helper()
+		_, err := store.Create(models.Notification{
+			UserID:  userID,
+			ActorID: actorID,
+			Type:    typ,
+			Payload: models.NotificationPayload{"target": target},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	notify("ada", "carol", models.NotificationMention, "post")
+	notify("ada", "bob", models.NotificationMention, "comment")
+	notify("ada", "carol", models.NotificationReaction, "post")
+	notify("bob", "carol", models.NotificationMention, "post")
+
+	srv := startSMTP(t)
+	s := NewSender(srv.config(), people, prefs, deliveries)
+	defer s.Close()
+	start := time.Now()
+
+	// Within the hour nothing is due
+	s.SendDueDigests(start.Add(30 * time.Minute))
+	srv.none(t)
+
+	// After an hour ada gets one email with both mentions; bob's daily
+	// digest is not due yet
+	s.SendDueDigests(start.Add(2 * time.Hour))
+	got := srv.next(t)
+	if got.To != "ada@example.com" {
+		t.Fatalf("digest went to %s, want ada", got.To)
+	}
+	srv.none(t)
+	m := parseMessage(t, got.Data)
+	if want := "Your hourly digest: 2 new notifications"; m.Subject != want {
+		t.Errorf("Subject = %q, want %q", m.Subject, want)
+	}
+	for _, want := range []string{"carol mentioned you in a post", "bob mentioned you in a comment"} {
+		if !strings.Contains(m.Text, want) || !strings.Contains(m.HTML, want) {
+			t.Errorf("digest lacks %q:\n%s", want, m.Text)
+		}
+	}
+	if strings.Contains(m.Text, "react") {
+		t.Errorf("digest includes the muted reaction:\n%s", m.Text)
+	}
+
+	var digests, rows int
+	if err := db.QueryRow(`SELECT COUNT(DISTINCT digest_id), COUNT(*) FROM notification_deliveries
+		WHERE user_id = 'ada' AND channel = ? AND status = ?`, models.ChannelEmail, models.DeliverySent).Scan(&digests, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if digests != 1 || rows != 2 {
+		t.Errorf("recorded %d deliveries in %d digests, want 2 in 1", rows, digests)
+	}
+
+	// What was sent is not sent again
+	s.SendDueDigests(start.Add(3 * time.Hour))
+	srv.none(t)
+	notify("ada", "bob", models.NotificationMention, "post")
+
+	// A day later bob's digest is due too, and ada's second one holds only
+	// the new mention
+	s.SendDueDigests(start.Add(25 * time.Hour))
+	subjects := make(map[string]string)
+	for range 2 {
+		msg := srv.next(t)
+		subjects[msg.To] = parseMessage(t, msg.Data).Subject
+	}
+	srv.none(t)
+	if want := "Your daily digest: 1 new notification"; subjects["bob@example.com"] != want {
+		t.Errorf("bob's Subject = %q, want %q", subjects["bob@example.com"], want)
+	}
+	if want := "Your hourly digest: 1 new notification"; subjects["ada@example.com"] != want {
+		t.Errorf("ada's Subject = %q, want %q", subjects["ada@example.com"], want)
+	}
+}
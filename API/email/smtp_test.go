@@ -0,0 +1,261 @@
+package email
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// received is one message the SMTP stand-in accepted
+type received struct {
+	From, To string
+	Data     []byte
+}
+
+// smtpStandIn speaks just enough SMTP for send: no STARTTLS and no AUTH, so
+// the client goes straight to MAIL, RCPT and DATA
+type smtpStandIn struct {
+	ln       net.Listener
+	messages chan received
+}
+
+func startSMTP(t *testing.T) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{ln: ln, messages: make(chan received, 16)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP stand-in")
+	var msg received
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			msg = received{From: addrArg(arg)}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = addrArg(arg)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 end with <CRLF>.<CRLF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			s.messages <- msg
+			tp.PrintfLine("250 OK queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// addrArg pulls the address out of "FROM:<a@b> ..." or "TO:<a@b>"
+func addrArg(arg string) string {
+	start, end := strings.Index(arg, "<"), strings.Index(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
+
+func (s *smtpStandIn) port() int { return s.ln.Addr().(*net.TCPAddr).Port }
+
+func (s *smtpStandIn) config() Config {
+	return Config{
+		Host:        "127.0.0.1",
+		Port:        s.port(),
+		From:        "Forum <no-reply@forum.test>",
+		BaseURL:     "http://forum.test",
+		MaxAttempts: 1,
+		RetryDelay:  time.Millisecond,
+		Timeout:     5 * time.Second,
+	}
+}
+
+// next waits for the next accepted message
+func (s *smtpStandIn) next(t *testing.T) received {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message reached the SMTP stand-in")
+		return received{}
+	}
+}
+
+// none fails if a message arrives
+func (s *smtpStandIn) none(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		t.Fatalf("unexpected message to %s", msg.To)
+	default:
+	}
+}
+
+// parsed is a received message split into headers and its two bodies
+type parsed struct {
+	Header      mail.Header
+	Subject     string
+	Text, HTML  string
+	PartHeaders []textproto.MIMEHeader
+}
+
+func parseMessage(t *testing.T, data []byte) parsed {
+	t.Helper()
+	m, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data))))
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	p := parsed{Header: m.Header}
+	if p.Subject, err = new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); err != nil {
+		t.Fatalf("decode Subject: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", m.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		p.PartHeaders = append(p.PartHeaders, part.Header)
+		if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "quoted-printable" {
+			t.Fatalf("part Content-Transfer-Encoding = %q, want quoted-printable", enc)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("decode part: %v", err)
+		}
+		switch part.Header.Get("Content-Type") {
+		case "text/plain; charset=UTF-8":
+			p.Text = string(body)
+		case "text/html; charset=UTF-8":
+			p.HTML = string(body)
+		default:
+			t.Fatalf("unexpected part %q", part.Header.Get("Content-Type"))
+		}
+	}
+	return p
+}
+
+func TestSendMIME(t *testing.T) {
+	srv := startSMTP(t)
+	cfg := srv.config()
+	subject := "Réponse à « Café »: ✓"
+	text := "Ligne un\nune très longue ligne " + strings.Repeat("é", 80) + "\nfin"
+	html := `<p>Bonjour <b>Zoë</b> = 1</p>`
+	if err := send(cfg, Message{To: "zoe@example.com", Subject: subject, Text: text, HTML: html}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	got := srv.next(t)
+	if got.From != "no-reply@forum.test" || got.To != "zoe@example.com" {
+		t.Fatalf("envelope = %s -> %s", got.From, got.To)
+	}
+	for _, line := range strings.Split(string(got.Data), "\n") {
+		if len(line) > 998 {
+			t.Fatalf("line longer than SMTP allows: %d bytes", len(line))
+		}
+		for _, c := range []byte(line) {
+			if c >= 0x80 {
+				t.Fatalf("raw 8-bit byte in message line %q", line)
+			}
+		}
+	}
+
+	m := parseMessage(t, got.Data)
+	raw := m.Header.Get("Subject")
+	if !strings.HasPrefix(raw, "=?UTF-8?q?") {
+		t.Errorf("Subject %q is not Q-encoded", raw)
+	}
+	if m.Subject != subject {
+		t.Errorf("Subject = %q, want %q", m.Subject, subject)
+	}
+	if m.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("MIME-Version = %q", m.Header.Get("MIME-Version"))
+	}
+	if from := m.Header.Get("From"); from != cfg.From {
+		t.Errorf("From = %q", from)
+	}
+	if id := m.Header.Get("Message-Id"); !strings.HasSuffix(id, "@forum.test>") {
+		t.Errorf("Message-ID = %q, want the From domain", id)
+	}
+	if _, err := m.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+	if len(m.PartHeaders) != 2 || !strings.HasPrefix(m.PartHeaders[0].Get("Content-Type"), "text/plain") {
+		t.Fatalf("want the text part then the HTML part, got %v", m.PartHeaders)
+	}
+	if m.Text != text {
+		t.Errorf("text body = %q, want %q", m.Text, text)
+	}
+	if m.HTML != html {
+		t.Errorf("HTML body = %q, want %q", m.HTML, html)
+	}
+}
+
+func TestSendASCIISubjectUnencoded(t *testing.T) {
+	srv := startSMTP(t)
+	if err := send(srv.config(), Message{To: "a@example.com", Subject: "Plain subject", Text: "x", HTML: "x"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	m := parseMessage(t, srv.next(t).Data)
+	if raw := m.Header.Get("Subject"); raw != "Plain subject" {
+		t.Errorf("Subject = %q, want it left as is", raw)
+	}
+}
+
+func TestSendRejectsBadFrom(t *testing.T) {
+	srv := startSMTP(t)
+	cfg := srv.config()
+	cfg.From = "not an address"
+	if err := send(cfg, Message{To: "a@example.com", Subject: "x"}); err == nil {
+		t.Fatal("send with an invalid SMTP_FROM succeeded")
+	}
+	srv.none(t)
+}
@@ -0,0 +1,75 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"net/url"
+	texttemplate "text/template"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var (
+	textTemplate = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/notifications.txt.tmpl"))
+	htmlTemplate = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/notifications.html.tmpl"))
+)
+
+// templateData feeds both notification templates
+type templateData struct {
+	Username string
+	Intro    string
+	Items    []templateItem
+	InboxURL string
+}
+
+type templateItem struct {
+	Message   string
+	PostTitle string
+	URL       string
+	When      string
+}
+
+// render builds the text and HTML bodies for a list of notifications
+func render(cfg Config, username, intro string, ns []models.Notification) (text, html string, err error) {
+	data := templateData{
+		Username: username,
+		Intro:    intro,
+		InboxURL: cfg.BaseURL + "/user/feed",
+	}
+	for _, n := range ns {
+		data.Items = append(data.Items, templateItem{
+			Message:   utils.DerefString(n.Message),
+			PostTitle: utils.DerefString(n.PostTitle),
+			URL:       notificationURL(cfg, n),
+			When:      n.CreatedAt.Format(time.RFC1123),
+		})
+	}
+
+	var tb, hb bytes.Buffer
+	if err := textTemplate.Execute(&tb, data); err != nil {
+		return "", "", err
+	}
+	if err := htmlTemplate.Execute(&hb, data); err != nil {
+		return "", "", err
+	}
+	return tb.String(), hb.String(), nil
+}
+
+// notificationURL links to the post a notification is about, the same way
+// the notification list in the UI does
+func notificationURL(cfg Config, n models.Notification) string {
+	if n.PostID == nil {
+		return cfg.BaseURL + "/user/feed"
+	}
+	u := cfg.BaseURL + "/user/post?id=" + url.QueryEscape(*n.PostID)
+	if n.CommentID != nil {
+		u += "#" + url.QueryEscape(*n.CommentID)
+	}
+	return u
+}
@@ -15,6 +15,7 @@ import (
 
 	"forum/models"
 	"forum/repository"
+	nrepo "forum/repository/notification"
 	"forum/repository/session"
 	"forum/repository/user"
 	"forum/utils"
@@ -484,7 +485,12 @@ func (h *OAuthHandler) handleOAuthUser(userInfo *models.OAuthUserInfo, provider,
 		Password: "", // No password for OAuth
 	}
 
-	return h.UserRepo.CreateOAuthUser(reg, provider, userInfo.ID, userInfo.AvatarURL, accessToken, refreshToken, tokenExpiresAt)
+	created, err := h.UserRepo.CreateOAuthUser(reg, provider, userInfo.ID, userInfo.AvatarURL, accessToken, refreshToken, tokenExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	h.AuthHandler.Notifier.Dispatch(nrepo.UserRegistered{UserID: created.ID})
+	return created, nil
 }
 
 func (h *OAuthHandler) generateUsernameFromEmail(email string) string {
@@ -10,6 +10,7 @@ import (
 	"forum/middleware"
 	"forum/models"
 	"forum/repository"
+	nrepo "forum/repository/notification"
 	"forum/repository/session"
 	"forum/repository/user"
 	"forum/utils"
@@ -19,13 +20,15 @@ import (
 type AuthHandler struct {
 	UserRepo    *user.UserRepository
 	SessionRepo *session.SessionRepository
+	Notifier    *nrepo.Dispatcher
 }
 
 // NewAuthHandler creates a new AuthHandler
-func NewAuthHandler(userRepo *user.UserRepository, sessionRepo *session.SessionRepository) *AuthHandler {
+func NewAuthHandler(userRepo *user.UserRepository, sessionRepo *session.SessionRepository, notifier *nrepo.Dispatcher) *AuthHandler {
 	return &AuthHandler{
 		UserRepo:    userRepo,
 		SessionRepo: sessionRepo,
+		Notifier:    notifier,
 	}
 }
 
@@ -88,6 +91,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	h.Notifier.Dispatch(nrepo.UserRegistered{UserID: user.ID})
 
 	// Create session after successful registration
 	session, err := h.createUserSession(w, r, user)
@@ -87,6 +87,7 @@ func (h *CategoryHandler) GetCategoryByID(w http.ResponseWriter, r *http.Request
 			posts[i].ImageURL = apiStaticBase + imgs[0].FilePath
 			posts[i].ThumbnailURL = apiStaticBase + imgs[0].ThumbnailPath
 		}
+		posts[i].Images = gallery(imgs)
 	}
 
 	categoryByID := models.CategoryWithPosts{
@@ -2,27 +2,35 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"unicode/utf8"
 
 	"forum/middleware"
 	"forum/models"
+	"forum/realtime"
 	"forum/repository"
 	nrepo "forum/repository/notification"
-	"forum/repository/user"
 	"forum/utils"
 )
 
+// maxQuotes caps how many comments one comment may quote
+const maxQuotes = 10
+
 // CommentHandler handles comment related endpoints
 type CommentHandler struct {
-	CommentRepo      *repository.CommentRepository
-	PostRepo         *repository.PostRepository
-	NotificationRepo *nrepo.Repository
-	UserRepo         *user.UserRepository
+	CommentRepo *repository.CommentRepository
+	Notifier    *nrepo.Dispatcher
+	Mentions    *MentionTracker
+	Subs        *nrepo.SubscriptionRepository
+	Hub         *realtime.Hub
 }
 
 // NewCommentHandler creates a new CommentHandler
-func NewCommentHandler(repo *repository.CommentRepository, postRepo *repository.PostRepository, nRepo *nrepo.Repository, uRepo *user.UserRepository) *CommentHandler {
-	return &CommentHandler{CommentRepo: repo, PostRepo: postRepo, NotificationRepo: nRepo, UserRepo: uRepo}
+func NewCommentHandler(repo *repository.CommentRepository, notifier *nrepo.Dispatcher, mentions *MentionTracker, subs *nrepo.SubscriptionRepository, hub *realtime.Hub) *CommentHandler {
+	return &CommentHandler{CommentRepo: repo, Notifier: notifier, Mentions: mentions, Subs: subs, Hub: hub}
 }
 
 // CreateComment creates a new comment on a post for the authenticated user
@@ -39,8 +47,10 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		PostID  string `json:"post_id"`
-		Content string `json:"content"`
+		PostID  string   `json:"post_id"`
+		Content string   `json:"content"`
+		ReplyTo string   `json:"reply_to,omitempty"` // parent comment, for a threaded reply
+		Quotes  []string `json:"quotes,omitempty"`   // comments quoted in content
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
@@ -50,6 +60,27 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Post ID and content are required", http.StatusBadRequest)
 		return
 	}
+	if !validCommentLength(w, req.Content) {
+		return
+	}
+	if len(req.Quotes) > maxQuotes {
+		utils.ErrorResponse(w, "Too many quoted comments", http.StatusBadRequest)
+		return
+	}
+	if req.ReplyTo != "" && !h.onPost(req.ReplyTo, req.PostID) {
+		utils.ErrorResponse(w, "reply_to must be a comment on this post", http.StatusBadRequest)
+		return
+	}
+	quotes := make([]string, 0, len(req.Quotes))
+	for _, id := range req.Quotes {
+		if !h.onPost(id, req.PostID) {
+			utils.ErrorResponse(w, "quotes must be comments on this post", http.StatusBadRequest)
+			return
+		}
+		if !contains(quotes, id) && id != req.ReplyTo {
+			quotes = append(quotes, id)
+		}
+	}
 
 	comment := models.Comment{
 		PostID:  req.PostID,
@@ -57,20 +88,49 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		Content: &req.Content,
 	}
 
-	created, err := h.CommentRepo.Create(comment)
+	var created *models.Comment
+	var err error
+	if req.ReplyTo != "" {
+		created, err = h.CommentRepo.CreateReply(comment, req.ReplyTo)
+	} else {
+		created, err = h.CommentRepo.Create(comment)
+	}
+	if errors.Is(err, repository.ErrCommentTooDeep) {
+		utils.ErrorResponse(w, fmt.Sprintf("Replies cannot be nested more than %d levels deep", models.MaxCommentDepth), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		utils.ErrorResponse(w, "reply_to must be a comment on this post", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrPostNotFound) {
+		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to create comment", http.StatusInternalServerError)
 		return
 	}
-
-	if ownerID, err := h.PostRepo.GetPostOwner(req.PostID); err == nil && ownerID != user.ID {
-		if actor, err2 := h.UserRepo.GetByID(user.ID); err2 == nil {
-			msg := actor.Username + " commented on your post"
-			n := models.Notification{UserID: ownerID, ActorID: user.ID, PostID: &req.PostID, CommentID: &created.ID, Type: "comment", Message: &msg}
-			h.NotificationRepo.Create(n)
-		}
+	created.Mentions = h.Mentions.TrackComment(user.ID, req.PostID, created.ID, req.Content)
+	if err := h.Subs.AutoSubscribe(user.ID, req.PostID, models.SubscribeComment); err != nil {
+		log.Printf("CommentHandler [WARN]: subscribe %s to post %s: %v", user.ID, req.PostID, err)
 	}
 
+	h.Hub.PublishPost(req.PostID, realtime.EventCommentCreated, models.CommentWithUser{
+		ID:              created.ID,
+		PostID:          created.PostID,
+		UserID:          created.UserID,
+		Username:        user.Username,
+		ParentCommentID: created.ParentCommentID,
+		Depth:           created.Depth,
+		Content:         created.Content,
+		ContentHTML:     created.ContentHTML,
+		CreatedAt:       created.CreatedAt,
+		Mentions:        created.Mentions,
+	})
+
+	h.Notifier.Dispatch(nrepo.CommentCreated{ActorID: user.ID, PostID: req.PostID, CommentID: created.ID, ReplyToID: req.ReplyTo, QuotedIDs: quotes})
+
 	utils.JSONResponse(w, created, http.StatusCreated)
 }
 
@@ -101,6 +161,9 @@ func (h *CommentHandler) EditComment(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Nothing to update", http.StatusBadRequest)
 		return
 	}
+	if !validCommentLength(w, *req.Content) {
+		return
+	}
 	ownerID, err := h.CommentRepo.GetCommentOwner(commentID)
 	if err != nil {
 		utils.ErrorResponse(w, "Comment not found", http.StatusNotFound)
@@ -115,16 +178,16 @@ func (h *CommentHandler) EditComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mentions := []models.Mention{}
+	var html *string
 	if c, err := h.CommentRepo.GetByID(commentID); err == nil {
-		if ownerID, err2 := h.PostRepo.GetPostOwner(c.PostID); err2 == nil && ownerID != user.ID {
-			if actor, err3 := h.UserRepo.GetByID(user.ID); err3 == nil {
-				msg := actor.Username + " edited a comment on your post"
-				n := models.Notification{UserID: ownerID, ActorID: user.ID, PostID: &c.PostID, CommentID: &c.ID, Type: "comment_edit", Message: &msg}
-				h.NotificationRepo.Create(n)
-			}
-		}
+		mentions = h.Mentions.TrackComment(user.ID, c.PostID, c.ID, *req.Content)
+		c.Mentions = mentions
+		html = c.ContentHTML
+		h.publishCommentUpdate(realtime.EventCommentUpdated, c)
+		h.Notifier.Dispatch(nrepo.CommentEdited{ActorID: user.ID, PostID: c.PostID, CommentID: c.ID})
 	}
-	utils.JSONResponse(w, map[string]string{"status": "updated"}, http.StatusOK)
+	utils.JSONResponse(w, map[string]interface{}{"status": "updated", "content_html": html, "mentions": mentions}, http.StatusOK)
 }
 
 // DeleteComment soft-deletes a comment
@@ -156,15 +219,44 @@ func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Failed to delete comment", http.StatusInternalServerError)
 		return
 	}
+	h.Mentions.ForgetComment(commentID)
 
 	if c, err := h.CommentRepo.GetByID(commentID); err == nil {
-		if ownerID, err2 := h.PostRepo.GetPostOwner(c.PostID); err2 == nil && ownerID != user.ID {
-			if actor, err3 := h.UserRepo.GetByID(user.ID); err3 == nil {
-				msg := actor.Username + " deleted a comment on your post"
-				n := models.Notification{UserID: ownerID, ActorID: user.ID, PostID: &c.PostID, CommentID: &c.ID, Type: "comment_delete", Message: &msg}
-				h.NotificationRepo.Create(n)
-			}
-		}
+		h.publishCommentUpdate(realtime.EventCommentDeleted, c)
+		h.Notifier.Dispatch(nrepo.CommentDeleted{ActorID: user.ID, PostID: c.PostID, CommentID: c.ID})
 	}
 	utils.JSONResponse(w, map[string]string{"status": "deleted"}, http.StatusOK)
 }
+
+// publishCommentUpdate tells viewers of the comment's post that it changed
+func (h *CommentHandler) publishCommentUpdate(eventType string, c *models.Comment) {
+	update := realtime.CommentUpdate{CommentID: c.ID, PostID: c.PostID, Content: c.Content, ContentHTML: c.ContentHTML, Mentions: c.Mentions}
+	if c.UpdatedAt != nil {
+		update.UpdatedAt = *c.UpdatedAt
+	}
+	h.Hub.PublishPost(c.PostID, eventType, update)
+}
+
+// validCommentLength checks a comment's content against its length limit
+func validCommentLength(w http.ResponseWriter, content string) bool {
+	if utf8.RuneCountInString(content) > models.MaxCommentLength {
+		utils.ErrorResponse(w, fmt.Sprintf("Content must be at most %d characters", models.MaxCommentLength), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// onPost reports whether the comment exists on the post and is not deleted
+func (h *CommentHandler) onPost(commentID, postID string) bool {
+	c, err := h.CommentRepo.GetByID(commentID)
+	return err == nil && c.PostID == postID && c.Content != nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
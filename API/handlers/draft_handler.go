@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+)
+
+// draftRequest is the body of the draft create and update endpoints. A
+// publish_at schedules the post; without one it stays a draft.
+type draftRequest struct {
+	CategoryIDs []int      `json:"category_ids"`
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	PublishAt   *time.Time `json:"publish_at"`
+}
+
+// post validates the request and turns it into a draft or scheduled post.
+// A draft may be unfinished; a scheduled post needs everything a post
+// needs, and a publish time in the future.
+func (req draftRequest) post(userID string) (models.Post, string) {
+	post := models.Post{UserID: userID, Title: &req.Title, Content: &req.Content, Status: models.PostDraft}
+	if msg := postTextError(&req.Title, &req.Content); msg != "" {
+		return post, msg
+	}
+	if req.PublishAt == nil {
+		return post, ""
+	}
+	if !complete(req.CategoryIDs, req.Title, req.Content) {
+		return post, "At least one category, title and content are required to schedule a post"
+	}
+	if !req.PublishAt.After(time.Now()) {
+		return post, "publish_at must be in the future"
+	}
+	at := req.PublishAt.UTC()
+	post.Status = models.PostScheduled
+	post.PublishAt = &at
+	return post, ""
+}
+
+// complete reports whether a post has what publishing it requires
+func complete(categoryIDs []int, title, content string) bool {
+	return len(categoryIDs) > 0 && title != "" && content != ""
+}
+
+// CreateDraft saves a new post without publishing it, as a draft or
+// scheduled for publish_at
+func (h *PostHandler) CreateDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req draftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	post, msg := req.post(user.ID)
+	if msg != "" {
+		utils.ErrorResponse(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.PostRepo.Create(post, req.CategoryIDs)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to save draft", http.StatusInternalServerError)
+		return
+	}
+	if created.Status == models.PostScheduled {
+		h.Publisher.Reschedule()
+	}
+	created.CategoryIDs = req.CategoryIDs
+	utils.JSONResponse(w, created, http.StatusCreated)
+}
+
+// Drafts lists the current user's drafts and scheduled posts
+func (h *PostHandler) Drafts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	drafts, err := h.PostRepo.GetDrafts(user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load drafts", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, drafts, http.StatusOK)
+}
+
+// UpdateDraft replaces a draft's categories, title, content and publish
+// time. A null publish_at takes a scheduled post back to draft.
+func (h *PostHandler) UpdateDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	postID := utils.GetLastPathParam(r)
+	if postID == "" {
+		utils.ErrorResponse(w, "Missing post ID", http.StatusBadRequest)
+		return
+	}
+
+	var req draftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.ownDraft(w, user.ID, postID); !ok {
+		return
+	}
+	post, msg := req.post(user.ID)
+	if msg != "" {
+		utils.ErrorResponse(w, msg, http.StatusBadRequest)
+		return
+	}
+	post.ID = postID
+
+	err := h.PostRepo.UpdateDraft(post, req.CategoryIDs)
+	if errors.Is(err, repository.ErrPostNotFound) {
+		utils.ErrorResponse(w, "Post is already published", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to update draft", http.StatusInternalServerError)
+		return
+	}
+	h.Publisher.Reschedule()
+
+	updated, err := h.PostRepo.GetByID(postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load draft", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, updated, http.StatusOK)
+}
+
+// PublishDraft publishes a draft or scheduled post right away
+func (h *PostHandler) PublishDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	postID := utils.GetLastPathParam(r)
+	if postID == "" {
+		utils.ErrorResponse(w, "Missing post ID", http.StatusBadRequest)
+		return
+	}
+
+	draft, ok := h.ownDraft(w, user.ID, postID)
+	if !ok {
+		return
+	}
+	if draft.Title == nil || draft.Content == nil || !complete(draft.CategoryIDs, *draft.Title, *draft.Content) {
+		utils.ErrorResponse(w, "At least one category, title and content are required", http.StatusBadRequest)
+		return
+	}
+
+	published, ok, err := h.Publisher.Publish(postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to publish post", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(w, "Post is already published", http.StatusConflict)
+		return
+	}
+	h.Publisher.Reschedule()
+	utils.JSONResponse(w, published, http.StatusOK)
+}
+
+// ownDraft loads a draft or scheduled post of userID, answering 404, 403 or
+// 409 when it is missing, someone else's or already published
+func (h *PostHandler) ownDraft(w http.ResponseWriter, userID, postID string) (*models.Post, bool) {
+	post, err := h.PostRepo.GetByID(postID)
+	if err != nil || (post.Title == nil && post.Content == nil) {
+		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
+		return nil, false
+	}
+	if post.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	if post.Status == models.PostPublished {
+		utils.ErrorResponse(w, "Post is already published", http.StatusConflict)
+		return nil, false
+	}
+	return post, true
+}
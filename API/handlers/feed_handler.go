@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+)
+
+// FeedHandler serves the paginated post feeds, globally and per category
+type FeedHandler struct {
+	FeedRepo     *repository.FeedRepository
+	CategoryRepo *repository.CategoryRepository
+	MentionRepo  *repository.MentionRepository
+}
+
+func NewFeedHandler(feedRepo *repository.FeedRepository, categoryRepo *repository.CategoryRepository, mentionRepo *repository.MentionRepository) *FeedHandler {
+	return &FeedHandler{FeedRepo: feedRepo, CategoryRepo: categoryRepo, MentionRepo: mentionRepo}
+}
+
+// Posts answers GET /forum/api/feed/posts with posts from every category
+func (h *FeedHandler) Posts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseFeedQuery(r)
+	if err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.serve(w, q)
+}
+
+// CategoryPosts answers GET /forum/api/feed/category/{id}
+func (h *FeedHandler) CategoryPosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(utils.GetLastPathParam(r))
+	if err != nil || id <= 0 {
+		utils.ErrorResponse(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	category, err := h.CategoryRepo.GetCategoryByID(id)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load category", http.StatusInternalServerError)
+		return
+	}
+	if category == nil {
+		utils.ErrorResponse(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	q, err := parseFeedQuery(r)
+	if err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.CategoryID = id
+	h.serve(w, q)
+}
+
+// serve loads a feed page with its mentions and writes it out
+func (h *FeedHandler) serve(w http.ResponseWriter, q models.FeedQuery) {
+	page, err := h.FeedRepo.Feed(q)
+	if err == repository.ErrInvalidCursor {
+		utils.ErrorResponse(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load feed", http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]string, len(page.Posts))
+	for i := range page.Posts {
+		ids[i] = page.Posts[i].ID
+	}
+	mentions, err := h.MentionRepo.GetByPosts(ids)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load mentions", http.StatusInternalServerError)
+		return
+	}
+	for i := range page.Posts {
+		p := &page.Posts[i]
+		p.Mentions = mentions[p.ID]
+		if p.ImageURL != "" {
+			p.ImageURL = apiStaticBase + p.ImageURL
+			p.ThumbnailURL = apiStaticBase + p.ThumbnailURL
+		}
+		for j := range p.Images {
+			p.Images[j] = publicImage(p.Images[j])
+		}
+	}
+	utils.JSONResponse(w, page, http.StatusOK)
+}
+
+// parseFeedQuery reads sort (newest, comments, likes or hot), limit and cursor
+func parseFeedQuery(r *http.Request) (models.FeedQuery, error) {
+	v := r.URL.Query()
+	q := models.FeedQuery{Sort: v.Get("sort"), Cursor: v.Get("cursor")}
+	switch q.Sort {
+	case "":
+		q.Sort = models.FeedNewest
+	case models.FeedNewest, models.FeedComments, models.FeedLikes, models.FeedHot:
+	default:
+		return q, errors.New("invalid sort, expected newest, comments, likes or hot")
+	}
+	if s := v.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"forum/models"
 	"forum/repository"
 	"forum/utils"
 	"net/http"
@@ -16,6 +17,7 @@ type GuestHandler struct {
 	commentRepo  *repository.CommentRepository
 	reactionRepo *repository.ReactionRepository
 	imageRepo    *repository.ImageRepository
+	mentionRepo  *repository.MentionRepository
 }
 
 type ReactionResponse struct {
@@ -26,13 +28,19 @@ type ReactionResponse struct {
 }
 
 type CommentResponse struct {
-	ID        string             `json:"id"`
-	UserID    string             `json:"user_id"`
-	Username  string             `json:"username"`
-	Content   string             `json:"content"`
-	CreatedAt time.Time          `json:"created_at"`
-	UpdatedAt *time.Time         `json:"updated_at,omitempty"`
-	Reactions []ReactionResponse `json:"reactions,omitempty"`
+	ID          string             `json:"id"`
+	UserID      string             `json:"user_id"`
+	Username    string             `json:"username"`
+	ParentID    *string            `json:"parent_comment_id,omitempty"`
+	Depth       int                `json:"depth"`
+	Content     string             `json:"content"`
+	ContentHTML string             `json:"content_html"`
+	Deleted     bool               `json:"deleted,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   *time.Time         `json:"updated_at,omitempty"`
+	Reactions   []ReactionResponse `json:"reactions,omitempty"`
+	Mentions    []models.Mention   `json:"mentions,omitempty"`
+	Replies     []CommentResponse  `json:"replies,omitempty"`
 }
 
 type PostResponse struct {
@@ -43,12 +51,15 @@ type PostResponse struct {
 	CategoryName string             `json:"category_name"` // NEW FIELD
 	Title        string             `json:"title"`         // Optional title field
 	Content      string             `json:"content"`
+	ContentHTML  string             `json:"content_html"`
 	CreatedAt    time.Time          `json:"created_at"`
 	UpdatedAt    *time.Time         `json:"updated_at,omitempty"`
 	ImageURL     string             `json:"image_url,omitempty"`
 	ThumbnailURL string             `json:"thumbnail_url,omitempty"`
+	Images       []models.GalleryImage `json:"images"`
 	Comments     []CommentResponse  `json:"comments,omitempty"`
 	Reactions    []ReactionResponse `json:"reactions,omitempty"`
+	Mentions     []models.Mention   `json:"mentions,omitempty"`
 }
 
 type CategoryResponse struct {
@@ -67,6 +78,7 @@ func NewGuestHandler(
 	commentRepo *repository.CommentRepository,
 	reactionRepo *repository.ReactionRepository,
 	imageRepo *repository.ImageRepository,
+	mentionRepo *repository.MentionRepository,
 ) *GuestHandler {
 	return &GuestHandler{
 		categoryRepo: categoryRepo,
@@ -74,6 +86,7 @@ func NewGuestHandler(
 		commentRepo:  commentRepo,
 		reactionRepo: reactionRepo,
 		imageRepo:    imageRepo,
+		mentionRepo:  mentionRepo,
 	}
 }
 
@@ -124,6 +137,10 @@ func (h *GuestHandler) GetGuestData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?comments=tree nests replies under their parents; by default comments
+	// are a flat list in thread order, each with its depth
+	nested := r.URL.Query().Get("comments") == "tree"
+
 	var response GuestResponse
 	for _, cat := range categories {
 		catResp := CategoryResponse{
@@ -148,6 +165,7 @@ func (h *GuestHandler) GetGuestData(w http.ResponseWriter, r *http.Request) {
 				CategoryName: cat.Name,   // ✅ inject category name
 				Title:        utils.DerefString(post.Title), // Optional title field
 				Content:      utils.DerefString(post.Content),
+				ContentHTML:  utils.DerefString(post.ContentHTML),
 				CreatedAt:    post.CreatedAt,
 				UpdatedAt:    post.UpdatedAt,
 				Comments:     []CommentResponse{},  // ✅ avoid null
@@ -162,6 +180,13 @@ func (h *GuestHandler) GetGuestData(w http.ResponseWriter, r *http.Request) {
 				postResp.ImageURL = apiStaticBase + imgs[0].FilePath
 				postResp.ThumbnailURL = apiStaticBase + imgs[0].ThumbnailPath
 			}
+			postResp.Images = gallery(imgs)
+
+			postResp.Mentions, err = h.mentionRepo.GetByPost(post.ID)
+			if err != nil {
+				utils.ErrorResponse(w, "Failed to load mentions", http.StatusInternalServerError)
+				return
+			}
 
 			comments, err := h.commentRepo.GetCommentsByPostWithUser(post.ID)
 			if err != nil {
@@ -171,13 +196,23 @@ func (h *GuestHandler) GetGuestData(w http.ResponseWriter, r *http.Request) {
 
 			for _, comment := range comments {
 				commentResp := CommentResponse{
-					ID:        comment.ID,
-					UserID:    comment.UserID,
-					Username:  comment.Username,
-					Content:   utils.DerefString(comment.Content),
-					CreatedAt: comment.CreatedAt,
-					UpdatedAt: comment.UpdatedAt,
-					Reactions: []ReactionResponse{}, // ✅ avoid null
+					ID:          comment.ID,
+					UserID:      comment.UserID,
+					Username:    comment.Username,
+					ParentID:    comment.ParentCommentID,
+					Depth:       comment.Depth,
+					Content:     utils.DerefString(comment.Content),
+					ContentHTML: utils.DerefString(comment.ContentHTML),
+					Deleted:     comment.Content == nil,
+					CreatedAt:   comment.CreatedAt,
+					UpdatedAt:   comment.UpdatedAt,
+					Reactions:   []ReactionResponse{}, // ✅ avoid null
+				}
+
+				commentResp.Mentions, err = h.mentionRepo.GetByComment(comment.ID)
+				if err != nil {
+					utils.ErrorResponse(w, "Failed to load mentions", http.StatusInternalServerError)
+					return
 				}
 
 				reactions, err := h.reactionRepo.GetReactionsByCommentWithUser(comment.ID)
@@ -196,6 +231,9 @@ func (h *GuestHandler) GetGuestData(w http.ResponseWriter, r *http.Request) {
 
 				postResp.Comments = append(postResp.Comments, commentResp)
 			}
+			if nested {
+				postResp.Comments = nestComments(postResp.Comments)
+			}
 
 			reactions, err := h.reactionRepo.GetReactionsByPostWithUser(post.ID)
 			if err != nil {
@@ -219,3 +257,23 @@ func (h *GuestHandler) GetGuestData(w http.ResponseWriter, r *http.Request) {
 
 	utils.JSONResponse(w, response, http.StatusOK)
 }
+
+// nestComments moves each comment's replies into its Replies. comments must
+// be in thread order, where a comment's replies directly follow it and are
+// one level deeper.
+func nestComments(comments []CommentResponse) []CommentResponse {
+	out := []CommentResponse{}
+	for i := 0; i < len(comments); {
+		c := comments[i]
+		end := i + 1
+		for end < len(comments) && comments[end].Depth > c.Depth {
+			end++
+		}
+		if end > i+1 {
+			c.Replies = nestComments(comments[i+1 : end])
+		}
+		out = append(out, c)
+		i = end
+	}
+	return out
+}
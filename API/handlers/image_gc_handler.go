@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+)
+
+// ImageGCHandler lets admins see and remove stored image files no row points
+// at and image rows whose files are gone
+type ImageGCHandler struct {
+	GC *repository.ImageGC
+}
+
+func NewImageGCHandler(gc *repository.ImageGC) *ImageGCHandler {
+	return &ImageGCHandler{GC: gc}
+}
+
+// Preview reports what a pass would delete right now, without changing
+// anything
+func (h *ImageGCHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := h.GC.Preview()
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to scan images", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, report, http.StatusOK)
+}
+
+// Run makes a pass now and reports what was deleted. Query: dry_run=true
+// only reports, as Preview does, but waits its turn with other passes.
+func (h *ImageGCHandler) Run(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := h.GC.Run(models.ImageGCTriggerManual, r.URL.Query().Get("dry_run") == "true")
+	if err == repository.ErrImageGCRunning {
+		utils.ErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil && report == nil {
+		utils.ErrorResponse(w, "Failed to collect images", http.StatusInternalServerError)
+		return
+	}
+	// A partial pass still reports what it did; its error is in the report
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	utils.JSONResponse(w, report, status)
+}
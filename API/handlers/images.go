@@ -1,36 +1,127 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/gif"
-	"image/jpeg"
-	"image/png"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
+	"unicode/utf8"
 
+	"forum/imaging"
 	"forum/middleware"
 	"forum/models"
 	"forum/repository"
+	"forum/storage"
 	"forum/utils"
 )
 
-// uploadBaseDir is relative to the API project root. Images will be served
-// from the API container under /static/.
-const uploadBaseDir = "uploads/images"
+// DefaultMaxImagesPerPost is the gallery size when MAX_IMAGES_PER_POST isn't set
+const DefaultMaxImagesPerPost = 10
+
+// MaxImagesPerPostFromEnv reads the gallery size from MAX_IMAGES_PER_POST
+func MaxImagesPerPostFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("MAX_IMAGES_PER_POST")); err == nil && n > 0 {
+		return n
+	}
+	return DefaultMaxImagesPerPost
+}
 
 type ImageHandler struct {
 	ImageRepo *repository.ImageRepository
+	PostRepo  *repository.PostRepository
+	Store     *storage.Store
+	MaxImages int
+	Imaging   imaging.Config
+}
+
+func NewImageHandler(repo *repository.ImageRepository, postRepo *repository.PostRepository, store *storage.Store, maxImages int, cfg imaging.Config) *ImageHandler {
+	return &ImageHandler{ImageRepo: repo, PostRepo: postRepo, Store: store, MaxImages: maxImages, Imaging: cfg}
+}
+
+// gallery lists a post's images with their public URLs
+func gallery(imgs []models.Image) []models.GalleryImage {
+	out := make([]models.GalleryImage, 0, len(imgs))
+	for _, img := range imgs {
+		g := models.GalleryImage{
+			ID:           img.ID,
+			URL:          img.FilePath,
+			ThumbnailURL: img.ThumbnailPath,
+			Width:        img.Width,
+			Height:       img.Height,
+			Position:     img.Position,
+			Caption:      img.Caption,
+			AltText:      img.AltText,
+		}
+		for _, v := range img.Variants {
+			g.Variants = append(g.Variants, models.GalleryVariant{URL: v.FilePath, Width: v.Width, Height: v.Height, ContentType: v.ContentType})
+		}
+		out = append(out, publicImage(g))
+	}
+	return out
 }
 
-func NewImageHandler(repo *repository.ImageRepository) *ImageHandler {
-	return &ImageHandler{ImageRepo: repo}
+// publicImage turns a gallery image's upload paths into URLs and lists its
+// variants, smallest first, as srcset strings. The original closes Srcset;
+// images without variants get none.
+func publicImage(g models.GalleryImage) models.GalleryImage {
+	g.URL = apiStaticBase + g.URL
+	g.ThumbnailURL = apiStaticBase + g.ThumbnailURL
+	var srcset, webp []string
+	for i := range g.Variants {
+		v := &g.Variants[i]
+		v.URL = apiStaticBase + v.URL
+		entry := fmt.Sprintf("%s %dw", v.URL, v.Width)
+		if v.ContentType == "image/webp" {
+			webp = append(webp, entry)
+		} else {
+			srcset = append(srcset, entry)
+		}
+	}
+	if len(g.Variants) > 0 && g.Width > 0 {
+		srcset = append(srcset, fmt.Sprintf("%s %dw", g.URL, g.Width))
+	}
+	g.Srcset = strings.Join(srcset, ", ")
+	g.SrcsetWebP = strings.Join(webp, ", ")
+	return g
+}
+
+// ownPost answers 404 or 403 unless postID is a post of userID
+func (h *ImageHandler) ownPost(w http.ResponseWriter, userID, postID string) bool {
+	ownerID, err := h.PostRepo.GetPostOwner(postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
+		return false
+	}
+	if ownerID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
 }
 
+// validImageText checks a caption or alt text against its length limit
+func validImageText(w http.ResponseWriter, field, text string) bool {
+	if utf8.RuneCountInString(text) > models.MaxImageTextLength {
+		utils.ErrorResponse(w, fmt.Sprintf("%s must be at most %d characters", field, models.MaxImageTextLength), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// Upload adds an image to the end of a post's gallery, with an optional
+// caption and alt_text
 func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -53,9 +144,11 @@ func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Post ID required", http.StatusBadRequest)
 		return
 	}
-	// Remove any existing images for this post before saving the new one
-	if err := h.ImageRepo.DeleteByPostID(postID); err != nil {
-		utils.ErrorResponse(w, "Failed to remove old images", http.StatusInternalServerError)
+	if !h.ownPost(w, user.ID, postID) {
+		return
+	}
+	caption, altText := r.FormValue("caption"), r.FormValue("alt_text")
+	if !validImageText(w, "caption", caption) || !validImageText(w, "alt_text", altText) {
 		return
 	}
 
@@ -71,6 +164,16 @@ func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	data, err := io.ReadAll(io.LimitReader(file, 20<<20+1))
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to read image", http.StatusBadRequest)
+		return
+	}
+	if len(data) > 20<<20 {
+		utils.ErrorResponse(w, "Image exceeds 20 MB limit", http.StatusBadRequest)
+		return
+	}
+
 	ext := strings.ToLower(filepath.Ext(header.Filename))
 	var contentType string
 	switch ext {
@@ -82,10 +185,7 @@ func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	case ".gif":
 		contentType = "image/gif"
 	default:
-		buf := make([]byte, 512)
-		n, _ := file.Read(buf)
-		contentType = http.DetectContentType(buf[:n])
-		file.Seek(0, 0)
+		contentType = http.DetectContentType(data)
 		switch contentType {
 		case "image/jpeg":
 			ext = ".jpg"
@@ -99,91 +199,134 @@ func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// An upload of content already stored shares its files and sizes
+	sum := sha256.Sum256(data)
+	contentHash := hex.EncodeToString(sum[:])
+	if prior, err := h.ImageRepo.FindByHash(contentHash); err == nil {
+		created, err := h.ImageRepo.Create(models.Image{
+			PostID:        postID,
+			UserID:        user.ID,
+			FilePath:      prior.FilePath,
+			ThumbnailPath: prior.ThumbnailPath,
+			ContentHash:   contentHash,
+			Width:         prior.Width,
+			Height:        prior.Height,
+			Caption:       caption,
+			AltText:       altText,
+			Variants:      prior.Variants,
+		}, h.MaxImages)
+		if err == nil {
+			utils.JSONResponse(w, created, http.StatusCreated)
+			return
+		}
+		if errors.Is(err, repository.ErrGalleryFull) {
+			utils.ErrorResponse(w, fmt.Sprintf("A post can have at most %d images", h.MaxImages), http.StatusBadRequest)
+			return
+		}
+		if !errors.Is(err, repository.ErrImageFilesMissing) {
+			utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
+			return
+		}
+		// The files went with their last row; make them again below
+	}
+
+	// JPEGs and PNGs are re-encoded upright, which leaves their metadata
+	// behind; GIFs are stored as they decode, frames only
 	var img image.Image
 	var gifData *gif.GIF
-	switch contentType {
-	case "image/jpeg":
-		img, err = jpeg.Decode(file)
-	case "image/png":
-		img, err = png.Decode(file)
-	case "image/gif":
-		gifData, err = gif.DecodeAll(file)
+	if _, err := h.Imaging.DecodeConfig(data, contentType); errors.Is(err, imaging.ErrTooManyPixels) {
+		utils.ErrorResponse(w, fmt.Sprintf("Image is too large: at most %d pixels", h.Imaging.MaxPixels), http.StatusBadRequest)
+		return
+	}
+	if contentType == "image/gif" {
+		gifData, err = gif.DecodeAll(bytes.NewReader(data))
 		if err == nil && len(gifData.Image) > 0 {
 			img = gifData.Image[0]
 		}
+	} else {
+		img, err = h.Imaging.Decode(data, contentType)
 	}
-	if err != nil {
+	if err != nil || img == nil {
 		utils.ErrorResponse(w, "Failed to decode image", http.StatusBadRequest)
 		return
 	}
 
-	dateStr := time.Now().Format("2006-01-02")
-	baseDir := filepath.Join(uploadBaseDir, user.ID, dateStr)
-	thumbDir := filepath.Join(baseDir, "thumbnails")
-	if err := os.MkdirAll(thumbDir, 0755); err != nil {
-		utils.ErrorResponse(w, "Failed to create directory", http.StatusInternalServerError)
-		return
-	}
-
-	uuid := utils.GenerateUUID()
-	fileName := uuid + ext
-	filePath := filepath.Join(baseDir, fileName)
-	out, err := os.Create(filePath)
-	if err != nil {
-		utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
-		return
-	}
+	// files holds the original, the thumbnail and then each variant, in the
+	// order their keys are filled in below
+	var files []storedFile
+	var original, thumb []byte
 	if contentType == "image/gif" {
-		if err := gif.EncodeAll(out, gifData); err != nil {
-			out.Close()
-			utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
-			return
+		original, err = encodeBytes(func(out io.Writer) error { return gif.EncodeAll(out, gifData) })
+		if err == nil {
+			thumb, err = encodeBytes(func(out io.Writer) error { return gif.EncodeAll(out, createThumbnailGIF(gifData)) })
 		}
 	} else {
-		if err := encodeImage(out, img, contentType); err != nil {
-			out.Close()
-			utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
-			return
+		original, err = encodeBytes(func(out io.Writer) error { return h.Imaging.Encode(out, img, contentType) })
+		if err == nil {
+			thumbImg := createThumbnail(img, contentType != "image/jpeg")
+			thumb, err = encodeBytes(func(out io.Writer) error { return h.Imaging.Encode(out, thumbImg, contentType) })
 		}
 	}
-	out.Close()
-
-	thumbPath := filepath.Join(thumbDir, fileName)
-	outT, err := os.Create(thumbPath)
 	if err != nil {
-		utils.ErrorResponse(w, "Failed to save thumbnail", http.StatusInternalServerError)
+		utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
 		return
 	}
+	files = append(files, storedFile{original, ext}, storedFile{thumb, ext})
 
-	if contentType == "image/gif" {
-		thumbGIF := createThumbnailGIF(gifData)
-		if err := gif.EncodeAll(outT, thumbGIF); err != nil {
-			outT.Close()
-			utils.ErrorResponse(w, "Failed to save thumbnail", http.StatusInternalServerError)
+	imgModel := models.Image{
+		PostID:      postID,
+		UserID:      user.ID,
+		ContentHash: contentHash,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Caption:     caption,
+		AltText:     altText,
+	}
+	if gifData != nil && gifData.Config.Width > 0 {
+		imgModel.Width, imgModel.Height = gifData.Config.Width, gifData.Config.Height
+	}
+
+	if contentType != "image/gif" {
+		variants, err := h.Imaging.Variants(img, contentType)
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
 			return
 		}
-	} else {
-		thumbImg := createThumbnail(img, contentType != "image/jpeg")
-		if err := encodeImage(outT, thumbImg, contentType); err != nil {
-			outT.Close()
-			utils.ErrorResponse(w, "Failed to save thumbnail", http.StatusInternalServerError)
-			return
+		for _, v := range variants {
+			vExt := ext
+			if v.ContentType == "image/webp" {
+				vExt = ".webp"
+			}
+			files = append(files, storedFile{v.Data, vExt})
+			imgModel.Variants = append(imgModel.Variants, models.ImageVariant{Width: v.Width, Height: v.Height, ContentType: v.ContentType})
 		}
 	}
-	outT.Close()
 
-	// Store paths relative to the "uploads" directory so they can be served
-	// via the /static/ route.
-	relPath := filepath.ToSlash(strings.TrimPrefix(filePath, "uploads/"))
-	relThumb := filepath.ToSlash(strings.TrimPrefix(thumbPath, "uploads/"))
-	imgModel := models.Image{
-		PostID:        postID,
-		UserID:        user.ID,
-		FilePath:      relPath,
-		ThumbnailPath: relThumb,
+	// A delete can take a file shared with an older upload between storing
+	// it and adding the row; storing it again and retrying once covers that.
+	// Files left behind by a failed upload are for the garbage collector.
+	var created *models.Image
+	for attempt := 0; attempt < 2; attempt++ {
+		keys := make([]string, len(files))
+		for i, f := range files {
+			if keys[i], err = h.Store.Put(f.data, f.ext); err != nil {
+				utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
+				return
+			}
+		}
+		imgModel.FilePath, imgModel.ThumbnailPath = keys[0], keys[1]
+		for i := range imgModel.Variants {
+			imgModel.Variants[i].FilePath = keys[2+i]
+		}
+		created, err = h.ImageRepo.Create(imgModel, h.MaxImages)
+		if !errors.Is(err, repository.ErrImageFilesMissing) {
+			break
+		}
+	}
+	if errors.Is(err, repository.ErrGalleryFull) {
+		utils.ErrorResponse(w, fmt.Sprintf("A post can have at most %d images", h.MaxImages), http.StatusBadRequest)
+		return
 	}
-
-	created, err := h.ImageRepo.Create(imgModel)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
 		return
@@ -192,16 +335,20 @@ func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	utils.JSONResponse(w, created, http.StatusCreated)
 }
 
-func encodeImage(w *os.File, img image.Image, contentType string) error {
-	switch contentType {
-	case "image/jpeg":
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
-	case "image/png":
-		return png.Encode(w, img)
-	case "image/gif":
-		return gif.Encode(w, img, nil)
+// storedFile is an encoded file waiting to be stored, with the extension
+// its key gets
+type storedFile struct {
+	data []byte
+	ext  string
+}
+
+// encodeBytes returns what encode writes
+func encodeBytes(encode func(io.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return nil, err
 	}
-	return nil
+	return buf.Bytes(), nil
 }
 
 func createThumbnail(src image.Image, keepAlpha bool) image.Image {
@@ -297,19 +444,156 @@ func createThumbnailGIF(src *gif.GIF) *gif.GIF {
 	return dstGif
 }
 
+// DeleteImagesByPost empties a post's gallery
 func (h *ImageHandler) DeleteImagesByPost(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 	postID := utils.GetLastPathParam(r)
 	if postID == "" {
 		utils.ErrorResponse(w, "Missing post ID", http.StatusBadRequest)
 		return
 	}
+	if !h.ownPost(w, user.ID, postID) {
+		return
+	}
 	if err := h.ImageRepo.DeleteByPostID(postID); err != nil {
 		utils.ErrorResponse(w, "Failed to delete images", http.StatusInternalServerError)
 		return
 	}
 	utils.JSONResponse(w, map[string]string{"status": "images deleted"}, http.StatusOK)
 }
+
+// DeleteImage removes one image from its post's gallery; the ones after it
+// move up
+func (h *ImageHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	img, ok := h.ownImage(w, user.ID, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+	if err := h.ImageRepo.Delete(img.ID); err != nil && !errors.Is(err, repository.ErrImageNotFound) {
+		utils.ErrorResponse(w, "Failed to delete image", http.StatusInternalServerError)
+		return
+	}
+	h.respondGallery(w, img.PostID)
+}
+
+// EditImage sets an image's caption and alt text
+func (h *ImageHandler) EditImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Caption string `json:"caption"`
+		AltText string `json:"alt_text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !validImageText(w, "caption", req.Caption) || !validImageText(w, "alt_text", req.AltText) {
+		return
+	}
+	img, ok := h.ownImage(w, user.ID, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+	if err := h.ImageRepo.UpdateText(img.ID, req.Caption, req.AltText); errors.Is(err, repository.ErrImageNotFound) {
+		utils.ErrorResponse(w, "Image not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.ErrorResponse(w, "Failed to update image", http.StatusInternalServerError)
+		return
+	}
+	h.respondGallery(w, img.PostID)
+}
+
+// ReorderImages puts a post's gallery in the order of image_ids, which must
+// list every image of the post once
+func (h *ImageHandler) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	postID := utils.GetLastPathParam(r)
+	if postID == "" {
+		utils.ErrorResponse(w, "Missing post ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		ImageIDs []string `json:"image_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.ownPost(w, user.ID, postID) {
+		return
+	}
+	if err := h.ImageRepo.Reorder(postID, req.ImageIDs); errors.Is(err, repository.ErrInvalidImageOrder) {
+		utils.ErrorResponse(w, "image_ids must list each of the post's images once", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		utils.ErrorResponse(w, "Failed to reorder images", http.StatusInternalServerError)
+		return
+	}
+	h.respondGallery(w, postID)
+}
+
+// ownImage loads an image on a post of userID, answering 404 or 403
+// otherwise
+func (h *ImageHandler) ownImage(w http.ResponseWriter, userID, imageID string) (*models.Image, bool) {
+	if imageID == "" {
+		utils.ErrorResponse(w, "Missing image ID", http.StatusBadRequest)
+		return nil, false
+	}
+	img, err := h.ImageRepo.GetByID(imageID)
+	if errors.Is(err, repository.ErrImageNotFound) {
+		utils.ErrorResponse(w, "Image not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !h.ownPost(w, userID, img.PostID) {
+		return nil, false
+	}
+	return img, true
+}
+
+// respondGallery answers with a post's gallery as it now stands
+func (h *ImageHandler) respondGallery(w http.ResponseWriter, postID string) {
+	imgs, err := h.ImageRepo.GetByPostID(postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, map[string]interface{}{"post_id": postID, "images": gallery(imgs)}, http.StatusOK)
+}
@@ -56,12 +56,15 @@ func (h *LikedPostsHandler) GetLikedPosts(w http.ResponseWriter, r *http.Request
 		var commentResp []CommentResponse
 		for _, c := range comments {
 			cr := CommentResponse{
-				ID:        c.ID,
-				UserID:    c.UserID,
-				Username:  c.Username,
-				Content:   utils.DerefString(c.Content),
-				CreatedAt: c.CreatedAt,
-				Reactions: []ReactionResponse{},
+				ID:          c.ID,
+				UserID:      c.UserID,
+				Username:    c.Username,
+				ParentID:    c.ParentCommentID,
+				Depth:       c.Depth,
+				Content:     utils.DerefString(c.Content),
+				ContentHTML: utils.DerefString(c.ContentHTML),
+				CreatedAt:   c.CreatedAt,
+				Reactions:   []ReactionResponse{},
 			}
 			reactions, err := h.ReactionRepo.GetReactionsByCommentWithUser(c.ID)
 			if err != nil {
@@ -112,8 +115,10 @@ func (h *LikedPostsHandler) GetLikedPosts(w http.ResponseWriter, r *http.Request
 			Categories:   catInfo,
 			Title:        utils.DerefString(post.Title),
 			Content:      utils.DerefString(post.Content),
+			ContentHTML:  utils.DerefString(post.ContentHTML),
 			ImageURL:     imgURL,
 			ThumbnailURL: thumbURL,
+			Images:       gallery(imgs),
 			CreatedAt:    post.CreatedAt,
 			Comments:     commentResp,
 			Reactions:    reactResp,
@@ -161,12 +166,15 @@ func (h *LikedPostsHandler) GetDislikedPosts(w http.ResponseWriter, r *http.Requ
 		var commentResp []CommentResponse
 		for _, c := range comments {
 			cr := CommentResponse{
-				ID:        c.ID,
-				UserID:    c.UserID,
-				Username:  c.Username,
-				Content:   utils.DerefString(c.Content),
-				CreatedAt: c.CreatedAt,
-				Reactions: []ReactionResponse{},
+				ID:          c.ID,
+				UserID:      c.UserID,
+				Username:    c.Username,
+				ParentID:    c.ParentCommentID,
+				Depth:       c.Depth,
+				Content:     utils.DerefString(c.Content),
+				ContentHTML: utils.DerefString(c.ContentHTML),
+				CreatedAt:   c.CreatedAt,
+				Reactions:   []ReactionResponse{},
 			}
 			reactions, err := h.ReactionRepo.GetReactionsByCommentWithUser(c.ID)
 			if err != nil {
@@ -217,8 +225,10 @@ func (h *LikedPostsHandler) GetDislikedPosts(w http.ResponseWriter, r *http.Requ
 			Categories:   catInfo,
 			Title:        utils.DerefString(post.Title),
 			Content:      utils.DerefString(post.Content),
+			ContentHTML:  utils.DerefString(post.ContentHTML),
 			ImageURL:     imgURL,
 			ThumbnailURL: thumbURL,
+			Images:       gallery(imgs),
 			CreatedAt:    post.CreatedAt,
 			Comments:     commentResp,
 			Reactions:    reactResp,
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/realtime"
+	"forum/utils"
+)
+
+const (
+	// livePingInterval is how often the server pings an open WebSocket
+	livePingInterval = 25 * time.Second
+	// liveReadTimeout closes connections that stop answering pings
+	liveReadTimeout = 60 * time.Second
+	// liveMaxSubscriptions caps channels per connection
+	liveMaxSubscriptions = 50
+)
+
+// Channel names clients use in subscribe commands
+const (
+	liveNotificationsChannel = "notifications"
+	livePostChannelPrefix    = "post:"
+)
+
+// LiveHandler serves the WebSocket endpoint for notifications and post activity
+type LiveHandler struct {
+	Hub *realtime.Hub
+}
+
+func NewLiveHandler(hub *realtime.Hub) *LiveHandler {
+	return &LiveHandler{Hub: hub}
+}
+
+// liveCommand is a JSON message sent by the client
+type liveCommand struct {
+	Action      string `json:"action"` // subscribe, unsubscribe or ping
+	Channel     string `json:"channel"`
+	LastEventID uint64 `json:"last_event_id,omitempty"`
+}
+
+// liveFrame is a JSON message sent to the client
+type liveFrame struct {
+	Type    string          `json:"type"`
+	Channel string          `json:"channel,omitempty"`
+	ID      uint64          `json:"id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+// liveSession tracks the hub subscriptions of one WebSocket connection
+type liveSession struct {
+	hub  *realtime.Hub
+	conn *realtime.Conn
+	user *models.User
+
+	mu   sync.Mutex
+	subs map[string]*realtime.Client // keyed by the client-facing channel name
+}
+
+// ServeWS upgrades the request and relays subscribed channels as JSON frames
+func (h *LiveHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	conn, err := realtime.Upgrade(w, r)
+	if err != nil {
+		utils.ErrorResponse(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return
+	}
+
+	s := &liveSession{hub: h.Hub, conn: conn, user: user, subs: make(map[string]*realtime.Client)}
+	defer s.close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.pingLoop(done)
+
+	conn.SetReadTimeout(liveReadTimeout)
+	for {
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("LiveHandler [DEBUG]: connection for user %s ended: %v", user.ID, err)
+			}
+			return
+		}
+		if op != realtime.OpText {
+			s.send(liveFrame{Type: "error", Message: "text frames only"})
+			continue
+		}
+		var cmd liveCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			s.send(liveFrame{Type: "error", Message: "invalid JSON"})
+			continue
+		}
+		s.handle(cmd)
+	}
+}
+
+func (s *liveSession) handle(cmd liveCommand) {
+	switch cmd.Action {
+	case "ping":
+		s.send(liveFrame{Type: "pong"})
+	case "subscribe":
+		hubChannel, ok := s.resolve(cmd.Channel)
+		if !ok {
+			s.send(liveFrame{Type: "error", Channel: cmd.Channel, Message: "unknown channel"})
+			return
+		}
+		s.subscribe(cmd.Channel, hubChannel, cmd.LastEventID)
+	case "unsubscribe":
+		s.mu.Lock()
+		c := s.subs[cmd.Channel]
+		delete(s.subs, cmd.Channel)
+		s.mu.Unlock()
+		if c != nil {
+			s.hub.Unsubscribe(c)
+		}
+		s.send(liveFrame{Type: "unsubscribed", Channel: cmd.Channel})
+	default:
+		s.send(liveFrame{Type: "error", Message: "unknown action"})
+	}
+}
+
+// resolve maps a client channel name to a hub channel. Users may only listen
+// to their own inbox; posts are public so any post can be watched.
+func (s *liveSession) resolve(channel string) (string, bool) {
+	if channel == liveNotificationsChannel {
+		return realtime.UserChannel(s.user.ID), true
+	}
+	if strings.HasPrefix(channel, livePostChannelPrefix) {
+		postID := strings.TrimPrefix(channel, livePostChannelPrefix)
+		if postID == "" {
+			return "", false
+		}
+		return realtime.PostChannel(postID), true
+	}
+	return "", false
+}
+
+func (s *liveSession) subscribe(name, hubChannel string, lastEventID uint64) {
+	s.mu.Lock()
+	if _, exists := s.subs[name]; exists {
+		s.mu.Unlock()
+		s.send(liveFrame{Type: "subscribed", Channel: name})
+		return
+	}
+	if len(s.subs) >= liveMaxSubscriptions {
+		s.mu.Unlock()
+		s.send(liveFrame{Type: "error", Channel: name, Message: "too many subscriptions"})
+		return
+	}
+	client, missed, complete := s.hub.Subscribe(hubChannel, lastEventID)
+	s.subs[name] = client
+	s.mu.Unlock()
+
+	s.send(liveFrame{Type: "subscribed", Channel: name})
+	if !complete {
+		s.send(liveFrame{Type: "resync", Channel: name})
+	}
+	for _, e := range missed {
+		s.send(eventFrame(name, e))
+	}
+	go s.relay(name, client)
+}
+
+// relay forwards hub events for one subscription until it is removed
+func (s *liveSession) relay(name string, client *realtime.Client) {
+	for e := range client.Events {
+		if err := s.send(eventFrame(name, e)); err != nil {
+			s.conn.Close()
+			return
+		}
+	}
+	// The channel was closed. If we did not unsubscribe ourselves the hub
+	// dropped us for being slow, so tell the client to refetch.
+	s.mu.Lock()
+	dropped := s.subs[name] == client
+	if dropped {
+		delete(s.subs, name)
+	}
+	s.mu.Unlock()
+	if dropped {
+		s.send(liveFrame{Type: "resync", Channel: name})
+	}
+}
+
+func (s *liveSession) pingLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(livePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteMessage(realtime.OpPing, nil); err != nil {
+				s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *liveSession) send(f liveFrame) error {
+	return s.conn.WriteJSON(f)
+}
+
+func (s *liveSession) close() {
+	s.mu.Lock()
+	subs := s.subs
+	s.subs = map[string]*realtime.Client{}
+	s.mu.Unlock()
+	for _, c := range subs {
+		s.hub.Unsubscribe(c)
+	}
+	s.conn.WriteClose(realtime.CloseNormal)
+	s.conn.Close()
+}
+
+func eventFrame(channel string, e realtime.Event) liveFrame {
+	return liveFrame{Type: e.Type, Channel: channel, ID: e.ID, Data: e.Data}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"forum/locale"
+	"forum/middleware"
+	nrepo "forum/repository/notification"
+	"forum/repository/user"
+	"forum/utils"
+)
+
+// LocaleHandler lets users pick the language of their notifications
+type LocaleHandler struct {
+	Users *user.UserRepository
+}
+
+func NewLocaleHandler(users *user.UserRepository) *LocaleHandler {
+	return &LocaleHandler{Users: users}
+}
+
+type localeBody struct {
+	// Locale is the user's choice; empty means follow Accept-Language
+	Locale string `json:"locale"`
+	// Effective is the locale messages are rendered in for this request
+	Effective string   `json:"effective,omitempty"`
+	Supported []string `json:"supported,omitempty"`
+}
+
+// Locale serves GET (read the setting) and PUT (change or clear it)
+func (h *LocaleHandler) Locale(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req localeBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorResponse(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Locale != "" && !locale.IsSupported(req.Locale) {
+			utils.ErrorResponse(w, "unsupported locale: "+req.Locale, http.StatusBadRequest)
+			return
+		}
+		if err := h.Users.SetLocale(user.ID, req.Locale); err != nil {
+			utils.ErrorResponse(w, "failed to save locale", http.StatusInternalServerError)
+			return
+		}
+	default:
+		utils.ErrorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stored, err := h.Users.GetLocale(user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "failed to load locale", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, localeBody{
+		Locale:    stored,
+		Effective: readerLocale(h.Users, r, user.ID),
+		Supported: locale.Supported(),
+	}, http.StatusOK)
+}
+
+// readerLocale picks the locale to render messages in for a request: the
+// user's saved choice, else the best match for Accept-Language
+func readerLocale(users nrepo.LocaleLookup, r *http.Request, userID string) string {
+	if users != nil {
+		if stored, err := users.GetLocale(userID); err == nil && locale.IsSupported(stored) {
+			return stored
+		}
+	}
+	return locale.Match(r.Header.Get("Accept-Language"))
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"log"
+
+	"forum/models"
+	"forum/repository"
+	nrepo "forum/repository/notification"
+	"forum/utils"
+)
+
+// UsernameLookup resolves a username to a user
+type UsernameLookup interface {
+	GetByUsername(username string) (*models.User, error)
+}
+
+// MentionTracker stores the @mentions in post and comment content and
+// notifies users the first time they are mentioned in each one
+type MentionTracker struct {
+	Repo     *repository.MentionRepository
+	Users    UsernameLookup
+	Notifier *nrepo.Dispatcher
+}
+
+func NewMentionTracker(repo *repository.MentionRepository, users UsernameLookup, notifier *nrepo.Dispatcher) *MentionTracker {
+	return &MentionTracker{Repo: repo, Users: users, Notifier: notifier}
+}
+
+// TrackPost records the mentions in a post's content. The post is already
+// saved, so failures are logged and an empty list returned.
+func (t *MentionTracker) TrackPost(actorID, postID, content string) []models.Mention {
+	mentions := t.resolve(content)
+	added, err := t.Repo.ReplaceForPost(postID, mentions)
+	if err != nil {
+		log.Printf("Mentions [ERROR]: store mentions for post %s: %v", postID, err)
+		return []models.Mention{}
+	}
+	t.notify(actorID, postID, "", added)
+	return mentions
+}
+
+// TrackComment records the mentions in a comment's content, like TrackPost
+func (t *MentionTracker) TrackComment(actorID, postID, commentID, content string) []models.Mention {
+	mentions := t.resolve(content)
+	added, err := t.Repo.ReplaceForComment(commentID, mentions)
+	if err != nil {
+		log.Printf("Mentions [ERROR]: store mentions for comment %s: %v", commentID, err)
+		return []models.Mention{}
+	}
+	t.notify(actorID, postID, commentID, added)
+	return mentions
+}
+
+// ForgetPost drops the mentions of a deleted post
+func (t *MentionTracker) ForgetPost(postID string) {
+	if _, err := t.Repo.ReplaceForPost(postID, nil); err != nil {
+		log.Printf("Mentions [ERROR]: clear mentions for post %s: %v", postID, err)
+	}
+}
+
+// ForgetComment drops the mentions of a deleted comment
+func (t *MentionTracker) ForgetComment(commentID string) {
+	if _, err := t.Repo.ReplaceForComment(commentID, nil); err != nil {
+		log.Printf("Mentions [ERROR]: clear mentions for comment %s: %v", commentID, err)
+	}
+}
+
+// resolve turns @username tokens into mentions, skipping unknown names
+func (t *MentionTracker) resolve(content string) []models.Mention {
+	mentions := []models.Mention{}
+	users := make(map[string]*models.User)
+	for _, tok := range utils.ParseMentions(content) {
+		u, seen := users[tok.Username]
+		if !seen {
+			found, err := t.Users.GetByUsername(tok.Username)
+			if err != nil && err != repository.ErrUserNotFound {
+				log.Printf("Mentions [WARN]: look up %q: %v", tok.Username, err)
+			}
+			u = found
+			users[tok.Username] = u
+		}
+		if u == nil {
+			continue
+		}
+		mentions = append(mentions, models.Mention{UserID: u.ID, Username: u.Username, Start: tok.Start, End: tok.End})
+	}
+	return mentions
+}
+
+func (t *MentionTracker) notify(actorID, postID, commentID string, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	t.Notifier.Dispatch(nrepo.Mentioned{ActorID: actorID, PostID: postID, CommentID: commentID, UserIDs: userIDs})
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"forum/middleware"
+	"forum/models"
 	"forum/repository"
 	"forum/utils"
 )
@@ -26,18 +27,20 @@ type CategoryInfo struct {
 }
 
 type MyPostResponse struct {
-	ID           string             `json:"id"`
-	UserID       string             `json:"user_id"`
-	Username     string             `json:"username"`
-	Categories   []CategoryInfo     `json:"categories"`
-	Title        string             `json:"title"`
-	Content      string             `json:"content"`
-	ImageURL     string             `json:"image_url,omitempty"`
-	ThumbnailURL string             `json:"thumbnail_url,omitempty"`
-	CreatedAt    time.Time          `json:"created_at"`
-	UpdatedAt    *time.Time         `json:"updated_at,omitempty"`
-	Comments     []CommentResponse  `json:"comments,omitempty"`
-	Reactions    []ReactionResponse `json:"reactions,omitempty"`
+	ID           string                `json:"id"`
+	UserID       string                `json:"user_id"`
+	Username     string                `json:"username"`
+	Categories   []CategoryInfo        `json:"categories"`
+	Title        string                `json:"title"`
+	Content      string                `json:"content"`
+	ContentHTML  string                `json:"content_html"`
+	ImageURL     string                `json:"image_url,omitempty"`
+	ThumbnailURL string                `json:"thumbnail_url,omitempty"`
+	Images       []models.GalleryImage `json:"images"`
+	CreatedAt    time.Time             `json:"created_at"`
+	UpdatedAt    *time.Time            `json:"updated_at,omitempty"`
+	Comments     []CommentResponse     `json:"comments,omitempty"`
+	Reactions    []ReactionResponse    `json:"reactions,omitempty"`
 }
 
 func (h *MyPostsHandler) GetMyPosts(w http.ResponseWriter, r *http.Request) {
@@ -78,13 +81,16 @@ func (h *MyPostsHandler) GetMyPosts(w http.ResponseWriter, r *http.Request) {
 		var commentResp []CommentResponse
 		for _, c := range comments {
 			cr := CommentResponse{
-				ID:        c.ID,
-				UserID:    c.UserID,
-				Username:  c.Username,
-				Content:   utils.DerefString(c.Content),
-				CreatedAt: c.CreatedAt,
-				UpdatedAt: c.UpdatedAt,
-				Reactions: []ReactionResponse{},
+				ID:          c.ID,
+				UserID:      c.UserID,
+				Username:    c.Username,
+				ParentID:    c.ParentCommentID,
+				Depth:       c.Depth,
+				Content:     utils.DerefString(c.Content),
+				ContentHTML: utils.DerefString(c.ContentHTML),
+				CreatedAt:   c.CreatedAt,
+				UpdatedAt:   c.UpdatedAt,
+				Reactions:   []ReactionResponse{},
 			}
 			reactions, err := h.ReactionRepo.GetReactionsByCommentWithUser(c.ID)
 			if err != nil {
@@ -135,8 +141,10 @@ func (h *MyPostsHandler) GetMyPosts(w http.ResponseWriter, r *http.Request) {
 			Categories:   catInfo,
 			Title:        utils.DerefString(post.Title),
 			Content:      utils.DerefString(post.Content),
+			ContentHTML:  utils.DerefString(post.ContentHTML),
 			ImageURL:     imgURL,
 			ThumbnailURL: thumbURL,
+			Images:       gallery(imgs),
 			CreatedAt:    post.CreatedAt,
 			UpdatedAt:    post.UpdatedAt,
 			Comments:     commentResp,
@@ -185,13 +193,16 @@ func (h *MyPostsHandler) GetCommentedPosts(w http.ResponseWriter, r *http.Reques
 		var commentResp []CommentResponse
 		for _, c := range comments {
 			cr := CommentResponse{
-				ID:        c.ID,
-				UserID:    c.UserID,
-				Username:  c.Username,
-				Content:   utils.DerefString(c.Content),
-				CreatedAt: c.CreatedAt,
-				UpdatedAt: c.UpdatedAt,
-				Reactions: []ReactionResponse{},
+				ID:          c.ID,
+				UserID:      c.UserID,
+				Username:    c.Username,
+				ParentID:    c.ParentCommentID,
+				Depth:       c.Depth,
+				Content:     utils.DerefString(c.Content),
+				ContentHTML: utils.DerefString(c.ContentHTML),
+				CreatedAt:   c.CreatedAt,
+				UpdatedAt:   c.UpdatedAt,
+				Reactions:   []ReactionResponse{},
 			}
 			reactions, err := h.ReactionRepo.GetReactionsByCommentWithUser(c.ID)
 			if err != nil {
@@ -242,8 +253,10 @@ func (h *MyPostsHandler) GetCommentedPosts(w http.ResponseWriter, r *http.Reques
 			Categories:   catInfo,
 			Title:        utils.DerefString(post.Title),
 			Content:      utils.DerefString(post.Content),
+			ContentHTML:  utils.DerefString(post.ContentHTML),
 			ImageURL:     imgURL,
 			ThumbnailURL: thumbURL,
+			Images:       gallery(imgs),
 			CreatedAt:    post.CreatedAt,
 			UpdatedAt:    post.UpdatedAt,
 			Comments:     commentResp,
@@ -1,17 +1,36 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"forum/locale"
 	"forum/middleware"
+	"forum/models"
+	"forum/realtime"
+	"forum/repository"
 	nrepo "forum/repository/notification"
 	"forum/utils"
 )
 
-type NotificationHandler struct{ Repo *nrepo.Repository }
+// streamHeartbeat is how often an idle notification stream sends a keep-alive comment
+const streamHeartbeat = 25 * time.Second
 
-func NewNotificationHandler(r *nrepo.Repository) *NotificationHandler {
-	return &NotificationHandler{Repo: r}
+// maxSnooze is the furthest ahead a notification can be snoozed
+const maxSnooze = 365 * 24 * time.Hour
+
+type NotificationHandler struct {
+	Repo    *nrepo.Repository
+	Hub     *realtime.Hub
+	Locales nrepo.LocaleLookup
+}
+
+func NewNotificationHandler(r *nrepo.Repository, hub *realtime.Hub, locales nrepo.LocaleLookup) *NotificationHandler {
+	return &NotificationHandler{Repo: r, Hub: hub, Locales: locales}
 }
 
 func (h *NotificationHandler) GetUserNotifications(w http.ResponseWriter, r *http.Request) {
@@ -20,12 +39,142 @@ func (h *NotificationHandler) GetUserNotifications(w http.ResponseWriter, r *htt
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	notes, err := h.Repo.GetByUser(user.ID)
+	filter, err := parseNotificationFilter(r)
+	if err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := h.Repo.GetByUser(user.ID, filter)
+	if err == repository.ErrInvalidCursor {
+		utils.ErrorResponse(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		utils.ErrorResponse(w, "failed to load notifications", http.StatusInternalServerError)
 		return
 	}
-	utils.JSONResponse(w, notes, http.StatusOK)
+	lang := readerLocale(h.Locales, r, user.ID)
+	for i := range page.Notifications {
+		locale.Render(lang, &page.Notifications[i])
+	}
+	utils.JSONResponse(w, page, http.StatusOK)
+}
+
+// parseNotificationFilter reads the inbox query parameters:
+// limit, cursor, unread_only, snoozed, type, post_id, since and until (RFC 3339)
+func parseNotificationFilter(r *http.Request) (models.NotificationFilter, error) {
+	q := r.URL.Query()
+	f := models.NotificationFilter{
+		Cursor: q.Get("cursor"),
+		Type:   q.Get("type"),
+		PostID: q.Get("post_id"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return f, errors.New("invalid limit")
+		}
+		f.Limit = limit
+	}
+	if v := q.Get("unread_only"); v != "" {
+		unread, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.New("invalid unread_only")
+		}
+		f.UnreadOnly = unread
+	}
+	if v := q.Get("snoozed"); v != "" {
+		snoozed, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.New("invalid snoozed")
+		}
+		f.Snoozed = snoozed
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, errors.New("invalid since, expected RFC 3339")
+		}
+		f.Since = &t
+	}
+	if v := q.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, errors.New("invalid until, expected RFC 3339")
+		}
+		f.Until = &t
+	}
+	return f, nil
+}
+
+// Stream pushes the user's notifications and unread count as Server-Sent Events
+func (h *NotificationHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.ErrorResponse(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Browsers send Last-Event-ID on reconnect; allow a query param for manual clients
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	lastEventID, _ := strconv.ParseUint(lastID, 10, 64)
+
+	client, missed, complete := h.Hub.Subscribe(realtime.UserChannel(user.ID), lastEventID)
+	defer h.Hub.Unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if !complete {
+		// Too much was missed to replay; the client should reload its inbox
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		writeStreamEvent(w, e)
+	}
+	if count, err := h.Repo.CountUnread(user.ID); err == nil {
+		fmt.Fprintf(w, "event: %s\ndata: {\"unread\":%d}\n\n", realtime.EventUnreadCount, count)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-client.Events:
+			if !ok {
+				// Dropped by the hub; the browser will reconnect and replay
+				return
+			}
+			writeStreamEvent(w, e)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, e realtime.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
 }
 
 func (h *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
@@ -123,3 +272,141 @@ func (h *NotificationHandler) DeleteAll(w http.ResponseWriter, r *http.Request)
 	}
 	utils.JSONResponse(w, map[string]string{"status": "deleted"}, http.StatusOK)
 }
+
+// UnreadCount returns the user's unread count without loading the inbox
+func (h *NotificationHandler) UnreadCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	count, err := h.Repo.CountUnread(user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "failed", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, realtime.UnreadCount{Unread: count}, http.StatusOK)
+}
+
+// Bulk marks several notifications read or unread, or deletes them, in one
+// transaction. Nothing changes if any ID is not the user's.
+func (h *NotificationHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req models.NotificationBulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		utils.ErrorResponse(w, "missing ids", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > nrepo.MaxBulkIDs {
+		utils.ErrorResponse(w, fmt.Sprintf("at most %d ids per request", nrepo.MaxBulkIDs), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch req.Action {
+	case models.NotificationBulkRead:
+		err = h.Repo.MarkReadMany(req.IDs, user.ID)
+	case models.NotificationBulkUnread:
+		err = h.Repo.MarkUnreadMany(req.IDs, user.ID)
+	case models.NotificationBulkDelete:
+		err = h.Repo.SoftDeleteMany(req.IDs, user.ID)
+	default:
+		utils.ErrorResponse(w, "action must be read, unread or delete", http.StatusBadRequest)
+		return
+	}
+	if err == nrepo.ErrNotificationsNotFound {
+		utils.ErrorResponse(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "failed", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, map[string]interface{}{"status": req.Action, "count": len(req.IDs)}, http.StatusOK)
+}
+
+// Snooze hides a notification until the requested time
+func (h *NotificationHandler) Snooze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id := utils.GetLastPathParam(r)
+	if id == "" {
+		utils.ErrorResponse(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	var req models.NotificationSnoozeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "invalid request body, expected {\"until\": RFC 3339 time}", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if !req.Until.After(now) {
+		utils.ErrorResponse(w, "until must be in the future", http.StatusBadRequest)
+		return
+	}
+	if req.Until.Sub(now) > maxSnooze {
+		utils.ErrorResponse(w, "until must be within a year", http.StatusBadRequest)
+		return
+	}
+	err := h.Repo.Snooze(id, user.ID, req.Until)
+	if err == nrepo.ErrNotificationsNotFound {
+		utils.ErrorResponse(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "failed", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, map[string]interface{}{"status": "snoozed", "until": req.Until}, http.StatusOK)
+}
+
+// Unsnooze brings a snoozed notification back immediately
+func (h *NotificationHandler) Unsnooze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id := utils.GetLastPathParam(r)
+	if id == "" {
+		utils.ErrorResponse(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	err := h.Repo.Unsnooze(id, user.ID)
+	if err == nrepo.ErrNotificationsNotFound {
+		utils.ErrorResponse(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "failed", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, map[string]string{"status": "unsnoozed"}, http.StatusOK)
+}
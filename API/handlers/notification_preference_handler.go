@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"forum/middleware"
+	"forum/models"
+	nrepo "forum/repository/notification"
+	"forum/utils"
+)
+
+// NotificationPreferenceHandler manages per-user notification opt-outs
+type NotificationPreferenceHandler struct {
+	Repo *nrepo.PreferenceRepository
+}
+
+func NewNotificationPreferenceHandler(repo *nrepo.PreferenceRepository) *NotificationPreferenceHandler {
+	return &NotificationPreferenceHandler{Repo: repo}
+}
+
+type preferencesBody struct {
+	Preferences []models.NotificationPreference `json:"preferences"`
+	// EmailMode is immediate, hourly or daily; omit it to keep the current one
+	EmailMode string `json:"email_mode,omitempty"`
+}
+
+// Preferences serves GET (read the matrix) and PUT (update some switches)
+func (h *NotificationPreferenceHandler) Preferences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.getPreferences(w, r)
+	case http.MethodPut:
+		h.updatePreferences(w, r)
+	default:
+		utils.ErrorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *NotificationPreferenceHandler) getPreferences(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	prefs, err := h.Repo.GetForUser(user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "failed to load preferences", http.StatusInternalServerError)
+		return
+	}
+	mode, err := h.Repo.EmailMode(user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "failed to load preferences", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, preferencesBody{Preferences: prefs, EmailMode: mode}, http.StatusOK)
+}
+
+func (h *NotificationPreferenceHandler) updatePreferences(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req preferencesBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, p := range req.Preferences {
+		if !slices.Contains(models.NotificationTypes, p.Type) {
+			utils.ErrorResponse(w, "unknown notification type: "+p.Type, http.StatusBadRequest)
+			return
+		}
+		for channel := range p.Channels {
+			if !slices.Contains(models.NotificationChannels, channel) {
+				utils.ErrorResponse(w, "unknown channel: "+channel, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if req.EmailMode != "" && !slices.Contains(models.EmailModes, req.EmailMode) {
+		utils.ErrorResponse(w, "unknown email mode: "+req.EmailMode, http.StatusBadRequest)
+		return
+	}
+	if err := h.Repo.Set(user.ID, req.Preferences); err != nil {
+		utils.ErrorResponse(w, "failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+	if req.EmailMode != "" {
+		if err := h.Repo.SetEmailMode(user.ID, req.EmailMode); err != nil {
+			utils.ErrorResponse(w, "failed to save preferences", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.getPreferences(w, r)
+}
@@ -2,22 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
+	"unicode/utf8"
 
 	"forum/middleware"
 	"forum/models"
+	"forum/realtime"
 	"forum/repository"
+	nrepo "forum/repository/notification"
 	"forum/utils"
 )
 
 // PostHandler handles post related endpoints
 type PostHandler struct {
-	PostRepo *repository.PostRepository
+	PostRepo  *repository.PostRepository
+	Notifier  *nrepo.Dispatcher
+	Mentions  *MentionTracker
+	Subs      *nrepo.SubscriptionRepository
+	Hub       *realtime.Hub
+	Publisher *PostPublisher
 }
 
 // NewPostHandler creates a new PostHandler
-func NewPostHandler(repo *repository.PostRepository) *PostHandler {
-	return &PostHandler{PostRepo: repo}
+func NewPostHandler(repo *repository.PostRepository, notifier *nrepo.Dispatcher, mentions *MentionTracker, subs *nrepo.SubscriptionRepository, hub *realtime.Hub, publisher *PostPublisher) *PostHandler {
+	return &PostHandler{PostRepo: repo, Notifier: notifier, Mentions: mentions, Subs: subs, Hub: hub, Publisher: publisher}
 }
 
 // CreatePost creates a new post for the authenticated user
@@ -47,6 +57,10 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "At least one category, title and content are required", http.StatusBadRequest)
 		return
 	}
+	if msg := postTextError(&req.Title, &req.Content); msg != "" {
+		utils.ErrorResponse(w, msg, http.StatusBadRequest)
+		return
+	}
 
 	post := models.Post{
 		UserID:  user.ID,
@@ -59,6 +73,7 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Failed to create post", http.StatusInternalServerError)
 		return
 	}
+	h.Publisher.Announce(created)
 
 	utils.JSONResponse(w, created, http.StatusCreated)
 }
@@ -90,7 +105,11 @@ func (h *PostHandler) EditPostTitle(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Title is required", http.StatusBadRequest)
 		return
 	}
-	ownerID, err := h.PostRepo.GetPostOwner(postID)
+	if msg := postTextError(req.Title, nil); msg != "" {
+		utils.ErrorResponse(w, msg, http.StatusBadRequest)
+		return
+	}
+	ownerID, err := h.publishedPostOwner(postID)
 	if err != nil {
 		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
 		return
@@ -103,6 +122,7 @@ func (h *PostHandler) EditPostTitle(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Failed to update title", http.StatusInternalServerError)
 		return
 	}
+	h.Hub.PublishPost(postID, realtime.EventPostUpdated, realtime.PostUpdate{PostID: postID, Title: req.Title, UpdatedAt: time.Now()})
 	utils.JSONResponse(w, map[string]string{"status": "title updated"}, http.StatusOK)
 }
 
@@ -133,7 +153,11 @@ func (h *PostHandler) EditPostContent(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Content is required", http.StatusBadRequest)
 		return
 	}
-	ownerID, err := h.PostRepo.GetPostOwner(postID)
+	if msg := postTextError(nil, req.Content); msg != "" {
+		utils.ErrorResponse(w, msg, http.StatusBadRequest)
+		return
+	}
+	ownerID, err := h.publishedPostOwner(postID)
 	if err != nil {
 		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
 		return
@@ -146,7 +170,46 @@ func (h *PostHandler) EditPostContent(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Failed to update content", http.StatusInternalServerError)
 		return
 	}
-	utils.JSONResponse(w, map[string]string{"status": "content updated"}, http.StatusOK)
+	mentions := h.Mentions.TrackPost(user.ID, postID, *req.Content)
+	html := h.contentHTML(postID)
+	h.Hub.PublishPost(postID, realtime.EventPostUpdated, realtime.PostUpdate{PostID: postID, Content: req.Content, ContentHTML: html, UpdatedAt: time.Now(), Mentions: mentions})
+	utils.JSONResponse(w, map[string]interface{}{"status": "content updated", "content_html": html, "mentions": mentions}, http.StatusOK)
+}
+
+// postTextError checks a post's title and content, either of which may be
+// left out, against their length limits. It returns "" when they fit.
+func postTextError(title, content *string) string {
+	if title != nil && utf8.RuneCountInString(*title) > models.MaxPostTitleLength {
+		return fmt.Sprintf("Title must be at most %d characters", models.MaxPostTitleLength)
+	}
+	if content != nil && utf8.RuneCountInString(*content) > models.MaxPostContentLength {
+		return fmt.Sprintf("Content must be at most %d characters", models.MaxPostContentLength)
+	}
+	return ""
+}
+
+// publishedPostOwner returns the author of a published post. Drafts are
+// edited through the drafts endpoints, so they don't announce mentions or
+// live updates before they are out.
+func (h *PostHandler) publishedPostOwner(postID string) (string, error) {
+	post, err := h.PostRepo.GetByID(postID)
+	if err != nil {
+		return "", err
+	}
+	if post.Status != models.PostPublished {
+		return "", repository.ErrPostNotFound
+	}
+	return post.UserID, nil
+}
+
+// contentHTML returns the rendered content of a post just written, for
+// events and responses
+func (h *PostHandler) contentHTML(postID string) *string {
+	post, err := h.PostRepo.GetByID(postID)
+	if err != nil {
+		return nil
+	}
+	return post.ContentHTML
 }
 
 // DeletePost soft-deletes a post
@@ -178,5 +241,7 @@ func (h *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Failed to delete post", http.StatusInternalServerError)
 		return
 	}
+	h.Mentions.ForgetPost(postID)
+	h.Hub.PublishPost(postID, realtime.EventPostDeleted, realtime.PostUpdate{PostID: postID, UpdatedAt: time.Now()})
 	utils.JSONResponse(w, map[string]string{"status": "deleted"}, http.StatusOK)
 }
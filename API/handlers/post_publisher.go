@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"forum/models"
+	"forum/repository"
+	nrepo "forum/repository/notification"
+)
+
+// PublishCheckInterval is the longest the publisher sleeps between checks,
+// so a post scheduled outside this server is still published on time, give
+// or take this much
+const PublishCheckInterval = time.Minute
+
+// PostPublisher publishes scheduled posts when their time comes and sends
+// out what a new post sends: the author's subscription, mention
+// notifications and the PostCreated event
+type PostPublisher struct {
+	Posts    *repository.PostRepository
+	Notifier *nrepo.Dispatcher
+	Mentions *MentionTracker
+	Subs     *nrepo.SubscriptionRepository
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewPostPublisher publishes anything already due, then sleeps until the
+// next scheduled post or PublishCheckInterval, whichever comes first
+func NewPostPublisher(posts *repository.PostRepository, notifier *nrepo.Dispatcher, mentions *MentionTracker, subs *nrepo.SubscriptionRepository) *PostPublisher {
+	p := &PostPublisher{
+		Posts:    posts,
+		Notifier: notifier,
+		Mentions: mentions,
+		Subs:     subs,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.loop()
+	return p
+}
+
+// Reschedule tells the publisher a post's publish time changed, so it
+// doesn't oversleep
+func (p *PostPublisher) Reschedule() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the publisher
+func (p *PostPublisher) Close() {
+	p.once.Do(func() { close(p.done) })
+	p.wg.Wait()
+}
+
+// Publish publishes a draft or scheduled post now and announces it. It
+// reports false when the post was already published.
+func (p *PostPublisher) Publish(postID string) (*models.Post, bool, error) {
+	ok, err := p.Posts.PublishPost(postID, time.Now().UTC())
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	post, err := p.Posts.GetByID(postID)
+	if err != nil {
+		return nil, true, err
+	}
+	p.Announce(post)
+	return post, true, nil
+}
+
+// Announce subscribes the author to a post just published, notifies the
+// users it mentions and raises PostCreated
+func (p *PostPublisher) Announce(post *models.Post) {
+	if err := p.Subs.AutoSubscribe(post.UserID, post.ID, models.SubscribeAuthor); err != nil {
+		log.Printf("Publisher [WARN]: subscribe author to post %s: %v", post.ID, err)
+	}
+	if post.Content != nil {
+		post.Mentions = p.Mentions.TrackPost(post.UserID, post.ID, *post.Content)
+	}
+	p.Notifier.Dispatch(nrepo.PostCreated{ActorID: post.UserID, PostID: post.ID})
+}
+
+func (p *PostPublisher) loop() {
+	defer p.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-timer.C:
+		case <-p.wake:
+		}
+		p.publishDue()
+		timer.Reset(p.nextCheck())
+	}
+}
+
+// publishDue publishes every scheduled post whose time has come
+func (p *PostPublisher) publishDue() {
+	ids, err := p.Posts.DuePosts(time.Now())
+	if err != nil {
+		log.Printf("Publisher [ERROR]: loading scheduled posts: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if _, ok, err := p.Publish(id); err != nil {
+			log.Printf("Publisher [ERROR]: publishing post %s: %v", id, err)
+		} else if ok {
+			log.Printf("Publisher [INFO]: published scheduled post %s", id)
+		}
+	}
+}
+
+// nextCheck is how long to sleep before the next scheduled post is due
+func (p *PostPublisher) nextCheck() time.Duration {
+	next, err := p.Posts.NextPublishAt()
+	if err != nil {
+		log.Printf("Publisher [ERROR]: loading next publish time: %v", err)
+		return PublishCheckInterval
+	}
+	if next == nil {
+		return PublishCheckInterval
+	}
+	wait := time.Until(*next)
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > PublishCheckInterval {
+		wait = PublishCheckInterval
+	}
+	return wait
+}
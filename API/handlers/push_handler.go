@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/push"
+	nrepo "forum/repository/notification"
+	"forum/utils"
+)
+
+// PushHandler registers browsers for Web Push notifications
+type PushHandler struct {
+	Repo *nrepo.PushRepository
+	// PublicKey is the VAPID applicationServerKey; empty when push is not
+	// configured
+	PublicKey string
+}
+
+func NewPushHandler(repo *nrepo.PushRepository, publicKey string) *PushHandler {
+	return &PushHandler{Repo: repo, PublicKey: publicKey}
+}
+
+// VAPIDKey returns the applicationServerKey for pushManager.subscribe
+func (h *PushHandler) VAPIDKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.PublicKey == "" {
+		utils.ErrorResponse(w, "Push notifications are not configured", http.StatusNotFound)
+		return
+	}
+	utils.JSONResponse(w, map[string]string{"public_key": h.PublicKey}, http.StatusOK)
+}
+
+// Subscribe stores the browser's PushSubscription for the current session
+func (h *PushHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	session := middleware.GetCurrentSession(r)
+	if user == nil || session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if h.PublicKey == "" {
+		utils.ErrorResponse(w, "Push notifications are not configured", http.StatusNotFound)
+		return
+	}
+
+	var req models.PushSubscriptionJSON
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(req.Endpoint); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		utils.ErrorResponse(w, "endpoint must be an absolute URL", http.StatusBadRequest)
+		return
+	}
+	if err := push.ValidateKeys(req.Keys.P256dh, req.Keys.Auth); err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := models.PushSubscription{
+		UserID:    user.ID,
+		SessionID: session.SessionID,
+		Endpoint:  req.Endpoint,
+		P256dh:    req.Keys.P256dh,
+		Auth:      req.Keys.Auth,
+		UserAgent: r.UserAgent(),
+	}
+	if err := h.Repo.Save(&sub); err != nil {
+		utils.ErrorResponse(w, "Failed to save subscription", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, sub, http.StatusCreated)
+}
+
+// Unsubscribe removes one of the user's subscriptions by endpoint
+func (h *PushHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Endpoint == "" {
+		utils.ErrorResponse(w, "endpoint is required", http.StatusBadRequest)
+		return
+	}
+	removed, err := h.Repo.Delete(user.ID, req.Endpoint)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to remove subscription", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		utils.ErrorResponse(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	utils.JSONResponse(w, map[string]string{"status": "unsubscribed"}, http.StatusOK)
+}
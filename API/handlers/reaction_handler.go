@@ -2,26 +2,29 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
 	"forum/middleware"
 	"forum/models"
+	"forum/realtime"
 	"forum/repository"
 	nrepo "forum/repository/notification"
-	"forum/repository/user"
 	"forum/utils"
 )
 
 // ReactionHandler handles like/dislike reactions
 type ReactionHandler struct {
-	Repo             *repository.ReactionRepository
-	PostRepo         *repository.PostRepository
-	NotificationRepo *nrepo.Repository
-	UserRepo         *user.UserRepository
+	Repo        *repository.ReactionRepository
+	CommentRepo *repository.CommentRepository
+	Notifier    *nrepo.Dispatcher
+	Subs        *nrepo.SubscriptionRepository
+	Hub         *realtime.Hub
 }
 
-func NewReactionHandler(repo *repository.ReactionRepository, postRepo *repository.PostRepository, nRepo *nrepo.Repository, uRepo *user.UserRepository) *ReactionHandler {
-	return &ReactionHandler{Repo: repo, PostRepo: postRepo, NotificationRepo: nRepo, UserRepo: uRepo}
+func NewReactionHandler(repo *repository.ReactionRepository, commentRepo *repository.CommentRepository, notifier *nrepo.Dispatcher, subs *nrepo.SubscriptionRepository, hub *realtime.Hub) *ReactionHandler {
+	return &ReactionHandler{Repo: repo, CommentRepo: commentRepo, Notifier: notifier, Subs: subs, Hub: hub}
 }
 
 // React toggles a reaction on a post or comment for the authenticated user
@@ -53,24 +56,24 @@ func (h *ReactionHandler) CreateReact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Repo.ToggleReaction(user.ID, req.TargetType, req.TargetID, req.ReactionType); err != nil {
+	oldType, _ := h.Repo.GetReaction(user.ID, req.TargetType, req.TargetID)
+	if oldType == models.ReactionNone {
+		oldType = 0
+	}
+	if err := h.Repo.ToggleReaction(user.ID, req.TargetType, req.TargetID, req.ReactionType); errors.Is(err, repository.ErrPostNotFound) {
+		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		utils.ErrorResponse(w, "Failed to react", http.StatusInternalServerError)
 		return
 	}
 
 	newType, _ := h.Repo.GetReaction(user.ID, req.TargetType, req.TargetID)
+	if newType == models.ReactionNone {
+		newType = 0
+	}
 	if req.TargetType == "post" && newType != 0 {
-		if ownerID, err := h.PostRepo.GetPostOwner(req.TargetID); err == nil && ownerID != user.ID {
-			if actor, err2 := h.UserRepo.GetByID(user.ID); err2 == nil {
-				action := "liked"
-				if newType == 2 {
-					action = "disliked"
-				}
-				msg := actor.Username + " " + action + " your post"
-				n := models.Notification{UserID: ownerID, ActorID: user.ID, PostID: &req.TargetID, Type: "reaction", Message: &msg}
-				h.NotificationRepo.Create(n)
-			}
-		}
+		h.Notifier.Dispatch(nrepo.PostReacted{ActorID: user.ID, PostID: req.TargetID, ReactionType: newType})
 	}
 
 	var (
@@ -87,5 +90,36 @@ func (h *ReactionHandler) CreateReact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	postID := req.TargetID
+	if req.TargetType == "comment" {
+		postID = ""
+		if c, err := h.CommentRepo.GetByID(req.TargetID); err == nil {
+			postID = c.PostID
+		}
+	}
+	if req.TargetType == "comment" && postID != "" && newType != 0 {
+		h.Notifier.Dispatch(nrepo.CommentReacted{ActorID: user.ID, PostID: postID, CommentID: req.TargetID, ReactionType: newType})
+	}
+	// A reaction taken back or swapped must not leave a stale "liked your ..."
+	if postID != "" && oldType != 0 && oldType != newType {
+		removed := nrepo.ReactionRemoved{ActorID: user.ID, PostID: postID, ReactionType: oldType}
+		if req.TargetType == "comment" {
+			removed.CommentID = req.TargetID
+		}
+		h.Notifier.Dispatch(removed)
+	}
+	if postID != "" && newType != 0 {
+		if err := h.Subs.AutoSubscribe(user.ID, postID, models.SubscribeReaction); err != nil {
+			log.Printf("ReactionHandler [WARN]: subscribe %s to post %s: %v", user.ID, postID, err)
+		}
+	}
+	if postID != "" {
+		h.Hub.PublishPost(postID, realtime.EventReactionsUpdated, realtime.ReactionsUpdate{
+			TargetType: req.TargetType,
+			TargetID:   req.TargetID,
+			Reactions:  reactions,
+		})
+	}
+
 	utils.JSONResponse(w, reactions, http.StatusOK)
 }
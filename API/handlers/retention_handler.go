@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"forum/models"
+	nrepo "forum/repository/notification"
+	"forum/utils"
+)
+
+// RetentionHandler lets admins preview and run the notification retention
+// job and see its past runs
+type RetentionHandler struct {
+	Job *nrepo.RetentionJob
+}
+
+func NewRetentionHandler(job *nrepo.RetentionJob) *RetentionHandler {
+	return &RetentionHandler{Job: job}
+}
+
+// Preview reports what a purge would delete or archive right now, by action
+// and notification type, without changing anything
+func (h *RetentionHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := h.Job.Preview()
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to preview retention", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, report, http.StatusOK)
+}
+
+// Run applies the retention policy now and reports what was removed
+func (h *RetentionHandler) Run(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := h.Job.Run(models.RetentionTriggerManual)
+	if err == nrepo.ErrRetentionRunning {
+		utils.ErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil && report == nil {
+		utils.ErrorResponse(w, "Failed to run retention", http.StatusInternalServerError)
+		return
+	}
+	// A partial run still reports what it did; its error is in the report
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	utils.JSONResponse(w, report, status)
+}
+
+// Runs lists recent retention runs, newest first. Query: limit (default 20,
+// max 100).
+func (h *RetentionHandler) Runs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			utils.ErrorResponse(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	runs, err := h.Job.Runs(limit)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load retention runs", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, runs, http.StatusOK)
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"forum/diff"
+	"forum/middleware"
+	"forum/models"
+	"forum/realtime"
+	"forum/repository"
+	nrepo "forum/repository/notification"
+	"forum/utils"
+)
+
+// PostRevisions lists a post's revisions, newest first. Like the post itself,
+// its history is public.
+func (h *PostHandler) PostRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	revisions, ok := h.postRevisions(w, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+	utils.JSONResponse(w, revisions, http.StatusOK)
+}
+
+// PostRevisionDiff compares two revisions of a post; see parseDiffQuery
+func (h *PostHandler) PostRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	revisions, ok := h.postRevisions(w, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+	result, err := diffRevisions(r, revisions, true)
+	if err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utils.JSONResponse(w, result, http.StatusOK)
+}
+
+// RestorePostRevision lets the author bring back an earlier revision's title
+// and content, as a new revision
+func (h *PostHandler) RestorePostRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	postID := utils.GetLastPathParam(r)
+	revision, ok := readRestoreRequest(w, r)
+	if !ok {
+		return
+	}
+	ownerID, err := h.publishedPostOwner(postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if ownerID != user.ID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	rev, err := h.PostRepo.RestoreRevision(postID, revision)
+	if err == repository.ErrRevisionNotFound {
+		utils.ErrorResponse(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	mentions := h.Mentions.TrackPost(user.ID, postID, utils.DerefString(rev.Content))
+	html := h.contentHTML(postID)
+	h.Hub.PublishPost(postID, realtime.EventPostUpdated, realtime.PostUpdate{PostID: postID, Title: rev.Title, Content: rev.Content, ContentHTML: html, UpdatedAt: time.Now(), Mentions: mentions})
+	utils.JSONResponse(w, map[string]interface{}{"status": "restored", "revision": rev, "content_html": html, "mentions": mentions}, http.StatusOK)
+}
+
+// postRevisions loads the revisions of a published post that isn't deleted,
+// answering 404 otherwise
+func (h *PostHandler) postRevisions(w http.ResponseWriter, postID string) ([]models.Revision, bool) {
+	post, err := h.PostRepo.GetByID(postID)
+	if err != nil || post.Status != models.PostPublished || (post.Title == nil && post.Content == nil) {
+		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
+		return nil, false
+	}
+	revisions, err := h.PostRepo.GetRevisions(postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load revisions", http.StatusInternalServerError)
+		return nil, false
+	}
+	return revisions, true
+}
+
+// CommentRevisions lists a comment's revisions, newest first
+func (h *CommentHandler) CommentRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	revisions, ok := h.commentRevisions(w, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+	utils.JSONResponse(w, revisions, http.StatusOK)
+}
+
+// CommentRevisionDiff compares two revisions of a comment; see parseDiffQuery
+func (h *CommentHandler) CommentRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	revisions, ok := h.commentRevisions(w, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+	result, err := diffRevisions(r, revisions, false)
+	if err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utils.JSONResponse(w, result, http.StatusOK)
+}
+
+// RestoreCommentRevision lets the author bring back an earlier revision of a
+// comment, as a new revision
+func (h *CommentHandler) RestoreCommentRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	commentID := utils.GetLastPathParam(r)
+	revision, ok := readRestoreRequest(w, r)
+	if !ok {
+		return
+	}
+	ownerID, err := h.CommentRepo.GetCommentOwner(commentID)
+	if err != nil {
+		utils.ErrorResponse(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if ownerID != user.ID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	rev, err := h.CommentRepo.RestoreRevision(commentID, revision)
+	if err == repository.ErrRevisionNotFound {
+		utils.ErrorResponse(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+
+	mentions := []models.Mention{}
+	var html *string
+	if c, err := h.CommentRepo.GetByID(commentID); err == nil {
+		mentions = h.Mentions.TrackComment(user.ID, c.PostID, c.ID, utils.DerefString(c.Content))
+		c.Mentions = mentions
+		html = c.ContentHTML
+		h.publishCommentUpdate(realtime.EventCommentUpdated, c)
+		h.Notifier.Dispatch(nrepo.CommentEdited{ActorID: user.ID, PostID: c.PostID, CommentID: c.ID})
+	}
+	utils.JSONResponse(w, map[string]interface{}{"status": "restored", "revision": rev, "content_html": html, "mentions": mentions}, http.StatusOK)
+}
+
+// commentRevisions loads the revisions of a comment that exists and isn't
+// deleted, answering 404 otherwise
+func (h *CommentHandler) commentRevisions(w http.ResponseWriter, commentID string) ([]models.Revision, bool) {
+	c, err := h.CommentRepo.GetByID(commentID)
+	if err != nil || c.Content == nil {
+		utils.ErrorResponse(w, "Comment not found", http.StatusNotFound)
+		return nil, false
+	}
+	revisions, err := h.CommentRepo.GetRevisions(commentID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load revisions", http.StatusInternalServerError)
+		return nil, false
+	}
+	return revisions, true
+}
+
+// readRestoreRequest reads {"revision": n}
+func readRestoreRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var req struct {
+		Revision int `json:"revision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return 0, false
+	}
+	if req.Revision <= 0 {
+		utils.ErrorResponse(w, "revision is required", http.StatusBadRequest)
+		return 0, false
+	}
+	return req.Revision, true
+}
+
+// diffRevisions compares the revisions named by the from and to parameters.
+// to defaults to the current revision and from to the one before it; mode is
+// line (the default) or word. Titles are compared too when withTitle is set.
+func diffRevisions(r *http.Request, revisions []models.Revision, withTitle bool) (*models.RevisionDiff, error) {
+	v := r.URL.Query()
+	result := &models.RevisionDiff{Mode: v.Get("mode"), Content: []diff.Op{}}
+	compare := diff.Lines
+	switch result.Mode {
+	case "", models.DiffLines:
+		result.Mode = models.DiffLines
+	case models.DiffWords:
+		compare = diff.Words
+	default:
+		return nil, errors.New("invalid mode, expected line or word")
+	}
+	if len(revisions) == 0 {
+		return nil, errors.New("no revisions to compare")
+	}
+
+	// revisions are newest first, numbered from 1 without gaps
+	result.To = revisions[0].Revision
+	if s := v.Get("to"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > revisions[0].Revision {
+			return nil, errors.New("invalid to revision")
+		}
+		result.To = n
+	}
+	result.From = result.To - 1
+	if result.From < 1 {
+		result.From = 1
+	}
+	if s := v.Get("from"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > revisions[0].Revision {
+			return nil, errors.New("invalid from revision")
+		}
+		result.From = n
+	}
+
+	from := revisions[len(revisions)-result.From]
+	to := revisions[len(revisions)-result.To]
+	result.Content = compare(utils.DerefString(from.Content), utils.DerefString(to.Content))
+	if withTitle {
+		result.Title = compare(utils.DerefString(from.Title), utils.DerefString(to.Title))
+	}
+	return result, nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+)
+
+// SearchHandler serves full-text search over posts and comments
+type SearchHandler struct {
+	Repo *repository.SearchRepository
+}
+
+func NewSearchHandler(repo *repository.SearchRepository) *SearchHandler {
+	return &SearchHandler{Repo: repo}
+}
+
+// Search answers GET /forum/api/search; it is open to guests like the feed
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := h.Repo.Search(q)
+	if err == repository.ErrEmptySearch {
+		utils.ErrorResponse(w, "q must contain at least one word", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, page, http.StatusOK)
+}
+
+// parseSearchQuery reads the search parameters: q, type (all, posts or
+// comments), category (repeatable or comma-separated IDs), author, since and
+// until (RFC 3339), limit and offset
+func parseSearchQuery(r *http.Request) (models.SearchQuery, error) {
+	v := r.URL.Query()
+	q := models.SearchQuery{
+		Terms:  v.Get("q"),
+		Scope:  v.Get("type"),
+		Author: strings.TrimPrefix(v.Get("author"), "@"),
+	}
+	if strings.TrimSpace(q.Terms) == "" {
+		return q, errors.New("q is required")
+	}
+	switch q.Scope {
+	case "":
+		q.Scope = models.SearchAll
+	case models.SearchAll, models.SearchPosts, models.SearchComments:
+	default:
+		return q, errors.New("invalid type, expected all, posts or comments")
+	}
+	for _, list := range v["category"] {
+		for _, s := range strings.Split(list, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || id <= 0 {
+				return q, errors.New("invalid category")
+			}
+			q.CategoryIDs = append(q.CategoryIDs, id)
+		}
+	}
+	if s := v.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, errors.New("invalid since, expected RFC 3339")
+		}
+		q.Since = &t
+	}
+	if s := v.Get("until"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, errors.New("invalid until, expected RFC 3339")
+		}
+		q.Until = &t
+	}
+	if s := v.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = limit
+	}
+	if s := v.Get("offset"); s != "" {
+		offset, err := strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return q, errors.New("invalid offset")
+		}
+		q.Offset = offset
+	}
+	return q, nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"forum/storage"
+	"forum/utils"
+)
+
+// StaticHandler serves uploaded files from the storage backend, mounted
+// under /static/ with the prefix stripped. With a SignedTTL and a backend
+// that signs, it redirects to a signed URL instead of reading the file.
+type StaticHandler struct {
+	Blob      storage.Blob
+	SignedTTL time.Duration
+}
+
+func NewStaticHandler(blob storage.Blob, signedTTL time.Duration) *StaticHandler {
+	return &StaticHandler{Blob: blob, SignedTTL: signedTTL}
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Keys never name a directory or a hidden file, such as a half written
+	// upload
+	key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if key == "" || strings.HasSuffix(r.URL.Path, "/") || strings.HasPrefix(path.Base(key), ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if signer, ok := h.Blob.(storage.Signer); ok && h.SignedTTL > 0 {
+		url, err := signer.SignedURL(key, h.SignedTTL)
+		if err != nil {
+			log.Printf("Static [ERROR]: sign %s: %v", key, err)
+			http.Error(w, "Failed to load file", http.StatusInternalServerError)
+			return
+		}
+		// Browsers may reuse the redirect while the URL is still good
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(h.SignedTTL/2/time.Second)))
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	file, err := h.Blob.Get(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Static [ERROR]: read %s: %v", key, err)
+		http.Error(w, "Failed to load file", http.StatusBadGateway)
+		return
+	}
+	defer file.Close()
+
+	// A key is never given other content, so copies can be kept for good
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, time.Time{}, seeker)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, file)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	nrepo "forum/repository/notification"
+	"forum/utils"
+)
+
+// SubscriptionHandler lets users follow, unfollow and mute posts
+type SubscriptionHandler struct {
+	Repo     *nrepo.SubscriptionRepository
+	PostRepo *repository.PostRepository
+}
+
+func NewSubscriptionHandler(repo *nrepo.SubscriptionRepository, postRepo *repository.PostRepository) *SubscriptionHandler {
+	return &SubscriptionHandler{Repo: repo, PostRepo: postRepo}
+}
+
+// GetSubscription reports the user's state for a post: subscribed, muted or none
+func (h *SubscriptionHandler) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, postID, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	sub, err := h.Repo.Get(user.ID, postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load subscription", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, sub, http.StatusOK)
+}
+
+// Subscribe follows a post, also lifting a mute
+func (h *SubscriptionHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	h.change(w, r, func(userID, postID string) error {
+		return h.Repo.SetState(userID, postID, models.SubscriptionSubscribed)
+	})
+}
+
+// Unsubscribe stops following a post until the user takes part in it again
+func (h *SubscriptionHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	h.change(w, r, h.Repo.Unsubscribe)
+}
+
+// Mute silences a post for good, even if the user comments or reacts later
+func (h *SubscriptionHandler) Mute(w http.ResponseWriter, r *http.Request) {
+	h.change(w, r, func(userID, postID string) error {
+		return h.Repo.SetState(userID, postID, models.SubscriptionMuted)
+	})
+}
+
+// change applies a POSTed subscription change and returns the new state
+func (h *SubscriptionHandler) change(w http.ResponseWriter, r *http.Request, apply func(userID, postID string) error) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, postID, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	if err := apply(user.ID, postID); err != nil {
+		utils.ErrorResponse(w, "Failed to update subscription", http.StatusInternalServerError)
+		return
+	}
+	sub, err := h.Repo.Get(user.ID, postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load subscription", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, sub, http.StatusOK)
+}
+
+// target resolves the current user and the post ID from the path, writing
+// an error response when either is missing
+func (h *SubscriptionHandler) target(w http.ResponseWriter, r *http.Request) (*models.User, string, bool) {
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+	postID := utils.GetLastPathParam(r)
+	if postID == "" {
+		utils.ErrorResponse(w, "Missing post ID", http.StatusBadRequest)
+		return nil, "", false
+	}
+	if _, err := h.PostRepo.GetPostOwner(postID); err != nil {
+		utils.ErrorResponse(w, "Post not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	return user, postID, true
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+	"forum/webhook"
+)
+
+// maxWebhookDeliveries caps one page of the delivery log
+const maxWebhookDeliveries = 100
+
+// WebhookHandler lets users manage webhooks for forum events and inspect
+// their delivery log
+type WebhookHandler struct {
+	Repo *repository.WebhookRepository
+}
+
+func NewWebhookHandler(repo *repository.WebhookRepository) *WebhookHandler {
+	return &WebhookHandler{Repo: repo}
+}
+
+// webhookBody is the create/update request. Omitted fields keep their
+// current value on update; on create, omitted events mean every event and an
+// omitted secret is generated.
+type webhookBody struct {
+	URL    *string   `json:"url"`
+	Secret *string   `json:"secret"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// Webhooks serves GET (list the user's webhooks) and POST (register one)
+func (h *WebhookHandler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := h.Repo.GetByUser(user.ID)
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to load webhooks", http.StatusInternalServerError)
+			return
+		}
+		for i := range hooks {
+			hooks[i].Secret = ""
+		}
+		if hooks == nil {
+			hooks = []models.Webhook{}
+		}
+		utils.JSONResponse(w, hooks, http.StatusOK)
+	case http.MethodPost:
+		h.create(w, r, user.ID)
+	default:
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *WebhookHandler) create(w http.ResponseWriter, r *http.Request, userID string) {
+	var req webhookBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.URL == nil {
+		utils.ErrorResponse(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	hook := models.Webhook{UserID: userID, Events: models.WebhookEvents, Active: true}
+	if req.Secret == nil || *req.Secret == "" {
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		req.Secret = &secret
+	}
+	if msg := applyWebhookBody(&hook, req); msg != "" {
+		utils.ErrorResponse(w, msg, http.StatusBadRequest)
+		return
+	}
+	if err := h.Repo.Create(&hook); err != nil {
+		utils.ErrorResponse(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	// The secret is shown this once so the receiver can be configured
+	utils.JSONResponse(w, hook, http.StatusCreated)
+}
+
+// Webhook serves GET, PUT and DELETE for one of the user's webhooks
+// at /forum/api/user/webhooks/{id}
+func (h *WebhookHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	hook, ok := h.owned(w, user.ID, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hook.Secret = ""
+		utils.JSONResponse(w, hook, http.StatusOK)
+	case http.MethodPut:
+		var req webhookBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Secret != nil && *req.Secret == "" {
+			utils.ErrorResponse(w, "secret cannot be empty", http.StatusBadRequest)
+			return
+		}
+		if msg := applyWebhookBody(hook, req); msg != "" {
+			utils.ErrorResponse(w, msg, http.StatusBadRequest)
+			return
+		}
+		if err := h.Repo.Update(hook); err != nil {
+			utils.ErrorResponse(w, "Failed to update webhook", http.StatusInternalServerError)
+			return
+		}
+		hook.Secret = ""
+		utils.JSONResponse(w, hook, http.StatusOK)
+	case http.MethodDelete:
+		if err := h.Repo.Delete(hook.ID, user.ID); err != nil {
+			utils.ErrorResponse(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+		utils.JSONResponse(w, map[string]string{"status": "deleted"}, http.StatusOK)
+	default:
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Deliveries lists a webhook's most recent deliveries, newest first, at
+// GET /forum/api/user/webhooks/deliveries/{id}?limit=
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	hook, ok := h.owned(w, user.ID, utils.GetLastPathParam(r))
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxWebhookDeliveries {
+			utils.ErrorResponse(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	deliveries, err := h.Repo.GetDeliveries(hook.ID, limit)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load deliveries", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	utils.JSONResponse(w, deliveries, http.StatusOK)
+}
+
+// owned loads a webhook and checks it belongs to the user. Other users'
+// webhooks are reported as missing.
+func (h *WebhookHandler) owned(w http.ResponseWriter, userID, id string) (*models.Webhook, bool) {
+	hook, err := h.Repo.GetByID(id)
+	if err == repository.ErrWebhookNotFound || (err == nil && hook.UserID != userID) {
+		utils.ErrorResponse(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load webhook", http.StatusInternalServerError)
+		return nil, false
+	}
+	return hook, true
+}
+
+// applyWebhookBody copies the set fields onto the webhook and returns a
+// validation message, or "" when the result is valid
+func applyWebhookBody(hook *models.Webhook, req webhookBody) string {
+	if req.URL != nil {
+		if err := webhook.ValidateURL(*req.URL); err != nil {
+			return err.Error()
+		}
+		hook.URL = *req.URL
+	}
+	if req.Secret != nil {
+		hook.Secret = *req.Secret
+	}
+	if req.Events != nil {
+		if len(*req.Events) == 0 {
+			return "events must list at least one event"
+		}
+		var events []string
+		for _, e := range *req.Events {
+			if !slices.Contains(models.WebhookEvents, e) {
+				return "unknown event: " + e
+			}
+			if !slices.Contains(events, e) {
+				events = append(events, e)
+			}
+		}
+		hook.Events = events
+	}
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	return ""
+}
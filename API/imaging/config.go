@@ -0,0 +1,61 @@
+package imaging
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxPixels is the largest upload, in pixels, when IMAGE_MAX_PIXELS is
+// not set: 40 megapixels, 160 MB decoded
+const DefaultMaxPixels = 40_000_000
+
+// DefaultWidths are the variant widths made when IMAGE_VARIANT_WIDTHS is not set
+var DefaultWidths = []int{320, 640, 1280}
+
+// Config holds the upload pipeline's settings, read from the environment
+// (see utils.LoadEnv)
+type Config struct {
+	Widths      []int // IMAGE_VARIANT_WIDTHS, comma separated pixel widths, default 320,640,1280
+	WebP        bool  // IMAGE_WEBP=true also writes lossless WebP copies of PNG uploads, see Variants
+	JPEGQuality int   // IMAGE_JPEG_QUALITY, 1 to 100, default 90
+	MaxPixels   int64 // IMAGE_MAX_PIXELS, width times height, default DefaultMaxPixels
+}
+
+// ConfigFromEnv builds a Config from the environment. Widths that do not
+// parse or are not positive are skipped.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Widths:      DefaultWidths,
+		WebP:        os.Getenv("IMAGE_WEBP") == "true",
+		JPEGQuality: 90,
+		MaxPixels:   DefaultMaxPixels,
+	}
+	if v := os.Getenv("IMAGE_VARIANT_WIDTHS"); v != "" {
+		cfg.Widths = parseWidths(v)
+	}
+	if n, err := strconv.Atoi(os.Getenv("IMAGE_JPEG_QUALITY")); err == nil && n >= 1 && n <= 100 {
+		cfg.JPEGQuality = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("IMAGE_MAX_PIXELS"), 10, 64); err == nil && n > 0 {
+		cfg.MaxPixels = n
+	}
+	return cfg
+}
+
+// parseWidths reads a comma separated list into sorted, distinct widths
+func parseWidths(v string) []int {
+	seen := make(map[int]bool)
+	var widths []int
+	for _, field := range strings.Split(v, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || w <= 0 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		widths = append(widths, w)
+	}
+	sort.Ints(widths)
+	return widths
+}
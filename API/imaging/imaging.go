@@ -0,0 +1,127 @@
+// Package imaging prepares uploaded photos for the web: it turns them upright,
+// drops their metadata, and makes the resized and WebP copies listed in an
+// image's srcset.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Variant is one encoded copy to store next to the original
+type Variant struct {
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// ErrTooManyPixels is returned for images whose declared size is over
+// Config.MaxPixels
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+// DecodeConfig reads the size from a JPEG, PNG or GIF header without decoding
+// the pixels, and refuses images over MaxPixels. A few bytes can declare a
+// picture that would take gigabytes to decode.
+func (c Config) DecodeConfig(data []byte, contentType string) (image.Config, error) {
+	var cfg image.Config
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		cfg, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	case "image/gif":
+		cfg, err = gif.DecodeConfig(bytes.NewReader(data))
+	default:
+		cfg, err = png.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if c.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > c.MaxPixels {
+		return cfg, ErrTooManyPixels
+	}
+	return cfg, nil
+}
+
+// Decode reads a JPEG or PNG and returns it upright. The metadata is not kept:
+// whatever is written from the result has no EXIF, GPS position included.
+func (c Config) Decode(data []byte, contentType string) (image.Image, error) {
+	if _, err := c.DecodeConfig(data, contentType); err != nil {
+		return nil, err
+	}
+	switch contentType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return Orient(img, Orientation(data)), nil
+	default:
+		return png.Decode(bytes.NewReader(data))
+	}
+}
+
+// Encode writes img as a JPEG, PNG or WebP
+func (c Config) Encode(w io.Writer, img image.Image, contentType string) error {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: c.JPEGQuality})
+	case "image/webp":
+		return EncodeWebP(w, img)
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+// Variants encodes the copies to make of an upright image stored as
+// contentType: one per configured width narrower than the image. With WebP
+// enabled, PNGs also get a WebP of each size, the full one included, kept
+// only where it is smaller. The WebP encoder is lossless, which never pays
+// off against a JPEG photo. Images are never scaled up.
+func (c Config) Variants(img image.Image, contentType string) ([]Variant, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	webp := c.WebP && contentType == "image/png" && w <= 16384 && h <= 16384
+
+	var out []Variant
+	add := func(sized image.Image, width, height int) error {
+		var native bytes.Buffer
+		if err := c.Encode(&native, sized, contentType); err != nil {
+			return err
+		}
+		if width < w {
+			out = append(out, Variant{Width: width, Height: height, ContentType: contentType, Data: native.Bytes()})
+		}
+		if !webp {
+			return nil
+		}
+		var lossless bytes.Buffer
+		if err := EncodeWebP(&lossless, sized); err != nil {
+			return err
+		}
+		if lossless.Len() < native.Len() {
+			out = append(out, Variant{Width: width, Height: height, ContentType: "image/webp", Data: lossless.Bytes()})
+		}
+		return nil
+	}
+	for _, width := range c.Widths {
+		if width >= w {
+			break
+		}
+		height := max(1, (h*width+w/2)/w)
+		if err := add(Resize(img, width, height), width, height); err != nil {
+			return nil, err
+		}
+	}
+	if webp {
+		if err := add(img, w, h); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,120 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func tiny() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	return img
+}
+
+// hugePNG is a valid 2x2 PNG whose IHDR claims 100000x100000 pixels
+func hugePNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tiny()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// signature(8) length(4) "IHDR"(4) width(4) height(4) ... crc
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// hugeJPEG is a 2x2 JPEG whose SOF0 claims 60000x60000 pixels
+func hugeJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, tiny(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	if sof < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	// marker(2) length(2) precision(1) height(2) width(2)
+	binary.BigEndian.PutUint16(data[sof+5:], 60000)
+	binary.BigEndian.PutUint16(data[sof+7:], 60000)
+	return data
+}
+
+func hugeGIF(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	pal := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, pal, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// "GIF89a" then the logical screen width and height, little endian
+	binary.LittleEndian.PutUint16(data[6:], 65535)
+	binary.LittleEndian.PutUint16(data[8:], 65535)
+	return data
+}
+
+func TestDecodeRefusesTooManyPixels(t *testing.T) {
+	c := Config{MaxPixels: DefaultMaxPixels}
+	for _, tc := range []struct {
+		contentType string
+		data        []byte
+	}{
+		{"image/png", hugePNG(t)},
+		{"image/jpeg", hugeJPEG(t)},
+	} {
+		cfg, err := c.DecodeConfig(tc.data, tc.contentType)
+		if !errors.Is(err, ErrTooManyPixels) {
+			t.Errorf("%s: DecodeConfig of %dx%d: err = %v, want ErrTooManyPixels", tc.contentType, cfg.Width, cfg.Height, err)
+		}
+		if img, err := c.Decode(tc.data, tc.contentType); !errors.Is(err, ErrTooManyPixels) || img != nil {
+			t.Errorf("%s: Decode = %v, %v; want ErrTooManyPixels before decoding", tc.contentType, img, err)
+		}
+	}
+	if _, err := c.DecodeConfig(hugeGIF(t), "image/gif"); !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("gif: DecodeConfig err = %v, want ErrTooManyPixels", err)
+	}
+}
+
+func TestDecodeWithinLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tiny()); err != nil {
+		t.Fatal(err)
+	}
+	for _, max := range []int64{4, 0} { // exactly at the limit, and no limit
+		img, err := Config{MaxPixels: max}.Decode(buf.Bytes(), "image/png")
+		if err != nil || img.Bounds().Dx() != 2 {
+			t.Errorf("MaxPixels %d: Decode = %v, %v", max, img, err)
+		}
+	}
+	if _, err := (Config{MaxPixels: 3}).Decode(buf.Bytes(), "image/png"); !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("MaxPixels 3: err = %v, want ErrTooManyPixels", err)
+	}
+}
+
+func TestConfigFromEnvMaxPixels(t *testing.T) {
+	t.Setenv("IMAGE_MAX_PIXELS", "")
+	if got := ConfigFromEnv().MaxPixels; got != DefaultMaxPixels {
+		t.Errorf("default MaxPixels = %d, want %d", got, DefaultMaxPixels)
+	}
+	t.Setenv("IMAGE_MAX_PIXELS", "1000000")
+	if got := ConfigFromEnv().MaxPixels; got != 1000000 {
+		t.Errorf("MaxPixels = %d, want 1000000", got)
+	}
+	t.Setenv("IMAGE_MAX_PIXELS", "-5")
+	if got := ConfigFromEnv().MaxPixels; got != DefaultMaxPixels {
+		t.Errorf("negative IMAGE_MAX_PIXELS gave %d, want the default", got)
+	}
+}
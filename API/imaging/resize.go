@@ -0,0 +1,63 @@
+package imaging
+
+import "image"
+
+// Resize scales img to w by h pixels. Each output pixel averages the source
+// pixels it covers, so downscaled photos stay smooth instead of aliasing.
+func Resize(img image.Image, w, h int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	// Horizontal pass into rows of sums, then vertical into dst
+	tmp := make([]uint32, w*sh*4)
+	for x := 0; x < w; x++ {
+		x0, x1 := span(x, w, sw)
+		for y := 0; y < sh; y++ {
+			var r, g, b, a uint32
+			row := src.Pix[y*src.Stride:]
+			for sx := x0; sx < x1; sx++ {
+				p := row[4*sx : 4*sx+4]
+				r += uint32(p[0])
+				g += uint32(p[1])
+				b += uint32(p[2])
+				a += uint32(p[3])
+			}
+			n := uint32(x1 - x0)
+			t := tmp[(y*w+x)*4:]
+			t[0], t[1], t[2], t[3] = (r+n/2)/n, (g+n/2)/n, (b+n/2)/n, (a+n/2)/n
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := span(y, h, sh)
+		n := uint32(y1 - y0)
+		for x := 0; x < w; x++ {
+			var r, g, b, a uint32
+			for sy := y0; sy < y1; sy++ {
+				t := tmp[(sy*w+x)*4:]
+				r += t[0]
+				g += t[1]
+				b += t[2]
+				a += t[3]
+			}
+			d := dst.Pix[y*dst.Stride+4*x:]
+			d[0], d[1], d[2], d[3] = uint8((r+n/2)/n), uint8((g+n/2)/n), uint8((b+n/2)/n), uint8((a+n/2)/n)
+		}
+	}
+	return dst
+}
+
+// span returns the source range [lo, hi) that output index i of n covers in a
+// source of size size. The range is never empty.
+func span(i, n, size int) (int, int) {
+	lo := i * size / n
+	hi := ((i+1)*size + n - 1) / n
+	if hi <= lo {
+		hi = lo + 1
+	}
+	if hi > size {
+		hi = size
+	}
+	return lo, hi
+}
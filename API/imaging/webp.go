@@ -0,0 +1,686 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// ErrTooLarge is returned for images WebP cannot hold
+var ErrTooLarge = errors.New("image is larger than 16384 pixels on a side")
+
+// The encoder below writes lossless WebP (VP8L, RFC 9649) with the subtract
+// green and predictor transforms, hash chain backward references, a color
+// cache and one set of prefix codes per image. It trades size for simplicity
+// against libwebp.
+
+const (
+	predictorBits  = 4 // 16x16 predictor tiles
+	cacheBits      = 10
+	maxCopyLength  = 4096
+	maxDistance    = 1<<20 - 120
+	maxChain       = 32 // candidates tried per pixel
+	hashBits       = 16
+	lengthSymbols  = 24
+	distSymbols    = 40
+	planeCodes     = 120
+	minCopyLength  = 3
+	literalSymbols = 256
+)
+
+// The order code length code lengths are written in
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// The predictor modes the encoder picks between for each tile
+var predictorModes = []int{1, 2, 3, 4, 7, 12, 13}
+
+// EncodeWebP writes img to w as a lossless WebP file. Nothing but the pixels
+// is written, so no metadata survives.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 16384 || height > 16384 {
+		return ErrTooLarge
+	}
+	data := encodeVP8L(argbPixels(img), width, height)
+
+	pad := len(data) & 1
+	out := make([]byte, 0, 20+len(data)+pad)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(12+len(data)+pad))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if pad == 1 {
+		out = append(out, 0)
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+// argbPixels returns img's pixels as non-premultiplied 0xAARRGGBB values
+func argbPixels(img image.Image) []uint32 {
+	if n, ok := img.(*image.NRGBA); ok {
+		b := n.Rect
+		argb := make([]uint32, 0, b.Dx()*b.Dy())
+		for y := 0; y < b.Dy(); y++ {
+			row := n.Pix[y*n.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				p := row[4*x : 4*x+4]
+				argb = append(argb, uint32(p[3])<<24|uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2]))
+			}
+		}
+		return argb
+	}
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	argb := make([]uint32, 0, w*h)
+	for y := 0; y < h; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < w; x++ {
+			p := row[4*x : 4*x+4]
+			r, g, b, a := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+			switch a {
+			case 0:
+				r, g, b = 0, 0, 0
+			case 255:
+			default:
+				r, g, b = (r*255+a/2)/a, (g*255+a/2)/a, (b*255+a/2)/a
+			}
+			argb = append(argb, a<<24|min(r, 255)<<16|min(g, 255)<<8|min(b, 255))
+		}
+	}
+	return argb
+}
+
+func encodeVP8L(argb []uint32, width, height int) []byte {
+	bw := &bitWriter{}
+	bw.write(0x2f, 8) // signature
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	var alpha uint32
+	for _, p := range argb {
+		if p>>24 != 0xff {
+			alpha = 1
+			break
+		}
+	}
+	bw.write(alpha, 1)
+	bw.write(0, 3) // version
+
+	// Subtract green, then predict; the decoder undoes them in reverse
+	bw.write(1, 1)
+	bw.write(2, 2)
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | b
+	}
+
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(predictorBits-2, 3)
+	modes, residual := predict(argb, width, height)
+	writeImage(bw, modes, (width+1<<predictorBits-1)>>predictorBits, false, 0)
+
+	bw.write(0, 1) // no more transforms
+	writeImage(bw, residual, width, true, cacheBits)
+	return bw.bytes()
+}
+
+// predict picks a predictor per tile and returns the tile modes, stored in
+// the green channel, and the residual image
+func predict(argb []uint32, width, height int) ([]uint32, []uint32) {
+	tilesX := (width + 1<<predictorBits - 1) >> predictorBits
+	tilesY := (height + 1<<predictorBits - 1) >> predictorBits
+	modes := make([]uint32, tilesX*tilesY)
+	residual := make([]uint32, len(argb))
+
+	for ty := 0; ty < tilesY; ty++ {
+		y0, y1 := ty<<predictorBits, min((ty+1)<<predictorBits, height)
+		for tx := 0; tx < tilesX; tx++ {
+			x0, x1 := tx<<predictorBits, min((tx+1)<<predictorBits, width)
+			best, bestCost := predictorModes[0], -1
+			for _, mode := range predictorModes {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						cost += residualCost(subPixels(argb[y*width+x], predictPixel(argb, width, x, y, mode)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(best)<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residual[y*width+x] = subPixels(argb[y*width+x], predictPixel(argb, width, x, y, best))
+				}
+			}
+		}
+	}
+	return modes, residual
+}
+
+// predictPixel returns the prediction for x, y. The top row and left column
+// use fixed predictors whatever the tile's mode.
+func predictPixel(argb []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[i-1]
+	case x == 0:
+		return argb[i-width]
+	}
+	// At the right edge TR wraps to the first pixel of the current row,
+	// which is where i-width+1 lands
+	l, t, tl, tr := argb[i-1], argb[i-width], argb[i-width-1], argb[i-width+1]
+	switch mode {
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 7:
+		return average2(l, t)
+	case 12:
+		return clampAddSubtractFull(l, t, tl)
+	case 13:
+		return clampAddSubtractHalf(average2(l, t), tl)
+	}
+	return 0xff000000
+}
+
+func average2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(a>>shift&0xff) + int(b>>shift&0xff) - int(c>>shift&0xff)
+		out |= uint32(clamp255(v)) << shift
+	}
+	return out
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		ca, cb := int(a>>shift&0xff), int(b>>shift&0xff)
+		out |= uint32(clamp255(ca+(ca-cb)/2)) << shift
+	}
+	return out
+}
+
+func clamp255(v int) int {
+	return max(0, min(v, 255))
+}
+
+// subPixels subtracts b from a channel by channel, modulo 256
+func subPixels(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// residualCost sums the channels of a residual read as signed bytes
+func residualCost(p uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(int8(p >> shift))
+		if v < 0 {
+			v = -v
+		}
+		cost += v
+	}
+	return cost
+}
+
+// token is a literal pixel, a color cache hit when cache >= 0, or a copy of
+// length pixels when length > 0
+type token struct {
+	pixel  uint32
+	cache  int
+	length int
+	dist   int // distance code: 1 is the pixel above, 2 the one to the left
+}
+
+// backwardRefs replaces repeated pixel runs with copies of earlier ones,
+// found through a hash chain of pixel pairs plus the pixels left and above
+func backwardRefs(pix []uint32, width int) []token {
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pix))
+	insert := func(i int) {
+		if i+1 < len(pix) {
+			h := pairHash(pix[i], pix[i+1])
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLen := func(i, from, limit int) int {
+		n := 0
+		for n < limit && pix[i+n] == pix[from+n] {
+			n++
+		}
+		return n
+	}
+
+	var tokens []token
+	for i := 0; i < len(pix); {
+		limit := min(maxCopyLength, len(pix)-i)
+		best, bestDist := 0, 0
+		if i >= width {
+			best, bestDist = matchLen(i, i-width, limit), width
+		}
+		if i >= 1 {
+			if n := matchLen(i, i-1, limit); n > best {
+				best, bestDist = n, 1
+			}
+		}
+		if i+1 < len(pix) && best < limit {
+			cand := head[pairHash(pix[i], pix[i+1])]
+			for tries := 0; cand >= 0 && tries < maxChain && best < limit; tries++ {
+				from := int(cand)
+				if i-from > maxDistance {
+					break
+				}
+				if n := matchLen(i, from, limit); n > best {
+					best, bestDist = n, i-from
+				}
+				cand = prev[from]
+			}
+		}
+
+		if best >= minCopyLength {
+			tokens = append(tokens, token{cache: -1, length: best, dist: distanceCode(bestDist, width)})
+			for k := 0; k < best; k++ {
+				insert(i + k)
+			}
+			i += best
+			continue
+		}
+		tokens = append(tokens, token{pixel: pix[i], cache: -1})
+		insert(i)
+		i++
+	}
+	return tokens
+}
+
+func pairHash(a, b uint32) uint32 {
+	return (a*0x9e3779b1 ^ b*0x85ebca6b) >> (32 - hashBits)
+}
+
+// distanceCode maps a pixel distance to its distance code. The 120 smallest
+// codes stand for nearby 2D offsets; only the two for the pixel above and
+// the pixel to the left are used here, anything else is sent as is.
+func distanceCode(dist, width int) int {
+	switch dist {
+	case width:
+		return 1
+	case 1:
+		return 2
+	}
+	return dist + planeCodes
+}
+
+// applyColorCache turns literals whose color was seen recently into cache
+// hits. The decoder adds every pixel it produces to the cache, copies
+// included, so the encoder follows the same pixels.
+func applyColorCache(tokens []token, pix []uint32, bits int) {
+	cache := make([]uint32, 1<<bits)
+	valid := make([]bool, 1<<bits)
+	add := func(p uint32) int {
+		key := int((0x1e35a7bd * p) >> (32 - bits))
+		hit := valid[key] && cache[key] == p
+		cache[key], valid[key] = p, true
+		if hit {
+			return key
+		}
+		return -1
+	}
+	pos := 0
+	for i := range tokens {
+		t := &tokens[i]
+		if t.length > 0 {
+			for k := 0; k < t.length; k++ {
+				add(pix[pos+k])
+			}
+			pos += t.length
+			continue
+		}
+		t.cache = add(t.pixel)
+		pos++
+	}
+}
+
+// prefixEncode splits a length or distance into a prefix symbol and extra bits
+func prefixEncode(v int) (code, nbits, extra int) {
+	n := v - 1
+	if n < 4 {
+		return n, 0, 0
+	}
+	high := bits.Len(uint(n)) - 1
+	second := (n >> (high - 1)) & 1
+	nbits = high - 1
+	return 2*high + second, nbits, n & (1<<nbits - 1)
+}
+
+// writeImage writes an entropy coded image: the main image when main is set,
+// otherwise a sub-image such as the predictor modes. A color cache of
+// 1<<cache entries is used when cache > 0.
+func writeImage(bw *bitWriter, pix []uint32, width int, main bool, cache int) {
+	tokens := backwardRefs(pix, width)
+	if cache > 0 {
+		bw.write(1, 1)
+		bw.write(uint32(cache), 4)
+		applyColorCache(tokens, pix, cache)
+	} else {
+		bw.write(0, 1)
+	}
+	if main {
+		bw.write(0, 1) // one prefix code group for the whole image
+	}
+
+	cacheBase := literalSymbols + lengthSymbols
+	green := make([]uint32, cacheBase+(1<<cache)*min(cache, 1))
+	red := make([]uint32, 256)
+	blue := make([]uint32, 256)
+	alpha := make([]uint32, 256)
+	dist := make([]uint32, distSymbols)
+	for _, t := range tokens {
+		switch {
+		case t.length > 0:
+			code, _, _ := prefixEncode(t.length)
+			green[literalSymbols+code]++
+			code, _, _ = prefixEncode(t.dist)
+			dist[code]++
+		case t.cache >= 0:
+			green[cacheBase+t.cache]++
+		default:
+			green[t.pixel>>8&0xff]++
+			red[t.pixel>>16&0xff]++
+			blue[t.pixel&0xff]++
+			alpha[t.pixel>>24]++
+		}
+	}
+	gLen, gCode := writePrefixCode(bw, green)
+	rLen, rCode := writePrefixCode(bw, red)
+	bLen, bCode := writePrefixCode(bw, blue)
+	aLen, aCode := writePrefixCode(bw, alpha)
+	dLen, dCode := writePrefixCode(bw, dist)
+
+	for _, t := range tokens {
+		switch {
+		case t.length > 0:
+			code, nbits, extra := prefixEncode(t.length)
+			bw.write(uint32(gCode[literalSymbols+code]), uint(gLen[literalSymbols+code]))
+			bw.write(uint32(extra), uint(nbits))
+			code, nbits, extra = prefixEncode(t.dist)
+			bw.write(uint32(dCode[code]), uint(dLen[code]))
+			bw.write(uint32(extra), uint(nbits))
+		case t.cache >= 0:
+			bw.write(uint32(gCode[cacheBase+t.cache]), uint(gLen[cacheBase+t.cache]))
+		default:
+			g, r, b, a := t.pixel>>8&0xff, t.pixel>>16&0xff, t.pixel&0xff, t.pixel>>24
+			bw.write(uint32(gCode[g]), uint(gLen[g]))
+			bw.write(uint32(rCode[r]), uint(rLen[r]))
+			bw.write(uint32(bCode[b]), uint(bLen[b]))
+			bw.write(uint32(aCode[a]), uint(aLen[a]))
+		}
+	}
+}
+
+// writePrefixCode writes the prefix code for a histogram and returns each
+// symbol's code length and bit-reversed code
+func writePrefixCode(bw *bitWriter, hist []uint32) ([]uint8, []uint16) {
+	var used []int
+	for sym, c := range hist {
+		if c > 0 {
+			used = append(used, sym)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	lengths := make([]uint8, len(hist))
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		// Simple code: one or two 8-bit symbols
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return lengths, canonicalCodes(lengths)
+	}
+
+	lengths = huffmanLengths(hist, 15)
+
+	// Run-length code the lengths with symbols 16 to 18
+	type clToken struct{ sym, extra uint8 }
+	var cl []clToken
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 3 {
+				if run >= 11 {
+					k := min(run, 138)
+					cl = append(cl, clToken{18, uint8(k - 11)})
+					run -= k
+				} else {
+					k := min(run, 10)
+					cl = append(cl, clToken{17, uint8(k - 3)})
+					run -= k
+				}
+			}
+		} else {
+			cl = append(cl, clToken{l, 0})
+			run--
+			for run >= 3 {
+				k := min(run, 6)
+				cl = append(cl, clToken{16, uint8(k - 3)})
+				run -= k
+			}
+		}
+		for ; run > 0; run-- {
+			cl = append(cl, clToken{l, 0})
+		}
+	}
+
+	clHist := make([]uint32, 19)
+	for _, t := range cl {
+		clHist[t.sym]++
+	}
+	clLengths := huffmanLengths(clHist, 7)
+	clCodes := canonicalCodes(clLengths)
+
+	bw.write(0, 1) // normal code
+	n := len(codeLengthOrder)
+	for n > 4 && clLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, sym := range codeLengthOrder[:n] {
+		bw.write(uint32(clLengths[sym]), 3)
+	}
+	bw.write(0, 1) // lengths for the whole alphabet follow
+	for _, t := range cl {
+		bw.write(uint32(clCodes[t.sym]), uint(clLengths[t.sym]))
+		switch t.sym {
+		case 16:
+			bw.write(uint32(t.extra), 2)
+		case 17:
+			bw.write(uint32(t.extra), 3)
+		case 18:
+			bw.write(uint32(t.extra), 7)
+		}
+	}
+	return lengths, canonicalCodes(lengths)
+}
+
+// huffmanLengths returns code lengths of at most maxLen bits for a histogram.
+// At least two symbols get a code so that the code is always complete.
+func huffmanLengths(hist []uint32, maxLen int) []uint8 {
+	counts := make([]uint32, len(hist))
+	copy(counts, hist)
+	nonzero := 0
+	for _, c := range counts {
+		if c > 0 {
+			nonzero++
+		}
+	}
+	for sym := 0; nonzero < 2 && sym < len(counts); sym++ {
+		if counts[sym] == 0 {
+			counts[sym] = 1
+			nonzero++
+		}
+	}
+
+	for {
+		lengths := buildLengths(counts)
+		longest := uint8(0)
+		for _, l := range lengths {
+			longest = max(longest, l)
+		}
+		if int(longest) <= maxLen {
+			return lengths
+		}
+		// Flatten the distribution and try again
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// buildLengths builds an unrestricted Huffman tree and returns leaf depths
+func buildLengths(counts []uint32) []uint8 {
+	type node struct {
+		count  uint64
+		parent int
+	}
+	var nodes []node
+	var leaves []int // symbol of each leaf node
+	for sym, c := range counts {
+		if c > 0 {
+			nodes = append(nodes, node{count: uint64(c), parent: -1})
+			leaves = append(leaves, sym)
+		}
+	}
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return nodes[order[a]].count < nodes[order[b]].count })
+
+	// Two queues: sorted leaves and internal nodes, which come out sorted
+	var internal []int
+	pop := func() int {
+		if len(order) > 0 && (len(internal) == 0 || nodes[order[0]].count <= nodes[internal[0]].count) {
+			n := order[0]
+			order = order[1:]
+			return n
+		}
+		n := internal[0]
+		internal = internal[1:]
+		return n
+	}
+	for len(order)+len(internal) > 1 {
+		a, b := pop(), pop()
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, parent: -1})
+		parent := len(nodes) - 1
+		nodes[a].parent, nodes[b].parent = parent, parent
+		internal = append(internal, parent)
+	}
+
+	// Parents are created after their children, so walk back from the root
+	depth := make([]uint8, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if p := nodes[i].parent; p >= 0 {
+			depth[i] = depth[p] + 1
+		}
+	}
+	lengths := make([]uint8, len(counts))
+	for i, sym := range leaves {
+		lengths[sym] = depth[i]
+	}
+	return lengths
+}
+
+// canonicalCodes assigns canonical codes to code lengths, bit-reversed
+// because the bit writer is least significant bit first
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count [16]int
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [16]int
+	code := 0
+	for b := 1; b < 16; b++ {
+		code = (code + count[b-1]) << 1
+		next[b] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[sym] = uint16(bits.Reverse16(uint16(next[l])) >> (16 - l))
+		next[l]++
+	}
+	return codes
+}
+
+// bitWriter packs values least significant bit first
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+func (b *bitWriter) write(v uint32, nbits uint) {
+	b.acc |= uint64(v) << b.n
+	b.n += nbits
+	for b.n >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.n -= 8
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.n > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+	}
+	return b.buf
+}
@@ -0,0 +1,146 @@
+package locale
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"forum/models"
+)
+
+// Default is used when neither the user nor the browser picks a supported locale
+const Default = "en"
+
+//go:embed catalogs/*.tmpl
+var catalogFS embed.FS
+
+// catalogs holds one template set per locale, keyed by language tag
+var catalogs = loadCatalogs()
+
+// loadCatalogs parses every catalog and checks that it can render every
+// registered notification kind, so a missing translation fails at startup
+func loadCatalogs() map[string]*template.Template {
+	files, err := catalogFS.ReadDir("catalogs")
+	if err != nil {
+		panic(err)
+	}
+	out := make(map[string]*template.Template, len(files))
+	for _, f := range files {
+		lang := strings.TrimSuffix(f.Name(), path.Ext(f.Name()))
+		t := template.Must(template.New(lang).Option("missingkey=zero").ParseFS(catalogFS, "catalogs/"+f.Name()))
+		for _, kind := range append(models.NotificationKindTypes(), "actors", "unknown") {
+			if t.Lookup(kind) == nil {
+				panic(fmt.Sprintf("locale: catalog %s has no %q template", lang, kind))
+			}
+		}
+		out[lang] = t
+	}
+	if out[Default] == nil {
+		panic("locale: no catalog for the default locale " + Default)
+	}
+	return out
+}
+
+// Supported lists the available locales
+func Supported() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// IsSupported reports whether a catalog exists for the locale
+func IsSupported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Match picks the supported locale the Accept-Language header prefers most,
+// comparing primary language subtags ("fr-CA" matches "fr"). It returns
+// Default when nothing matches.
+func Match(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, q := parseLanguage(part)
+		if tag == "" || q <= bestQ {
+			continue
+		}
+		if IsSupported(tag) {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// parseLanguage splits "fr-CA;q=0.8" into "fr" and 0.8
+func parseLanguage(part string) (string, float64) {
+	fields := strings.Split(strings.TrimSpace(part), ";")
+	tag := strings.ToLower(strings.TrimSpace(fields[0]))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	q := 1.0
+	for _, f := range fields[1:] {
+		f = strings.TrimSpace(f)
+		if strings.HasPrefix(f, "q=") {
+			v, err := strconv.ParseFloat(f[2:], 64)
+			if err != nil {
+				return "", 0
+			}
+			q = v
+		}
+	}
+	if tag == "*" {
+		tag = Default
+	}
+	return tag, q
+}
+
+// messageData is what the catalog templates see
+type messageData struct {
+	First   string // most recent actor
+	Second  string // the one before, when listed
+	Count   int    // all actors
+	Others  int    // actors besides First
+	Payload models.NotificationPayload
+}
+
+// Render fills in n.Message in the given locale from its type, payload and
+// actors. n.Actors must be loaded, newest first.
+func Render(lang string, n *models.Notification) {
+	t, ok := catalogs[lang]
+	if !ok {
+		t = catalogs[Default]
+	}
+	data := messageData{Count: n.ActorCount, Payload: n.Payload}
+	if len(n.Actors) > 0 {
+		data.First = n.Actors[0].Username
+	} else {
+		data.Count = 0
+	}
+	if len(n.Actors) > 1 {
+		data.Second = n.Actors[1].Username
+	}
+	if data.Count > 0 {
+		data.Others = data.Count - 1
+	}
+
+	name := n.Type
+	if t.Lookup(name) == nil {
+		name = "unknown"
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		buf.Reset()
+		t.ExecuteTemplate(&buf, "unknown", data)
+	}
+	msg := buf.String()
+	n.Message = &msg
+}
@@ -0,0 +1,450 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Inline kinds
+const (
+	inlineText = iota
+	inlineHTML
+	inlineDelim
+)
+
+// inline is a piece of a paragraph: text still to be escaped, finished HTML,
+// or a run of * or _ that may become emphasis
+type inline struct {
+	kind  int
+	text  string
+	char  byte
+	count int // delimiters left unmatched
+	orig  int // length of the run as written
+
+	canOpen, canClose bool
+	inert             bool     // left as text by a match around it
+	opens, closes     []string // tags from the matches it took part in
+}
+
+var (
+	entityRef     = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	autolinkURI   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^<>\x00-\x20]*)>`)
+	autolinkEmail = regexp.MustCompile("^<([a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>")
+)
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// unescapeText resolves backslash escapes and entities, as in link
+// destinations, titles and code fence info strings
+func unescapeText(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			b.WriteByte(s[i+1])
+			i += 2
+			continue
+		}
+		if s[i] == '&' {
+			if m := entityRef.FindString(s[i:]); m != "" {
+				b.WriteString(html.UnescapeString(m))
+				i += len(m)
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// renderInline renders the inline content of a paragraph or heading. Links
+// can't be nested, so inside a link's text inLink is set.
+func (p *parser) renderInline(s string, inLink bool) string {
+	nodes := p.parseInline(s, inLink)
+	processEmphasis(nodes)
+
+	var out strings.Builder
+	for _, n := range nodes {
+		switch n.kind {
+		case inlineText:
+			out.WriteString(html.EscapeString(n.text))
+		case inlineHTML:
+			out.WriteString(n.text)
+		case inlineDelim:
+			out.WriteString(strings.Join(n.closes, ""))
+			out.WriteString(strings.Repeat(string(n.char), n.count))
+			out.WriteString(strings.Join(n.opens, ""))
+		}
+	}
+	return out.String()
+}
+
+func (p *parser) parseInline(s string, inLink bool) []*inline {
+	var nodes []*inline
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, &inline{kind: inlineText, text: text.String()})
+			text.Reset()
+		}
+	}
+	addHTML := func(h string) {
+		flush()
+		nodes = append(nodes, &inline{kind: inlineHTML, text: h})
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			addHTML("<br />\n")
+			i += 2
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			text.WriteByte(s[i+1])
+			i += 2
+		case c == '`':
+			n := runLength(s, i, '`')
+			if end := closingBackticks(s, i+n, n); end >= 0 {
+				addHTML("<code>" + html.EscapeString(codeSpanText(s[i+n:end])) + "</code>")
+				i = end + n
+			} else {
+				text.WriteString(s[i : i+n])
+				i += n
+			}
+		case c == '*' || c == '_':
+			n := runLength(s, i, c)
+			before, after := ' ', ' '
+			if i > 0 {
+				before, _ = utf8.DecodeLastRuneInString(s[:i])
+			}
+			if i+n < len(s) {
+				after, _ = utf8.DecodeRuneInString(s[i+n:])
+			}
+			left := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+			right := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+			d := &inline{kind: inlineDelim, char: c, count: n, orig: n, canOpen: left, canClose: right}
+			if c == '_' {
+				d.canOpen = left && (!right || isPunct(before))
+				d.canClose = right && (!left || isPunct(after))
+			}
+			flush()
+			nodes = append(nodes, d)
+			i += n
+		case c == '<':
+			if m := autolinkURI.FindStringSubmatch(s[i:]); m != nil && !inLink {
+				addHTML(linkHTML(m[1], "", html.EscapeString(m[1])))
+				i += len(m[0])
+			} else if m := autolinkEmail.FindStringSubmatch(s[i:]); m != nil && !inLink {
+				addHTML(linkHTML("mailto:"+m[1], "", html.EscapeString(m[1])))
+				i += len(m[0])
+			} else {
+				text.WriteByte(c)
+				i++
+			}
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			label, dest, title, end, ok := p.parseLink(s, i+1)
+			if !ok {
+				text.WriteByte(c)
+				i++
+				continue
+			}
+			// Images aren't embedded; they become links to the image
+			alt := p.renderInline(label, true)
+			if alt == "" {
+				alt = html.EscapeString(dest)
+			}
+			if inLink {
+				addHTML(alt)
+			} else {
+				addHTML(linkHTML(dest, title, alt))
+			}
+			i = end
+		case c == '[' && !inLink:
+			label, dest, title, end, ok := p.parseLink(s, i)
+			if !ok {
+				text.WriteByte(c)
+				i++
+				continue
+			}
+			addHTML(linkHTML(dest, title, p.renderInline(label, true)))
+			i = end
+		case c == '&':
+			if m := entityRef.FindString(s[i:]); m != "" {
+				text.WriteString(html.UnescapeString(m))
+				i += len(m)
+			} else {
+				text.WriteByte(c)
+				i++
+			}
+		case c == '\n':
+			// Two or more trailing spaces make a hard break
+			line := text.String()
+			trimmed := strings.TrimRight(line, " ")
+			text.Reset()
+			text.WriteString(trimmed)
+			if len(line)-len(trimmed) >= 2 {
+				addHTML("<br />\n")
+			} else {
+				text.WriteByte('\n')
+			}
+			for i++; i < len(s) && s[i] == ' '; i++ {
+			}
+		default:
+			text.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return nodes
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// closingBackticks finds the next run of exactly n backticks at or after i
+func closingBackticks(s string, i, n int) int {
+	for i < len(s) {
+		j := strings.IndexByte(s[i:], '`')
+		if j < 0 {
+			return -1
+		}
+		i += j
+		run := runLength(s, i, '`')
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// codeSpanText turns line breaks into spaces and drops one space of padding
+// from each side
+func codeSpanText(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if len(s) >= 2 && s[0] == ' ' && s[len(s)-1] == ' ' && strings.Trim(s, " ") != "" {
+		s = s[1 : len(s)-1]
+	}
+	return s
+}
+
+// processEmphasis pairs up * and _ runs into <em> and <strong>, following
+// CommonMark's delimiter rules
+func processEmphasis(nodes []*inline) {
+	for ci, c := range nodes {
+		if c.kind != inlineDelim || !c.canClose {
+			continue
+		}
+		for c.count > 0 {
+			oi := -1
+			for k := ci - 1; k >= 0; k-- {
+				o := nodes[k]
+				if o.kind != inlineDelim || o.inert || o.char != c.char || !o.canOpen || o.count == 0 {
+					continue
+				}
+				// A run that can both open and close can't pair with one whose
+				// length makes the total a multiple of three
+				if (o.canClose || c.canOpen) && (o.orig+c.orig)%3 == 0 && (o.orig%3 != 0 || c.orig%3 != 0) {
+					continue
+				}
+				oi = k
+				break
+			}
+			if oi < 0 {
+				break
+			}
+			o := nodes[oi]
+			tag := "em"
+			use := 1
+			if o.count >= 2 && c.count >= 2 {
+				tag, use = "strong", 2
+			}
+			o.count -= use
+			c.count -= use
+			o.opens = append([]string{"<" + tag + ">"}, o.opens...)
+			c.closes = append(c.closes, "</"+tag+">")
+			for _, n := range nodes[oi+1 : ci] {
+				n.inert = true
+			}
+		}
+	}
+}
+
+// parseLink reads a link starting at the [ at s[i]: inline, full, collapsed
+// or shortcut reference. It returns the link text, the destination and title,
+// and the index just past the link.
+func (p *parser) parseLink(s string, i int) (label, dest, title string, end int, ok bool) {
+	closing := matchBracket(s, i)
+	if closing < 0 {
+		return
+	}
+	label = s[i+1 : closing]
+	j := closing + 1
+	if j < len(s) && s[j] == '(' {
+		if dest, title, end, ok = parseDestination(s, j+1); ok {
+			return
+		}
+	}
+	ref := label
+	end = j
+	if j < len(s) && s[j] == '[' {
+		if k := strings.IndexAny(s[j+1:], "[]"); k >= 0 && s[j+1+k] == ']' {
+			if k > 0 {
+				ref = s[j+1 : j+1+k]
+			}
+			end = j + k + 2
+		}
+	}
+	if def, found := p.refs[normalizeLabel(ref)]; found {
+		return label, def.dest, def.title, end, true
+	}
+	return label, "", "", 0, false
+}
+
+// matchBracket finds the ] that closes the [ at s[i], skipping escapes,
+// nested brackets and code spans
+func matchBracket(s string, i int) int {
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			n := runLength(s, j, '`')
+			if end := closingBackticks(s, j+n, n); end >= 0 {
+				j = end + n - 1
+			} else {
+				j += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// parseDestination reads the destination and optional title of an inline
+// link, from just after its ( up to and including the )
+func parseDestination(s string, i int) (dest, title string, end int, ok bool) {
+	skipSpace := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+			i++
+		}
+	}
+	skipSpace()
+	if i < len(s) && s[i] == '<' {
+		j := strings.IndexAny(s[i+1:], "<>\n")
+		if j < 0 || s[i+1+j] != '>' {
+			return
+		}
+		dest = s[i+1 : i+1+j]
+		i += j + 2
+	} else {
+		start, depth := i, 0
+	loop:
+		for ; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+				i++
+			case c == '(':
+				depth++
+			case c == ')':
+				if depth == 0 {
+					break loop
+				}
+				depth--
+			case c <= ' ':
+				break loop
+			}
+		}
+		dest = s[start:i]
+	}
+	skipSpace()
+	if i < len(s) && strings.IndexByte(`"'(`, s[i]) >= 0 {
+		closer := s[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		j := i + 1
+		for ; j < len(s) && s[j] != closer; j++ {
+			if s[j] == '\\' {
+				j++
+			}
+		}
+		if j >= len(s) {
+			return
+		}
+		title = s[i+1 : j]
+		i = j + 1
+		skipSpace()
+	}
+	if i >= len(s) || s[i] != ')' {
+		return
+	}
+	return unescapeText(dest), unescapeText(title), i + 1, true
+}
+
+// linkHTML renders a link around already rendered text. A destination with a
+// scheme other than http, https or mailto leaves just the text.
+func linkHTML(dest, title, text string) string {
+	if !safeURL(dest) {
+		return text
+	}
+	a := `<a href="` + html.EscapeString(encodeURL(dest)) + `"`
+	if title != "" {
+		a += ` title="` + html.EscapeString(title) + `"`
+	}
+	return a + ">" + text + "</a>"
+}
+
+// safeURL reports whether u is relative or uses an allowed scheme
+func safeURL(u string) bool {
+	u = strings.TrimSpace(u)
+	colon := strings.IndexByte(u, ':')
+	if colon < 0 || strings.ContainsAny(u[:colon], "/?#") {
+		return true
+	}
+	switch strings.ToLower(u[:colon]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// encodeURL percent-encodes the bytes that can't appear in a URL as is
+func encodeURL(u string) string {
+	const keep = "-._~:/?#[]@!$&'()*+,;=%"
+	var b strings.Builder
+	for i := 0; i < len(u); i++ {
+		c := u[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(keep, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,458 @@
+// Package markdown renders the CommonMark used in posts and comments to HTML.
+// It covers paragraphs, headings, emphasis, code spans, fenced and indented
+// code blocks, block quotes, lists, thematic breaks, links (inline,
+// reference and autolinks) and hard line breaks. Raw HTML in the source is
+// shown as text, images are shown as links to the image, and the output goes
+// through Sanitize before it is returned.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version identifies the renderer's output. Bump it when a change should
+// reach HTML that is already cached, and stale rows are rendered again at
+// startup.
+const Version = 1
+
+// Render converts Markdown source to sanitized HTML
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	src = strings.ReplaceAll(src, "\x00", "�")
+	lines := strings.Split(src, "\n")
+	for i, l := range lines {
+		lines[i] = expandIndent(l)
+	}
+
+	p := &parser{refs: make(map[string]linkRef)}
+	blocks := p.parseBlocks(lines)
+	var out strings.Builder
+	p.renderBlocks(&out, blocks, false)
+	return Sanitize(out.String())
+}
+
+// Block kinds
+const (
+	blockParagraph = iota
+	blockHeading
+	blockCode
+	blockQuote
+	blockList
+	blockItem
+	blockBreak
+)
+
+type block struct {
+	kind       int
+	afterBlank bool     // a blank line came before it
+	lines      []string // text of paragraphs, headings and code
+	level      int      // heading level
+	lang       string   // language of a fenced code block
+	children   []*block // of quotes, lists and list items
+	ordered    bool
+	start      int
+	tight      bool
+}
+
+type linkRef struct {
+	dest, title string
+}
+
+type parser struct {
+	refs map[string]linkRef // link reference definitions by normalized label
+}
+
+var (
+	atxHeading    = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	thematicBreak = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	setextLine    = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	fenceOpen     = regexp.MustCompile("^(`{3,}|~{3,})[ \t]*([^`]*?)[ \t]*$")
+	refDefinition = regexp.MustCompile(`^\[((?:[^\[\]\\]|\\.){1,999})\]:[ \t]*(<[^<>\n]*>|\S+)(?:[ \t]+("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^()\\]|\\.)*\)))?[ \t]*$`)
+)
+
+// expandIndent turns tabs in a line's indentation into spaces, with tab
+// stops every four columns
+func expandIndent(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			b.WriteByte(' ')
+			col++
+		case '\t':
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// listMarker describes the bullet or number that starts a list item
+type listMarker struct {
+	ordered       bool
+	delim         byte // bullet character, or . or ) after a number
+	start         int
+	contentIndent int // column where the item's content starts
+	empty         bool
+}
+
+// parseListMarker reads a list item marker at the start of line
+func parseListMarker(line string) (listMarker, bool) {
+	var m listMarker
+	indent := indentOf(line)
+	if indent > 3 {
+		return m, false
+	}
+	rest := line[indent:]
+	width := 0
+	switch {
+	case rest != "" && strings.IndexByte("-+*", rest[0]) >= 0:
+		m.delim, width = rest[0], 1
+	default:
+		digits := 0
+		for digits < len(rest) && digits < 10 && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || digits > 9 || digits >= len(rest) || (rest[digits] != '.' && rest[digits] != ')') {
+			return m, false
+		}
+		m.ordered, m.delim, width = true, rest[digits], digits+1
+		m.start, _ = strconv.Atoi(rest[:digits])
+	}
+	after := rest[width:]
+	spaces := indentOf(after)
+	switch {
+	case isBlank(after):
+		m.empty = true
+		m.contentIndent = indent + width + 1
+	case spaces == 0:
+		return m, false
+	case spaces > 4:
+		// The content is an indented code block
+		m.contentIndent = indent + width + 1
+	default:
+		m.contentIndent = indent + width + spaces
+	}
+	return m, true
+}
+
+func isListItem(line string) bool {
+	_, ok := parseListMarker(line)
+	return ok
+}
+
+// startsBlock reports whether line opens a block other than a paragraph.
+// Inside a paragraph only lists starting at 1 with content may interrupt it.
+func startsBlock(line string, inParagraph bool) bool {
+	if isBlank(line) || indentOf(line) > 3 {
+		return false
+	}
+	t := strings.TrimLeft(line, " ")
+	if t[0] == '>' || atxHeading.MatchString(t) || thematicBreak.MatchString(t) || fenceOpen.MatchString(t) {
+		return true
+	}
+	m, ok := parseListMarker(line)
+	if !ok {
+		return false
+	}
+	return !inParagraph || (!m.empty && (!m.ordered || m.start == 1))
+}
+
+// parseBlocks splits lines into blocks, recursing into quotes and lists
+func (p *parser) parseBlocks(lines []string) []*block {
+	var blocks []*block
+	blank := false
+	add := func(b *block) {
+		b.afterBlank = blank && len(blocks) > 0
+		blocks = append(blocks, b)
+		blank = false
+	}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			blank = true
+			i++
+			continue
+		}
+		indent := indentOf(line)
+		if indent >= 4 {
+			b := &block{kind: blockCode}
+			for ; i < len(lines) && (isBlank(lines[i]) || indentOf(lines[i]) >= 4); i++ {
+				if len(lines[i]) >= 4 {
+					b.lines = append(b.lines, lines[i][4:])
+				} else {
+					b.lines = append(b.lines, "")
+				}
+			}
+			for len(b.lines) > 0 && isBlank(b.lines[len(b.lines)-1]) {
+				b.lines = b.lines[:len(b.lines)-1]
+			}
+			add(b)
+			continue
+		}
+		t := line[indent:]
+
+		if m := fenceOpen.FindStringSubmatch(t); m != nil {
+			fence := m[1]
+			b := &block{kind: blockCode}
+			if fields := strings.Fields(m[2]); len(fields) > 0 {
+				b.lang = unescapeText(fields[0])
+			}
+			for i++; i < len(lines); i++ {
+				l := lines[i]
+				if c := strings.TrimLeft(l, " "); indentOf(l) <= 3 && strings.HasPrefix(c, fence) &&
+					strings.Trim(strings.TrimRight(c, " \t"), fence[:1]) == "" {
+					i++
+					break
+				}
+				n := indentOf(l)
+				if n > indent {
+					n = indent
+				}
+				b.lines = append(b.lines, l[n:])
+			}
+			add(b)
+			continue
+		}
+		if m := atxHeading.FindStringSubmatch(t); m != nil {
+			add(&block{kind: blockHeading, level: len(m[1]), lines: []string{m[2]}})
+			i++
+			continue
+		}
+		if thematicBreak.MatchString(t) {
+			add(&block{kind: blockBreak})
+			i++
+			continue
+		}
+		if t[0] == '>' {
+			var inner []string
+			for ; i < len(lines); i++ {
+				l := lines[i]
+				c := strings.TrimLeft(l, " ")
+				if indentOf(l) <= 3 && strings.HasPrefix(c, ">") {
+					c = c[1:]
+					if strings.HasPrefix(c, " ") {
+						c = c[1:]
+					}
+					inner = append(inner, c)
+					continue
+				}
+				// Lazy continuation of a paragraph inside the quote
+				if !isBlank(l) && len(inner) > 0 && !isBlank(inner[len(inner)-1]) && !startsBlock(l, true) {
+					inner = append(inner, l)
+					continue
+				}
+				break
+			}
+			add(&block{kind: blockQuote, children: p.parseBlocks(inner)})
+			continue
+		}
+		if _, ok := parseListMarker(line); ok {
+			var list *block
+			list, i = p.parseList(lines, i)
+			add(list)
+			continue
+		}
+
+		// Paragraph, possibly turned into a setext heading
+		para := []string{strings.TrimLeft(line, " ")}
+		heading := 0
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if isBlank(l) {
+				break
+			}
+			if indentOf(l) <= 3 && setextLine.MatchString(strings.TrimLeft(l, " ")) {
+				heading = 2
+				if strings.TrimLeft(l, " ")[0] == '=' {
+					heading = 1
+				}
+				i++
+				break
+			}
+			if startsBlock(l, true) {
+				break
+			}
+			para = append(para, strings.TrimLeft(l, " "))
+		}
+		para = p.takeDefinitions(para)
+		if len(para) == 0 {
+			continue
+		}
+		if heading > 0 {
+			add(&block{kind: blockHeading, level: heading, lines: para})
+		} else {
+			add(&block{kind: blockParagraph, lines: para})
+		}
+	}
+	return blocks
+}
+
+// parseList reads the items of one list starting at lines[i] and returns it
+// with the index of the first line after it
+func (p *parser) parseList(lines []string, i int) (*block, int) {
+	first, _ := parseListMarker(lines[i])
+	list := &block{kind: blockList, ordered: first.ordered, start: first.start, tight: true}
+	blankBefore := false
+	for i < len(lines) {
+		m, ok := parseListMarker(lines[i])
+		if !ok || m.ordered != first.ordered || m.delim != first.delim || thematicBreak.MatchString(strings.TrimLeft(lines[i], " ")) {
+			break
+		}
+		var item []string
+		if !m.empty {
+			item = append(item, lines[i][m.contentIndent:])
+		} else {
+			item = append(item, "")
+		}
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			switch {
+			case isBlank(l):
+				// An empty item ends at its first blank line
+				if m.empty && len(item) == 1 {
+					goto done
+				}
+				item = append(item, "")
+			case indentOf(l) >= m.contentIndent:
+				item = append(item, l[m.contentIndent:])
+			case !isBlank(item[len(item)-1]) && !startsBlock(l, true) && !isListItem(l) && !setextLine.MatchString(strings.TrimLeft(l, " ")):
+				// Lazy continuation of the item's last paragraph
+				item = append(item, strings.TrimLeft(l, " "))
+			default:
+				goto done
+			}
+		}
+	done:
+		trailing := 0
+		for len(item) > 0 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+			trailing++
+		}
+		children := p.parseBlocks(item)
+		for _, c := range children {
+			if c.afterBlank {
+				list.tight = false
+			}
+		}
+		if blankBefore {
+			list.tight = false
+		}
+		list.children = append(list.children, &block{kind: blockItem, children: children})
+		for ; i < len(lines) && isBlank(lines[i]); i++ {
+			trailing++
+		}
+		blankBefore = trailing > 0
+	}
+	// Blank lines after the last item belong to whatever follows
+	for i > 0 && isBlank(lines[i-1]) {
+		i--
+	}
+	return list, i
+}
+
+// takeDefinitions removes link reference definitions from the start of a
+// paragraph and records them. The first definition of a label wins.
+func (p *parser) takeDefinitions(para []string) []string {
+	for len(para) > 0 {
+		m := refDefinition.FindStringSubmatch(para[0])
+		if m == nil {
+			break
+		}
+		label := normalizeLabel(m[1])
+		if label == "" {
+			break
+		}
+		if _, seen := p.refs[label]; !seen {
+			dest := m[2]
+			if strings.HasPrefix(dest, "<") {
+				dest = dest[1 : len(dest)-1]
+			}
+			title := ""
+			if len(m[3]) >= 2 {
+				title = m[3][1 : len(m[3])-1]
+			}
+			p.refs[label] = linkRef{dest: unescapeText(dest), title: unescapeText(title)}
+		}
+		para = para[1:]
+	}
+	return para
+}
+
+// normalizeLabel makes reference labels match case-insensitively and
+// regardless of spacing
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// renderBlocks writes blocks as HTML. In tight lists paragraphs lose their
+// <p> tags.
+func (p *parser) renderBlocks(out *strings.Builder, blocks []*block, tight bool) {
+	for _, b := range blocks {
+		switch b.kind {
+		case blockParagraph:
+			text := p.renderInline(strings.TrimRight(strings.Join(b.lines, "\n"), " "), false)
+			if tight {
+				out.WriteString(text)
+				out.WriteString("\n")
+			} else {
+				out.WriteString("<p>" + text + "</p>\n")
+			}
+		case blockHeading:
+			tag := "h" + strconv.Itoa(b.level)
+			out.WriteString("<" + tag + ">" + p.renderInline(strings.TrimSpace(strings.Join(b.lines, "\n")), false) + "</" + tag + ">\n")
+		case blockCode:
+			out.WriteString("<pre><code")
+			if b.lang != "" {
+				out.WriteString(` class="language-` + html.EscapeString(b.lang) + `"`)
+			}
+			out.WriteString(">")
+			for _, l := range b.lines {
+				out.WriteString(html.EscapeString(l) + "\n")
+			}
+			out.WriteString("</code></pre>\n")
+		case blockQuote:
+			out.WriteString("<blockquote>\n")
+			p.renderBlocks(out, b.children, false)
+			out.WriteString("</blockquote>\n")
+		case blockList:
+			tag := "ul"
+			if b.ordered {
+				tag = "ol"
+			}
+			out.WriteString("<" + tag)
+			if b.ordered && b.start != 1 {
+				out.WriteString(` start="` + strconv.Itoa(b.start) + `"`)
+			}
+			out.WriteString(">\n")
+			for _, item := range b.children {
+				var inner strings.Builder
+				p.renderBlocks(&inner, item.children, b.tight)
+				out.WriteString("<li>" + strings.TrimSuffix(inner.String(), "\n") + "</li>\n")
+			}
+			out.WriteString("</" + tag + ">\n")
+		case blockBreak:
+			out.WriteString("<hr />\n")
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowedTags maps each tag that may appear in rendered content to the
+// attributes it may keep. Anything else is dropped, keeping its text.
+var allowedTags = map[string]map[string]func(string) bool{
+	"p": nil, "br": nil, "hr": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"em": nil, "strong": nil, "code": nil, "pre": nil, "blockquote": nil,
+	"ul": nil, "li": nil,
+	"ol": {"start": regexp.MustCompile(`^[0-9]{1,9}$`).MatchString},
+	"a":  {"href": safeURL, "title": func(string) bool { return true }},
+}
+
+// Tags whose content goes too when they are dropped
+var droppedWithContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"textarea": true, "title": true, "noscript": true, "template": true, "svg": true, "math": true,
+}
+
+var voidTags = map[string]bool{"br": true, "hr": true}
+
+var codeClass = regexp.MustCompile(`^language-[A-Za-z0-9_+#.-]{1,32}$`)
+
+// linkRel is set on every link, replacing any rel the input had
+const linkRel = "nofollow noopener noreferrer"
+
+type tag struct {
+	name    string
+	closing bool
+	attrs   [][2]string
+}
+
+// Sanitize keeps only allowlisted tags and attributes of s. Scripts, styles
+// and comments are removed with their content, unsafe link targets are
+// dropped, links get rel="nofollow noopener noreferrer" and any tag left
+// open is closed.
+func Sanitize(s string) string {
+	var out strings.Builder
+	var open []string
+	text := func(t string) {
+		out.WriteString(html.EscapeString(html.UnescapeString(t)))
+	}
+
+	for i := 0; i < len(s); {
+		lt := strings.IndexByte(s[i:], '<')
+		if lt < 0 {
+			text(s[i:])
+			break
+		}
+		text(s[i : i+lt])
+		i += lt
+		if strings.HasPrefix(s[i:], "<!--") {
+			end := strings.Index(s[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += end + 7
+			continue
+		}
+		t, n, ok := parseTag(s[i:])
+		if !ok {
+			out.WriteString("&lt;")
+			i++
+			continue
+		}
+		i += n
+
+		if t.closing {
+			for k := len(open) - 1; k >= 0; k-- {
+				if open[k] == t.name {
+					for len(open) > k {
+						out.WriteString("</" + open[len(open)-1] + ">")
+						open = open[:len(open)-1]
+					}
+					break
+				}
+			}
+			continue
+		}
+		if droppedWithContent[t.name] {
+			end := strings.Index(strings.ToLower(s[i:]), "</"+t.name)
+			if end < 0 {
+				break
+			}
+			i += end
+			if gt := strings.IndexByte(s[i:], '>'); gt >= 0 {
+				i += gt + 1
+			} else {
+				i = len(s)
+			}
+			continue
+		}
+		rules, allowed := allowedTags[t.name]
+		if !allowed {
+			continue
+		}
+		out.WriteString("<" + t.name)
+		for _, attr := range t.attrs {
+			name, value := attr[0], attr[1]
+			keep := false
+			if check, ok := rules[name]; ok {
+				keep = check(value)
+			} else if t.name == "code" && name == "class" {
+				keep = codeClass.MatchString(value)
+			}
+			if keep {
+				out.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+			}
+		}
+		if t.name == "a" {
+			out.WriteString(` rel="` + linkRel + `"`)
+		}
+		if voidTags[t.name] {
+			out.WriteString(" />")
+			continue
+		}
+		out.WriteString(">")
+		open = append(open, t.name)
+	}
+	for k := len(open) - 1; k >= 0; k-- {
+		out.WriteString("</" + open[k] + ">")
+	}
+	return out.String()
+}
+
+// parseTag reads the tag at the start of s, returning it and its length.
+// Names are lowercased and attribute values unescaped.
+func parseTag(s string) (tag, int, bool) {
+	var t tag
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		t.closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && (isLetter(s[i]) || (i > start && s[i] >= '0' && s[i] <= '9')) {
+		i++
+	}
+	if i == start {
+		return t, 0, false
+	}
+	t.name = strings.ToLower(s[start:i])
+
+	for i < len(s) {
+		for i < len(s) && isTagSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		if s[i] == '>' {
+			return t, i + 1, true
+		}
+		if s[i] == '/' {
+			i++
+			continue
+		}
+		nameStart := i
+		for i < len(s) && !isTagSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(s[nameStart:i])
+		for i < len(s) && isTagSpace(s[i]) {
+			i++
+		}
+		value := ""
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isTagSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end < 0 {
+					return t, 0, false
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				valueStart := i
+				for i < len(s) && !isTagSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[valueStart:i]
+			}
+		}
+		if name != "" {
+			t.attrs = append(t.attrs, [2]string{name, html.UnescapeString(value)})
+		}
+	}
+	return t, 0, false
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isTagSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
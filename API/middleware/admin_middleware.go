@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"forum/models"
+	"forum/utils"
+
+	"github.com/google/uuid"
+)
+
+// AdminList names the users allowed to call admin endpoints by user ID.
+// Users have no roles, so the list comes from ADMIN_USERS, a comma-separated
+// list; empty means nobody is an admin. Usernames are not accepted: anyone
+// could register a listed name that is not taken yet, while IDs never change.
+type AdminList map[string]bool
+
+// AdminsFromEnv reads ADMIN_USERS (see utils.LoadEnv). Entries that are not
+// user IDs are skipped with a warning.
+func AdminsFromEnv() AdminList {
+	admins := AdminList{}
+	for _, id := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			log.Printf("Admin [WARN]: ignoring ADMIN_USERS entry %q, which is not a user ID", id)
+			continue
+		}
+		admins[strings.ToLower(id)] = true
+	}
+	return admins
+}
+
+// IsAdmin reports whether the user's ID is on the list
+func (a AdminList) IsAdmin(user *models.User) bool {
+	return user != nil && a[strings.ToLower(user.ID)]
+}
+
+// Require rejects everyone who is not an admin. Use it inside RequireAuth.
+func (a AdminList) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.IsAdmin(GetCurrentUser(r)) {
+			utils.ErrorResponse(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -199,3 +199,27 @@ func (m *AuthMiddleware) CSRF(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// WebSocketCSRF applies the CSRF check to WebSocket upgrades, which are always
+// GET requests. Browsers cannot set custom headers on the handshake, so the
+// token is read from the csrf_token query parameter.
+func (m *AuthMiddleware) WebSocketCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := GetCurrentSession(r)
+		if session == nil {
+			log.Printf("AuthMiddleware [WARN]: WebSocket CSRF check failed: No session in context for %s", r.URL.Path)
+			http.Error(w, "Forbidden: No active session for CSRF check", http.StatusForbidden)
+			return
+		}
+		token := r.URL.Query().Get("csrf_token")
+		if token == "" {
+			token = r.Header.Get("X-CSRF-Token")
+		}
+		if session.CSRFToken != "" && token != session.CSRFToken {
+			log.Printf("AuthMiddleware [WARN]: WebSocket CSRF check failed for path %s: missing or mismatched token.", r.URL.Path)
+			http.Error(w, "Forbidden: Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,23 +2,41 @@ package models
 
 import "time"
 
+// MaxCommentDepth is the deepest a reply may be nested; top-level comments
+// have depth 0
+const MaxCommentDepth = 4
+
+// MaxCommentLength caps a comment's content in characters, the same the
+// comments table checks
+const MaxCommentLength = 1000
+
 type Comment struct {
-	ID        string     `json:"id"`
-	PostID    string     `json:"post_id"`
-	UserID    string     `json:"user_id"`
-	Content   *string    `json:"content"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	ID              string     `json:"id"`
+	PostID          string     `json:"post_id"`
+	UserID          string     `json:"user_id"`
+	ParentCommentID *string    `json:"parent_comment_id,omitempty"`
+	Depth           int        `json:"depth"`
+	Content         *string    `json:"content"`
+	ContentHTML     *string    `json:"content_html"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+	Mentions        []Mention  `json:"mentions,omitempty"`
 }
 
 
 // CommentWithUser is a comment along with the username of its author
 type CommentWithUser struct {
-	ID        string     `json:"id"`
-	PostID    string     `json:"post_id"`
-	UserID    string     `json:"user_id"`
-	Username  string     `json:"username"`
-	Content   *string    `json:"content"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	ID              string     `json:"id"`
+	PostID          string     `json:"post_id"`
+	UserID          string     `json:"user_id"`
+	Username        string     `json:"username"`
+	ParentCommentID *string    `json:"parent_comment_id,omitempty"`
+	Depth           int        `json:"depth"`
+	Content         *string    `json:"content"`
+	ContentHTML     *string    `json:"content_html"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+	Mentions        []Mention  `json:"mentions,omitempty"`
+	// Replies is only filled in by CommentTree
+	Replies []CommentWithUser `json:"replies,omitempty"`
 }
\ No newline at end of file
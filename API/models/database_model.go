@@ -14,7 +14,7 @@ import (
 
 // Database version constants
 const (
-	CURRENT_DB_VERSION = 6 // Updated to version 6 for notifications composite index
+	CURRENT_DB_VERSION = 26 // Updated to version 26 for rowid-keyed search indexes
 	INITIAL_VERSION    = 1
 )
 
@@ -83,10 +83,294 @@ func GetMigrations() []Migration {
 				config.IdxNotificationsUserCreated,
 			},
 		},
+		{
+			Version:     7,
+			Description: "Add notification preferences",
+			SQL: []string{
+				config.CreateNotificationPreferencesTable,
+			},
+		},
+		{
+			Version:     8,
+			Description: "Group notifications by target",
+			SQL: []string{
+				`ALTER TABLE notifications ADD COLUMN group_key TEXT`,
+				`ALTER TABLE notifications ADD COLUMN actor_count INTEGER NOT NULL DEFAULT 1`,
+				config.CreateNotificationActorsTable,
+				config.IdxNotificationsUserGroup,
+				// Existing rows become groups of one
+				`INSERT OR IGNORE INTO notification_actors (notification_id, actor_id, created_at)
+					SELECT notification_id, actor_id, created_at FROM notifications WHERE actor_id IS NOT NULL`,
+			},
+		},
+		{
+			Version:     9,
+			Description: "Add mentions",
+			SQL: []string{
+				config.CreateMentionsTable,
+				config.IdxMentionsPostID,
+				config.IdxMentionsCommentID,
+				config.IdxMentionsUserID,
+			},
+		},
+		{
+			Version:     10,
+			Description: "Add post subscriptions",
+			SQL: []string{
+				config.CreatePostSubscriptionsTable,
+				config.IdxPostSubscriptionsPostID,
+				// Subscribe everyone already taking part, authors first so
+				// they keep the author reason
+				`INSERT OR IGNORE INTO post_subscriptions (user_id, post_id, state, reason)
+					SELECT user_id, post_id, 'subscribed', 'author' FROM posts`,
+				`INSERT OR IGNORE INTO post_subscriptions (user_id, post_id, state, reason)
+					SELECT DISTINCT user_id, post_id, 'subscribed', 'comment' FROM comments`,
+				`INSERT OR IGNORE INTO post_subscriptions (user_id, post_id, state, reason)
+					SELECT DISTINCT user_id, post_id, 'subscribed', 'reaction' FROM reactions WHERE post_id IS NOT NULL`,
+			},
+		},
+		{
+			Version:     11,
+			Description: "Add email settings and notification deliveries",
+			SQL: []string{
+				config.CreateNotificationEmailSettingsTable,
+				config.CreateNotificationDeliveriesTable,
+				config.IdxNotificationDeliveriesNotification,
+				config.IdxNotificationDeliveriesUser,
+			},
+		},
+		{
+			Version:     12,
+			Description: "Add webhooks",
+			SQL: []string{
+				config.CreateWebhooksTable,
+				config.CreateWebhookDeliveriesTable,
+				config.IdxWebhooksUserID,
+				config.IdxWebhookDeliveriesWebhook,
+				config.IdxWebhookDeliveriesStatus,
+			},
+		},
+		{
+			Version:     13,
+			Description: "Add push subscriptions",
+			SQL: []string{
+				config.CreatePushSubscriptionsTable,
+				config.IdxPushSubscriptionsUser,
+			},
+		},
+		{
+			Version:     14,
+			Description: "Add notification archive and retention runs",
+			SQL: []string{
+				config.CreateNotificationsArchiveTable,
+				config.CreateNotificationRetentionRunsTable,
+				config.IdxNotificationsCreated,
+				config.IdxNotificationsArchiveArchived,
+			},
+		},
+		{
+			Version:     15,
+			Description: "Add notification snoozing",
+			SQL: []string{
+				`ALTER TABLE notifications ADD COLUMN snoozed_until TIMESTAMP`,
+				config.IdxNotificationsUserUnread,
+				config.IdxNotificationsSnoozed,
+			},
+		},
+		{
+			Version:     16,
+			Description: "Store notification payloads instead of rendered messages",
+			SQL: append(append(append([]string{
+				`ALTER TABLE notifications ADD COLUMN payload TEXT NOT NULL DEFAULT '{}'`,
+				`ALTER TABLE notifications ADD COLUMN deleted_at TIMESTAMP`,
+				`UPDATE notifications SET deleted_at = COALESCE(updated_at, created_at) WHERE message IS NULL`,
+				`ALTER TABLE notifications_archive ADD COLUMN payload TEXT NOT NULL DEFAULT '{}'`,
+				// Messages are rendered from the actor list, so every row needs one
+				`INSERT OR IGNORE INTO notification_actors (notification_id, actor_id, created_at)
+					SELECT notification_id, actor_id, COALESCE(updated_at, created_at) FROM notifications WHERE actor_id IS NOT NULL`,
+			}, payloadBackfill("notifications")...), payloadBackfill("notifications_archive")...),
+				`DROP INDEX IF EXISTS idx_notifications_user_unread`,
+				config.IdxNotificationsUserUnreadVisible,
+				`ALTER TABLE notifications DROP COLUMN message`,
+				`ALTER TABLE notifications_archive DROP COLUMN message`,
+				`ALTER TABLE user ADD COLUMN locale TEXT`,
+			),
+		},
+		{
+			Version:     17,
+			Description: "Add threaded comment replies",
+			SQL: []string{
+				`ALTER TABLE comments ADD COLUMN parent_comment_id TEXT REFERENCES comments(comment_id) ON DELETE CASCADE`,
+				`ALTER TABLE comments ADD COLUMN depth INTEGER NOT NULL DEFAULT 0 CHECK (depth >= 0)`,
+				config.IdxCommentsParent,
+			},
+		},
+		{
+			Version:     18,
+			Description: "Add full-text search over posts and comments",
+			SQL: []string{
+				config.CreatePostsFTSTable,
+				config.CreateCommentsFTSTable,
+				config.TriggerPostsFTSInsert,
+				config.TriggerPostsFTSUpdate,
+				config.TriggerPostsFTSDelete,
+				config.TriggerCommentsFTSInsert,
+				config.TriggerCommentsFTSUpdate,
+				config.TriggerCommentsFTSDelete,
+				config.BackfillPostsFTS,
+				config.BackfillCommentsFTS,
+			},
+		},
+		{
+			Version:     19,
+			Description: "Add comment and reaction counters to posts for paginated feeds",
+			SQL: []string{
+				`ALTER TABLE posts ADD COLUMN comment_count INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE posts ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE posts ADD COLUMN dislike_count INTEGER NOT NULL DEFAULT 0`,
+				config.TriggerCommentCountInsert,
+				config.TriggerCommentCountUpdate,
+				config.TriggerCommentCountDelete,
+				config.TriggerReactionCountInsert,
+				config.TriggerReactionCountUpdate,
+				config.TriggerReactionCountDelete,
+				config.BackfillPostCounts,
+				config.IdxPostsCreated,
+				config.IdxPostsCommentCount,
+				config.IdxPostsLikeCount,
+			},
+		},
+		{
+			Version:     20,
+			Description: "Add post and comment revision history",
+			SQL: []string{
+				config.CreatePostRevisionsTable,
+				config.CreateCommentRevisionsTable,
+				// Earlier edits were not kept, so the current text is revision 1
+				`INSERT INTO post_revisions (post_id, revision, title, content, created_at)
+					SELECT post_id, 1, title, content, COALESCE(updated_at, created_at) FROM posts
+					WHERE title IS NOT NULL OR content IS NOT NULL`,
+				`INSERT INTO comment_revisions (comment_id, revision, content, created_at)
+					SELECT comment_id, 1, content, COALESCE(updated_at, created_at) FROM comments
+					WHERE content IS NOT NULL`,
+			},
+		},
+		{
+			Version:     21,
+			Description: "Cache rendered Markdown of posts and comments",
+			SQL: []string{
+				`ALTER TABLE posts ADD COLUMN content_html TEXT`,
+				`ALTER TABLE posts ADD COLUMN html_version INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE comments ADD COLUMN content_html TEXT`,
+				`ALTER TABLE comments ADD COLUMN html_version INTEGER NOT NULL DEFAULT 0`,
+				config.TriggerPostHTMLInvalidate,
+				config.TriggerCommentHTMLInvalidate,
+				// Existing content is rendered at startup, see
+				// repository.RefreshContentHTML
+			},
+		},
+		{
+			Version:     22,
+			Description: "Add draft and scheduled post status",
+			SQL: []string{
+				// Existing posts were published when they were created
+				`ALTER TABLE posts ADD COLUMN status TEXT NOT NULL DEFAULT 'published'
+					CHECK (status IN ('draft', 'scheduled', 'published'))`,
+				`ALTER TABLE posts ADD COLUMN publish_at DATETIME`,
+				config.IdxPostsUserStatus,
+				config.IdxPostsScheduled,
+			},
+		},
+		{
+			Version:     23,
+			Description: "Add image gallery position, caption and alt text",
+			SQL: []string{
+				`ALTER TABLE images ADD COLUMN position INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE images ADD COLUMN caption TEXT NOT NULL DEFAULT '' CHECK (LENGTH(caption) <= 300)`,
+				`ALTER TABLE images ADD COLUMN alt_text TEXT NOT NULL DEFAULT '' CHECK (LENGTH(alt_text) <= 300)`,
+				// Existing images keep their upload order
+				`UPDATE images SET position = (
+					SELECT COUNT(*) FROM images earlier
+					WHERE earlier.post_id = images.post_id
+						AND (earlier.created_at < images.created_at
+							OR (earlier.created_at = images.created_at AND earlier.image_id < images.image_id)))`,
+				config.IdxImagesPostPosition,
+			},
+		},
+		{
+			Version:     24,
+			Description: "Add image dimensions and responsive variants",
+			SQL: []string{
+				// Images uploaded before this stay 0 by 0 with no variants
+				`ALTER TABLE images ADD COLUMN width INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE images ADD COLUMN height INTEGER NOT NULL DEFAULT 0`,
+				config.CreateImageVariantsTable,
+			},
+		},
+		{
+			Version:     25,
+			Description: "Add image content hashes and file reference indexes",
+			SQL: []string{
+				// Existing images have no hash and keep their paths
+				`ALTER TABLE images ADD COLUMN content_hash TEXT`,
+				config.IdxImagesContentHash,
+				config.IdxImagesFilePath,
+				config.IdxImagesThumbnailPath,
+				config.IdxImageVariantsFilePath,
+			},
+		},
+		{
+			Version:     26,
+			Description: "Key full-text search rows by a stable rowid",
+			SQL: []string{
+				// Migration 18 keyed rows by post_id and comment_id alone,
+				// which triggers could only find with a full scan
+				`DROP TRIGGER IF EXISTS posts_fts_insert`,
+				`DROP TRIGGER IF EXISTS posts_fts_update`,
+				`DROP TRIGGER IF EXISTS posts_fts_delete`,
+				`DROP TRIGGER IF EXISTS comments_fts_insert`,
+				`DROP TRIGGER IF EXISTS comments_fts_update`,
+				`DROP TRIGGER IF EXISTS comments_fts_delete`,
+				`DROP TABLE IF EXISTS posts_fts`,
+				`DROP TABLE IF EXISTS comments_fts`,
+				config.CreatePostsFTSTable,
+				config.CreateCommentsFTSTable,
+				config.CreatePostsFTSKeysTable,
+				config.CreateCommentsFTSKeysTable,
+				config.TriggerPostsFTSInsertKeyed,
+				config.TriggerPostsFTSUpdateKeyed,
+				config.TriggerPostsFTSDeleteKeyed,
+				config.TriggerCommentsFTSInsertKeyed,
+				config.TriggerCommentsFTSUpdateKeyed,
+				config.TriggerCommentsFTSDeleteKeyed,
+				config.BackfillPostsFTSKeys,
+				config.BackfillPostsFTSKeyed,
+				config.BackfillCommentsFTSKeys,
+				config.BackfillCommentsFTSKeyed,
+			},
+		},
 		// Add future migrations here
 	}
 }
 
+// payloadBackfill derives the structured payload of notifications stored
+// with only an English message. Deleted rows have no message left; they get
+// the defaults and are purged by the retention job anyway.
+func payloadBackfill(table string) []string {
+	return []string{
+		`UPDATE ` + table + ` SET payload = json_object('where',
+			CASE WHEN message LIKE '%your post' THEN 'own' ELSE 'followed' END)
+			WHERE type = 'comment'`,
+		`UPDATE ` + table + ` SET payload = json_object(
+			'target', CASE WHEN comment_id IS NULL THEN 'post' ELSE 'comment' END,
+			'verb', CASE WHEN message LIKE '% disliked your %' THEN 'dislike' ELSE 'like' END)
+			WHERE type = 'reaction'`,
+		`UPDATE ` + table + ` SET payload = json_object('target',
+			CASE WHEN comment_id IS NULL THEN 'post' ELSE 'comment' END)
+			WHERE type = 'mention'`,
+	}
+}
+
 // InitDB initializes the database and returns a connection
 func InitDB() (*sql.DB, error) {
 	dbPath := filepath.Join("./database", "forum.db")
@@ -99,7 +383,7 @@ func InitDB() (*sql.DB, error) {
 		}
 	}
 
-	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -109,6 +393,10 @@ func InitDB() (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
+	if err := checkFTS5(db); err != nil {
+		db.Close()
+		return nil, err
+	}
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
 
@@ -158,6 +446,19 @@ func InitDB() (*sql.DB, error) {
 	return db, nil
 }
 
+// checkFTS5 fails early when the SQLite driver was built without FTS5,
+// which search needs, instead of failing halfway through a migration
+func checkFTS5(db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return fmt.Errorf("failed to check SQLite options: %v", err)
+	}
+	if !enabled {
+		return fmt.Errorf("SQLite was built without FTS5; build the API with -tags sqlite_fts5")
+	}
+	return nil
+}
+
 func createDatabaseVersionTable(db *sql.DB) error {
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS database_version (
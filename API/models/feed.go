@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Feed sort orders
+const (
+	FeedNewest   = "newest"
+	FeedComments = "comments" // most commented
+	FeedLikes    = "likes"    // most liked
+	FeedHot      = "hot"      // likes, dislikes and comments, decaying with age
+)
+
+// FeedQuery asks for one page of posts, across all categories or in one
+type FeedQuery struct {
+	Sort       string // FeedNewest, FeedComments, FeedLikes or FeedHot
+	CategoryID int    // 0 for every category
+	Limit      int
+	Cursor     string // opaque, from a previous page's NextCursor
+}
+
+// FeedPost is a post as listed in a feed, with its counts instead of its
+// comments and reactions
+type FeedPost struct {
+	ID           string         `json:"id"`
+	UserID       string         `json:"user_id"`
+	Username     string         `json:"username"`
+	Title        string         `json:"title"`
+	Content      string         `json:"content"`
+	ContentHTML  string         `json:"content_html"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    *time.Time     `json:"updated_at,omitempty"`
+	Categories   []Category     `json:"categories"`
+	ImageURL     string         `json:"image_url,omitempty"`
+	ThumbnailURL string         `json:"thumbnail_url,omitempty"`
+	Images       []GalleryImage `json:"images"`
+	CommentCount int            `json:"comment_count"`
+	LikeCount    int            `json:"like_count"`
+	DislikeCount int            `json:"dislike_count"`
+	Mentions     []Mention      `json:"mentions,omitempty"`
+}
+
+// FeedPage is one page of a feed
+type FeedPage struct {
+	Posts      []FeedPost `json:"posts"`
+	Sort       string     `json:"sort"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	HasMore    bool       `json:"has_more"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// What started an image garbage collection pass
+const (
+	ImageGCTriggerSchedule = "schedule"
+	ImageGCTriggerManual   = "manual"
+)
+
+// ImageGCReport describes a pass of the image garbage collector: stored files
+// no row points at, and rows whose files are gone. A dry run only reports
+// them; otherwise FilesDeleted and RowsDeleted say what was removed.
+type ImageGCReport struct {
+	Trigger         string           `json:"trigger,omitempty"`
+	DryRun          bool             `json:"dry_run"`
+	FilesScanned    int              `json:"files_scanned"`
+	OrphanFiles     []OrphanFile     `json:"orphan_files"`
+	OrphanBytes     int64            `json:"orphan_bytes"`
+	MissingImages   []MissingImage   `json:"missing_images"`
+	MissingVariants []MissingVariant `json:"missing_variants"`
+	FilesDeleted    int              `json:"files_deleted"`
+	RowsDeleted     int              `json:"rows_deleted"`
+	StartedAt       time.Time        `json:"started_at"`
+	FinishedAt      *time.Time       `json:"finished_at,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
+// OrphanFile is a stored file no image or variant points at
+type OrphanFile struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// MissingImage is an image whose original or thumbnail is not stored.
+// Deleting it takes it out of its gallery.
+type MissingImage struct {
+	ImageID string   `json:"image_id"`
+	PostID  string   `json:"post_id"`
+	Missing []string `json:"missing"`
+}
+
+// MissingVariant is a resized or WebP copy whose file is not stored.
+// Deleting it drops it from the image's srcset.
+type MissingVariant struct {
+	ImageID     string `json:"image_id"`
+	Width       int    `json:"width"`
+	ContentType string `json:"content_type"`
+	Path        string `json:"path"`
+}
@@ -2,11 +2,55 @@ package models
 
 import "time"
 
+// MaxImageTextLength caps an image's caption and alt text
+const MaxImageTextLength = 300
+
 type Image struct {
-	ID            string    `json:"id"`
-	PostID        string    `json:"post_id"`
-	UserID        string    `json:"user_id"`
-	FilePath      string    `json:"file_path"`
-	ThumbnailPath string    `json:"thumbnail_path"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            string         `json:"id"`
+	PostID        string         `json:"post_id"`
+	UserID        string         `json:"user_id"`
+	FilePath      string         `json:"file_path"`
+	ThumbnailPath string         `json:"thumbnail_path"`
+	ContentHash   string         `json:"-"` // SHA-256 of the uploaded bytes, empty for old uploads
+	Width         int            `json:"width"`
+	Height        int            `json:"height"`
+	Position      int            `json:"position"`
+	Caption       string         `json:"caption"`
+	AltText       string         `json:"alt_text"`
+	Variants      []ImageVariant `json:"variants"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// ImageVariant is a resized or WebP copy of an image. FilePath is relative
+// to the uploads directory like the image's own.
+type ImageVariant struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	FilePath    string `json:"file_path"`
+}
+
+// GalleryImage is an image as listed with its post, in gallery order.
+// Srcset lists the original and its smaller copies in the original's format
+// and SrcsetWebP the WebP copies, ready for an img or picture element.
+type GalleryImage struct {
+	ID           string           `json:"id"`
+	URL          string           `json:"url"`
+	ThumbnailURL string           `json:"thumbnail_url"`
+	Width        int              `json:"width"`
+	Height       int              `json:"height"`
+	Position     int              `json:"position"`
+	Caption      string           `json:"caption"`
+	AltText      string           `json:"alt_text"`
+	Srcset       string           `json:"srcset,omitempty"`
+	SrcsetWebP   string           `json:"srcset_webp,omitempty"`
+	Variants     []GalleryVariant `json:"variants,omitempty"`
+}
+
+// GalleryVariant is one entry of a gallery image's srcset
+type GalleryVariant struct {
+	URL         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
 }
@@ -0,0 +1,10 @@
+package models
+
+// Mention is an @username in a post or comment that resolved to a user.
+// Start and End are UTF-16 offsets into the content, including the "@".
+type Mention struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
@@ -2,18 +2,138 @@ package models
 
 import "time"
 
+// Notification types
+const (
+	NotificationComment       = "comment"
+	NotificationCommentEdit   = "comment_edit"
+	NotificationCommentDelete = "comment_delete"
+	NotificationReaction      = "reaction"
+	NotificationMention       = "mention"
+	NotificationReply         = "reply"
+	NotificationQuote         = "quote"
+)
+
+// NotificationTypes lists every type a user can set preferences for
+var NotificationTypes = []string{
+	NotificationComment,
+	NotificationCommentEdit,
+	NotificationCommentDelete,
+	NotificationReaction,
+	NotificationMention,
+	NotificationReply,
+	NotificationQuote,
+}
+
+// Notification delivery channels
+const (
+	ChannelInApp   = "in_app"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelPush    = "push"
+)
+
+// NotificationChannels lists every delivery channel
+var NotificationChannels = []string{ChannelInApp, ChannelEmail, ChannelWebhook, ChannelPush}
+
+// Email delivery modes. Digests batch unread notifications into one email.
+const (
+	EmailImmediate = "immediate"
+	EmailHourly    = "hourly"
+	EmailDaily     = "daily"
+)
+
+// EmailModes lists every email delivery mode
+var EmailModes = []string{EmailImmediate, EmailHourly, EmailDaily}
+
+// DefaultEmailMode applies to users who never chose a mode
+const DefaultEmailMode = EmailDaily
+
+// Delivery outcomes recorded in notification_deliveries
+const (
+	DeliverySent   = "sent"
+	DeliveryFailed = "failed"
+)
+
 // Notification represents a user notification
 type Notification struct {
+	ID             string              `json:"id"`
+	UserID         string              `json:"user_id"`
+	ActorID        string              `json:"actor_id,omitempty"`
+	PostID         *string             `json:"post_id,omitempty"`
+	CommentID      *string             `json:"comment_id,omitempty"`
+	Type           string              `json:"type"`
+	Payload        NotificationPayload `json:"payload,omitempty"`
+	Message        *string             `json:"message"` // rendered when read, in the reader's language
+	PostTitle      *string             `json:"post_title,omitempty"`
+	CommentSnippet *string             `json:"comment_snippet,omitempty"`
+	GroupKey       string              `json:"-"`
+	ActorCount     int                 `json:"actor_count"`
+	Actors         []Actor             `json:"actors,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	ReadAt         *time.Time          `json:"read_at,omitempty"`
+	UpdatedAt      *time.Time          `json:"updated_at,omitempty"`
+	SnoozedUntil   *time.Time          `json:"snoozed_until,omitempty"`
+}
+
+// Actor is a user named in a grouped notification
+type Actor struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// NotificationPreference holds the channel switches for one notification type
+type NotificationPreference struct {
+	Type     string          `json:"type"`
+	Channels map[string]bool `json:"channels"`
+}
+
+// NotificationDelivery records one attempt to send a notification outside the app
+type NotificationDelivery struct {
 	ID             string     `json:"id"`
+	NotificationID *string    `json:"notification_id,omitempty"`
 	UserID         string     `json:"user_id"`
-	ActorID        string     `json:"actor_id,omitempty"`
-	PostID         *string    `json:"post_id,omitempty"`
-	CommentID      *string    `json:"comment_id,omitempty"`
-	Type           string     `json:"type"`
-	Message        *string    `json:"message"`
-	PostTitle      *string    `json:"post_title,omitempty"`
-	CommentSnippet *string    `json:"comment_snippet,omitempty"`
+	Channel        string     `json:"channel"`
+	DigestID       *string    `json:"digest_id,omitempty"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastError      *string    `json:"last_error,omitempty"`
 	CreatedAt      time.Time  `json:"created_at"`
-	ReadAt         *time.Time `json:"read_at,omitempty"`
-	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// NotificationFilter narrows and pages a user's notification inbox
+type NotificationFilter struct {
+	UnreadOnly bool
+	Snoozed    bool // list only snoozed notifications instead of the inbox
+	Type       string
+	PostID     string
+	Since      *time.Time // inclusive
+	Until      *time.Time // exclusive
+	Cursor     string     // opaque, from a previous page's NextCursor
+	Limit      int
+}
+
+// Bulk inbox actions
+const (
+	NotificationBulkRead   = "read"
+	NotificationBulkUnread = "unread"
+	NotificationBulkDelete = "delete"
+)
+
+// NotificationBulkRequest applies one action to several notifications at once
+type NotificationBulkRequest struct {
+	Action string   `json:"action"`
+	IDs    []string `json:"ids"`
+}
+
+// NotificationSnoozeRequest hides a notification until the given time
+type NotificationSnoozeRequest struct {
+	Until time.Time `json:"until"`
+}
+
+// NotificationPage is one page of a user's notification inbox
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+	HasMore       bool           `json:"has_more"`
 }
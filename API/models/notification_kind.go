@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Payload fields read by the message templates
+const (
+	PayloadWhere  = "where"  // comment: whose post, "own" or "followed"
+	PayloadVerb   = "verb"   // reaction: "like" or "dislike"
+	PayloadTarget = "target" // reaction, mention: "post" or "comment"
+)
+
+// NotificationPayload holds a notification's structured fields. Messages are
+// rendered from the payload and the actors when read, in the reader's language.
+type NotificationPayload map[string]string
+
+// NotificationKind describes one notification type: the payload fields it
+// carries and the values each field may take
+type NotificationKind struct {
+	Type   string
+	Fields map[string][]string
+}
+
+// notificationKinds is the registry of every type the dispatcher may store.
+// Each one needs a template of the same name in every locale catalog.
+var notificationKinds = map[string]NotificationKind{
+	NotificationComment: {Type: NotificationComment, Fields: map[string][]string{
+		PayloadWhere: {"own", "followed"},
+	}},
+	NotificationCommentEdit:   {Type: NotificationCommentEdit},
+	NotificationCommentDelete: {Type: NotificationCommentDelete},
+	NotificationReaction: {Type: NotificationReaction, Fields: map[string][]string{
+		PayloadVerb:   {"like", "dislike"},
+		PayloadTarget: {"post", "comment"},
+	}},
+	NotificationMention: {Type: NotificationMention, Fields: map[string][]string{
+		PayloadTarget: {"post", "comment"},
+	}},
+	NotificationReply: {Type: NotificationReply},
+	NotificationQuote: {Type: NotificationQuote},
+}
+
+// LookupNotificationKind returns the registered kind for a type
+func LookupNotificationKind(t string) (NotificationKind, bool) {
+	k, ok := notificationKinds[t]
+	return k, ok
+}
+
+// NotificationKindTypes lists the registered types in a stable order
+func NotificationKindTypes() []string {
+	types := make([]string, 0, len(notificationKinds))
+	for t := range notificationKinds {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Validate checks that p sets exactly the kind's fields to allowed values
+func (k NotificationKind) Validate(p NotificationPayload) error {
+	for name := range p {
+		if _, ok := k.Fields[name]; !ok {
+			return fmt.Errorf("%s notifications have no %q field", k.Type, name)
+		}
+	}
+	for name, allowed := range k.Fields {
+		v, ok := p[name]
+		if !ok {
+			return fmt.Errorf("%s notifications need a %q field", k.Type, name)
+		}
+		if !containsString(allowed, v) {
+			return fmt.Errorf("%s notifications cannot have %s %q", k.Type, name, v)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
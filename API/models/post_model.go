@@ -2,26 +2,46 @@ package models
 
 import "time"
 
+// Post statuses. Only published posts are listed, searched, commented on or
+// reacted to; drafts and scheduled posts are seen by their author alone.
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled" // published by the publisher at PublishAt
+	PostPublished = "published"
+)
+
+// Length limits of a post in characters, the same the posts table checks
+const (
+	MaxPostTitleLength   = 200
+	MaxPostContentLength = 2000
+)
+
 type Post struct {
 	ID          string     `json:"id"`
 	UserID      string     `json:"user_id"`
 	CategoryIDs []int      `json:"category_id"`
 	Title       *string    `json:"title"`
 	Content     *string    `json:"content"`
+	ContentHTML *string    `json:"content_html"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	Mentions    []Mention  `json:"mentions,omitempty"`
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
 }
 
 // PostWithUser is a post along with the username of its author
 type PostWithUser struct {
-	ID           string     `json:"id"`
-	UserID       string     `json:"user_id"`
-	Username     string     `json:"username"`
-	CategoryID   int        `json:"category_id"`
-	Title        *string    `json:"title"`
-	Content      *string    `json:"content"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
-	ImageURL     string     `json:"image_url,omitempty"`
-	ThumbnailURL string     `json:"thumbnail_url,omitempty"`
+	ID           string         `json:"id"`
+	UserID       string         `json:"user_id"`
+	Username     string         `json:"username"`
+	CategoryID   int            `json:"category_id"`
+	Title        *string        `json:"title"`
+	Content      *string        `json:"content"`
+	ContentHTML  *string        `json:"content_html"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    *time.Time     `json:"updated_at,omitempty"`
+	ImageURL     string         `json:"image_url,omitempty"`
+	ThumbnailURL string         `json:"thumbnail_url,omitempty"`
+	Images       []GalleryImage `json:"images,omitempty"`
 }
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// PushSubscription is a browser registered for Web Push. Endpoint, P256dh
+// and Auth come from the browser's PushSubscription object.
+type PushSubscription struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	SessionID string    `json:"-"`
+	Endpoint  string    `json:"endpoint"`
+	P256dh    string    `json:"-"`
+	Auth      string    `json:"-"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PushSubscriptionJSON is the browser's PushSubscription.toJSON() shape
+type PushSubscriptionJSON struct {
+	Endpoint       string  `json:"endpoint"`
+	ExpirationTime *int64  `json:"expirationTime,omitempty"`
+	Keys           PushKey `json:"keys"`
+}
+
+// PushKey holds a subscription's base64url encoded encryption keys
+type PushKey struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
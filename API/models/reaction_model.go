@@ -2,6 +2,14 @@ package models
 
 import "time"
 
+// Reaction types. The UI stores ReactionNone when a reaction is taken back,
+// so it counts as no reaction at all.
+const (
+	ReactionLike    = 1
+	ReactionDislike = 2
+	ReactionNone    = 3
+)
+
 type Reaction struct {
 	UserID    string    `json:"user_id"`
 	Type      int       `json:"reaction_type"` // 1 = Like, 2 = Love, etc.
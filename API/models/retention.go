@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Retention actions, one bucket each in a RetentionReport
+const (
+	RetentionDeleteRead    = "delete_read"    // read notifications past the read limit
+	RetentionDeleteSoft    = "delete_deleted" // rows the user deleted (message cleared)
+	RetentionArchive       = "archive"        // unread notifications moved to notifications_archive
+	RetentionPurgeArchived = "purge_archived" // archived rows past the archive limit
+)
+
+// What started a retention run
+const (
+	RetentionTriggerSchedule = "schedule"
+	RetentionTriggerManual   = "manual"
+)
+
+// RetentionBucket counts the rows one retention action affects. Preview
+// reports also break the count down by type with the age range.
+type RetentionBucket struct {
+	Action string           `json:"action"`
+	Before *time.Time       `json:"before,omitempty"`
+	Count  int64            `json:"count"`
+	ByType map[string]int64 `json:"by_type,omitempty"`
+	Oldest *time.Time       `json:"oldest,omitempty"`
+	Newest *time.Time       `json:"newest,omitempty"`
+}
+
+// RetentionReport describes a retention run, or what one would do when
+// DryRun is set. Removed counts rows taken out of the notifications table.
+type RetentionReport struct {
+	RunID      string            `json:"run_id,omitempty"`
+	Trigger    string            `json:"trigger,omitempty"`
+	DryRun     bool              `json:"dry_run"`
+	Buckets    []RetentionBucket `json:"buckets"`
+	Removed    int64             `json:"removed"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"forum/diff"
+)
+
+// Diff modes
+const (
+	DiffLines = "line"
+	DiffWords = "word"
+)
+
+// Revision is one version of a post or comment. Revision 1 is the original
+// and the highest number is the current text. Comments have no title.
+type Revision struct {
+	Revision  int       `json:"revision"`
+	Title     *string   `json:"title,omitempty"`
+	Content   *string   `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	Current   bool      `json:"current"`
+}
+
+// RevisionDiff is what changed between two revisions. Title is only set for
+// posts.
+type RevisionDiff struct {
+	From    int       `json:"from"`
+	To      int       `json:"to"`
+	Mode    string    `json:"mode"` // DiffLines or DiffWords
+	Title   []diff.Op `json:"title,omitempty"`
+	Content []diff.Op `json:"content"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Search scopes
+const (
+	SearchAll      = "all"
+	SearchPosts    = "posts"
+	SearchComments = "comments"
+)
+
+// SearchQuery is a full-text search with its filters. Terms are matched as
+// whole words, all of them required; a trailing * matches a prefix.
+type SearchQuery struct {
+	Terms       string
+	Scope       string // SearchAll, SearchPosts or SearchComments
+	CategoryIDs []int  // posts in any of these categories, and their comments
+	Author      string // username
+	Since       *time.Time
+	Until       *time.Time
+	Limit       int
+	Offset      int
+}
+
+// SearchResult is one matching post or comment. Title and Snippet are HTML:
+// escaped text with the matched terms wrapped in <mark>.
+type SearchResult struct {
+	Type      string    `json:"type"` // "post" or "comment"
+	PostID    string    `json:"post_id"`
+	CommentID *string   `json:"comment_id,omitempty"`
+	Title     string    `json:"title"`
+	Snippet   string    `json:"snippet"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	Rank      float64   `json:"rank"` // lower is a better match
+}
+
+// SearchPage is one page of results, best matches first
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	Total      int            `json:"total"`
+	HasMore    bool           `json:"has_more"`
+	NextOffset int            `json:"next_offset,omitempty"`
+}
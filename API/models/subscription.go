@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Post subscription states. SubscriptionNone is only reported, never stored.
+const (
+	SubscriptionSubscribed = "subscribed"
+	SubscriptionMuted      = "muted"
+	SubscriptionNone       = "none"
+)
+
+// Reasons a user was subscribed to a post
+const (
+	SubscribeAuthor   = "author"
+	SubscribeComment  = "comment"
+	SubscribeReaction = "reaction"
+	SubscribeManual   = "manual"
+)
+
+// PostSubscription is a user's subscription state for one post
+type PostSubscription struct {
+	PostID    string     `json:"post_id"`
+	State     string     `json:"state"`
+	Reason    string     `json:"reason,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
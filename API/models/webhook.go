@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Webhook events. A webhook receives the events in its filter.
+const (
+	WebhookPostCreated     = "post.created"
+	WebhookCommentCreated  = "comment.created"
+	WebhookReactionCreated = "reaction.created"
+	WebhookUserRegistered  = "user.registered"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{
+	WebhookPostCreated,
+	WebhookCommentCreated,
+	WebhookReactionCreated,
+	WebhookUserRegistered,
+}
+
+// Webhook delivery states. A pending delivery is still being retried.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is a user's endpoint for forum events. Secret signs each payload
+// and is only returned when the webhook is created.
+type Webhook struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	URL       string     `json:"url"`
+	Secret    string     `json:"secret,omitempty"`
+	Events    []string   `json:"events"`
+	Active    bool       `json:"active"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// WebhookDelivery is one event sent, or being sent, to a webhook
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	WebhookID      string     `json:"webhook_id"`
+	EventID        string     `json:"event_id"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus *int       `json:"response_status,omitempty"`
+	LastError      *string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
@@ -0,0 +1,65 @@
+package push
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds Web Push settings, read from the environment (see
+// utils.LoadEnv)
+type Config struct {
+	PrivateKey string // VAPID_PRIVATE_KEY from cmd/vapidkeys; push is disabled when empty
+	Subject    string // VAPID_SUBJECT, a mailto: or https: contact, default "mailto:admin@localhost"
+	BaseURL    string // APP_BASE_URL for links opened from a push, default the UI at http://localhost:8081
+
+	TTL         time.Duration // PUSH_TTL, how long the push service keeps an undelivered message, default 24h
+	MaxAttempts int           // PUSH_MAX_ATTEMPTS, default 3
+	RetryDelay  time.Duration // PUSH_RETRY_DELAY, first backoff step, default 2s; doubles per attempt
+	Timeout     time.Duration // per request, 15s
+
+	// AllowPrivate lets subscriptions use loopback and private endpoints,
+	// such as the cmd/pushcapture stand-in (PUSH_ALLOW_PRIVATE=true). Real
+	// push services are public, so leave it off in production.
+	AllowPrivate bool
+}
+
+// ConfigFromEnv builds a Config from the environment. The second result is
+// false when VAPID_PRIVATE_KEY is not set.
+func ConfigFromEnv() (Config, bool) {
+	cfg := Config{
+		PrivateKey:  os.Getenv("VAPID_PRIVATE_KEY"),
+		Subject:     envString("VAPID_SUBJECT", "mailto:admin@localhost"),
+		BaseURL:     envString("APP_BASE_URL", "http://localhost:8081"),
+		TTL:         24 * time.Hour,
+		MaxAttempts: envInt("PUSH_MAX_ATTEMPTS", 3),
+		RetryDelay:  2 * time.Second,
+		Timeout:     15 * time.Second,
+
+		AllowPrivate: os.Getenv("PUSH_ALLOW_PRIVATE") == "true",
+	}
+	if d, err := time.ParseDuration(os.Getenv("PUSH_TTL")); err == nil && d > 0 {
+		cfg.TTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("PUSH_RETRY_DELAY")); err == nil && d > 0 {
+		cfg.RetryDelay = d
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return cfg, cfg.PrivateKey != ""
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
@@ -0,0 +1,170 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Payload encryption for Web Push (RFC 8291) with the aes128gcm content
+// coding (RFC 8188). Every message fits in a single record.
+const (
+	recordSize = 4096
+	saltLen    = 16
+	authLen    = 16
+	keyLen     = 65 // uncompressed P-256 point
+	headerLen  = saltLen + 4 + 1 + keyLen
+	// MaxPayload is the largest plaintext that fits in one record
+	MaxPayload = recordSize - headerLen - 16 - 1
+)
+
+var errPayloadTooLarge = errors.New("push payload too large")
+
+// Encrypt seals plaintext for a subscription given its base64url p256dh and
+// auth keys. A fresh ephemeral key and salt are used for every message.
+func Encrypt(plaintext []byte, p256dh, auth string) ([]byte, error) {
+	if len(plaintext) > MaxPayload {
+		return nil, errPayloadTooLarge
+	}
+	uaBytes, err := decodeKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh: %w", err)
+	}
+	authSecret, err := decodeKey(auth)
+	if err != nil || len(authSecret) != authLen {
+		return nil, errors.New("invalid auth secret")
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encrypt(plaintext, uaPublic, authSecret, asPrivate, salt)
+}
+
+// encrypt seals plaintext with the given ephemeral key and salt
+func encrypt(plaintext []byte, uaPublic *ecdh.PublicKey, authSecret []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	uaBytes := uaPublic.Bytes()
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asBytes := asPrivate.PublicKey().Bytes()
+	gcm, nonce, err := contentKeys(shared, authSecret, salt, uaBytes, asBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 0x02 marks the last (and only) record
+	padded := append(append([]byte{}, plaintext...), 0x02)
+
+	out := make([]byte, 0, headerLen+len(padded)+gcm.Overhead())
+	out = append(out, salt...)
+	out = binary.BigEndian.AppendUint32(out, recordSize)
+	out = append(out, keyLen)
+	out = append(out, asBytes...)
+	return gcm.Seal(out, nonce, padded, nil), nil
+}
+
+// Decrypt opens a message sealed by Encrypt, from the browser's side. It is
+// used by the push service stand-in to check what would be shown.
+func Decrypt(body []byte, uaPrivate *ecdh.PrivateKey, authSecret []byte) ([]byte, error) {
+	if len(body) < headerLen {
+		return nil, errors.New("message too short")
+	}
+	salt := body[:saltLen]
+	idLen := int(body[saltLen+4])
+	if idLen != keyLen || len(body) < saltLen+5+idLen {
+		return nil, errors.New("unexpected key id length")
+	}
+	asBytes := body[saltLen+5 : saltLen+5+idLen]
+	asPublic, err := ecdh.P256().NewPublicKey(asBytes)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		return nil, err
+	}
+	gcm, nonce, err := contentKeys(shared, authSecret, salt, uaPrivate.PublicKey().Bytes(), asBytes)
+	if err != nil {
+		return nil, err
+	}
+	padded, err := gcm.Open(nil, nonce, body[saltLen+5+idLen:], nil)
+	if err != nil {
+		return nil, err
+	}
+	// Strip padding back to the 0x02 delimiter
+	i := bytes.LastIndexByte(padded, 0x02)
+	if i < 0 || bytes.ContainsFunc(padded[i+1:], func(r rune) bool { return r != 0 }) {
+		return nil, errors.New("invalid padding")
+	}
+	return padded[:i], nil
+}
+
+// contentKeys derives the AES-128-GCM key and nonce from the ECDH secret
+// (RFC 8291 section 3.4, RFC 8188 section 2.2)
+func contentKeys(shared, authSecret, salt, uaPublic, asPublic []byte) (cipher.AEAD, []byte, error) {
+	prkKey, err := hkdf.Extract(sha256.New, shared, authSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyInfo := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	ikm, err := hkdf.Expand(sha256.New, prkKey, keyInfo, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, nonce, nil
+}
+
+// ValidateKeys checks a subscription's keys before it is stored: p256dh must
+// be a P-256 public key and auth a 16-byte secret
+func ValidateKeys(p256dh, auth string) error {
+	ua, err := decodeKey(p256dh)
+	if err != nil {
+		return errors.New("keys.p256dh is not base64url")
+	}
+	if _, err := ecdh.P256().NewPublicKey(ua); err != nil {
+		return errors.New("keys.p256dh is not a P-256 public key")
+	}
+	secret, err := decodeKey(auth)
+	if err != nil || len(secret) != authLen {
+		return errors.New("keys.auth must be a 16-byte base64url secret")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package push
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// The worked example of RFC 8291 Appendix A
+const (
+	rfcPlaintext  = "When I grow up, I want to be a watermelon"
+	rfcASPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcASPublic   = "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"
+	rfcUAPrivate  = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfcUAPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfcSalt       = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfcAuthSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcMessage    = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := decodeKey(s)
+	if err != nil {
+		t.Fatalf("decode %q: %v", s, err)
+	}
+	return b
+}
+
+func TestEncryptRFC8291Vector(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(mustDecode(t, rfcASPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b64.EncodeToString(asPrivate.PublicKey().Bytes()); got != rfcASPublic {
+		t.Fatalf("as_public = %s, want %s", got, rfcASPublic)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(mustDecode(t, rfcUAPublic))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := encrypt([]byte(rfcPlaintext), uaPublic, mustDecode(t, rfcAuthSecret), asPrivate, mustDecode(t, rfcSalt))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if got := b64.EncodeToString(sealed); got != rfcMessage {
+		t.Errorf("message =\n%s\nwant\n%s", got, rfcMessage)
+	}
+}
+
+func TestDecryptRFC8291Vector(t *testing.T) {
+	uaPrivate, err := ecdh.P256().NewPrivateKey(mustDecode(t, rfcUAPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := Decrypt(mustDecode(t, rfcMessage), uaPrivate, mustDecode(t, rfcAuthSecret))
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(plain) != rfcPlaintext {
+		t.Errorf("plaintext = %q, want %q", plain, rfcPlaintext)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	uaPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, authLen)
+	rand.Read(secret)
+	p256dh, auth := b64.EncodeToString(uaPrivate.PublicKey().Bytes()), b64.EncodeToString(secret)
+
+	for _, plaintext := range [][]byte{{}, []byte(rfcPlaintext), bytes.Repeat([]byte{0x02}, MaxPayload)} {
+		a, err := Encrypt(plaintext, p256dh, auth)
+		if err != nil {
+			t.Fatalf("Encrypt %d bytes: %v", len(plaintext), err)
+		}
+		b, err := Encrypt(plaintext, p256dh, auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(a, b) {
+			t.Error("two encryptions of the same message are equal; salt and key must be fresh")
+		}
+		if len(a) > recordSize {
+			t.Errorf("%d byte plaintext sealed to %d bytes, over one record", len(plaintext), len(a))
+		}
+		plain, err := Decrypt(a, uaPrivate, secret)
+		if err != nil {
+			t.Fatalf("Decrypt %d bytes: %v", len(plaintext), err)
+		}
+		if !bytes.Equal(plain, plaintext) {
+			t.Errorf("round trip of %d bytes gave %d bytes", len(plaintext), len(plain))
+		}
+	}
+
+	if _, err := Encrypt(make([]byte, MaxPayload+1), p256dh, auth); err != errPayloadTooLarge {
+		t.Errorf("oversized payload: err = %v, want errPayloadTooLarge", err)
+	}
+	if _, err := Encrypt(nil, p256dh, b64.EncodeToString(secret[:8])); err == nil {
+		t.Error("short auth secret accepted")
+	}
+	if _, err := Encrypt(nil, b64.EncodeToString([]byte("not a point")), auth); err == nil {
+		t.Error("invalid p256dh accepted")
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	if err := ValidateKeys(rfcUAPublic, rfcAuthSecret); err != nil {
+		t.Errorf("RFC keys rejected: %v", err)
+	}
+	// Standard base64 with padding, as some tools print it
+	std := "BCVxsr7N/eNgVRqvHtD0zTZsEc6+VV+JvLexhqUzORcxaOzi6+AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4="
+	if err := ValidateKeys(std, rfcAuthSecret+"=="); err != nil {
+		t.Errorf("standard base64 keys rejected: %v", err)
+	}
+	for _, tc := range []struct{ p256dh, auth string }{
+		{"!!", rfcAuthSecret},
+		{rfcAuthSecret, rfcAuthSecret},
+		{rfcUAPublic, "c2hvcnQ"},
+	} {
+		if err := ValidateKeys(tc.p256dh, tc.auth); err == nil {
+			t.Errorf("ValidateKeys(%q, %q) accepted", tc.p256dh, tc.auth)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/models"
+	"forum/repository/notification"
+	"forum/utils"
+)
+
+const (
+	// queueSize bounds notifications waiting for a push worker
+	queueSize = 256
+	workers   = 2
+)
+
+var (
+	errClosed = errors.New("push sender closed")
+	// errGone means the push service dropped the subscription
+	errGone = errors.New("subscription expired")
+)
+
+// Payload is the JSON a push carries; the UI's service worker shows it.
+// Tag lets a grouped notification replace its earlier push on screen.
+type Payload struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Tag   string `json:"tag"`
+}
+
+// Sender is the Web Push notification channel. Each notification is
+// encrypted for and pushed to every browser the user registered from a live
+// session. Outcomes are recorded in notification_deliveries.
+type Sender struct {
+	cfg        Config
+	vapid      *VAPID
+	subs       *notification.PushRepository
+	deliveries *notification.DeliveryRepository
+	client     *http.Client
+
+	queue chan models.Notification
+	done  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// NewSender loads the VAPID key and starts the push workers
+func NewSender(cfg Config, subs *notification.PushRepository, deliveries *notification.DeliveryRepository) (*Sender, error) {
+	vapid, err := ParseVAPID(cfg.PrivateKey, cfg.Subject)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sender{
+		cfg:        cfg,
+		vapid:      vapid,
+		subs:       subs,
+		deliveries: deliveries,
+		client:     newClient(cfg),
+		queue:      make(chan models.Notification, queueSize),
+		done:       make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	return s, nil
+}
+
+func (s *Sender) Channel() string { return models.ChannelPush }
+
+// PublicKey is the applicationServerKey browsers subscribe with
+func (s *Sender) PublicKey() string { return s.vapid.PublicKey() }
+
+// Send queues a push for the notification
+func (s *Sender) Send(n models.Notification) error {
+	select {
+	case <-s.done:
+		return errClosed
+	case s.queue <- n:
+		return nil
+	default:
+		return errors.New("push queue full")
+	}
+}
+
+// Close stops the workers; queued pushes are dropped
+func (s *Sender) Close() {
+	s.once.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
+
+func (s *Sender) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case n := <-s.queue:
+			if err := s.push(n); err != nil {
+				log.Printf("Push [ERROR]: notification %s to %s: %v", n.ID, n.UserID, err)
+			}
+		}
+	}
+}
+
+// push sends n to each of the user's browsers. The notification counts as
+// sent when at least one browser's push service accepted it.
+func (s *Sender) push(n models.Notification) error {
+	subs, err := s.subs.ForUser(n.UserID)
+	if err != nil {
+		return fmt.Errorf("load subscriptions: %w", err)
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(s.payload(n))
+	if err != nil {
+		return err
+	}
+
+	var (
+		sent     bool
+		attempts int
+		lastErr  error
+	)
+	for _, sub := range subs {
+		a, err := s.deliver(sub, body, topic(n.ID))
+		attempts = max(attempts, a)
+		switch {
+		case err == nil:
+			sent = true
+		case errors.Is(err, errGone):
+			if err := s.subs.Expire(sub.Endpoint); err != nil {
+				log.Printf("Push [WARN]: remove expired subscription %s: %v", sub.ID, err)
+			}
+			lastErr = err
+		default:
+			log.Printf("Push [WARN]: subscription %s for %s: %v", sub.ID, n.UserID, err)
+			lastErr = err
+		}
+	}
+	if sent {
+		lastErr = nil
+	}
+	s.record(n, attempts, lastErr)
+	return lastErr
+}
+
+func (s *Sender) payload(n models.Notification) Payload {
+	link := s.cfg.BaseURL + "/user/feed"
+	if n.PostID != nil {
+		link = s.cfg.BaseURL + "/user/post?id=" + url.QueryEscape(*n.PostID)
+		if n.CommentID != nil {
+			link += "#" + url.PathEscape(*n.CommentID)
+		}
+	}
+	return Payload{
+		ID:    n.ID,
+		Type:  n.Type,
+		Title: "Forum",
+		Body:  utils.DerefString(n.Message),
+		URL:   link,
+		Tag:   n.ID,
+	}
+}
+
+// deliver encrypts and posts one message, retrying with exponential backoff
+// on network errors, 429 and 5xx. It returns how many attempts were made.
+func (s *Sender) deliver(sub models.PushSubscription, body []byte, topic string) (int, error) {
+	delay := s.cfg.RetryDelay
+	var err error
+	for attempt := 1; attempt <= s.cfg.MaxAttempts; attempt++ {
+		var retry bool
+		if retry, err = s.post(sub, body, topic); err == nil || !retry {
+			return attempt, err
+		}
+		if attempt == s.cfg.MaxAttempts {
+			return attempt, err
+		}
+		log.Printf("Push [WARN]: attempt %d to %s failed, retrying in %s: %v", attempt, endpointHost(sub.Endpoint), delay, err)
+		select {
+		case <-s.done:
+			return attempt, errClosed
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return s.cfg.MaxAttempts, err
+}
+
+// post makes one request to the push service and reports whether a failure
+// is worth retrying
+func (s *Sender) post(sub models.PushSubscription, body []byte, topic string) (bool, error) {
+	sealed, err := Encrypt(body, sub.P256dh, sub.Auth)
+	if err != nil {
+		return false, err
+	}
+	auth, err := s.vapid.authorization(sub.Endpoint, time.Now())
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(sealed))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(s.cfg.TTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+	if topic != "" {
+		req.Header.Set("Topic", topic)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return false, errGone
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("push service returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("push service returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+func (s *Sender) record(n models.Notification, attempts int, sendErr error) {
+	if n.ID == "" {
+		return
+	}
+	now := time.Now()
+	d := models.NotificationDelivery{
+		NotificationID: &n.ID,
+		UserID:         n.UserID,
+		Channel:        models.ChannelPush,
+		Status:         models.DeliverySent,
+		Attempts:       attempts,
+		CreatedAt:      now,
+		DeliveredAt:    &now,
+	}
+	if sendErr != nil {
+		msg := sendErr.Error()
+		d.Status = models.DeliveryFailed
+		d.LastError = &msg
+		d.DeliveredAt = nil
+	}
+	if err := s.deliveries.Record(d); err != nil {
+		log.Printf("Push [ERROR]: record delivery of %s: %v", n.ID, err)
+	}
+}
+
+// topic lets the push service replace an undelivered push for the same
+// notification, e.g. a group that gained an actor. Topics are at most 32
+// base64url characters, which a UUID without dashes fits.
+func topic(notificationID string) string {
+	return strings.ReplaceAll(notificationID, "-", "")
+}
+
+// newClient builds the HTTP client for push services. Endpoints come from
+// browsers, so unless cfg.AllowPrivate is set they may not reach private
+// addresses.
+func newClient(cfg Config) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = utils.PublicDialControl
+	}
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: cfg.Timeout},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func endpointHost(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil {
+		return u.Host
+	}
+	return "push service"
+}
@@ -0,0 +1,274 @@
+package push
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"forum/models"
+	"forum/repository/notification"
+)
+
+// pushed is one request the push service stand-in received
+type pushed struct {
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// pushService answers each endpoint path with a fixed sequence of statuses,
+// repeating the last one, and keeps every request
+type pushService struct {
+	mu       sync.Mutex
+	statuses map[string][]int
+	requests []pushed
+}
+
+func (p *pushService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p.mu.Lock()
+	p.requests = append(p.requests, pushed{Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+	codes := p.statuses[r.URL.Path]
+	code := http.StatusNotFound
+	if len(codes) > 0 {
+		code = codes[0]
+		if len(codes) > 1 {
+			p.statuses[r.URL.Path] = codes[1:]
+		}
+	}
+	p.mu.Unlock()
+	w.WriteHeader(code)
+}
+
+func (p *pushService) received(path string) []pushed {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []pushed
+	for _, r := range p.requests {
+		if r.Path == path {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// browser is a subscribed browser's keys
+type browser struct {
+	key    *ecdh.PrivateKey
+	secret []byte
+}
+
+func newBrowser(t *testing.T) browser {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, authLen)
+	rand.Read(secret)
+	return browser{key: key, secret: secret}
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	db, err := models.InitDB()
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPushToService(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO user (user_id, username, email) VALUES ('ada', 'ada', 'ada@example.com')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO sessions (user_id, session_id, csrf_token, expires_at) VALUES ('ada', 'session-1', 'csrf', ?)`,
+		time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	service := &pushService{statuses: map[string][]int{
+		"/ok":      {http.StatusCreated},
+		"/flaky":   {http.StatusServiceUnavailable, http.StatusCreated},
+		"/gone":    {http.StatusGone},
+		"/missing": {http.StatusNotFound},
+	}}
+	srv := httptest.NewServer(service)
+	defer srv.Close()
+
+	subs := notification.NewPushRepository(db)
+	browsers := make(map[string]browser)
+	for _, path := range []string{"/ok", "/flaky", "/gone", "/missing"} {
+		b := newBrowser(t)
+		browsers[path] = b
+		err := subs.Save(&models.PushSubscription{
+			UserID:    "ada",
+			SessionID: "session-1",
+			Endpoint:  srv.URL + path,
+			P256dh:    b64.EncodeToString(b.key.PublicKey().Bytes()),
+			Auth:      b64.EncodeToString(b.secret),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	priv, pub, err := GenerateVAPID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSender(Config{
+		PrivateKey:   priv,
+		Subject:      "mailto:admin@forum.test",
+		BaseURL:      "http://forum.test",
+		TTL:          90 * time.Minute,
+		MaxAttempts:  2,
+		RetryDelay:   time.Millisecond,
+		Timeout:      5 * time.Second,
+		AllowPrivate: true,
+	}, subs, notification.NewDeliveryRepository(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	message := "carol mentioned you in a post"
+	n := models.Notification{ID: "0b9e8c1e-5f0a-4c47-9a6e-3d2b1c0f9e8d", UserID: "ada", Type: models.NotificationMention, Message: &message}
+	if _, err := db.Exec(`INSERT INTO notifications (notification_id, user_id, type) VALUES (?, ?, ?)`, n.ID, n.UserID, n.Type); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.push(n); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	for _, path := range []string{"/ok", "/flaky", "/gone", "/missing"} {
+		reqs := service.received(path)
+		want := 1
+		if path == "/flaky" {
+			want = 2 // a 503 is retried
+		}
+		if len(reqs) != want {
+			t.Fatalf("%s got %d requests, want %d", path, len(reqs), want)
+		}
+		req := reqs[len(reqs)-1]
+		for header, want := range map[string]string{
+			"Content-Encoding": "aes128gcm",
+			"Content-Type":     "application/octet-stream",
+			"Ttl":              "5400",
+			"Urgency":          "normal",
+			"Topic":            "0b9e8c1e5f0a4c479a6e3d2b1c0f9e8d",
+		} {
+			if got := req.Header.Get(header); got != want {
+				t.Errorf("%s: %s = %q, want %q", path, header, got, want)
+			}
+		}
+		tok := parseVAPIDHeader(t, req.Header.Get("Authorization"))
+		if tok.key != pub || tok.claims["aud"] != srv.URL || !verifyES256(t, pub, tok.signingInput, tok.signature) {
+			t.Errorf("%s: bad VAPID authorization %q", path, req.Header.Get("Authorization"))
+		}
+
+		plain, err := Decrypt(req.Body, browsers[path].key, browsers[path].secret)
+		if err != nil {
+			t.Fatalf("%s: browser cannot decrypt the push: %v", path, err)
+		}
+		var p Payload
+		if err := json.Unmarshal(plain, &p); err != nil {
+			t.Fatalf("%s: payload: %v", path, err)
+		}
+		if p.ID != n.ID || p.Body != message || p.Tag != n.ID || p.Type != models.NotificationMention {
+			t.Errorf("%s: payload = %+v", path, p)
+		}
+	}
+
+	// 404 and 410 drop the subscription; the others stay
+	left, err := subs.ForUser("ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var endpoints []string
+	for _, sub := range left {
+		endpoints = append(endpoints, sub.Endpoint)
+	}
+	sort.Strings(endpoints)
+	if want := []string{srv.URL + "/flaky", srv.URL + "/ok"}; len(endpoints) != 2 || endpoints[0] != want[0] || endpoints[1] != want[1] {
+		t.Errorf("subscriptions left = %v, want %v", endpoints, want)
+	}
+
+	var status string
+	var attempts int
+	if err := db.QueryRow(`SELECT status, attempts FROM notification_deliveries WHERE notification_id = ? AND channel = ?`,
+		n.ID, models.ChannelPush).Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != models.DeliverySent || attempts != 2 {
+		t.Errorf("delivery recorded as %s after %d attempts, want sent after 2", status, attempts)
+	}
+}
+
+func TestPushAllGoneFails(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`INSERT INTO user (user_id, username, email) VALUES ('bob', 'bob', 'bob@example.com')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO sessions (user_id, session_id, csrf_token, expires_at) VALUES ('bob', 'session-2', 'csrf', ?)`,
+		time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	service := &pushService{statuses: map[string][]int{"/gone": {http.StatusGone}}}
+	srv := httptest.NewServer(service)
+	defer srv.Close()
+
+	subs := notification.NewPushRepository(db)
+	b := newBrowser(t)
+	if err := subs.Save(&models.PushSubscription{
+		UserID: "bob", SessionID: "session-2", Endpoint: srv.URL + "/gone",
+		P256dh: b64.EncodeToString(b.key.PublicKey().Bytes()), Auth: b64.EncodeToString(b.secret),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	priv, _, err := GenerateVAPID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSender(Config{PrivateKey: priv, Subject: "mailto:a@b", TTL: time.Hour, MaxAttempts: 3, RetryDelay: time.Millisecond, Timeout: 5 * time.Second, AllowPrivate: true},
+		subs, notification.NewDeliveryRepository(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := db.Exec(`INSERT INTO notifications (notification_id, user_id, type) VALUES ('n1', 'bob', ?)`, models.NotificationMention); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.push(models.Notification{ID: "n1", UserID: "bob", Type: models.NotificationMention}); err != errGone {
+		t.Errorf("push = %v, want errGone", err)
+	}
+	if got := len(service.received("/gone")); got != 1 {
+		t.Errorf("a 410 was retried: %d requests", got)
+	}
+	if left, err := subs.ForUser("bob"); err != nil || len(left) != 0 {
+		t.Errorf("subscriptions left = %v (%v), want none", left, err)
+	}
+}
+
+func TestPushRefusesPrivateEndpoints(t *testing.T) {
+	srv := httptest.NewServer(&pushService{statuses: map[string][]int{"/ok": {http.StatusCreated}}})
+	defer srv.Close()
+	client := newClient(Config{Timeout: 5 * time.Second})
+	resp, err := client.Post(srv.URL+"/ok", "application/octet-stream", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("push client reached a loopback endpoint without AllowPrivate")
+	}
+}
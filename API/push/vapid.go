@@ -0,0 +1,110 @@
+package push
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// vapidTTL is how long a VAPID token stays valid; push services reject
+// anything over 24 hours
+const vapidTTL = 12 * time.Hour
+
+var b64 = base64.RawURLEncoding
+
+// VAPID identifies this server to push services (RFC 8292). Browsers only
+// accept pushes signed with the key they subscribed with, so the key pair
+// must stay the same across restarts.
+type VAPID struct {
+	key     *ecdsa.PrivateKey
+	public  []byte // uncompressed P-256 point
+	subject string
+}
+
+// ParseVAPID loads a key pair from the base64url encoded private scalar, as
+// printed by cmd/vapidkeys. Subject is a mailto: or https: contact URL.
+func ParseVAPID(privateKey, subject string) (*VAPID, error) {
+	d, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode VAPID private key: %w", err)
+	}
+	priv, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	public := priv.PublicKey().Bytes()
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(public[1:33]),
+			Y:     new(big.Int).SetBytes(public[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}
+	if subject == "" {
+		return nil, errors.New("VAPID subject is required")
+	}
+	return &VAPID{key: key, public: public, subject: subject}, nil
+}
+
+// GenerateVAPID creates a new key pair, base64url encoded. The public key is
+// what browsers pass to pushManager.subscribe as applicationServerKey.
+func GenerateVAPID() (privateKey, publicKey string, err error) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return b64.EncodeToString(priv.Bytes()), b64.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+// PublicKey returns the applicationServerKey for browsers
+func (v *VAPID) PublicKey() string {
+	return b64.EncodeToString(v.public)
+}
+
+// authorization returns the Authorization header for a push to endpoint:
+// an ES256 JWT for the endpoint's origin plus the public key
+func (v *VAPID) authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidTTL).Unix(),
+		"sub": v.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + b64.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, v.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants the raw 64-byte r||s form, not ASN.1
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", signingInput, b64.EncodeToString(sig), v.PublicKey()), nil
+}
+
+// decodeKey accepts base64url with or without padding, and standard base64,
+// since browsers and tools differ
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return b64.DecodeString(s)
+}
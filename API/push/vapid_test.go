@@ -0,0 +1,142 @@
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vapidToken is an Authorization header taken apart
+type vapidToken struct {
+	header, claims map[string]interface{}
+	signingInput   string
+	signature      []byte
+	key            string
+}
+
+func parseVAPIDHeader(t *testing.T, auth string) vapidToken {
+	t.Helper()
+	rest, ok := strings.CutPrefix(auth, "vapid t=")
+	if !ok {
+		t.Fatalf("Authorization %q does not use the vapid scheme", auth)
+	}
+	token, key, ok := strings.Cut(rest, ", k=")
+	if !ok {
+		t.Fatalf("Authorization %q has no k parameter", auth)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT has %d parts", len(parts))
+	}
+	v := vapidToken{signingInput: parts[0] + "." + parts[1], key: key}
+	for i, dst := range []*map[string]interface{}{&v.header, &v.claims} {
+		raw, err := b64.DecodeString(parts[i])
+		if err != nil {
+			t.Fatalf("JWT part %d is not base64url: %v", i, err)
+		}
+		if err := json.Unmarshal(raw, dst); err != nil {
+			t.Fatalf("JWT part %d: %v", i, err)
+		}
+	}
+	var err error
+	if v.signature, err = b64.DecodeString(parts[2]); err != nil {
+		t.Fatalf("signature is not base64url: %v", err)
+	}
+	return v
+}
+
+// verifyES256 checks a JWS ES256 signature: raw r||s over SHA-256
+func verifyES256(t *testing.T, publicKey string, signingInput string, sig []byte) bool {
+	t.Helper()
+	point := mustDecode(t, publicKey)
+	if len(point) != 65 || point[0] != 4 || len(sig) != 64 {
+		return false
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(point[1:33]),
+		Y:     new(big.Int).SetBytes(point[33:]),
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	return ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	priv, pub, err := GenerateVAPID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ParseVAPID(priv, "mailto:admin@forum.test")
+	if err != nil {
+		t.Fatalf("ParseVAPID: %v", err)
+	}
+	if v.PublicKey() != pub {
+		t.Fatalf("PublicKey = %s, want the generated %s", v.PublicKey(), pub)
+	}
+
+	now := time.Unix(1700000000, 0)
+	auth, err := v.authorization("https://push.example.net:8443/wpush/v2/abc?x=1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := parseVAPIDHeader(t, auth)
+	if tok.header["alg"] != "ES256" || tok.header["typ"] != "JWT" {
+		t.Errorf("JWT header = %v", tok.header)
+	}
+	if aud := tok.claims["aud"]; aud != "https://push.example.net:8443" {
+		t.Errorf("aud = %v, want the endpoint's origin", aud)
+	}
+	if sub := tok.claims["sub"]; sub != "mailto:admin@forum.test" {
+		t.Errorf("sub = %v", sub)
+	}
+	exp, _ := tok.claims["exp"].(float64)
+	if got := time.Unix(int64(exp), 0).Sub(now); got != vapidTTL || got > 24*time.Hour {
+		t.Errorf("exp is %s after now, want %s", got, vapidTTL)
+	}
+	if tok.key != pub {
+		t.Errorf("k = %s, want the public key %s", tok.key, pub)
+	}
+	if !verifyES256(t, tok.key, tok.signingInput, tok.signature) {
+		t.Error("ES256 signature does not verify against k")
+	}
+	if verifyES256(t, tok.key, tok.signingInput+"x", tok.signature) {
+		t.Error("signature verifies over altered input")
+	}
+}
+
+func TestParseVAPIDKeepsKeyAcrossRestarts(t *testing.T) {
+	// The private key of RFC 8291 Appendix A doubles as a fixed VAPID key
+	v, err := ParseVAPID(rfcASPrivate, "https://forum.test/contact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.PublicKey() != rfcASPublic {
+		t.Errorf("PublicKey = %s, want %s", v.PublicKey(), rfcASPublic)
+	}
+	auth, err := v.authorization("https://push.example.net/x", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := parseVAPIDHeader(t, auth)
+	if !verifyES256(t, rfcASPublic, tok.signingInput, tok.signature) {
+		t.Error("signature does not verify against the fixed public key")
+	}
+}
+
+func TestParseVAPIDRejects(t *testing.T) {
+	for _, tc := range []struct{ key, subject string }{
+		{"", "mailto:a@b"},
+		{"!!", "mailto:a@b"},
+		{b64.EncodeToString(make([]byte, 32)), "mailto:a@b"}, // zero scalar
+		{rfcASPrivate, ""},
+	} {
+		if _, err := ParseVAPID(tc.key, tc.subject); err == nil {
+			t.Errorf("ParseVAPID(%q, %q) accepted", tc.key, tc.subject)
+		}
+	}
+}
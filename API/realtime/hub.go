@@ -0,0 +1,231 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historySize is how many recent events are kept per channel for Last-Event-ID replay
+const historySize = 100
+
+// postHistorySize is the shorter history of post channels, whose events
+// carry whole comments and reaction lists and whose replay only serves a
+// client that has just reconnected
+const postHistorySize = 20
+
+// historyTTL is how long a channel keeps recording after its last client
+// leaves, long enough for that client to reconnect and replay
+const historyTTL = 2 * time.Minute
+
+// sweepInterval is how often Publish looks for idle histories to drop
+const sweepInterval = time.Minute
+
+// clientBuffer is how many undelivered events a client may queue before it is dropped
+const clientBuffer = 32
+
+// Event is a single message delivered to stream subscribers
+type Event struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+// Client is one subscription of an open connection to a channel
+type Client struct {
+	Channel string
+	Events  chan Event
+}
+
+// channelHistory is kept only while a channel has clients and for
+// historyTTL after the last one leaves; events published to other channels
+// are delivered to no one and forgotten
+type channelHistory struct {
+	events    []Event
+	evicted   uint64    // ID of the newest event dropped from the buffer, or not recorded
+	idleSince time.Time // when the last client left; zero while any is subscribed
+}
+
+// Hub is an in-process pub/sub keyed by channel (see UserChannel and
+// PostChannel). A channel may have many connections at once, such as several
+// tabs or devices of one user, and each receives every event.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[string]map[*Client]struct{}
+	history map[string]*channelHistory
+	lastID  uint64
+	swept   time.Time
+}
+
+// NewHub creates an empty Hub
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[string]map[*Client]struct{}),
+		history: make(map[string]*channelHistory),
+	}
+}
+
+// Subscribe registers a new client on the channel. When lastEventID is non-zero
+// the events the client missed are returned for replay; complete is false if
+// some of them are no longer buffered and the client should resync.
+func (h *Hub) Subscribe(channel string, lastEventID uint64) (c *Client, missed []Event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c = &Client{Channel: channel, Events: make(chan Event, clientBuffer)}
+	if h.clients[channel] == nil {
+		h.clients[channel] = make(map[*Client]struct{})
+	}
+	h.clients[channel][c] = struct{}{}
+
+	hist := h.history[channel]
+	if hist != nil && !hist.idleSince.IsZero() && time.Since(hist.idleSince) > historyTTL {
+		hist = nil
+	}
+	complete = true
+	switch {
+	case lastEventID == 0:
+	case lastEventID > h.lastID:
+		// IDs restart with the process, so an ID from the future means we restarted
+		complete = false
+	case hist == nil:
+		// Nothing was recorded while the channel had no clients
+		complete = lastEventID == h.lastID
+	default:
+		if lastEventID < hist.evicted {
+			complete = false
+		}
+		for _, e := range hist.events {
+			if e.ID > lastEventID {
+				missed = append(missed, e)
+			}
+		}
+	}
+	if hist == nil {
+		// Record from now on; anything earlier is unknown
+		hist = &channelHistory{evicted: h.lastID}
+		h.history[channel] = hist
+	}
+	hist.idleSince = time.Time{}
+	return c, missed, complete
+}
+
+// Unsubscribe removes a client and closes its channel
+func (h *Hub) Unsubscribe(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(c)
+}
+
+func (h *Hub) removeLocked(c *Client) {
+	set, ok := h.clients[c.Channel]
+	if !ok {
+		return
+	}
+	if _, ok := set[c]; !ok {
+		return
+	}
+	delete(set, c)
+	close(c.Events)
+	if len(set) == 0 {
+		delete(h.clients, c.Channel)
+		if hist := h.history[c.Channel]; hist != nil {
+			hist.idleSince = time.Now()
+		}
+	}
+}
+
+// sweepLocked drops the histories of channels idle for longer than
+// historyTTL, at most once per sweepInterval
+func (h *Hub) sweepLocked(now time.Time) {
+	if now.Sub(h.swept) < sweepInterval {
+		return
+	}
+	h.swept = now
+	for channel, hist := range h.history {
+		if !hist.idleSince.IsZero() && now.Sub(hist.idleSince) > historyTTL {
+			delete(h.history, channel)
+		}
+	}
+}
+
+// Publish sends an event to every client on the channel and, while the
+// channel has or recently had clients, records it for replay. It is a no-op
+// on a nil Hub so callers need not check.
+func (h *Hub) Publish(channel, eventType string, payload interface{}) {
+	if h == nil {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Hub [ERROR]: failed to encode %s event: %v", eventType, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	e := Event{ID: h.lastID, Type: eventType, Data: data}
+
+	now := time.Now()
+	h.sweepLocked(now)
+	if hist := h.history[channel]; hist != nil {
+		if !hist.idleSince.IsZero() && now.Sub(hist.idleSince) > historyTTL {
+			delete(h.history, channel)
+		} else {
+			hist.events = append(hist.events, e)
+			if len(hist.events) > historyLimit(channel) {
+				hist.evicted = hist.events[0].ID
+				hist.events = hist.events[1:]
+			}
+		}
+	}
+
+	for c := range h.clients[channel] {
+		select {
+		case c.Events <- e:
+		default:
+			// The client is not keeping up; drop it so it reconnects and replays
+			log.Printf("Hub [WARN]: dropping slow client on channel %s", channel)
+			h.removeLocked(c)
+		}
+	}
+}
+
+// Watched reports whether anyone would receive or replay an event published
+// to the channel now
+func (h *Hub) Watched(channel string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients[channel]) > 0 {
+		return true
+	}
+	hist := h.history[channel]
+	return hist != nil && time.Since(hist.idleSince) <= historyTTL
+}
+
+func historyLimit(channel string) int {
+	if strings.HasPrefix(channel, "post:") {
+		return postHistorySize
+	}
+	return historySize
+}
+
+// ClientCount returns how many clients are subscribed to the channel
+func (h *Hub) ClientCount(channel string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients[channel])
+}
+
+// UserChannel is the channel carrying a user's notifications
+func UserChannel(userID string) string { return "user:" + userID }
+
+// PostChannel is the channel carrying live activity on a post
+func PostChannel(postID string) string { return "post:" + postID }
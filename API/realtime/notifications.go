@@ -0,0 +1,30 @@
+package realtime
+
+import "forum/models"
+
+// Event types sent on the notification stream
+const (
+	EventNotification        = "notification"
+	EventNotificationRemoved = "notification_removed"
+	EventUnreadCount         = "unread"
+)
+
+// UnreadCount is the payload of an EventUnreadCount event
+type UnreadCount struct {
+	Unread int `json:"unread"`
+}
+
+// NotificationCreated pushes a freshly stored or regrouped notification to its recipient
+func (h *Hub) NotificationCreated(n models.Notification) {
+	h.Publish(UserChannel(n.UserID), EventNotification, n)
+}
+
+// NotificationRemoved tells the recipient a notification was taken back
+func (h *Hub) NotificationRemoved(userID, notificationID string) {
+	h.Publish(UserChannel(userID), EventNotificationRemoved, map[string]string{"id": notificationID})
+}
+
+// UnreadCountChanged pushes the user's current unread count
+func (h *Hub) UnreadCountChanged(userID string, count int) {
+	h.Publish(UserChannel(userID), EventUnreadCount, UnreadCount{Unread: count})
+}
@@ -0,0 +1,56 @@
+package realtime
+
+import (
+	"time"
+
+	"forum/models"
+)
+
+// Event types sent on a post channel
+const (
+	EventPostUpdated      = "post_updated"
+	EventPostDeleted      = "post_deleted"
+	EventCommentCreated   = "comment_created"
+	EventCommentUpdated   = "comment_updated"
+	EventCommentDeleted   = "comment_deleted"
+	EventReactionsUpdated = "reactions_updated"
+)
+
+// PostUpdate is the payload of EventPostUpdated and EventPostDeleted events
+type PostUpdate struct {
+	PostID      string    `json:"post_id"`
+	Title       *string   `json:"title,omitempty"`
+	Content     *string   `json:"content,omitempty"`
+	ContentHTML *string   `json:"content_html,omitempty"` // Content rendered from Markdown
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Mentions in Content; only sent when Content is
+	Mentions []models.Mention `json:"mentions,omitempty"`
+}
+
+// CommentUpdate is the payload of EventCommentUpdated and EventCommentDeleted events
+type CommentUpdate struct {
+	CommentID   string           `json:"comment_id"`
+	PostID      string           `json:"post_id"`
+	Content     *string          `json:"content"`
+	ContentHTML *string          `json:"content_html"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	Mentions    []models.Mention `json:"mentions,omitempty"`
+}
+
+// ReactionsUpdate is the payload of an EventReactionsUpdated event. It carries
+// the full reaction list of the target so clients can simply replace theirs.
+type ReactionsUpdate struct {
+	TargetType string                    `json:"target_type"`
+	TargetID   string                    `json:"target_id"`
+	Reactions  []models.ReactionWithUser `json:"reactions"`
+}
+
+// PublishPost sends an event to everyone watching the post. Posts no one has
+// open are skipped without encoding the payload or recording it.
+func (h *Hub) PublishPost(postID, eventType string, payload interface{}) {
+	channel := PostChannel(postID)
+	if !h.Watched(channel) {
+		return
+	}
+	h.Publish(channel, eventType, payload)
+}
@@ -0,0 +1,60 @@
+package realtime
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// clientFrame builds a masked frame as a browser sends it
+func clientFrame(op int, payload []byte) []byte {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | byte(op), 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func pipeConn(t *testing.T) (*Conn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close(); client.Close() })
+	return &Conn{conn: server, br: bufio.NewReader(server)}, client
+}
+
+func TestReadTimeoutExtendedByPongs(t *testing.T) {
+	c, client := pipeConn(t)
+	c.SetReadTimeout(200 * time.Millisecond)
+
+	go func() {
+		for i := 0; i < 8; i++ {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := client.Write(clientFrame(OpPong, nil)); err != nil {
+				return
+			}
+		}
+		client.Write(clientFrame(OpText, []byte(`{"action":"ping"}`)))
+	}()
+
+	start := time.Now()
+	op, data, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage after %s of pongs: %v", time.Since(start), err)
+	}
+	if op != OpText || string(data) != `{"action":"ping"}` {
+		t.Errorf("ReadMessage = %d %q", op, data)
+	}
+}
+
+func TestReadTimeoutExpiresWhenSilent(t *testing.T) {
+	c, _ := pipeConn(t)
+	c.SetReadTimeout(50 * time.Millisecond)
+	if _, _, err := c.ReadMessage(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadMessage on a silent peer: err = %v, want a deadline error", err)
+	}
+}
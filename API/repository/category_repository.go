@@ -71,10 +71,13 @@ func (r *CategoryRepository) GetCategoryByID(id int) (*models.Category, error) {
 	
 
 // // repository/comment_repository.go
+// GetCommentsByPostWithUser returns a post's comments in thread order (see
+// ThreadOrder), each with its parent and depth
 func (r *CommentRepository) GetCommentsByPostWithUser(postID string) ([]models.CommentWithUser, error) {
-	query := `SELECT c.comment_id, c.post_id, c.user_id, u.username, c.content, c.created_at, c.updated_at
+	query := `SELECT c.comment_id, c.post_id, c.user_id, u.username, c.parent_comment_id, c.content, c.content_html, c.created_at, c.updated_at
 			  FROM comments c JOIN user u ON c.user_id = u.user_id
-			  WHERE c.post_id = ?`
+			  WHERE c.post_id = ?
+			  ORDER BY c.created_at ASC, c.comment_id ASC`
 
 	rows, err := r.db.Query(query, postID)
 	if err != nil {
@@ -85,21 +88,25 @@ func (r *CommentRepository) GetCommentsByPostWithUser(postID string) ([]models.C
 	var comments []models.CommentWithUser
 	for rows.Next() {
 		var c models.CommentWithUser
-		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Username, &c.Content, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Username, &c.ParentCommentID, &c.Content, &c.ContentHTML, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
+		c.ContentHTML = withHTML(c.ContentHTML, c.Content)
 		comments = append(comments, c)
 	}
-	return comments, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ThreadOrder(comments), nil
 }
 
 func (r *PostRepository) GetPostsByCategoryWithUser(categoryID int) ([]models.PostWithUser, error) {
 	rows, err := r.db.Query(`
-		SELECT p.post_id, p.user_id, u.username, pc.category_id, p.title, p.content, p.created_at, p.updated_at
+		SELECT p.post_id, p.user_id, u.username, pc.category_id, p.title, p.content, p.content_html, p.created_at, p.updated_at
 		FROM posts p
 		JOIN post_categories pc ON p.post_id = pc.post_id
 		JOIN user u ON p.user_id = u.user_id
-		WHERE pc.category_id = ?
+		WHERE pc.category_id = ? AND p.status = 'published'
 		ORDER BY p.created_at DESC
 	`, categoryID)
 	if err != nil {
@@ -110,10 +117,11 @@ func (r *PostRepository) GetPostsByCategoryWithUser(categoryID int) ([]models.Po
 	var posts []models.PostWithUser
 	for rows.Next() {
 		var post models.PostWithUser
-		err := rows.Scan(&post.ID, &post.UserID, &post.Username, &post.CategoryID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt)
+		err := rows.Scan(&post.ID, &post.UserID, &post.Username, &post.CategoryID, &post.Title, &post.Content, &post.ContentHTML, &post.CreatedAt, &post.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		post.ContentHTML = withHTML(post.ContentHTML, post.Content)
 		posts = append(posts, post)
 	}
 	return posts, nil
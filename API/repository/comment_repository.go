@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"forum/markdown"
 	"forum/models"
 	"forum/utils"
 	"time"
@@ -19,11 +20,12 @@ func (r *CommentRepository) GetCommentOwner(commentID string) (string, error) {
 
 func (r *CommentRepository) GetByID(id string) (*models.Comment, error) {
 	var c models.Comment
-	err := r.db.QueryRow(`SELECT comment_id, post_id, user_id, content, created_at, updated_at FROM comments WHERE comment_id = ?`, id).
-		Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	err := r.db.QueryRow(`SELECT comment_id, post_id, user_id, parent_comment_id, depth, content, content_html, created_at, updated_at FROM comments WHERE comment_id = ?`, id).
+		Scan(&c.ID, &c.PostID, &c.UserID, &c.ParentCommentID, &c.Depth, &c.Content, &c.ContentHTML, &c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
+	c.ContentHTML = withHTML(c.ContentHTML, c.Content)
 	return &c, nil
 }
 
@@ -33,7 +35,7 @@ func NewCommentRepository(db *sql.DB) *CommentRepository {
 
 func (r *CommentRepository) GetAllComments() ([]models.Comment, error) {
 	rows, err := r.db.Query(`
-		SELECT comment_id, post_id, user_id, content, created_at, updated_at 
+		SELECT comment_id, post_id, user_id, parent_comment_id, depth, content, content_html, created_at, updated_at 
 		FROM comments ORDER BY created_at ASC`)
 	if err != nil {
 		return nil, err
@@ -43,36 +45,187 @@ func (r *CommentRepository) GetAllComments() ([]models.Comment, error) {
 	var comments []models.Comment
 	for rows.Next() {
 		var c models.Comment
-		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.ParentCommentID, &c.Depth, &c.Content, &c.ContentHTML, &c.CreatedAt, &c.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		c.ContentHTML = withHTML(c.ContentHTML, c.Content)
 		comments = append(comments, c)
 	}
 
 	return comments, nil
 }
 
-// Create inserts a new comment into the database
+// Create inserts a new comment into the database. It returns
+// ErrPostNotFound unless the post is published.
 func (r *CommentRepository) Create(comment models.Comment) (*models.Comment, error) {
 	comment.ID = utils.GenerateUUID()
 	comment.CreatedAt = time.Now()
-	_, err := r.db.Exec(`INSERT INTO comments (comment_id, post_id, user_id, content, created_at) VALUES (?, ?, ?, ?, ?)`,
-		comment.ID, comment.PostID, comment.UserID, comment.Content, comment.CreatedAt)
+	comment.ContentHTML = renderContent(comment.Content)
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	if err := requirePublished(tx, comment.PostID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO comments (comment_id, post_id, user_id, content, content_html, html_version, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		comment.ID, comment.PostID, comment.UserID, comment.Content, comment.ContentHTML, markdown.Version, comment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordCommentRevision(tx, comment.ID, comment.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// CreateReply stores comment as a reply to parentID, one level below it. The
+// parent must be on the same post, which must be published, and a reply may
+// not be nested deeper than models.MaxCommentDepth.
+func (r *CommentRepository) CreateReply(comment models.Comment, parentID string) (*models.Comment, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := requirePublished(tx, comment.PostID); err != nil {
+		return nil, err
+	}
+	var postID string
+	var depth int
+	err = tx.QueryRow(`SELECT post_id, depth FROM comments WHERE comment_id = ?`, parentID).Scan(&postID, &depth)
+	if err == sql.ErrNoRows || (err == nil && postID != comment.PostID) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if depth+1 > models.MaxCommentDepth {
+		return nil, ErrCommentTooDeep
+	}
+
+	comment.ID = utils.GenerateUUID()
+	comment.CreatedAt = time.Now()
+	comment.ParentCommentID = &parentID
+	comment.Depth = depth + 1
+	comment.ContentHTML = renderContent(comment.Content)
+	_, err = tx.Exec(`INSERT INTO comments (comment_id, post_id, user_id, parent_comment_id, depth, content, content_html, html_version, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		comment.ID, comment.PostID, comment.UserID, parentID, comment.Depth, comment.Content, comment.ContentHTML, markdown.Version, comment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordCommentRevision(tx, comment.ID, comment.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return &comment, nil
 }
 
-// UpdateComment updates the content of a comment and sets updated_at
+// UpdateComment updates the content of a comment, and its rendered HTML, and
+// sets updated_at. The new text is recorded as a revision in the same
+// transaction.
 func (r *CommentRepository) UpdateComment(commentID string, content *string) error {
-	_, err := r.db.Exec(`UPDATE comments SET content = ?, updated_at = ? WHERE comment_id = ?`, content, time.Now(), commentID)
-	return err
+	now := time.Now()
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE comments SET content = ?, content_html = ?, html_version = ?, updated_at = ? WHERE comment_id = ?`,
+		content, renderContent(content), markdown.Version, now, commentID); err != nil {
+		return err
+	}
+	if err := recordCommentRevision(tx, commentID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// SoftDeleteComment sets content to NULL and updates updated_at
+// SoftDeleteComment sets content to NULL and updates updated_at, and drops
+// the comment's revisions
 func (r *CommentRepository) SoftDeleteComment(commentID string) error {
-	_, err := r.db.Exec(`UPDATE comments SET content = NULL, updated_at = ? WHERE comment_id = ?`, time.Now(), commentID)
-	return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE comments SET content = NULL, content_html = NULL, updated_at = ? WHERE comment_id = ?`, time.Now(), commentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM comment_revisions WHERE comment_id = ?`, commentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ThreadOrder orders a post's comments for display: each comment is followed
+// by its replies, oldest first at every level. comments must be sorted by
+// creation time. Deleted comments stay in place so their replies keep their
+// context, and replies whose parent is missing are shown at the top level.
+func ThreadOrder(comments []models.CommentWithUser) []models.CommentWithUser {
+	children, roots := threadChildren(comments)
+	out := make([]models.CommentWithUser, 0, len(comments))
+	var walk func(c models.CommentWithUser, depth int)
+	walk = func(c models.CommentWithUser, depth int) {
+		c.Depth = depth
+		out = append(out, c)
+		for _, child := range children[c.ID] {
+			walk(child, depth+1)
+		}
+	}
+	for _, c := range roots {
+		walk(c, 0)
+	}
+	return out
+}
+
+// CommentTree nests a post's comments under their parents' Replies, in the
+// same order as ThreadOrder
+func CommentTree(comments []models.CommentWithUser) []models.CommentWithUser {
+	children, roots := threadChildren(comments)
+	var build func(c models.CommentWithUser, depth int) models.CommentWithUser
+	build = func(c models.CommentWithUser, depth int) models.CommentWithUser {
+		c.Depth = depth
+		c.Replies = nil
+		for _, child := range children[c.ID] {
+			c.Replies = append(c.Replies, build(child, depth+1))
+		}
+		return c
+	}
+	tree := make([]models.CommentWithUser, 0, len(roots))
+	for _, c := range roots {
+		tree = append(tree, build(c, 0))
+	}
+	return tree
+}
+
+// threadChildren groups comments by parent, keeping their order. Comments
+// without a parent in the list are roots.
+func threadChildren(comments []models.CommentWithUser) (map[string][]models.CommentWithUser, []models.CommentWithUser) {
+	present := make(map[string]bool, len(comments))
+	for _, c := range comments {
+		present[c.ID] = true
+	}
+	children := make(map[string][]models.CommentWithUser)
+	var roots []models.CommentWithUser
+	for _, c := range comments {
+		if c.ParentCommentID != nil && present[*c.ParentCommentID] {
+			children[*c.ParentCommentID] = append(children[*c.ParentCommentID], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+	return children, roots
 }
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"database/sql"
+
+	"forum/markdown"
+)
+
+// renderContent renders content for the content_html cache. Deleted content
+// (nil) has no HTML.
+func renderContent(content *string) *string {
+	if content == nil {
+		return nil
+	}
+	html := markdown.Render(*content)
+	return &html
+}
+
+// withHTML returns the cached HTML, rendering content when the cache was
+// cleared by an edit that didn't go through a repository
+func withHTML(html, content *string) *string {
+	if html != nil {
+		return html
+	}
+	return renderContent(content)
+}
+
+// RefreshContentHTML renders the posts and comments whose cached HTML is
+// missing or came from an older markdown.Version, and returns how many it
+// updated. It runs at startup, after migrations.
+func RefreshContentHTML(db *sql.DB) (int, error) {
+	total := 0
+	for _, table := range []struct{ name, key string }{{"posts", "post_id"}, {"comments", "comment_id"}} {
+		rows, err := db.Query(`SELECT `+table.key+`, content FROM `+table.name+`
+			WHERE content IS NOT NULL AND (content_html IS NULL OR html_version < ?)`, markdown.Version)
+		if err != nil {
+			return total, err
+		}
+		stale := map[string]string{}
+		for rows.Next() {
+			var id, content string
+			if err := rows.Scan(&id, &content); err != nil {
+				rows.Close()
+				return total, err
+			}
+			stale[id] = content
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return total, err
+		}
+		// The content check skips rows edited in the meantime; the edit
+		// rendered them already
+		stmt, err := tx.Prepare(`UPDATE ` + table.name + ` SET content_html = ?, html_version = ?
+			WHERE ` + table.key + ` = ? AND content = ?`)
+		if err != nil {
+			tx.Rollback()
+			return total, err
+		}
+		for id, content := range stale {
+			if _, err := stmt.Exec(markdown.Render(content), markdown.Version, id, content); err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return total, err
+			}
+		}
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			return total, err
+		}
+		total += len(stale)
+	}
+	return total, nil
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/markdown"
+	"forum/models"
+)
+
+// requirePublished returns ErrPostNotFound unless postID is a published post.
+// Drafts and scheduled posts take no comments or reactions.
+func requirePublished(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, postID string) error {
+	var status string
+	err := q.QueryRow(`SELECT status FROM posts WHERE post_id = ?`, postID).Scan(&status)
+	if err == sql.ErrNoRows || (err == nil && status != models.PostPublished) {
+		return ErrPostNotFound
+	}
+	return err
+}
+
+// GetDrafts lists a user's drafts and scheduled posts, most recently changed
+// first
+func (r *PostRepository) GetDrafts(userID string) ([]models.Post, error) {
+	rows, err := r.db.Query(`SELECT post_id, user_id, title, content, content_html, created_at, updated_at, status, publish_at
+		FROM posts
+		WHERE user_id = ? AND status != 'published' AND (title IS NOT NULL OR content IS NOT NULL)
+		ORDER BY julianday(COALESCE(updated_at, created_at)) DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	drafts := []models.Post{}
+	for rows.Next() {
+		var post models.Post
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ContentHTML, &post.CreatedAt, &post.UpdatedAt, &post.Status, &post.PublishAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		post.ContentHTML = withHTML(post.ContentHTML, post.Content)
+		drafts = append(drafts, post)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range drafts {
+		cats, err := r.GetCategoriesByPostID(drafts[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		drafts[i].CategoryIDs = []int{}
+		for _, c := range cats {
+			drafts[i].CategoryIDs = append(drafts[i].CategoryIDs, c.ID)
+		}
+	}
+	return drafts, nil
+}
+
+// UpdateDraft replaces the title, content, status, publish time and
+// categories of a post that isn't published yet. Drafts keep no revisions.
+// It returns ErrPostNotFound when the post was published or deleted.
+func (r *PostRepository) UpdateDraft(post models.Post, categoryIDs []int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE posts SET title = ?, content = ?, content_html = ?, html_version = ?, status = ?, publish_at = ?, updated_at = ?
+		WHERE post_id = ? AND status != 'published' AND (title IS NOT NULL OR content IS NOT NULL)`,
+		post.Title, post.Content, renderContent(post.Content), markdown.Version, post.Status, post.PublishAt, time.Now(), post.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPostNotFound
+	}
+
+	if r.hasLegacyCategoryColumn() && len(categoryIDs) > 0 {
+		if _, err := tx.Exec(`UPDATE posts SET category_id = ? WHERE post_id = ?`, categoryIDs[0], post.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM post_categories WHERE post_id = ?`, post.ID); err != nil {
+		return err
+	}
+	for _, cid := range categoryIDs {
+		if _, err := tx.Exec(`INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)`, post.ID, cid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PublishPost publishes a draft or scheduled post as of at, which becomes its
+// creation time, and records its first revision. It reports false when the
+// post was already published or is gone, so a post is published only once.
+func (r *PostRepository) PublishPost(postID string, at time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE posts SET status = 'published', publish_at = ?, created_at = ?, updated_at = NULL
+		WHERE post_id = ? AND status != 'published' AND (title IS NOT NULL OR content IS NOT NULL)`, at, at, postID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := recordPostRevision(tx, postID, at); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DuePosts lists the scheduled posts whose publish time is at or before now,
+// earliest first
+func (r *PostRepository) DuePosts(now time.Time) ([]string, error) {
+	rows, err := r.db.Query(`SELECT post_id FROM posts
+		WHERE status = 'scheduled' AND julianday(publish_at) <= julianday(?)
+			AND (title IS NOT NULL OR content IS NOT NULL)
+		ORDER BY julianday(publish_at)`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// NextPublishAt returns the earliest publish time of a scheduled post, or nil
+// when none is scheduled
+func (r *PostRepository) NextPublishAt() (*time.Time, error) {
+	var at time.Time
+	err := r.db.QueryRow(`SELECT publish_at FROM posts
+		WHERE status = 'scheduled' AND (title IS NOT NULL OR content IS NOT NULL)
+		ORDER BY julianday(publish_at) LIMIT 1`).Scan(&at)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &at, nil
+}
@@ -13,4 +13,15 @@ var (
 	ErrOAuthStateNotFound   = errors.New("oauth state not found")
 	ErrOAuthStateExpired    = errors.New("oauth state expired")
 	ErrOAuthAccountExists   = errors.New("oauth account already exists")
+	ErrInvalidCursor        = errors.New("invalid cursor")
+	ErrWebhookNotFound      = errors.New("webhook not found")
+	ErrCommentNotFound      = errors.New("comment not found")
+	ErrCommentTooDeep       = errors.New("reply nested too deeply")
+	ErrInvalidFeedSort      = errors.New("invalid feed sort")
+	ErrRevisionNotFound     = errors.New("revision not found")
+	ErrPostNotFound         = errors.New("post not found")
+	ErrImageNotFound        = errors.New("image not found")
+	ErrGalleryFull          = errors.New("post has the most images allowed")
+	ErrInvalidImageOrder    = errors.New("order must list each of the post's images once")
+	ErrImageFilesMissing    = errors.New("a file the image points at is not stored")
 )
@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// Feed page sizes
+const (
+	DefaultFeedLimit = 20
+	MaxFeedLimit     = 50
+)
+
+// feedScores is the sort key of each feed order; posts with equal scores
+// follow newest first. The hot score is (likes - dislikes + comments + 1)
+// divided by the square of the post's age in hours plus two, so a post needs
+// about four times the activity to keep its place when its age doubles. Age
+// is measured from the time the first page was read, kept in the cursor, so
+// scores don't drift while a reader pages through.
+var feedScores = map[string]string{
+	models.FeedNewest:   `0`,
+	models.FeedComments: `p.comment_count`,
+	models.FeedLikes:    `p.like_count`,
+	models.FeedHot: `(p.like_count - p.dislike_count + p.comment_count + 1) /
+		((MAX(julianday(?) - julianday(p.created_at), 0) * 24 + 2) * (MAX(julianday(?) - julianday(p.created_at), 0) * 24 + 2))`,
+}
+
+type FeedRepository struct {
+	db *sql.DB
+}
+
+func NewFeedRepository(db *sql.DB) *FeedRepository {
+	return &FeedRepository{db: db}
+}
+
+// Feed returns one page of posts in q.Sort order. Deleted posts are left out.
+// Comment and reaction counts come from the counters kept on posts, and each
+// page's categories and images are loaded in one query apiece.
+func (r *FeedRepository) Feed(q models.FeedQuery) (*models.FeedPage, error) {
+	scoreExpr, ok := feedScores[q.Sort]
+	if !ok {
+		return nil, ErrInvalidFeedSort
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultFeedLimit
+	}
+	if limit > MaxFeedLimit {
+		limit = MaxFeedLimit
+	}
+
+	at := time.Now()
+	var after *feedCursor
+	if q.Cursor != "" {
+		c, err := decodeFeedCursor(q.Cursor, q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		at, after = c.At, &c
+	}
+
+	var args []interface{}
+	if q.Sort == models.FeedHot {
+		args = append(args, at, at)
+	}
+	join := ""
+	if q.CategoryID != 0 {
+		join = ` JOIN post_categories pc ON pc.post_id = p.post_id AND pc.category_id = ?`
+		args = append(args, q.CategoryID)
+	}
+	where := ""
+	if after != nil {
+		where = ` WHERE score < ? OR (score = ? AND (created_at < ? OR (created_at = ? AND post_id < ?)))`
+		args = append(args, after.Score, after.Score, after.CreatedAt, after.CreatedAt, after.ID)
+	}
+	// Fetch one extra row to learn whether another page exists
+	args = append(args, limit+1)
+
+	rows, err := r.db.Query(`WITH feed AS (
+			SELECT p.post_id, p.user_id, u.username, p.title, p.content, p.content_html, p.created_at, p.updated_at,
+				p.comment_count, p.like_count, p.dislike_count, `+scoreExpr+` AS score
+			FROM posts p
+			JOIN user u ON u.user_id = p.user_id`+join+`
+			WHERE p.status = 'published' AND (p.title IS NOT NULL OR p.content IS NOT NULL)
+		)
+		SELECT post_id, user_id, username, title, content, content_html, created_at, updated_at,
+			comment_count, like_count, dislike_count, score
+		FROM feed`+where+`
+		ORDER BY score DESC, created_at DESC, post_id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.FeedPage{Posts: []models.FeedPost{}, Sort: q.Sort}
+	var scores []float64
+	for rows.Next() {
+		var p models.FeedPost
+		var title, content, contentHTML *string
+		var score float64
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &title, &content, &contentHTML, &p.CreatedAt, &p.UpdatedAt,
+			&p.CommentCount, &p.LikeCount, &p.DislikeCount, &score); err != nil {
+			return nil, err
+		}
+		p.Title = utils.DerefString(title)
+		p.Content = utils.DerefString(content)
+		p.ContentHTML = utils.DerefString(withHTML(contentHTML, content))
+		p.Categories = []models.Category{}
+		page.Posts = append(page.Posts, p)
+		scores = append(scores, score)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Posts) > limit {
+		page.Posts = page.Posts[:limit]
+		page.HasMore = true
+		last := page.Posts[limit-1]
+		page.NextCursor = encodeFeedCursor(q.Sort, feedCursor{At: at, Score: scores[limit-1], CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	if err := r.loadCategories(page.Posts); err != nil {
+		return nil, err
+	}
+	if err := r.loadImages(page.Posts); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// loadCategories fills in the categories of every post in one query
+func (r *FeedRepository) loadCategories(posts []models.FeedPost) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	index, args := feedIndex(posts)
+	rows, err := r.db.Query(`SELECT pc.post_id, c.category_id, c.name
+		FROM post_categories pc
+		JOIN categories c ON c.category_id = pc.category_id
+		WHERE pc.post_id IN (?`+strings.Repeat(", ?", len(posts)-1)+`)
+		ORDER BY c.category_id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID string
+		var c models.Category
+		if err := rows.Scan(&postID, &c.ID, &c.Name); err != nil {
+			return err
+		}
+		p := &posts[index[postID]]
+		p.Categories = append(p.Categories, c)
+	}
+	return rows.Err()
+}
+
+// loadImages sets each post's gallery, and its first image, in one query
+// plus one for the variants. The paths are relative to the uploads directory.
+func (r *FeedRepository) loadImages(posts []models.FeedPost) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	index, args := feedIndex(posts)
+	for i := range posts {
+		posts[i].Images = []models.GalleryImage{}
+	}
+	rows, err := r.db.Query(`SELECT post_id, image_id, file_path, thumbnail_path, width, height, position, caption, alt_text
+		FROM images
+		WHERE post_id IN (?`+strings.Repeat(", ?", len(posts)-1)+`)
+		ORDER BY position, created_at, image_id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type place struct{ post, image int }
+	images := make(map[string]place)
+	for rows.Next() {
+		var postID string
+		var img models.GalleryImage
+		if err := rows.Scan(&postID, &img.ID, &img.URL, &img.ThumbnailURL, &img.Width, &img.Height, &img.Position, &img.Caption, &img.AltText); err != nil {
+			return err
+		}
+		p := &posts[index[postID]]
+		if p.ImageURL == "" {
+			p.ImageURL, p.ThumbnailURL = img.URL, img.ThumbnailURL
+		}
+		images[img.ID] = place{index[postID], len(p.Images)}
+		p.Images = append(p.Images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(images) == 0 {
+		return nil
+	}
+
+	vrows, err := r.db.Query(`SELECT v.image_id, v.file_path, v.width, v.height, v.content_type
+		FROM image_variants v
+		JOIN images i ON i.image_id = v.image_id
+		WHERE i.post_id IN (?`+strings.Repeat(", ?", len(posts)-1)+`)
+		ORDER BY v.width, v.content_type`, args...)
+	if err != nil {
+		return err
+	}
+	defer vrows.Close()
+	for vrows.Next() {
+		var imageID string
+		var v models.GalleryVariant
+		if err := vrows.Scan(&imageID, &v.URL, &v.Width, &v.Height, &v.ContentType); err != nil {
+			return err
+		}
+		if at, ok := images[imageID]; ok {
+			img := &posts[at.post].Images[at.image]
+			img.Variants = append(img.Variants, v)
+		}
+	}
+	return vrows.Err()
+}
+
+// feedIndex maps post IDs to their place in posts and lists them as query args
+func feedIndex(posts []models.FeedPost) (map[string]int, []interface{}) {
+	index := make(map[string]int, len(posts))
+	args := make([]interface{}, len(posts))
+	for i, p := range posts {
+		index[p.ID] = i
+		args[i] = p.ID
+	}
+	return index, args
+}
+
+// feedCursor is the position after the last post of a page
+type feedCursor struct {
+	At        time.Time // when the first page was read
+	Score     float64
+	CreatedAt time.Time
+	ID        string
+}
+
+// encodeFeedCursor builds the opaque cursor for a page of the given sort.
+// Timestamps keep their zone so they compare equal to the stored values.
+func encodeFeedCursor(sort string, c feedCursor) string {
+	raw := strings.Join([]string{
+		sort,
+		c.At.Format(time.RFC3339Nano),
+		strconv.FormatFloat(c.Score, 'g', -1, 64),
+		c.CreatedAt.Format(time.RFC3339Nano),
+		c.ID,
+	}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeFeedCursor reads a cursor, which must come from a feed of the same sort
+func decodeFeedCursor(cursor, sort string) (feedCursor, error) {
+	var c feedCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 5)
+	if len(parts) != 5 || parts[0] != sort || parts[4] == "" {
+		return c, ErrInvalidCursor
+	}
+	if c.At, err = time.Parse(time.RFC3339Nano, parts[1]); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Score, err = strconv.ParseFloat(parts[2], 64); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.CreatedAt, err = time.Parse(time.RFC3339Nano, parts[3]); err != nil {
+		return c, ErrInvalidCursor
+	}
+	c.ID = parts[4]
+	return c, nil
+}
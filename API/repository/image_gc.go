@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"forum/models"
+	"forum/storage"
+)
+
+// imageGCStartDelay lets the server settle before the first scheduled pass
+const imageGCStartDelay = 5 * time.Minute
+
+// ErrImageGCRunning is returned when a pass is asked for while one is
+// already in progress
+var ErrImageGCRunning = errors.New("an image collection pass is already in progress")
+
+// ImageGCPolicy decides when the image garbage collector runs and what it
+// may delete
+type ImageGCPolicy struct {
+	Interval time.Duration // IMAGE_GC_INTERVAL between scheduled passes, default 24h; 0 disables the schedule
+	MinAge   time.Duration // IMAGE_GC_MIN_AGE: younger files are never orphans, default 1h, so uploads in flight are safe
+	Delete   bool          // IMAGE_GC_DELETE=true lets scheduled passes delete; otherwise they only report
+}
+
+// ImageGCPolicyFromEnv builds an ImageGCPolicy from the environment (see
+// utils.LoadEnv)
+func ImageGCPolicyFromEnv() ImageGCPolicy {
+	p := ImageGCPolicy{
+		Interval: 24 * time.Hour,
+		MinAge:   time.Hour,
+		Delete:   os.Getenv("IMAGE_GC_DELETE") == "true",
+	}
+	for key, d := range map[string]*time.Duration{"IMAGE_GC_INTERVAL": &p.Interval, "IMAGE_GC_MIN_AGE": &p.MinAge} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		if parsed, err := time.ParseDuration(v); err == nil && parsed >= 0 {
+			*d = parsed
+		} else {
+			log.Printf("ImageGC [WARN]: ignoring %s=%q", key, v)
+		}
+	}
+	return p
+}
+
+// ImageGC finds stored files no row points at and rows whose files are gone,
+// and deletes both unless asked for a dry run. Passes never overlap.
+type ImageGC struct {
+	images *ImageRepository
+	policy ImageGCPolicy
+
+	running sync.Mutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// NewImageGC starts the schedule unless the policy's interval is zero
+func NewImageGC(images *ImageRepository, policy ImageGCPolicy) *ImageGC {
+	g := &ImageGC{images: images, policy: policy, done: make(chan struct{})}
+	if policy.Interval > 0 {
+		g.wg.Add(1)
+		go g.loop()
+	}
+	return g
+}
+
+// Preview reports what a pass would delete right now
+func (g *ImageGC) Preview() (*models.ImageGCReport, error) {
+	report, _, err := g.scan(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = true
+	finished := time.Now()
+	report.FinishedAt = &finished
+	return report, nil
+}
+
+// Run makes a pass now, deleting what it finds unless dryRun is set. It
+// returns ErrImageGCRunning rather than waiting when another pass is in
+// progress.
+func (g *ImageGC) Run(trigger string, dryRun bool) (*models.ImageGCReport, error) {
+	if !g.running.TryLock() {
+		return nil, ErrImageGCRunning
+	}
+	defer g.running.Unlock()
+
+	report, found, err := g.scan(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	report.Trigger, report.DryRun = trigger, dryRun
+	if !dryRun {
+		err = g.apply(report, found)
+		if err != nil {
+			report.Error = err.Error()
+		}
+	}
+	finished := time.Now()
+	report.FinishedAt = &finished
+	log.Printf("ImageGC [INFO]: %s pass found %d orphan files (%d bytes), %d images and %d variants with missing files; deleted %d files and %d rows",
+		trigger, len(report.OrphanFiles), report.OrphanBytes, len(report.MissingImages), len(report.MissingVariants), report.FilesDeleted, report.RowsDeleted)
+	return report, err
+}
+
+// Close stops the schedule, waiting for a pass in progress to finish
+func (g *ImageGC) Close() {
+	g.once.Do(func() { close(g.done) })
+	g.wg.Wait()
+}
+
+func (g *ImageGC) loop() {
+	defer g.wg.Done()
+	timer := time.NewTimer(imageGCStartDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-timer.C:
+			if _, err := g.Run(models.ImageGCTriggerSchedule, !g.policy.Delete); err != nil {
+				log.Printf("ImageGC [ERROR]: scheduled pass: %v", err)
+			}
+			timer.Reset(g.policy.Interval)
+		}
+	}
+}
+
+// gcScan is what a scan saw, for apply to check again before deleting
+type gcScan struct {
+	referenced int // distinct files the rows point at
+	found      int // of those, how many are stored
+}
+
+// scan reads the rows first and walks the files second. A file stored after
+// the rows were read is at most MinAge old and so not taken for an orphan;
+// a row added meanwhile is not looked at.
+func (g *ImageGC) scan(now time.Time) (*models.ImageGCReport, gcScan, error) {
+	report := &models.ImageGCReport{
+		StartedAt:       now,
+		OrphanFiles:     []models.OrphanFile{},
+		MissingImages:   []models.MissingImage{},
+		MissingVariants: []models.MissingVariant{},
+	}
+	var found gcScan
+	db := g.images.db
+
+	type imageRow struct{ id, postID, file, thumb string }
+	var images []imageRow
+	rows, err := db.Query(`SELECT image_id, post_id, file_path, thumbnail_path FROM images ORDER BY created_at, image_id`)
+	if err != nil {
+		return nil, found, err
+	}
+	for rows.Next() {
+		var img imageRow
+		if err := rows.Scan(&img.id, &img.postID, &img.file, &img.thumb); err != nil {
+			rows.Close()
+			return nil, found, err
+		}
+		images = append(images, img)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, found, err
+	}
+
+	var variants []models.MissingVariant
+	rows, err = db.Query(`SELECT image_id, width, content_type, file_path FROM image_variants ORDER BY image_id, width, content_type`)
+	if err != nil {
+		return nil, found, err
+	}
+	for rows.Next() {
+		var v models.MissingVariant
+		if err := rows.Scan(&v.ImageID, &v.Width, &v.ContentType, &v.Path); err != nil {
+			rows.Close()
+			return nil, found, err
+		}
+		variants = append(variants, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, found, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, img := range images {
+		referenced[img.file], referenced[img.thumb] = true, true
+	}
+	for _, v := range variants {
+		referenced[v.Path] = true
+	}
+
+	stored := make(map[string]bool)
+	err = g.images.store.Walk(func(f storage.File) error {
+		report.FilesScanned++
+		stored[f.Key] = true
+		if !referenced[f.Key] && now.Sub(f.ModifiedAt) >= g.policy.MinAge {
+			report.OrphanFiles = append(report.OrphanFiles, models.OrphanFile{Path: f.Key, Size: f.Size, ModifiedAt: f.ModifiedAt})
+			report.OrphanBytes += f.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, found, err
+	}
+
+	found.referenced = len(referenced)
+	for key := range referenced {
+		if stored[key] {
+			found.found++
+		}
+	}
+	for _, img := range images {
+		var missing []string
+		for _, key := range []string{img.file, img.thumb} {
+			if !stored[key] {
+				missing = append(missing, key)
+			}
+		}
+		if missing != nil {
+			report.MissingImages = append(report.MissingImages, models.MissingImage{ImageID: img.id, PostID: img.postID, Missing: missing})
+		}
+	}
+	for _, v := range variants {
+		if !stored[v.Path] {
+			report.MissingVariants = append(report.MissingVariants, v)
+		}
+	}
+	return report, found, nil
+}
+
+// apply deletes what scan found, checking each item again inside one write
+// transaction so that uploads and deletes made since the scan are respected
+func (g *ImageGC) apply(report *models.ImageGCReport, found gcScan) error {
+	// With no referenced file stored at all, the uploads directory is more
+	// likely missing or the wrong one than every file lost
+	if found.referenced > 0 && found.found == 0 {
+		return fmt.Errorf("none of the %d files the images point at is stored in %s; nothing deleted", found.referenced, g.images.store)
+	}
+
+	r := g.images
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	missing := func(key string) (bool, error) {
+		ok, err := r.store.Exists(key)
+		return !ok, err
+	}
+
+	for _, v := range report.MissingVariants {
+		if gone, err := missing(v.Path); err != nil || !gone {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		res, err := tx.Exec(`DELETE FROM image_variants WHERE image_id = ? AND width = ? AND content_type = ?`, v.ImageID, v.Width, v.ContentType)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		report.RowsDeleted += int(n)
+	}
+
+	for _, m := range report.MissingImages {
+		var img struct {
+			postID, file, thumb string
+			position            int
+		}
+		err := tx.QueryRow(`SELECT post_id, file_path, thumbnail_path, position FROM images WHERE image_id = ?`, m.ImageID).
+			Scan(&img.postID, &img.file, &img.thumb, &img.position)
+		if err != nil {
+			continue // deleted since the scan
+		}
+		fileGone, err := missing(img.file)
+		if err != nil {
+			return err
+		}
+		thumbGone, err := missing(img.thumb)
+		if err != nil {
+			return err
+		}
+		if !fileGone && !thumbGone {
+			continue
+		}
+		keys := []string{img.file, img.thumb}
+		vrows, err := tx.Query(`SELECT file_path FROM image_variants WHERE image_id = ?`, m.ImageID)
+		if err != nil {
+			return err
+		}
+		for vrows.Next() {
+			var key string
+			if err := vrows.Scan(&key); err != nil {
+				vrows.Close()
+				return err
+			}
+			keys = append(keys, key)
+		}
+		vrows.Close()
+
+		if _, err := tx.Exec(`DELETE FROM images WHERE image_id = ?`, m.ImageID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE images SET position = position - 1 WHERE post_id = ? AND position > ?`, img.postID, img.position); err != nil {
+			return err
+		}
+		report.RowsDeleted++
+		if err := r.releaseFiles(tx, keys); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range report.OrphanFiles {
+		refs, err := fileRefs(tx, f.Path)
+		if err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue // an upload has come to share it
+		}
+		if err := r.store.Delete(f.Path); err != nil {
+			log.Printf("ImageGC [ERROR]: delete %s: %v", f.Path, err)
+			continue
+		}
+		report.FilesDeleted++
+	}
+	return tx.Commit()
+}
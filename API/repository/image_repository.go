@@ -2,34 +2,106 @@ package repository
 
 import (
 	"database/sql"
-	"os"
+	"log"
 	"time"
 
 	"forum/models"
+	"forum/storage"
 	"forum/utils"
 )
 
+// ImageRepository keeps image rows and the stored files they point at. Files
+// are shared between rows with the same content, so a file is deleted only
+// when the last row pointing at it goes. Writes that add or drop references
+// check the files inside their transaction, which SQLite runs one at a time.
 type ImageRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	store *storage.Store
 }
 
-func NewImageRepository(db *sql.DB) *ImageRepository {
-	return &ImageRepository{db: db}
+func NewImageRepository(db *sql.DB, store *storage.Store) *ImageRepository {
+	return &ImageRepository{db: db, store: store}
 }
 
-func (r *ImageRepository) Create(img models.Image) (*models.Image, error) {
+// Create adds an image at the end of its post's gallery. It returns
+// ErrGalleryFull when the post already has limit images, and
+// ErrImageFilesMissing when a file it points at is not stored, for instance
+// because the last row sharing it was just deleted; store it again and retry.
+func (r *ImageRepository) Create(img models.Image, limit int) (*models.Image, error) {
 	img.ID = utils.GenerateUUID()
 	img.CreatedAt = time.Now()
-	_, err := r.db.Exec(`INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
-		img.ID, img.PostID, img.UserID, img.FilePath, img.ThumbnailPath, img.CreatedAt)
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*), COALESCE(MAX(position) + 1, 0) FROM images WHERE post_id = ?`, img.PostID).
+		Scan(&count, &img.Position); err != nil {
+		return nil, err
+	}
+	if count >= limit {
+		return nil, ErrGalleryFull
+	}
+	for _, key := range imageFiles(img) {
+		if ok, err := r.store.Exists(key); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, ErrImageFilesMissing
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path, content_hash, width, height, position, caption, alt_text, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		img.ID, img.PostID, img.UserID, img.FilePath, img.ThumbnailPath, nullIfEmpty(img.ContentHash), img.Width, img.Height, img.Position, img.Caption, img.AltText, img.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range img.Variants {
+		if _, err := tx.Exec(`INSERT INTO image_variants (image_id, width, height, content_type, file_path) VALUES (?, ?, ?, ?, ?)`,
+			img.ID, v.Width, v.Height, v.ContentType, v.FilePath); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return &img, nil
 }
 
+// GetByID loads one image, or returns ErrImageNotFound
+func (r *ImageRepository) GetByID(imageID string) (*models.Image, error) {
+	return r.getOne(`image_id = ?`, imageID)
+}
+
+// FindByHash loads the newest image uploaded with the same content, or
+// returns ErrImageNotFound
+func (r *ImageRepository) FindByHash(contentHash string) (*models.Image, error) {
+	return r.getOne(`content_hash = ? ORDER BY created_at DESC LIMIT 1`, contentHash)
+}
+
+func (r *ImageRepository) getOne(where string, args ...interface{}) (*models.Image, error) {
+	var img models.Image
+	var contentHash sql.NullString
+	err := r.db.QueryRow(`SELECT image_id, post_id, user_id, file_path, thumbnail_path, content_hash, width, height, position, caption, alt_text, created_at FROM images WHERE `+where, args...).
+		Scan(&img.ID, &img.PostID, &img.UserID, &img.FilePath, &img.ThumbnailPath, &contentHash, &img.Width, &img.Height, &img.Position, &img.Caption, &img.AltText, &img.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.ContentHash = contentHash.String
+	images := []models.Image{img}
+	if err := r.loadVariants(images, `image_id = ?`, img.ID); err != nil {
+		return nil, err
+	}
+	return &images[0], nil
+}
+
+// GetByPostID lists a post's images in gallery order
 func (r *ImageRepository) GetByPostID(postID string) ([]models.Image, error) {
-	rows, err := r.db.Query(`SELECT image_id, post_id, user_id, file_path, thumbnail_path, created_at FROM images WHERE post_id = ?`, postID)
+	rows, err := r.db.Query(`SELECT image_id, post_id, user_id, file_path, thumbnail_path, width, height, position, caption, alt_text, created_at FROM images
+		WHERE post_id = ? ORDER BY position, created_at, image_id`, postID)
 	if err != nil {
 		return nil, err
 	}
@@ -38,24 +110,206 @@ func (r *ImageRepository) GetByPostID(postID string) ([]models.Image, error) {
 	var images []models.Image
 	for rows.Next() {
 		var img models.Image
-		if err := rows.Scan(&img.ID, &img.PostID, &img.UserID, &img.FilePath, &img.ThumbnailPath, &img.CreatedAt); err != nil {
+		if err := rows.Scan(&img.ID, &img.PostID, &img.UserID, &img.FilePath, &img.ThumbnailPath, &img.Width, &img.Height, &img.Position, &img.Caption, &img.AltText, &img.CreatedAt); err != nil {
 			return nil, err
 		}
 		images = append(images, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := r.loadVariants(images, `image_id IN (SELECT image_id FROM images WHERE post_id = ?)`, postID); err != nil {
+		return nil, err
+	}
 	return images, nil
 }
 
-// DeleteByPostID deletes all images for a post from DB and filesystem
+// loadVariants fills in the variants of images, smallest first, from the
+// variant rows matching where
+func (r *ImageRepository) loadVariants(images []models.Image, where string, args ...interface{}) error {
+	if len(images) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(images))
+	for i, img := range images {
+		index[img.ID] = i
+	}
+	rows, err := r.db.Query(`SELECT image_id, width, height, content_type, file_path FROM image_variants
+		WHERE `+where+` ORDER BY width, content_type`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var imageID string
+		var v models.ImageVariant
+		if err := rows.Scan(&imageID, &v.Width, &v.Height, &v.ContentType, &v.FilePath); err != nil {
+			return err
+		}
+		if i, ok := index[imageID]; ok {
+			images[i].Variants = append(images[i].Variants, v)
+		}
+	}
+	return rows.Err()
+}
+
+// UpdateText sets an image's caption and alt text
+func (r *ImageRepository) UpdateText(imageID, caption, altText string) error {
+	res, err := r.db.Exec(`UPDATE images SET caption = ?, alt_text = ? WHERE image_id = ?`, caption, altText, imageID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
+
+// Reorder puts a post's images in the order of imageIDs, which must list each
+// of them exactly once. Otherwise it returns ErrInvalidImageOrder.
+func (r *ImageRepository) Reorder(postID string, imageIDs []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM images WHERE post_id = ?`, postID).Scan(&count); err != nil {
+		return err
+	}
+	if count != len(imageIDs) {
+		return ErrInvalidImageOrder
+	}
+	seen := make(map[string]bool, len(imageIDs))
+	for i, id := range imageIDs {
+		if seen[id] {
+			return ErrInvalidImageOrder
+		}
+		seen[id] = true
+		res, err := tx.Exec(`UPDATE images SET position = ? WHERE image_id = ? AND post_id = ?`, i, id, postID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrInvalidImageOrder
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes one image from its gallery and closes the gap it leaves.
+// Files no other row points at are deleted with it.
+func (r *ImageRepository) Delete(imageID string) error {
+	img, err := r.GetByID(imageID)
+	if err != nil {
+		return err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM images WHERE image_id = ?`, imageID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrImageNotFound
+	}
+	if _, err := tx.Exec(`UPDATE images SET position = position - 1 WHERE post_id = ? AND position > ?`, img.PostID, img.Position); err != nil {
+		return err
+	}
+	if err := r.releaseFiles(tx, imageFiles(*img)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteByPostID deletes all images of a post, and the files no other row
+// points at
 func (r *ImageRepository) DeleteByPostID(postID string) error {
 	images, err := r.GetByPostID(postID)
 	if err != nil {
 		return err
 	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM images WHERE post_id = ?`, postID); err != nil {
+		return err
+	}
+	var keys []string
 	for _, img := range images {
-		_ = os.Remove(img.FilePath)
-		_ = os.Remove(img.ThumbnailPath)
+		keys = append(keys, imageFiles(img)...)
+	}
+	if err := r.releaseFiles(tx, keys); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// releaseFiles deletes the files among keys that no row points at any more.
+// It runs inside the transaction that dropped the references, so an upload
+// sharing one of the files either committed first and keeps it, or finds it
+// gone in Create and stores it again.
+func (r *ImageRepository) releaseFiles(tx *sql.Tx, keys []string) error {
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		refs, err := fileRefs(tx, key)
+		if err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := r.store.Delete(key); err != nil {
+			// The file stays behind as an orphan for the collector
+			log.Printf("Images [ERROR]: delete %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// fileRefs counts the image and variant rows that point at a stored file
+func fileRefs(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, key string) (int, error) {
+	var n int
+	err := q.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM images WHERE file_path = ?) +
+		(SELECT COUNT(*) FROM images WHERE thumbnail_path = ?) +
+		(SELECT COUNT(*) FROM image_variants WHERE file_path = ?)`, key, key, key).Scan(&n)
+	return n, err
+}
+
+// imageFiles lists the stored files an image points at
+func imageFiles(img models.Image) []string {
+	keys := []string{img.FilePath, img.ThumbnailPath}
+	for _, v := range img.Variants {
+		keys = append(keys, v.FilePath)
+	}
+	return keys
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
 	}
-	_, err = r.db.Exec(`DELETE FROM images WHERE post_id = ?`, postID)
-	return err
+	return s
 }
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// MentionRepository stores the @mentions found in posts and comments
+type MentionRepository struct {
+	db *sql.DB
+}
+
+func NewMentionRepository(db *sql.DB) *MentionRepository {
+	return &MentionRepository{db: db}
+}
+
+// ReplaceForPost makes mentions the full set for a post and returns the IDs of
+// users who were not mentioned in it before
+func (r *MentionRepository) ReplaceForPost(postID string, mentions []models.Mention) ([]string, error) {
+	return r.replace("post_id", postID, mentions)
+}
+
+// ReplaceForComment makes mentions the full set for a comment and returns the
+// IDs of users who were not mentioned in it before
+func (r *MentionRepository) ReplaceForComment(commentID string, mentions []models.Mention) ([]string, error) {
+	return r.replace("comment_id", commentID, mentions)
+}
+
+func (r *MentionRepository) GetByPost(postID string) ([]models.Mention, error) {
+	return r.get("post_id", postID)
+}
+
+func (r *MentionRepository) GetByComment(commentID string) ([]models.Mention, error) {
+	return r.get("comment_id", commentID)
+}
+
+// GetByPosts returns the mentions of several posts in one query, keyed by
+// post ID. Posts without mentions are absent from the map.
+func (r *MentionRepository) GetByPosts(postIDs []string) (map[string][]models.Mention, error) {
+	out := make(map[string][]models.Mention)
+	if len(postIDs) == 0 {
+		return out, nil
+	}
+	args := make([]interface{}, len(postIDs))
+	for i, id := range postIDs {
+		args[i] = id
+	}
+	rows, err := r.db.Query(`
+		SELECT m.post_id, m.user_id, u.username, m.start_offset, m.end_offset
+		FROM mentions m
+		JOIN user u ON m.user_id = u.user_id
+		WHERE m.post_id IN (?`+strings.Repeat(", ?", len(postIDs)-1)+`)
+		ORDER BY m.post_id, m.start_offset`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID string
+		var m models.Mention
+		if err := rows.Scan(&postID, &m.UserID, &m.Username, &m.Start, &m.End); err != nil {
+			return nil, err
+		}
+		out[postID] = append(out[postID], m)
+	}
+	return out, rows.Err()
+}
+
+// replace swaps the mention rows of one target; column is post_id or comment_id
+func (r *MentionRepository) replace(column, targetID string, mentions []models.Mention) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT DISTINCT user_id FROM mentions WHERE `+column+` = ?`, targetID)
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		before[userID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mentions WHERE `+column+` = ?`, targetID); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var added []string
+	for _, m := range mentions {
+		if _, err := tx.Exec(`INSERT INTO mentions (user_id, `+column+`, start_offset, end_offset, created_at) VALUES (?, ?, ?, ?, ?)`,
+			m.UserID, targetID, m.Start, m.End, now); err != nil {
+			return nil, err
+		}
+		if !before[m.UserID] {
+			before[m.UserID] = true
+			added = append(added, m.UserID)
+		}
+	}
+	return added, tx.Commit()
+}
+
+func (r *MentionRepository) get(column, targetID string) ([]models.Mention, error) {
+	rows, err := r.db.Query(`
+		SELECT m.user_id, u.username, m.start_offset, m.end_offset
+		FROM mentions m
+		JOIN user u ON m.user_id = u.user_id
+		WHERE m.`+column+` = ?
+		ORDER BY m.start_offset`, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []models.Mention{}
+	for rows.Next() {
+		var m models.Mention
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Start, &m.End); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
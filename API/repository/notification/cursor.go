@@ -0,0 +1,32 @@
+package notification
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"forum/repository"
+)
+
+// encodeCursor builds the opaque page cursor for the row at (createdAt, id).
+// The timestamp keeps its zone so it compares equal to the stored value.
+func encodeCursor(createdAt time.Time, id string) string {
+	raw := createdAt.Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", repository.ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", repository.ErrInvalidCursor
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", repository.ErrInvalidCursor
+	}
+	return at, parts[1], nil
+}
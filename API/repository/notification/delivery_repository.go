@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// DeliveryRepository logs notifications sent outside the app and finds the
+// ones still waiting to go out on a channel
+type DeliveryRepository struct {
+	db      *sql.DB
+	locales LocaleLookup
+}
+
+func NewDeliveryRepository(db *sql.DB) *DeliveryRepository {
+	return &DeliveryRepository{db: db}
+}
+
+// SetLocales registers where recipients' locales are read from when pending
+// notifications are rendered
+func (r *DeliveryRepository) SetLocales(l LocaleLookup) { r.locales = l }
+
+// Record stores one delivery outcome
+func (r *DeliveryRepository) Record(d models.NotificationDelivery) error {
+	if d.ID == "" {
+		d.ID = utils.GenerateUUID()
+	}
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+	_, err := r.db.Exec(`INSERT INTO notification_deliveries
+		(delivery_id, notification_id, user_id, channel, digest_id, status, attempts, last_error, created_at, delivered_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.NotificationID, d.UserID, d.Channel, d.DigestID, d.Status, d.Attempts, d.LastError, d.CreatedAt, d.DeliveredAt)
+	return err
+}
+
+// LastDigestAt returns when the user's last digest on the channel was sent
+func (r *DeliveryRepository) LastDigestAt(userID, channel string) (time.Time, bool, error) {
+	var last time.Time
+	err := r.db.QueryRow(`SELECT delivered_at FROM notification_deliveries
+		WHERE user_id = ? AND channel = ? AND digest_id IS NOT NULL AND status = ?
+		ORDER BY delivered_at DESC LIMIT 1`,
+		userID, channel, models.DeliverySent).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	return last, err == nil, err
+}
+
+// pendingWhere selects visible unread notifications never sent on the channel
+const pendingWhere = `n.read_at IS NULL AND n.deleted_at IS NULL AND n.snoozed_until IS NULL
+	AND NOT EXISTS (SELECT 1 FROM notification_deliveries d
+		WHERE d.notification_id = n.notification_id AND d.channel = ? AND d.status = 'sent')`
+
+// UsersWithPending lists users who have unread notifications not yet sent on the channel
+func (r *DeliveryRepository) UsersWithPending(channel string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT DISTINCT n.user_id FROM notifications n WHERE `+pendingWhere, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+
+// Pending returns the user's unread notifications not yet sent on the channel,
+// oldest first, with post titles filled in and messages rendered in the
+// user's locale
+func (r *DeliveryRepository) Pending(userID, channel string, limit int) ([]models.Notification, error) {
+	rows, err := r.db.Query(`SELECT n.notification_id, n.user_id, n.actor_id, n.post_id, n.comment_id, n.type, n.payload,
+		n.actor_count, p.title, n.created_at
+		FROM notifications n
+		LEFT JOIN posts p ON n.post_id = p.post_id
+		WHERE n.user_id = ? AND `+pendingWhere+`
+		ORDER BY n.created_at ASC
+		LIMIT ?`, userID, channel, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ns []models.Notification
+	for rows.Next() {
+		var n models.Notification
+		var payload string
+		if err := rows.Scan(&n.ID, &n.UserID, &n.ActorID, &n.PostID, &n.CommentID, &n.Type, &payload,
+			&n.ActorCount, &n.PostTitle, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		n.Payload = decodePayload(payload)
+		ns = append(ns, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadActors(r.db, ns); err != nil {
+		return nil, err
+	}
+	for i := range ns {
+		localize(r.db, r.locales, &ns[i])
+	}
+	return ns, nil
+}
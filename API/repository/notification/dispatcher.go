@@ -0,0 +1,246 @@
+package notification
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"forum/models"
+)
+
+// Dispatcher defaults
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 256
+	// dedupWindow suppresses repeats of the same notification, such as a
+	// reaction toggled off and on again
+	dedupWindow = time.Minute
+	// groupWindow is how long a group keeps absorbing new actors after its
+	// first one; later activity starts a fresh group
+	groupWindow = 24 * time.Hour
+)
+
+// PostOwnerLookup finds who wrote a post
+type PostOwnerLookup interface {
+	GetPostOwner(postID string) (string, error)
+}
+
+// CommentOwnerLookup finds who wrote a comment
+type CommentOwnerLookup interface {
+	GetCommentOwner(commentID string) (string, error)
+}
+
+// ReactionLookup reads a user's current reaction on a post or comment; 0
+// means none
+type ReactionLookup interface {
+	GetReaction(userID, targetType, targetID string) (int, error)
+}
+
+// UserLookup loads a user by ID
+type UserLookup interface {
+	GetByID(id string) (*models.User, error)
+}
+
+// Sender delivers a rendered notification over an external channel (see
+// models.NotificationChannels). In-app delivery is handled by the Dispatcher
+// itself because it groups notifications in the inbox.
+type Sender interface {
+	Channel() string
+	Send(n models.Notification) error
+}
+
+// Hook observes every event once the dispatcher has handled it, whether or
+// not anyone was notified. Observe runs on a dispatcher worker and must not
+// block; webhooks queue the event for their own workers.
+type Hook interface {
+	Observe(e Event)
+}
+
+// Dispatcher turns domain events into notifications. Events are queued and
+// handled by a fixed pool of workers, so requests never wait on delivery.
+type Dispatcher struct {
+	posts     PostOwnerLookup
+	comments  CommentOwnerLookup
+	reactions ReactionLookup
+	users     UserLookup
+	prefs     *PreferenceRepository
+	subs      *SubscriptionRepository
+	store     *Repository
+	senders   []Sender
+	hooks     []Hook
+
+	queue chan Event
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// NewDispatcher starts a dispatcher with the given number of workers and
+// queue capacity
+func NewDispatcher(posts PostOwnerLookup, comments CommentOwnerLookup, reactions ReactionLookup, users UserLookup, prefs *PreferenceRepository, subs *SubscriptionRepository, store *Repository, workers, queueSize int) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	d := &Dispatcher{
+		posts:     posts,
+		comments:  comments,
+		reactions: reactions,
+		users:     users,
+		prefs:     prefs,
+		subs:      subs,
+		store:     store,
+		queue:     make(chan Event, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	return d
+}
+
+// AddSender registers another delivery channel. Call it before dispatching.
+func (d *Dispatcher) AddSender(s Sender) {
+	d.senders = append(d.senders, s)
+}
+
+// AddHook registers an observer of every event. Call it before dispatching.
+func (d *Dispatcher) AddHook(h Hook) {
+	d.hooks = append(d.hooks, h)
+}
+
+// Dispatch queues an event without blocking. If the queue is full the event
+// is dropped and logged rather than slowing down the request.
+func (d *Dispatcher) Dispatch(e Event) {
+	select {
+	case d.queue <- e:
+	default:
+		log.Printf("Dispatcher [WARN]: queue full, dropping %T from %s", e, e.actor())
+	}
+}
+
+// Close stops accepting events and waits for queued ones to be handled
+func (d *Dispatcher) Close() {
+	d.once.Do(func() { close(d.queue) })
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for e := range d.queue {
+		if err := d.handle(e); err != nil {
+			log.Printf("Dispatcher [ERROR]: %T from %s: %v", e, e.actor(), err)
+		}
+		for _, h := range d.hooks {
+			h.Observe(e)
+		}
+	}
+}
+
+// retractor is an event that takes back notifications instead of sending any
+type retractor interface {
+	retract(d *Dispatcher) error
+}
+
+func (d *Dispatcher) handle(e Event) error {
+	if r, ok := e.(retractor); ok {
+		return r.retract(d)
+	}
+	deliveries, err := e.deliveries(d)
+	if err != nil {
+		return fmt.Errorf("resolve recipients: %w", err)
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	actor, err := d.users.GetByID(e.actor())
+	if err != nil {
+		return fmt.Errorf("load actor: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, del := range deliveries {
+		if del.recipientID == actor.ID || seen[del.recipientID] {
+			continue
+		}
+		seen[del.recipientID] = true
+		if d.muted(del) {
+			continue
+		}
+		kind, ok := models.LookupNotificationKind(del.kind)
+		if !ok {
+			log.Printf("Dispatcher [ERROR]: unregistered notification type %q", del.kind)
+			continue
+		}
+		if err := kind.Validate(del.payload); err != nil {
+			log.Printf("Dispatcher [ERROR]: %v", err)
+			continue
+		}
+
+		n := models.Notification{
+			UserID:    del.recipientID,
+			ActorID:   actor.ID,
+			PostID:    del.postID,
+			CommentID: del.commentID,
+			Type:      del.kind,
+			Payload:   del.payload,
+			GroupKey:  del.groupKey,
+		}
+		if del.groupKey == "" {
+			dup, err := d.store.HasRecent(n, time.Now().Add(-dedupWindow))
+			if err != nil {
+				log.Printf("Dispatcher [WARN]: dedup check failed for %s: %v", n.UserID, err)
+			} else if dup {
+				continue
+			}
+		}
+
+		if d.prefs.Allows(n.UserID, n.Type, models.ChannelInApp) {
+			stored, changed, err := d.store.CreateOrGroup(n, time.Now().Add(-groupWindow))
+			if err != nil {
+				log.Printf("Dispatcher [ERROR]: %s delivery to %s failed: %v", models.ChannelInApp, n.UserID, err)
+			} else if !changed {
+				// The actor is already part of this group, e.g. a reaction
+				// toggled off and on again
+				continue
+			} else {
+				// Other channels get the inbox row, so deliveries can refer to it
+				n = *stored
+			}
+		}
+
+		if len(d.senders) > 0 {
+			if n.ID == "" {
+				// Not kept in the inbox, so the actor is only known here
+				n.Actors = []models.Actor{{ID: actor.ID, Username: actor.Username}}
+				n.ActorCount = 1
+			}
+			d.store.Localize(&n)
+		}
+		for _, s := range d.senders {
+			if !d.prefs.Allows(n.UserID, n.Type, s.Channel()) {
+				continue
+			}
+			if err := s.Send(n); err != nil {
+				log.Printf("Dispatcher [ERROR]: %s delivery to %s failed: %v", s.Channel(), n.UserID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// muted reports whether the recipient muted the post the delivery is about.
+// Mentions are addressed to the user personally and always get through.
+func (d *Dispatcher) muted(del delivery) bool {
+	if del.postID == nil || del.kind == models.NotificationMention {
+		return false
+	}
+	muted, err := d.subs.IsMuted(del.recipientID, *del.postID)
+	if err != nil {
+		log.Printf("Dispatcher [WARN]: mute check failed for %s: %v", del.recipientID, err)
+		return false
+	}
+	return muted
+}
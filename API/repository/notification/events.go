@@ -0,0 +1,298 @@
+package notification
+
+import "forum/models"
+
+// Event is a domain event that may notify one or more users. Handlers build
+// an event and hand it to Dispatcher.Dispatch; they never create rows directly.
+type Event interface {
+	// actor is the user who caused the event; they are never notified of it
+	actor() string
+	// deliveries lists who should hear about the event, before preferences,
+	// deduplication and the actor filter are applied
+	deliveries(d *Dispatcher) ([]delivery, error)
+}
+
+// delivery is one notification waiting to be stored and sent
+type delivery struct {
+	recipientID string
+	kind        string
+	postID      *string
+	commentID   *string
+	payload     models.NotificationPayload // fields for the kind's message template
+	// groupKey folds deliveries with the same key into one unread
+	// notification per recipient; empty means never grouped
+	groupKey string
+}
+
+// CommentCreated is raised after a comment is stored. The authors of the
+// comments it replies to or quotes hear about that; everyone else subscribed
+// to the post hears about a new comment.
+type CommentCreated struct {
+	ActorID   string
+	PostID    string
+	CommentID string
+	ReplyToID string   // comment being answered, if any
+	QuotedIDs []string // comments quoted in the content
+}
+
+// CommentEdited is raised after a comment's content changes
+type CommentEdited struct {
+	ActorID   string
+	PostID    string
+	CommentID string
+}
+
+// CommentDeleted is raised after a comment is soft-deleted
+type CommentDeleted struct {
+	ActorID   string
+	PostID    string
+	CommentID string
+}
+
+// PostReacted is raised when a user's reaction on a post is set or changed.
+// Removing a reaction does not raise it.
+type PostReacted struct {
+	ActorID      string
+	PostID       string
+	ReactionType int
+}
+
+// CommentReacted is raised when a user's reaction on a comment is set or
+// changed. The comment's author hears about it.
+type CommentReacted struct {
+	ActorID      string
+	PostID       string
+	CommentID    string
+	ReactionType int
+}
+
+// ReactionRemoved is raised when a user takes a reaction back or replaces it
+// with another. CommentID is empty for a reaction on the post itself. The
+// actor is withdrawn from the author's notification while it is unread.
+type ReactionRemoved struct {
+	ActorID      string
+	PostID       string
+	CommentID    string
+	ReactionType int
+}
+
+// PostCreated is raised after a post is stored. Subscribers are only
+// notified through mentions; hooks observe the post itself.
+type PostCreated struct {
+	ActorID string
+	PostID  string
+}
+
+// UserRegistered is raised after a new account is created, by password or
+// OAuth sign-up
+type UserRegistered struct {
+	UserID string
+}
+
+// Mentioned is raised for users newly @mentioned in a post or comment.
+// CommentID is empty for a mention in the post itself.
+type Mentioned struct {
+	ActorID   string
+	PostID    string
+	CommentID string
+	UserIDs   []string
+}
+
+func (e CommentCreated) actor() string  { return e.ActorID }
+func (e CommentEdited) actor() string   { return e.ActorID }
+func (e CommentDeleted) actor() string  { return e.ActorID }
+func (e PostReacted) actor() string     { return e.ActorID }
+func (e CommentReacted) actor() string  { return e.ActorID }
+func (e ReactionRemoved) actor() string { return e.ActorID }
+func (e PostCreated) actor() string     { return e.ActorID }
+func (e UserRegistered) actor() string  { return e.UserID }
+func (e Mentioned) actor() string       { return e.ActorID }
+
+func (e CommentCreated) deliveries(d *Dispatcher) ([]delivery, error) {
+	ownerID, err := d.posts.GetPostOwner(e.PostID)
+	if err != nil {
+		return nil, err
+	}
+	subscribers, err := d.subs.Subscribers(e.PostID)
+	if err != nil {
+		return nil, err
+	}
+	postID, commentID := e.PostID, e.CommentID
+	out := make([]delivery, 0, len(subscribers)+len(e.QuotedIDs)+1)
+	// Replies and quotes come first, so their authors get the more specific
+	// notification rather than "commented on a post you follow"
+	if e.ReplyToID != "" {
+		del, err := d.toCommentOwner(e.PostID, e.CommentID, e.ReplyToID, models.NotificationReply)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, del)
+	}
+	for _, quotedID := range e.QuotedIDs {
+		del, err := d.toCommentOwner(e.PostID, e.CommentID, quotedID, models.NotificationQuote)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, del)
+	}
+	for _, userID := range subscribers {
+		where := "followed"
+		if userID == ownerID {
+			where = "own"
+		}
+		out = append(out, delivery{
+			recipientID: userID,
+			kind:        models.NotificationComment,
+			postID:      &postID,
+			commentID:   &commentID,
+			payload:     models.NotificationPayload{models.PayloadWhere: where},
+			groupKey:    models.NotificationComment + ":post:" + e.PostID,
+		})
+	}
+	return out, nil
+}
+
+func (e CommentEdited) deliveries(d *Dispatcher) ([]delivery, error) {
+	return d.toPostOwner(e.PostID, e.CommentID, models.NotificationCommentEdit)
+}
+
+func (e CommentDeleted) deliveries(d *Dispatcher) ([]delivery, error) {
+	return d.toPostOwner(e.PostID, e.CommentID, models.NotificationCommentDelete)
+}
+
+func (e PostReacted) deliveries(d *Dispatcher) ([]delivery, error) {
+	return d.reactionDeliveries(e.ActorID, e.PostID, "", e.ReactionType)
+}
+
+func (e CommentReacted) deliveries(d *Dispatcher) ([]delivery, error) {
+	return d.reactionDeliveries(e.ActorID, e.PostID, e.CommentID, e.ReactionType)
+}
+
+// ReactionRemoved notifies no one; see retract
+func (e ReactionRemoved) deliveries(d *Dispatcher) ([]delivery, error) { return nil, nil }
+
+// retract takes the actor out of the author's unread reaction notification,
+// unless the reaction was set again in the meantime
+func (e ReactionRemoved) retract(d *Dispatcher) error {
+	if current, err := d.reactions.GetReaction(e.ActorID, reactionTarget(e.CommentID), reactionTargetID(e.PostID, e.CommentID)); err != nil {
+		return err
+	} else if current == e.ReactionType {
+		return nil
+	}
+	del, err := d.reactionDelivery(e.PostID, e.CommentID, e.ReactionType)
+	if err != nil {
+		return err
+	}
+	return d.store.Withdraw(del.recipientID, del.groupKey, e.ActorID)
+}
+
+func (e PostCreated) deliveries(d *Dispatcher) ([]delivery, error)    { return nil, nil }
+func (e UserRegistered) deliveries(d *Dispatcher) ([]delivery, error) { return nil, nil }
+
+func (e Mentioned) deliveries(d *Dispatcher) ([]delivery, error) {
+	var commentID *string
+	if e.CommentID != "" {
+		commentID = &e.CommentID
+	}
+	postID := e.PostID
+	out := make([]delivery, 0, len(e.UserIDs))
+	for _, userID := range e.UserIDs {
+		out = append(out, delivery{
+			recipientID: userID,
+			kind:        models.NotificationMention,
+			postID:      &postID,
+			commentID:   commentID,
+			payload:     models.NotificationPayload{models.PayloadTarget: reactionTarget(e.CommentID)},
+		})
+	}
+	return out, nil
+}
+
+// reactionDeliveries addresses a reaction to the author of the post, or of
+// the comment when commentID is set. Reactions changed again before the event
+// was handled notify no one, so a quick like and unlike leaves nothing behind.
+func (d *Dispatcher) reactionDeliveries(actorID, postID, commentID string, reactionType int) ([]delivery, error) {
+	current, err := d.reactions.GetReaction(actorID, reactionTarget(commentID), reactionTargetID(postID, commentID))
+	if err != nil {
+		return nil, err
+	}
+	if current != reactionType {
+		return nil, nil
+	}
+	del, err := d.reactionDelivery(postID, commentID, reactionType)
+	if err != nil {
+		return nil, err
+	}
+	return []delivery{del}, nil
+}
+
+// reactionDelivery builds the grouped reaction notification for the author
+// of the post or comment
+func (d *Dispatcher) reactionDelivery(postID, commentID string, reactionType int) (delivery, error) {
+	verb, grouped := "like", "liked"
+	if reactionType == models.ReactionDislike {
+		verb, grouped = "dislike", "disliked"
+	}
+	if commentID == "" {
+		ownerID, err := d.posts.GetPostOwner(postID)
+		if err != nil {
+			return delivery{}, err
+		}
+		return delivery{
+			recipientID: ownerID,
+			kind:        models.NotificationReaction,
+			postID:      &postID,
+			payload:     models.NotificationPayload{models.PayloadVerb: verb, models.PayloadTarget: "post"},
+			groupKey:    models.NotificationReaction + ":" + grouped + ":post:" + postID,
+		}, nil
+	}
+	ownerID, err := d.comments.GetCommentOwner(commentID)
+	if err != nil {
+		return delivery{}, err
+	}
+	return delivery{
+		recipientID: ownerID,
+		kind:        models.NotificationReaction,
+		postID:      &postID,
+		commentID:   &commentID,
+		payload:     models.NotificationPayload{models.PayloadVerb: verb, models.PayloadTarget: "comment"},
+		groupKey:    models.NotificationReaction + ":" + grouped + ":comment:" + commentID,
+	}, nil
+}
+
+func reactionTarget(commentID string) string {
+	if commentID == "" {
+		return "post"
+	}
+	return "comment"
+}
+
+func reactionTargetID(postID, commentID string) string {
+	if commentID == "" {
+		return postID
+	}
+	return commentID
+}
+
+// toCommentOwner addresses a reply or quote to the author of the comment it
+// refers to. The notification points at the new comment.
+func (d *Dispatcher) toCommentOwner(postID, commentID, targetID, kind string) (delivery, error) {
+	ownerID, err := d.comments.GetCommentOwner(targetID)
+	if err != nil {
+		return delivery{}, err
+	}
+	return delivery{recipientID: ownerID, kind: kind, postID: &postID, commentID: &commentID}, nil
+}
+
+// toPostOwner addresses a delivery to the author of the post
+func (d *Dispatcher) toPostOwner(postID, commentID, kind string) ([]delivery, error) {
+	ownerID, err := d.posts.GetPostOwner(postID)
+	if err != nil {
+		return nil, err
+	}
+	del := delivery{recipientID: ownerID, kind: kind, postID: &postID}
+	if commentID != "" {
+		del.commentID = &commentID
+	}
+	return []delivery{del}, nil
+}
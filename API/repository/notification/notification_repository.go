@@ -2,58 +2,419 @@ package notification
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
 	"time"
 
+	"forum/locale"
 	"forum/models"
 	"forum/utils"
 )
 
-type Repository struct{ db *sql.DB }
+// Page size bounds for GetByUser
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	// MaxListedActors caps the actors returned with each grouped notification
+	MaxListedActors = 5
+	// MaxBulkIDs caps the notifications one bulk action may name
+	MaxBulkIDs = 100
+)
+
+// ErrNotificationsNotFound is returned when a notification, or any of a bulk
+// action's notifications, is missing, deleted or owned by someone else
+var ErrNotificationsNotFound = errors.New("notifications not found")
+
+// visibleWhere selects the rows shown in the inbox: not deleted and not snoozed
+const visibleWhere = `deleted_at IS NULL AND snoozed_until IS NULL`
+
+// Publisher is told about notification changes so live streams can be updated.
+// NotificationCreated also fires when a group absorbs or loses an actor.
+type Publisher interface {
+	NotificationCreated(n models.Notification)
+	NotificationRemoved(userID, notificationID string)
+	UnreadCountChanged(userID string, count int)
+}
+
+// LocaleLookup reads a user's chosen locale; "" means none was chosen
+type LocaleLookup interface {
+	GetLocale(userID string) (string, error)
+}
+
+type Repository struct {
+	db        *sql.DB
+	publisher Publisher
+	locales   LocaleLookup
+}
 
 func NewRepository(db *sql.DB) *Repository { return &Repository{db: db} }
 
+// SetPublisher registers the publisher that receives changes after they are stored
+func (r *Repository) SetPublisher(p Publisher) { r.publisher = p }
+
+// SetLocales registers where recipients' locales are read from when a
+// notification is rendered for them outside a request. Without it messages
+// are rendered in locale.Default.
+func (r *Repository) SetLocales(l LocaleLookup) { r.locales = l }
+
+// Localize renders n's message in its recipient's locale, loading its actors
+// first if needed
+func (r *Repository) Localize(n *models.Notification) {
+	localize(r.db, r.locales, n)
+}
+
+// localize renders n for its recipient. A failed lookup falls back to the
+// default locale rather than leaving the message empty.
+func localize(db *sql.DB, locales LocaleLookup, n *models.Notification) {
+	if len(n.Actors) == 0 {
+		ns := []models.Notification{*n}
+		if err := loadActors(db, ns); err != nil {
+			log.Printf("Notifications [WARN]: loading actors of %s: %v", n.ID, err)
+		}
+		*n = ns[0]
+	}
+	lang := locale.Default
+	if locales != nil {
+		if l, err := locales.GetLocale(n.UserID); err != nil {
+			log.Printf("Notifications [WARN]: locale of %s: %v", n.UserID, err)
+		} else if locale.IsSupported(l) {
+			lang = l
+		}
+	}
+	locale.Render(lang, n)
+}
+
+// encodePayload stores a payload as JSON. Keys are sorted, so equal payloads
+// encode to equal strings.
+func encodePayload(p models.NotificationPayload) string {
+	if len(p) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// decodePayload reads a stored payload; a malformed one reads as empty
+func decodePayload(raw string) models.NotificationPayload {
+	var p models.NotificationPayload
+	if err := json.Unmarshal([]byte(raw), &p); err != nil || len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+// publishUnread sends the user's fresh unread count to the publisher, if any
+func (r *Repository) publishUnread(userID string) {
+	if r.publisher == nil {
+		return
+	}
+	count, err := r.CountUnread(userID)
+	if err != nil {
+		return
+	}
+	r.publisher.UnreadCountChanged(userID, count)
+}
+
 func (r *Repository) Create(n models.Notification) (*models.Notification, error) {
-	n.ID = utils.GenerateUUID()
-	n.CreatedAt = time.Now()
-	_, err := r.db.Exec(`INSERT INTO notifications (notification_id, user_id, actor_id, post_id, comment_id, type, message, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		n.ID, n.UserID, n.ActorID, n.PostID, n.CommentID, n.Type, n.Message, n.CreatedAt)
+	tx, err := r.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+	if err := insertNotification(tx, &n); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	r.published(n)
 	return &n, nil
 }
 
-// GetByID fetches a single notification by its ID
+// insertNotification stores n as a new group of one
+func insertNotification(tx *sql.Tx, n *models.Notification) error {
+	n.ID = utils.GenerateUUID()
+	n.CreatedAt = time.Now()
+	n.ActorCount = 1
+	var groupKey *string
+	if n.GroupKey != "" {
+		groupKey = &n.GroupKey
+	}
+	_, err := tx.Exec(`INSERT INTO notifications (notification_id, user_id, actor_id, post_id, comment_id, type, payload, group_key, actor_count, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?)`,
+		n.ID, n.UserID, n.ActorID, n.PostID, n.CommentID, n.Type, encodePayload(n.Payload), groupKey, n.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if n.ActorID != "" {
+		_, err = tx.Exec(`INSERT INTO notification_actors (notification_id, actor_id, created_at) VALUES (?, ?, ?)`, n.ID, n.ActorID, n.CreatedAt)
+	}
+	return err
+}
+
+// published pushes a stored or regrouped notification to the publisher, if
+// any, rendered for its recipient
+func (r *Repository) published(n models.Notification) {
+	if r.publisher != nil {
+		r.Localize(&n)
+		r.publisher.NotificationCreated(n)
+		r.publishUnread(n.UserID)
+	}
+}
+
+// CreateOrGroup stores n, folding it into the recipient's unread notification
+// with the same GroupKey when that group was started after since. The group
+// takes n's actor, target and payload as its latest.
+// It returns false when nothing changed because the actor is already part of
+// the group. Notifications without a GroupKey are always created.
+func (r *Repository) CreateOrGroup(n models.Notification, since time.Time) (*models.Notification, bool, error) {
+	if n.GroupKey == "" {
+		stored, err := r.Create(n)
+		return stored, err == nil, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var groupID string
+	err = tx.QueryRow(`SELECT notification_id FROM notifications
+		WHERE user_id = ? AND group_key = ? AND read_at IS NULL AND `+visibleWhere+` AND created_at >= ?
+		ORDER BY created_at DESC LIMIT 1`, n.UserID, n.GroupKey, since).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		if err := insertNotification(tx, &n); err != nil {
+			return nil, false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, false, err
+		}
+		r.published(n)
+		return &n, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	res, err := tx.Exec(`INSERT OR IGNORE INTO notification_actors (notification_id, actor_id, created_at) VALUES (?, ?, ?)`, groupID, n.ActorID, now)
+	if err != nil {
+		return nil, false, err
+	}
+	if added, _ := res.RowsAffected(); added == 0 {
+		return nil, false, nil
+	}
+
+	count, _, err := groupActors(tx, groupID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, err = tx.Exec(`UPDATE notifications SET actor_id = ?, post_id = ?, comment_id = ?, payload = ?, actor_count = ?, updated_at = ?
+		WHERE notification_id = ?`, n.ActorID, n.PostID, n.CommentID, encodePayload(n.Payload), count, now, groupID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	stored, err := r.GetByID(groupID)
+	if err != nil {
+		return nil, false, err
+	}
+	r.published(*stored)
+	return stored, true, nil
+}
+
+// groupActors returns how many actors a group has and the most recent one
+func groupActors(tx *sql.Tx, groupID string) (int, string, error) {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM notification_actors WHERE notification_id = ?`, groupID).Scan(&count); err != nil {
+		return 0, "", err
+	}
+	var latest string
+	err := tx.QueryRow(`SELECT actor_id FROM notification_actors WHERE notification_id = ?
+		ORDER BY created_at DESC LIMIT 1`, groupID).Scan(&latest)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return count, latest, err
+}
+
+// Withdraw takes an actor back out of the recipient's unread notification
+// with the given GroupKey, such as a reaction that was removed. A
+// notification left with no actors is deleted like one the user deleted, so
+// its delivery log is kept. Read notifications are history and stay as they are.
+func (r *Repository) Withdraw(userID, groupKey, actorID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var groupID string
+	err = tx.QueryRow(`SELECT n.notification_id FROM notifications n
+		JOIN notification_actors na ON na.notification_id = n.notification_id AND na.actor_id = ?
+		WHERE n.user_id = ? AND n.group_key = ? AND n.read_at IS NULL AND n.deleted_at IS NULL
+		ORDER BY n.created_at DESC LIMIT 1`, actorID, userID, groupKey).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM notification_actors WHERE notification_id = ? AND actor_id = ?`, groupID, actorID); err != nil {
+		return err
+	}
+
+	count, latest, err := groupActors(tx, groupID)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		now := time.Now()
+		if _, err := tx.Exec(`UPDATE notifications SET deleted_at = ?, updated_at = ? WHERE notification_id = ?`, now, now, groupID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if r.publisher != nil {
+			r.publisher.NotificationRemoved(userID, groupID)
+		}
+		r.publishUnread(userID)
+		return nil
+	}
+
+	_, err = tx.Exec(`UPDATE notifications SET actor_id = ?, actor_count = ?, updated_at = ? WHERE notification_id = ?`,
+		latest, count, time.Now(), groupID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	stored, err := r.GetByID(groupID)
+	if err != nil {
+		return err
+	}
+	r.published(*stored)
+	return nil
+}
+
+// HasRecent reports whether an identical notification (same recipient, actor,
+// type, target and payload) was stored after since
+func (r *Repository) HasRecent(n models.Notification, since time.Time) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM notifications
+		WHERE user_id = ? AND created_at >= ? AND actor_id IS ? AND type = ?
+		  AND post_id IS ? AND comment_id IS ? AND payload = ?)`,
+		n.UserID, since, n.ActorID, n.Type, n.PostID, n.CommentID, encodePayload(n.Payload)).Scan(&exists)
+	return exists, err
+}
+
+// GetByID fetches a single notification by its ID, deleted or not. Its
+// message is not rendered.
 func (r *Repository) GetByID(id string) (*models.Notification, error) {
+	n, _, err := r.get(id)
+	return n, err
+}
+
+// get fetches a notification and whether it was deleted
+func (r *Repository) get(id string) (*models.Notification, bool, error) {
 	var n models.Notification
-	err := r.db.QueryRow(`SELECT notification_id, user_id, actor_id, post_id, comment_id, type, message, created_at, read_at, updated_at FROM notifications WHERE notification_id = ?`, id).
-		Scan(&n.ID, &n.UserID, &n.ActorID, &n.PostID, &n.CommentID, &n.Type, &n.Message, &n.CreatedAt, &n.ReadAt, &n.UpdatedAt)
+	var payload string
+	var deletedAt *time.Time
+	err := r.db.QueryRow(`SELECT notification_id, user_id, actor_id, post_id, comment_id, type, payload, actor_count, created_at, read_at, updated_at, snoozed_until, deleted_at FROM notifications WHERE notification_id = ?`, id).
+		Scan(&n.ID, &n.UserID, &n.ActorID, &n.PostID, &n.CommentID, &n.Type, &payload, &n.ActorCount, &n.CreatedAt, &n.ReadAt, &n.UpdatedAt, &n.SnoozedUntil, &deletedAt)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return &n, nil
+	n.Payload = decodePayload(payload)
+	return &n, deletedAt != nil, nil
 }
 
-func (r *Repository) GetByUser(userID string) ([]models.Notification, error) {
-	rows, err := r.db.Query(`SELECT n.notification_id, n.user_id, n.actor_id, n.post_id, n.comment_id, n.type, n.message,
+// GetByUser returns one page of the user's notifications, newest first.
+// Pages are keyed on (created_at, notification_id) so they stay stable while
+// new notifications arrive, and the walk uses idx_notifications_user_created.
+// Actors are loaded but messages are left for the caller to render in the
+// reader's locale.
+func (r *Repository) GetByUser(userID string, f models.NotificationFilter) (*models.NotificationPage, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	where := []string{"n.user_id = ?", "n.deleted_at IS NULL"}
+	args := []interface{}{userID}
+	if f.Snoozed {
+		where = append(where, "n.snoozed_until IS NOT NULL")
+	} else {
+		where = append(where, "n.snoozed_until IS NULL")
+	}
+	if f.Cursor != "" {
+		at, id, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "(n.created_at < ? OR (n.created_at = ? AND n.notification_id < ?))")
+		args = append(args, at, at, id)
+	}
+	if f.UnreadOnly {
+		where = append(where, "n.read_at IS NULL")
+	}
+	if f.Type != "" {
+		where = append(where, "n.type = ?")
+		args = append(args, f.Type)
+	}
+	if f.PostID != "" {
+		where = append(where, "n.post_id = ?")
+		args = append(args, f.PostID)
+	}
+	// Stored timestamps use the server's zone; compare in the same one
+	if f.Since != nil {
+		where = append(where, "n.created_at >= ?")
+		args = append(args, f.Since.In(time.Local))
+	}
+	if f.Until != nil {
+		where = append(where, "n.created_at < ?")
+		args = append(args, f.Until.In(time.Local))
+	}
+	// Fetch one extra row to learn whether another page exists
+	args = append(args, limit+1)
+
+	rows, err := r.db.Query(`SELECT n.notification_id, n.user_id, n.actor_id, n.post_id, n.comment_id, n.type, n.payload, n.actor_count,
                 p.title, c.content,
-                n.created_at, n.read_at, n.updated_at
+                n.created_at, n.read_at, n.updated_at, n.snoozed_until
                 FROM notifications n
                 LEFT JOIN posts p ON n.post_id = p.post_id
                 LEFT JOIN comments c ON n.comment_id = c.comment_id
-                WHERE n.user_id = ?
-                ORDER BY n.created_at DESC`, userID)
+                WHERE `+strings.Join(where, " AND ")+`
+                ORDER BY n.created_at DESC, n.notification_id DESC
+                LIMIT ?`, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var ns []models.Notification
+	page := &models.NotificationPage{Notifications: []models.Notification{}}
 	for rows.Next() {
 		var n models.Notification
 		var title, content *string
-		if err := rows.Scan(&n.ID, &n.UserID, &n.ActorID, &n.PostID, &n.CommentID, &n.Type, &n.Message, &title, &content, &n.CreatedAt, &n.ReadAt, &n.UpdatedAt); err != nil {
+		var payload string
+		if err := rows.Scan(&n.ID, &n.UserID, &n.ActorID, &n.PostID, &n.CommentID, &n.Type, &payload, &n.ActorCount, &title, &content, &n.CreatedAt, &n.ReadAt, &n.UpdatedAt, &n.SnoozedUntil); err != nil {
 			return nil, err
 		}
+		n.Payload = decodePayload(payload)
 		n.PostTitle = title
 		if content != nil {
 			snippet := *content
@@ -62,27 +423,245 @@ func (r *Repository) GetByUser(userID string) ([]models.Notification, error) {
 			}
 			n.CommentSnippet = &snippet
 		}
-		ns = append(ns, n)
+		page.Notifications = append(page.Notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Notifications) > limit {
+		page.Notifications = page.Notifications[:limit]
+		page.HasMore = true
+		last := page.Notifications[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if err := loadActors(r.db, page.Notifications); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// loadActors fills in the most recent actors of each notification with one query
+func loadActors(db *sql.DB, ns []models.Notification) error {
+	if len(ns) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(ns))
+	args := make([]interface{}, len(ns))
+	for i, n := range ns {
+		index[n.ID] = i
+		args[i] = n.ID
+	}
+	rows, err := db.Query(`SELECT na.notification_id, u.user_id, u.username
+		FROM notification_actors na JOIN user u ON u.user_id = na.actor_id
+		WHERE na.notification_id IN (?`+strings.Repeat(", ?", len(ns)-1)+`)
+		ORDER BY na.created_at DESC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var a models.Actor
+		if err := rows.Scan(&id, &a.ID, &a.Username); err != nil {
+			return err
+		}
+		n := &ns[index[id]]
+		if len(n.Actors) < MaxListedActors {
+			n.Actors = append(n.Actors, a)
+		}
 	}
-	return ns, nil
+	return rows.Err()
+}
+
+// CountUnread returns how many visible notifications the user has not read
+// yet. The query is answered from idx_notifications_user_unread_visible alone.
+func (r *Repository) CountUnread(userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL AND `+visibleWhere, userID).Scan(&count)
+	return count, err
 }
 
+// MarkRead marks one notification read. A grouped notification is a single
+// row, so this reads every actor folded into it. Reading a snoozed
+// notification also ends its snooze.
 func (r *Repository) MarkRead(id, userID string) error {
-	_, err := r.db.Exec(`UPDATE notifications SET read_at = ? WHERE notification_id = ? AND user_id = ?`, time.Now(), id, userID)
+	_, err := r.db.Exec(`UPDATE notifications SET read_at = ?, snoozed_until = NULL WHERE notification_id = ? AND user_id = ?`, time.Now(), id, userID)
+	if err == nil {
+		r.publishUnread(userID)
+	}
 	return err
 }
 
+// MarkAllRead marks the user's inbox read. Snoozed notifications are left
+// alone so they still come back unread.
 func (r *Repository) MarkAllRead(userID string) error {
-	_, err := r.db.Exec(`UPDATE notifications SET read_at = ? WHERE user_id = ?`, time.Now(), userID)
+	_, err := r.db.Exec(`UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL AND snoozed_until IS NULL`, time.Now(), userID)
+	if err == nil {
+		r.publishUnread(userID)
+	}
 	return err
 }
 
 func (r *Repository) SoftDelete(id, userID string) error {
-	_, err := r.db.Exec(`UPDATE notifications SET message = NULL, updated_at = ? WHERE notification_id = ? AND user_id = ?`, time.Now(), id, userID)
+	now := time.Now()
+	_, err := r.db.Exec(`UPDATE notifications SET deleted_at = ?, updated_at = ? WHERE notification_id = ? AND user_id = ? AND deleted_at IS NULL`, now, now, id, userID)
+	if err == nil {
+		r.publishUnread(userID)
+	}
 	return err
 }
 
 func (r *Repository) SoftDeleteAll(userID string) error {
-	_, err := r.db.Exec(`UPDATE notifications SET message = NULL, updated_at = ? WHERE user_id = ?`, time.Now(), userID)
+	now := time.Now()
+	_, err := r.db.Exec(`UPDATE notifications SET deleted_at = ?, updated_at = ? WHERE user_id = ? AND deleted_at IS NULL`, now, now, userID)
+	if err == nil {
+		r.publishUnread(userID)
+	}
 	return err
 }
+
+// MarkReadMany marks the listed notifications read, ending any snoozes.
+// Like the other bulk actions it changes all of them or none.
+func (r *Repository) MarkReadMany(ids []string, userID string) error {
+	return r.updateMany(ids, userID, `read_at = ?, snoozed_until = NULL`, time.Now())
+}
+
+// MarkUnreadMany marks the listed notifications unread
+func (r *Repository) MarkUnreadMany(ids []string, userID string) error {
+	return r.updateMany(ids, userID, `read_at = NULL`)
+}
+
+// SoftDeleteMany deletes the listed notifications
+func (r *Repository) SoftDeleteMany(ids []string, userID string) error {
+	now := time.Now()
+	return r.updateMany(ids, userID, `deleted_at = ?, updated_at = ?`, now, now)
+}
+
+// updateMany applies set to the user's listed notifications in one
+// transaction. It rolls back with ErrNotificationsNotFound unless every ID
+// names one of the user's notifications that is not deleted.
+func (r *Repository) updateMany(ids []string, userID, set string, setArgs ...interface{}) error {
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := append(setArgs, userID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	res, err := tx.Exec(`UPDATE notifications SET `+set+`
+		WHERE user_id = ? AND deleted_at IS NULL AND notification_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if int(n) != len(ids) {
+		return ErrNotificationsNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.publishUnread(userID)
+	return nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Snooze hides a notification until the given time, when WakeSnoozed brings
+// it back unread at the top of the inbox
+func (r *Repository) Snooze(id, userID string, until time.Time) error {
+	res, err := r.db.Exec(`UPDATE notifications SET snoozed_until = ?, read_at = NULL
+		WHERE notification_id = ? AND user_id = ? AND deleted_at IS NULL`, until.In(time.Local), id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotificationsNotFound
+	}
+	r.publishUnread(userID)
+	return nil
+}
+
+// Unsnooze brings a snoozed notification back now
+func (r *Repository) Unsnooze(id, userID string) error {
+	n, deleted, err := r.get(id)
+	if err == sql.ErrNoRows || (err == nil && (n.UserID != userID || deleted || n.SnoozedUntil == nil)) {
+		return ErrNotificationsNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.wake([]string{id}, time.Now())
+	return err
+}
+
+// WakeSnoozed brings back every notification whose snooze ended by now and
+// returns how many it woke
+func (r *Repository) WakeSnoozed(now time.Time) (int, error) {
+	rows, err := r.db.Query(`SELECT notification_id FROM notifications WHERE snoozed_until IS NOT NULL AND snoozed_until <= ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return r.wake(ids, now)
+}
+
+// wake clears the snoozes, marks the notifications unread and moves them to
+// the top of their inboxes, then publishes them as new
+func (r *Repository) wake(ids []string, now time.Time) (int, error) {
+	woken := 0
+	users := map[string]bool{}
+	for _, id := range ids {
+		res, err := r.db.Exec(`UPDATE notifications SET snoozed_until = NULL, read_at = NULL, created_at = ?
+			WHERE notification_id = ? AND snoozed_until IS NOT NULL`, now, id)
+		if err != nil {
+			return woken, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		woken++
+		n, deleted, err := r.get(id)
+		if err != nil {
+			return woken, err
+		}
+		if deleted {
+			continue
+		}
+		r.published(*n)
+		users[n.UserID] = true
+	}
+	for userID := range users {
+		r.publishUnread(userID)
+	}
+	return woken, nil
+}
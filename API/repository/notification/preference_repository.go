@@ -0,0 +1,119 @@
+package notification
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"forum/models"
+)
+
+// PreferenceRepository stores per-user notification opt-outs
+type PreferenceRepository struct{ db *sql.DB }
+
+func NewPreferenceRepository(db *sql.DB) *PreferenceRepository {
+	return &PreferenceRepository{db: db}
+}
+
+// GetForUser returns the full type x channel matrix for the user, with
+// defaults (enabled) filled in for anything never set
+func (r *PreferenceRepository) GetForUser(userID string) ([]models.NotificationPreference, error) {
+	prefs := make([]models.NotificationPreference, 0, len(models.NotificationTypes))
+	index := make(map[string]int, len(models.NotificationTypes))
+	for i, t := range models.NotificationTypes {
+		channels := make(map[string]bool, len(models.NotificationChannels))
+		for _, c := range models.NotificationChannels {
+			channels[c] = true
+		}
+		prefs = append(prefs, models.NotificationPreference{Type: t, Channels: channels})
+		index[t] = i
+	}
+
+	rows, err := r.db.Query(`SELECT type, channel, enabled FROM notification_preferences WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t, channel string
+		var enabled bool
+		if err := rows.Scan(&t, &channel, &enabled); err != nil {
+			return nil, err
+		}
+		if i, ok := index[t]; ok {
+			prefs[i].Channels[channel] = enabled
+		}
+	}
+	return prefs, rows.Err()
+}
+
+// Set stores the given switches in one transaction. Types and channels not
+// mentioned keep their current value.
+func (r *PreferenceRepository) Set(userID string, prefs []models.NotificationPreference) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO notification_preferences (user_id, type, channel, enabled, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, type, channel) DO UPDATE SET
+			enabled = excluded.enabled,
+			updated_at = excluded.updated_at`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, p := range prefs {
+		for channel, enabled := range p.Channels {
+			if _, err := stmt.Exec(userID, p.Type, channel, enabled, now); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// IsEnabled reports whether the user wants notifications of this type on the channel
+func (r *PreferenceRepository) IsEnabled(userID, notificationType, channel string) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRow(`SELECT enabled FROM notification_preferences WHERE user_id = ? AND type = ? AND channel = ?`,
+		userID, notificationType, channel).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return enabled, err
+}
+
+// Allows is IsEnabled for call sites that cannot handle an error. Lookups that
+// fail fall back to delivering, so a database hiccup does not silence users.
+func (r *PreferenceRepository) Allows(userID, notificationType, channel string) bool {
+	enabled, err := r.IsEnabled(userID, notificationType, channel)
+	if err != nil {
+		log.Printf("PreferenceRepository [WARN]: preference lookup failed for user %s: %v", userID, err)
+		return true
+	}
+	return enabled
+}
+
+// EmailMode returns how the user wants email delivered, falling back to
+// models.DefaultEmailMode
+func (r *PreferenceRepository) EmailMode(userID string) (string, error) {
+	var mode string
+	err := r.db.QueryRow(`SELECT mode FROM notification_email_settings WHERE user_id = ?`, userID).Scan(&mode)
+	if err == sql.ErrNoRows {
+		return models.DefaultEmailMode, nil
+	}
+	return mode, err
+}
+
+// SetEmailMode stores one of models.EmailModes for the user
+func (r *PreferenceRepository) SetEmailMode(userID, mode string) error {
+	_, err := r.db.Exec(`INSERT INTO notification_email_settings (user_id, mode, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET mode = excluded.mode, updated_at = excluded.updated_at`,
+		userID, mode, time.Now())
+	return err
+}
@@ -0,0 +1,77 @@
+package notification
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// PushRepository stores the browsers registered for Web Push
+type PushRepository struct{ db *sql.DB }
+
+func NewPushRepository(db *sql.DB) *PushRepository {
+	return &PushRepository{db: db}
+}
+
+// Save registers a subscription for the session. A browser re-subscribing
+// with the same endpoint, possibly after logging in as someone else, takes
+// the row over.
+func (r *PushRepository) Save(s *models.PushSubscription) error {
+	s.ID = utils.GenerateUUID()
+	s.CreatedAt = time.Now()
+	_, err := r.db.Exec(`INSERT INTO push_subscriptions
+		(subscription_id, user_id, session_id, endpoint, p256dh, auth, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET user_id = excluded.user_id, session_id = excluded.session_id,
+			p256dh = excluded.p256dh, auth = excluded.auth, user_agent = excluded.user_agent`,
+		s.ID, s.UserID, s.SessionID, s.Endpoint, s.P256dh, s.Auth, s.UserAgent, s.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRow(`SELECT subscription_id, created_at FROM push_subscriptions WHERE endpoint = ?`, s.Endpoint).
+		Scan(&s.ID, &s.CreatedAt)
+}
+
+// Delete unregisters one of the user's subscriptions. It reports whether a
+// row was removed.
+func (r *PushRepository) Delete(userID, endpoint string) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM push_subscriptions WHERE user_id = ? AND endpoint = ?`, userID, endpoint)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Expire removes a subscription the push service no longer knows
+func (r *PushRepository) Expire(endpoint string) error {
+	_, err := r.db.Exec(`DELETE FROM push_subscriptions WHERE endpoint = ?`, endpoint)
+	return err
+}
+
+// ForUser lists the user's subscriptions whose session is still live.
+// Subscriptions left behind by ended sessions are removed.
+func (r *PushRepository) ForUser(userID string) ([]models.PushSubscription, error) {
+	if _, err := r.db.Exec(`DELETE FROM push_subscriptions WHERE user_id = ? AND session_id NOT IN
+		(SELECT session_id FROM sessions WHERE user_id = ? AND expires_at > ?)`, userID, userID, time.Now()); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.Query(`SELECT subscription_id, user_id, session_id, endpoint, p256dh, auth, COALESCE(user_agent, ''), created_at
+		FROM push_subscriptions WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.PushSubscription
+	for rows.Next() {
+		var s models.PushSubscription
+		if err := rows.Scan(&s.ID, &s.UserID, &s.SessionID, &s.Endpoint, &s.P256dh, &s.Auth, &s.UserAgent, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,158 @@
+package notification
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"forum/models"
+)
+
+// retentionStartDelay lets the server settle before the first scheduled run
+const retentionStartDelay = time.Minute
+
+// ErrRetentionRunning is returned when a run is asked for while one is
+// already in progress
+var ErrRetentionRunning = errors.New("a retention run is already in progress")
+
+// RetentionPolicy decides how long notifications are kept. A limit of zero
+// days turns its rule off.
+type RetentionPolicy struct {
+	ReadDays        int           // NOTIFICATION_RETAIN_READ_DAYS: delete read notifications older than this, default 90
+	DeletedDays     int           // NOTIFICATION_RETAIN_DELETED_DAYS: delete rows the user deleted this long ago, default 7
+	ArchiveDays     int           // NOTIFICATION_ARCHIVE_UNREAD_DAYS: archive unread notifications older than this, default 365
+	ArchiveKeepDays int           // NOTIFICATION_ARCHIVE_KEEP_DAYS: delete archived rows after this, default 0 (keep)
+	Interval        time.Duration // NOTIFICATION_RETENTION_INTERVAL between scheduled runs, default 24h; 0 disables the schedule
+	BatchSize       int           // rows per delete or archive statement, 500
+}
+
+// RetentionPolicyFromEnv builds a RetentionPolicy from the environment (see
+// utils.LoadEnv)
+func RetentionPolicyFromEnv() RetentionPolicy {
+	p := RetentionPolicy{
+		ReadDays:        envDays("NOTIFICATION_RETAIN_READ_DAYS", 90),
+		DeletedDays:     envDays("NOTIFICATION_RETAIN_DELETED_DAYS", 7),
+		ArchiveDays:     envDays("NOTIFICATION_ARCHIVE_UNREAD_DAYS", 365),
+		ArchiveKeepDays: envDays("NOTIFICATION_ARCHIVE_KEEP_DAYS", 0),
+		Interval:        24 * time.Hour,
+		BatchSize:       500,
+	}
+	if v := os.Getenv("NOTIFICATION_RETENTION_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			p.Interval = d
+		} else {
+			log.Printf("Retention [WARN]: ignoring NOTIFICATION_RETENTION_INTERVAL=%q", v)
+		}
+	}
+	return p
+}
+
+func (p RetentionPolicy) batchSize() int {
+	if p.BatchSize <= 0 {
+		return 500
+	}
+	return p.BatchSize
+}
+
+func envDays(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Retention [WARN]: ignoring %s=%q", key, v)
+		return fallback
+	}
+	return n
+}
+
+// RetentionJob applies the retention policy on a schedule and on demand.
+// Runs never overlap.
+type RetentionJob struct {
+	repo   *RetentionRepository
+	policy RetentionPolicy
+
+	running sync.Mutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// NewRetentionJob starts the schedule unless the policy's interval is zero
+func NewRetentionJob(repo *RetentionRepository, policy RetentionPolicy) *RetentionJob {
+	j := &RetentionJob{repo: repo, policy: policy, done: make(chan struct{})}
+	if policy.Interval > 0 {
+		j.wg.Add(1)
+		go j.loop()
+	}
+	return j
+}
+
+// Policy returns the policy the job applies
+func (j *RetentionJob) Policy() RetentionPolicy { return j.policy }
+
+// Preview reports what a run would remove right now
+func (j *RetentionJob) Preview() (*models.RetentionReport, error) {
+	return j.repo.Preview(j.policy, time.Now())
+}
+
+// Run applies the policy now. It returns ErrRetentionRunning rather than
+// waiting when another run is in progress.
+func (j *RetentionJob) Run(trigger string) (*models.RetentionReport, error) {
+	if !j.running.TryLock() {
+		return nil, ErrRetentionRunning
+	}
+	defer j.running.Unlock()
+
+	report, err := j.repo.Apply(j.policy, trigger, time.Now())
+	if report != nil {
+		log.Printf("Retention [INFO]: %s run removed %d notifications (%s)", trigger, report.Removed, summarizeBuckets(report.Buckets))
+	}
+	return report, err
+}
+
+// Runs returns the most recent runs, newest first
+func (j *RetentionJob) Runs(limit int) ([]models.RetentionReport, error) {
+	return j.repo.Runs(limit)
+}
+
+// Close stops the schedule, waiting for a run in progress to finish
+func (j *RetentionJob) Close() {
+	j.once.Do(func() { close(j.done) })
+	j.wg.Wait()
+}
+
+func (j *RetentionJob) loop() {
+	defer j.wg.Done()
+	timer := time.NewTimer(retentionStartDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-timer.C:
+			if _, err := j.Run(models.RetentionTriggerSchedule); err != nil {
+				log.Printf("Retention [ERROR]: scheduled run: %v", err)
+			}
+			timer.Reset(j.policy.Interval)
+		}
+	}
+}
+
+func summarizeBuckets(buckets []models.RetentionBucket) string {
+	if len(buckets) == 0 {
+		return "all rules off"
+	}
+	s := ""
+	for i, b := range buckets {
+		if i > 0 {
+			s += ", "
+		}
+		s += b.Action + " " + strconv.FormatInt(b.Count, 10)
+	}
+	return s
+}
@@ -0,0 +1,285 @@
+package notification
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// retentionRule is one retention action: the rows of table matching where,
+// with the policy cutoff bound to its single placeholder
+type retentionRule struct {
+	action string
+	table  string
+	where  string
+	age    string // column the cutoff applies to, for the preview's age range
+	cutoff time.Time
+}
+
+// rules lists the enabled actions of a policy as of now
+func (p RetentionPolicy) rules(now time.Time) []retentionRule {
+	var out []retentionRule
+	add := func(days int, action, table, where, age string) {
+		if days > 0 {
+			out = append(out, retentionRule{action, table, where, age, now.AddDate(0, 0, -days)})
+		}
+	}
+	add(p.ReadDays, models.RetentionDeleteRead, "notifications",
+		`read_at IS NOT NULL AND deleted_at IS NULL AND created_at < ?`, "created_at")
+	add(p.DeletedDays, models.RetentionDeleteSoft, "notifications",
+		`deleted_at IS NOT NULL AND deleted_at < ?`, "deleted_at")
+	add(p.ArchiveDays, models.RetentionArchive, "notifications",
+		`read_at IS NULL AND deleted_at IS NULL AND created_at < ?`, "created_at")
+	add(p.ArchiveKeepDays, models.RetentionPurgeArchived, "notifications_archive",
+		`archived_at < ?`, "archived_at")
+	return out
+}
+
+// RetentionRepository finds and removes notifications that fell out of the
+// retention policy, and logs each run
+type RetentionRepository struct{ db *sql.DB }
+
+func NewRetentionRepository(db *sql.DB) *RetentionRepository {
+	return &RetentionRepository{db: db}
+}
+
+// Preview reports what Apply would remove now, by action and type, without
+// changing anything
+func (r *RetentionRepository) Preview(policy RetentionPolicy, now time.Time) (*models.RetentionReport, error) {
+	report := &models.RetentionReport{DryRun: true, StartedAt: now, Buckets: []models.RetentionBucket{}}
+	for _, rule := range policy.rules(now) {
+		bucket := models.RetentionBucket{Action: rule.action, Before: &rule.cutoff, ByType: map[string]int64{}}
+		rows, err := r.db.Query(`SELECT type, COUNT(*) FROM `+rule.table+` WHERE `+rule.where+` GROUP BY type`, rule.cutoff)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var kind string
+			var n int64
+			if err := rows.Scan(&kind, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			bucket.ByType[kind] = n
+			bucket.Count += n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if bucket.Count > 0 {
+			if bucket.Oldest, err = r.edge(rule, "ASC"); err != nil {
+				return nil, err
+			}
+			if bucket.Newest, err = r.edge(rule, "DESC"); err != nil {
+				return nil, err
+			}
+		}
+		if rule.table == "notifications" {
+			report.Removed += bucket.Count
+		}
+		report.Buckets = append(report.Buckets, bucket)
+	}
+	return report, nil
+}
+
+// edge returns the oldest or newest age among a rule's rows
+func (r *RetentionRepository) edge(rule retentionRule, order string) (*time.Time, error) {
+	var raw interface{}
+	err := r.db.QueryRow(`SELECT `+rule.age+` AS age FROM `+rule.table+` WHERE `+rule.where+
+		` ORDER BY age `+order+` LIMIT 1`, rule.cutoff).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sqliteTime(raw), nil
+}
+
+// sqliteTime decodes a timestamp the driver returned undecoded, as it does
+// for expressions such as COALESCE that have no declared column type
+func sqliteTime(raw interface{}) *time.Time {
+	var s string
+	switch v := raw.(type) {
+	case time.Time:
+		return &v
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return nil
+	}
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// Apply runs every enabled action in batches and records the run. The
+// report is returned even when an action fails part way; its counts cover
+// what was done.
+func (r *RetentionRepository) Apply(policy RetentionPolicy, trigger string, now time.Time) (*models.RetentionReport, error) {
+	report := &models.RetentionReport{RunID: utils.GenerateUUID(), Trigger: trigger, StartedAt: now, Buckets: []models.RetentionBucket{}}
+	if _, err := r.db.Exec(`INSERT INTO notification_retention_runs (run_id, trigger, started_at) VALUES (?, ?, ?)`,
+		report.RunID, trigger, now); err != nil {
+		return nil, err
+	}
+
+	var runErr error
+	for _, rule := range policy.rules(now) {
+		bucket := models.RetentionBucket{Action: rule.action, Before: &rule.cutoff}
+		var err error
+		if rule.action == models.RetentionArchive {
+			bucket.Count, err = r.archive(rule, policy.batchSize(), now)
+		} else {
+			bucket.Count, err = r.delete(rule, policy.batchSize())
+		}
+		if rule.table == "notifications" {
+			report.Removed += bucket.Count
+		}
+		report.Buckets = append(report.Buckets, bucket)
+		if err != nil {
+			runErr = err
+			report.Error = rule.action + ": " + err.Error()
+			break
+		}
+	}
+
+	var errText *string
+	if report.Error != "" {
+		errText = &report.Error
+	}
+	finished := time.Now()
+	report.FinishedAt = &finished
+	counts := map[string]int64{}
+	for _, b := range report.Buckets {
+		counts[b.Action] = b.Count
+	}
+	if _, err := r.db.Exec(`UPDATE notification_retention_runs SET finished_at = ?, deleted_read = ?, deleted_soft = ?,
+		archived = ?, purged_archive = ?, error = ? WHERE run_id = ?`,
+		finished, counts[models.RetentionDeleteRead], counts[models.RetentionDeleteSoft],
+		counts[models.RetentionArchive], counts[models.RetentionPurgeArchived],
+		errText, report.RunID); err != nil && runErr == nil {
+		runErr = err
+	}
+	return report, runErr
+}
+
+// delete removes a rule's rows a batch at a time so writers are never
+// locked out for long. Actors and delivery logs go with them (ON DELETE
+// CASCADE).
+func (r *RetentionRepository) delete(rule retentionRule, batch int) (int64, error) {
+	var total int64
+	for {
+		res, err := r.db.Exec(`DELETE FROM `+rule.table+` WHERE notification_id IN
+			(SELECT notification_id FROM `+rule.table+` WHERE `+rule.where+` LIMIT ?)`, rule.cutoff, batch)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < int64(batch) {
+			return total, nil
+		}
+	}
+}
+
+// archive moves a rule's rows into notifications_archive, one transaction
+// per batch
+func (r *RetentionRepository) archive(rule retentionRule, batch int, now time.Time) (int64, error) {
+	var total int64
+	for {
+		n, err := r.archiveBatch(rule, batch, now)
+		total += n
+		if err != nil || n < int64(batch) {
+			return total, err
+		}
+	}
+}
+
+func (r *RetentionRepository) archiveBatch(rule retentionRule, batch int, now time.Time) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT notification_id FROM notifications WHERE `+rule.where+` LIMIT ?`, rule.cutoff, batch)
+	if err != nil {
+		return 0, err
+	}
+	var args []interface{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		args = append(args, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(args) == 0 {
+		return 0, nil
+	}
+
+	in := "(" + strings.TrimSuffix(strings.Repeat("?,", len(args)), ",") + ")"
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO notifications_archive
+		(notification_id, user_id, actor_id, post_id, comment_id, type, payload, group_key, actor_count,
+		 created_at, read_at, updated_at, archived_at)
+		SELECT notification_id, user_id, actor_id, post_id, comment_id, type, payload, group_key, actor_count,
+		 created_at, read_at, updated_at, ?
+		FROM notifications WHERE notification_id IN `+in, append([]interface{}{now}, args...)...); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM notifications WHERE notification_id IN `+in, args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// Runs returns the most recent retention runs, newest first
+func (r *RetentionRepository) Runs(limit int) ([]models.RetentionReport, error) {
+	rows, err := r.db.Query(`SELECT run_id, trigger, started_at, finished_at, deleted_read, deleted_soft,
+		archived, purged_archive, COALESCE(error, '') FROM notification_retention_runs
+		ORDER BY started_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []models.RetentionReport{}
+	for rows.Next() {
+		var rep models.RetentionReport
+		var read, soft, archived, purged int64
+		if err := rows.Scan(&rep.RunID, &rep.Trigger, &rep.StartedAt, &rep.FinishedAt,
+			&read, &soft, &archived, &purged, &rep.Error); err != nil {
+			return nil, err
+		}
+		rep.Buckets = []models.RetentionBucket{
+			{Action: models.RetentionDeleteRead, Count: read},
+			{Action: models.RetentionDeleteSoft, Count: soft},
+			{Action: models.RetentionArchive, Count: archived},
+			{Action: models.RetentionPurgeArchived, Count: purged},
+		}
+		rep.Removed = read + soft + archived
+		out = append(out, rep)
+	}
+	return out, rows.Err()
+}
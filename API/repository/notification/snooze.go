@@ -0,0 +1,53 @@
+package notification
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// SnoozeWakeInterval is how often snoozed notifications are checked. A
+// snooze can end up to this much later than asked.
+const SnoozeWakeInterval = 30 * time.Second
+
+// SnoozeWaker brings snoozed notifications back when their time comes
+type SnoozeWaker struct {
+	repo     *Repository
+	interval time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewSnoozeWaker wakes anything already due, then checks every interval
+func NewSnoozeWaker(repo *Repository, interval time.Duration) *SnoozeWaker {
+	w := &SnoozeWaker{repo: repo, interval: interval, done: make(chan struct{})}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+// Close stops the waker
+func (w *SnoozeWaker) Close() {
+	w.once.Do(func() { close(w.done) })
+	w.wg.Wait()
+}
+
+func (w *SnoozeWaker) loop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := w.repo.WakeSnoozed(time.Now()); err != nil {
+			log.Printf("Snooze [ERROR]: waking notifications: %v", err)
+		} else if n > 0 {
+			log.Printf("Snooze [INFO]: woke %d notifications", n)
+		}
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package notification
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+)
+
+// SubscriptionRepository stores who follows or has muted each post
+type SubscriptionRepository struct{ db *sql.DB }
+
+func NewSubscriptionRepository(db *sql.DB) *SubscriptionRepository {
+	return &SubscriptionRepository{db: db}
+}
+
+// AutoSubscribe follows a post on the user's behalf. It never overrides an
+// existing subscription, so a muted post stays muted.
+func (r *SubscriptionRepository) AutoSubscribe(userID, postID, reason string) error {
+	now := time.Now()
+	_, err := r.db.Exec(`INSERT OR IGNORE INTO post_subscriptions (user_id, post_id, state, reason, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, userID, postID, models.SubscriptionSubscribed, reason, now, now)
+	return err
+}
+
+// SetState explicitly subscribes to or mutes a post
+func (r *SubscriptionRepository) SetState(userID, postID, state string) error {
+	now := time.Now()
+	_, err := r.db.Exec(`INSERT INTO post_subscriptions (user_id, post_id, state, reason, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, post_id) DO UPDATE SET state = excluded.state, reason = excluded.reason, updated_at = excluded.updated_at`,
+		userID, postID, state, models.SubscribeManual, now, now)
+	return err
+}
+
+// Unsubscribe removes the user's subscription. Taking part in the post again
+// subscribes them again; use a mute to stay quiet for good.
+func (r *SubscriptionRepository) Unsubscribe(userID, postID string) error {
+	_, err := r.db.Exec(`DELETE FROM post_subscriptions WHERE user_id = ? AND post_id = ?`, userID, postID)
+	return err
+}
+
+// Get returns the user's subscription to a post, with state
+// models.SubscriptionNone when there is none
+func (r *SubscriptionRepository) Get(userID, postID string) (*models.PostSubscription, error) {
+	s := models.PostSubscription{PostID: postID}
+	var updatedAt time.Time
+	err := r.db.QueryRow(`SELECT state, reason, updated_at FROM post_subscriptions WHERE user_id = ? AND post_id = ?`, userID, postID).
+		Scan(&s.State, &s.Reason, &updatedAt)
+	if err == sql.ErrNoRows {
+		s.State = models.SubscriptionNone
+		return &s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.UpdatedAt = &updatedAt
+	return &s, nil
+}
+
+// Subscribers returns the users following a post, leaving out those who muted it
+func (r *SubscriptionRepository) Subscribers(postID string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT user_id FROM post_subscriptions WHERE post_id = ? AND state = ?`, postID, models.SubscriptionSubscribed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+
+// IsMuted reports whether the user muted the post
+func (r *SubscriptionRepository) IsMuted(userID, postID string) (bool, error) {
+	var muted bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM post_subscriptions WHERE user_id = ? AND post_id = ? AND state = ?)`,
+		userID, postID, models.SubscriptionMuted).Scan(&muted)
+	return muted, err
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"forum/markdown"
 	"forum/models"
 	"forum/utils"
 )
@@ -19,6 +20,25 @@ func (r *PostRepository) GetPostOwner(postID string) (string, error) {
 	return userID, err
 }
 
+// GetByID loads a post with its category IDs
+func (r *PostRepository) GetByID(postID string) (*models.Post, error) {
+	var post models.Post
+	err := r.db.QueryRow(`SELECT post_id, user_id, title, content, content_html, created_at, updated_at, status, publish_at FROM posts WHERE post_id = ?`, postID).
+		Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ContentHTML, &post.CreatedAt, &post.UpdatedAt, &post.Status, &post.PublishAt)
+	if err != nil {
+		return nil, err
+	}
+	post.ContentHTML = withHTML(post.ContentHTML, post.Content)
+	cats, err := r.GetCategoriesByPostID(postID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cats {
+		post.CategoryIDs = append(post.CategoryIDs, c.ID)
+	}
+	return &post, nil
+}
+
 // checks if the legacy category_id column exists on the posts table
 func (r *PostRepository) hasLegacyCategoryColumn() bool {
 	rows, err := r.db.Query(`PRAGMA table_info(posts)`)
@@ -47,8 +67,8 @@ func NewPostRepository(db *sql.DB) *PostRepository {
 
 func (r *PostRepository) GetAllPosts() ([]models.Post, error) {
 	rows, err := r.db.Query(`
-		SELECT post_id, user_id, title, content, created_at, updated_at
-                FROM posts ORDER BY created_at DESC`)
+		SELECT post_id, user_id, title, content, content_html, created_at, updated_at
+                FROM posts WHERE status = 'published' ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -58,21 +78,27 @@ func (r *PostRepository) GetAllPosts() ([]models.Post, error) {
 	for rows.Next() {
 		var post models.Post
 		//err := rows.Scan(&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt)
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt)
+		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.ContentHTML, &post.CreatedAt, &post.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		post.ContentHTML = withHTML(post.ContentHTML, post.Content)
 		posts = append(posts, post)
 	}
 
 	return posts, nil
 }
 
-// Create inserts a new post into the database
+// Create inserts a new post into the database. A post without a Status is
+// published.
 // func (r *PostRepository) Create(post models.Post) (*models.Post, error) {
 func (r *PostRepository) Create(post models.Post, categoryIDs []int) (*models.Post, error) {
 	post.ID = utils.GenerateUUID()
 	post.CreatedAt = time.Now()
+	post.ContentHTML = renderContent(post.Content)
+	if post.Status == "" {
+		post.Status = models.PostPublished
+	}
 	// _, err := r.db.Exec(`INSERT INTO posts (post_id, user_id, category_id, title, content, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
 	// 	post.ID, post.UserID, post.CategoryID, post.Title, post.Content, post.CreatedAt)
 
@@ -88,11 +114,11 @@ func (r *PostRepository) Create(post models.Post, categoryIDs []int) (*models.Po
 			tx.Rollback()
 			return nil, sql.ErrNoRows
 		}
-		insertPost = `INSERT INTO posts (post_id, user_id, category_id, title, content, created_at) VALUES (?, ?, ?, ?, ?, ?)`
-		args = []interface{}{post.ID, post.UserID, categoryIDs[0], post.Title, post.Content, post.CreatedAt}
+		insertPost = `INSERT INTO posts (post_id, user_id, category_id, title, content, content_html, html_version, created_at, status, publish_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		args = []interface{}{post.ID, post.UserID, categoryIDs[0], post.Title, post.Content, post.ContentHTML, markdown.Version, post.CreatedAt, post.Status, post.PublishAt}
 	} else {
-		insertPost = `INSERT INTO posts (post_id, user_id, title, content, created_at) VALUES (?, ?, ?, ?, ?)`
-		args = []interface{}{post.ID, post.UserID, post.Title, post.Content, post.CreatedAt}
+		insertPost = `INSERT INTO posts (post_id, user_id, title, content, content_html, html_version, created_at, status, publish_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		args = []interface{}{post.ID, post.UserID, post.Title, post.Content, post.ContentHTML, markdown.Version, post.CreatedAt, post.Status, post.PublishAt}
 	}
 
 	_, err = tx.Exec(insertPost, args...)
@@ -115,6 +141,11 @@ func (r *PostRepository) Create(post models.Post, categoryIDs []int) (*models.Po
 		}
 	}
 
+	if err := recordPostRevision(tx, post.ID, post.CreatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
@@ -124,10 +155,10 @@ func (r *PostRepository) Create(post models.Post, categoryIDs []int) (*models.Po
 
 func (r *PostRepository) GetPostsByUser(userID string) ([]models.PostWithUser, error) {
 	rows, err := r.db.Query(`
-        SELECT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at, p.updated_at
+        SELECT p.post_id, p.user_id, u.username, p.title, p.content, p.content_html, p.created_at, p.updated_at
         FROM posts p
         JOIN user u ON p.user_id = u.user_id
-        WHERE p.user_id = ?
+        WHERE p.user_id = ? AND p.status = 'published'
         ORDER BY p.created_at DESC`, userID)
 	if err != nil {
 		return nil, err
@@ -137,9 +168,10 @@ func (r *PostRepository) GetPostsByUser(userID string) ([]models.PostWithUser, e
 	var posts []models.PostWithUser
 	for rows.Next() {
 		var p models.PostWithUser
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.ContentHTML, &p.CreatedAt, &p.UpdatedAt); err != nil {
 			return nil, err
 		}
+		p.ContentHTML = withHTML(p.ContentHTML, p.Content)
 		posts = append(posts, p)
 	}
 	return posts, nil
@@ -200,13 +232,13 @@ func (r *PostRepository) GetCategoriesByPostID(postID string) ([]models.Category
 
 func (r *PostRepository) GetPostsReactedByUser(userID string) ([]models.PostWithUser, error) {
 	query := `
-		SELECT DISTINCT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at
+		SELECT DISTINCT p.post_id, p.user_id, u.username, p.title, p.content, p.content_html, p.created_at
 		FROM posts p
 		JOIN user u ON p.user_id = u.user_id
 		WHERE p.post_id IN (
 			SELECT post_id FROM reactions
 			WHERE user_id = ? AND reaction_type = 1 AND post_id IS NOT NULL
-		)
+		) AND p.status = 'published'
 		ORDER BY p.created_at DESC
 	`
 
@@ -219,9 +251,10 @@ func (r *PostRepository) GetPostsReactedByUser(userID string) ([]models.PostWith
 	var posts []models.PostWithUser
 	for rows.Next() {
 		var p models.PostWithUser
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.ContentHTML, &p.CreatedAt); err != nil {
 			return nil, err
 		}
+		p.ContentHTML = withHTML(p.ContentHTML, p.Content)
 		posts = append(posts, p)
 	}
 	return posts, nil
@@ -263,13 +296,13 @@ func (r *PostRepository) GetPostsReactedByUser(userID string) ([]models.PostWith
 // GetPostsDislikedByUser returns posts that the given user has disliked (reaction_type = 2)
 func (r *PostRepository) GetPostsDislikedByUser(userID string) ([]models.PostWithUser, error) {
 	query := `
-		SELECT DISTINCT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at
+		SELECT DISTINCT p.post_id, p.user_id, u.username, p.title, p.content, p.content_html, p.created_at
 		FROM posts p
 		JOIN user u ON p.user_id = u.user_id
 		WHERE p.post_id IN (
 			SELECT post_id FROM reactions
 			WHERE user_id = ? AND reaction_type = 2 AND post_id IS NOT NULL
-		)
+		) AND p.status = 'published'
 		ORDER BY p.created_at DESC
 	`
 
@@ -282,9 +315,10 @@ func (r *PostRepository) GetPostsDislikedByUser(userID string) ([]models.PostWit
 	var posts []models.PostWithUser
 	for rows.Next() {
 		var p models.PostWithUser
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.ContentHTML, &p.CreatedAt); err != nil {
 			return nil, err
 		}
+		p.ContentHTML = withHTML(p.ContentHTML, p.Content)
 		posts = append(posts, p)
 	}
 	return posts, nil
@@ -293,12 +327,12 @@ func (r *PostRepository) GetPostsDislikedByUser(userID string) ([]models.PostWit
 // GetPostsCommentedByUser returns posts that the given user has commented on
 func (r *PostRepository) GetPostsCommentedByUser(userID string) ([]models.PostWithUser, error) {
 	query := `
-		SELECT DISTINCT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at, p.updated_at
+		SELECT DISTINCT p.post_id, p.user_id, u.username, p.title, p.content, p.content_html, p.created_at, p.updated_at
 		FROM posts p
 		JOIN user u ON p.user_id = u.user_id
 		WHERE p.post_id IN (
 			SELECT post_id FROM comments WHERE user_id = ?
-		)
+		) AND p.status = 'published'
 		ORDER BY p.created_at DESC
 	`
 
@@ -311,15 +345,18 @@ func (r *PostRepository) GetPostsCommentedByUser(userID string) ([]models.PostWi
 	var posts []models.PostWithUser
 	for rows.Next() {
 		var p models.PostWithUser
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.ContentHTML, &p.CreatedAt, &p.UpdatedAt); err != nil {
 			return nil, err
 		}
+		p.ContentHTML = withHTML(p.ContentHTML, p.Content)
 		posts = append(posts, p)
 	}
 	return posts, nil
 }
 
-// UpdatePost updates the title and content of a post and sets updated_at
+// UpdatePost updates the title and content of a post, rendering the content
+// again, and sets updated_at. The new text is recorded as a revision in the
+// same transaction.
 func (r *PostRepository) UpdatePost(postID string, title, content *string) error {
 	setClauses := []string{}
 	args := []interface{}{}
@@ -329,22 +366,46 @@ func (r *PostRepository) UpdatePost(postID string, title, content *string) error
 		args = append(args, title)
 	}
 	if content != nil {
-		setClauses = append(setClauses, "content = ?")
-		args = append(args, content)
+		setClauses = append(setClauses, "content = ?", "content_html = ?", "html_version = ?")
+		args = append(args, content, renderContent(content), markdown.Version)
 	}
 	if len(setClauses) == 0 {
 		return nil // nothing to update
 	}
+	now := time.Now()
 	setClauses = append(setClauses, "updated_at = ?")
-	args = append(args, time.Now(), postID)
+	args = append(args, now, postID)
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
 	query := "UPDATE posts SET " + strings.Join(setClauses, ", ") + " WHERE post_id = ?"
-	_, err := r.db.Exec(query, args...)
-	return err
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	if err := recordPostRevision(tx, postID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// SoftDeletePost sets title and content to NULL and updates updated_at
+// SoftDeletePost sets title and content to NULL and updates updated_at. The
+// post's revisions go too, so its old text doesn't outlive it.
 func (r *PostRepository) SoftDeletePost(postID string) error {
-	_, err := r.db.Exec(`UPDATE posts SET title = NULL, content = NULL, updated_at = ? WHERE post_id = ?`, time.Now(), postID)
-	return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE posts SET title = NULL, content = NULL, content_html = NULL, updated_at = ? WHERE post_id = ?`, time.Now(), postID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM post_revisions WHERE post_id = ?`, postID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -55,10 +55,14 @@ func (r *ReactionRepository) GetAllReactions() ([]models.Reaction, error) {
 }
 
 // ToggleReaction adds or updates a reaction. If the same reaction already
-// exists for the user and target, it is removed.
+// exists for the user and target, it is removed. Only published posts take
+// reactions; others give ErrPostNotFound.
 func (r *ReactionRepository) ToggleReaction(userID, targetType, targetID string, reactionType int) error {
 	switch targetType {
 	case "post":
+		if err := requirePublished(r.db, targetID); err != nil {
+			return err
+		}
 		var existing int
 		err := r.db.QueryRow(`SELECT reaction_type FROM reactions WHERE user_id = ? AND post_id = ?`, userID, targetID).Scan(&existing)
 		if err != nil && err != sql.ErrNoRows {
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/markdown"
+	"forum/models"
+)
+
+// recordPostRevision stores the post's current title and content as its next
+// revision, unless they match the latest one. Drafts have no history; their
+// first revision is recorded when they are published.
+func recordPostRevision(tx *sql.Tx, postID string, at time.Time) error {
+	_, err := tx.Exec(`INSERT INTO post_revisions (post_id, revision, title, content, created_at)
+		SELECT p.post_id, COALESCE(last.revision, 0) + 1, p.title, p.content, ?
+		FROM posts p
+		LEFT JOIN post_revisions last ON last.post_id = p.post_id
+			AND last.revision = (SELECT MAX(revision) FROM post_revisions WHERE post_id = p.post_id)
+		WHERE p.post_id = ? AND p.status = 'published'
+			AND (p.title IS NOT NULL OR p.content IS NOT NULL)
+			AND (last.revision IS NULL OR last.title IS NOT p.title OR last.content IS NOT p.content)`, at, postID)
+	return err
+}
+
+// recordCommentRevision stores the comment's current content as its next
+// revision, unless it matches the latest one
+func recordCommentRevision(tx *sql.Tx, commentID string, at time.Time) error {
+	_, err := tx.Exec(`INSERT INTO comment_revisions (comment_id, revision, content, created_at)
+		SELECT c.comment_id, COALESCE(last.revision, 0) + 1, c.content, ?
+		FROM comments c
+		LEFT JOIN comment_revisions last ON last.comment_id = c.comment_id
+			AND last.revision = (SELECT MAX(revision) FROM comment_revisions WHERE comment_id = c.comment_id)
+		WHERE c.comment_id = ?
+			AND c.content IS NOT NULL
+			AND (last.revision IS NULL OR last.content IS NOT c.content)`, at, commentID)
+	return err
+}
+
+// GetRevisions lists a post's revisions, newest first. A deleted post has none.
+func (r *PostRepository) GetRevisions(postID string) ([]models.Revision, error) {
+	rows, err := r.db.Query(`SELECT revision, title, content, created_at FROM post_revisions
+		WHERE post_id = ? ORDER BY revision DESC`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.Revision{}
+	for rows.Next() {
+		var rev models.Revision
+		if err := rows.Scan(&rev.Revision, &rev.Title, &rev.Content, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		rev.Current = len(revisions) == 0
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// RestoreRevision makes an earlier revision's title and content current
+// again. The restore is an edit like any other: it is recorded as a new
+// revision, which is returned, and history is never rewritten.
+func (r *PostRepository) RestoreRevision(postID string, revision int) (*models.Revision, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var title, content *string
+	err = tx.QueryRow(`SELECT title, content FROM post_revisions WHERE post_id = ? AND revision = ?`, postID, revision).
+		Scan(&title, &content)
+	if err == sql.ErrNoRows {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE posts SET title = ?, content = ?, content_html = ?, html_version = ?, updated_at = ? WHERE post_id = ?`,
+		title, content, renderContent(content), markdown.Version, now, postID); err != nil {
+		return nil, err
+	}
+	if err := recordPostRevision(tx, postID, now); err != nil {
+		return nil, err
+	}
+	var rev models.Revision
+	err = tx.QueryRow(`SELECT revision, title, content, created_at FROM post_revisions
+		WHERE post_id = ? ORDER BY revision DESC LIMIT 1`, postID).
+		Scan(&rev.Revision, &rev.Title, &rev.Content, &rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	rev.Current = true
+	return &rev, tx.Commit()
+}
+
+// GetRevisions lists a comment's revisions, newest first. A deleted comment
+// has none.
+func (r *CommentRepository) GetRevisions(commentID string) ([]models.Revision, error) {
+	rows, err := r.db.Query(`SELECT revision, content, created_at FROM comment_revisions
+		WHERE comment_id = ? ORDER BY revision DESC`, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.Revision{}
+	for rows.Next() {
+		var rev models.Revision
+		if err := rows.Scan(&rev.Revision, &rev.Content, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		rev.Current = len(revisions) == 0
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// RestoreRevision makes an earlier revision of a comment current again,
+// recording it as a new revision
+func (r *CommentRepository) RestoreRevision(commentID string, revision int) (*models.Revision, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var content *string
+	err = tx.QueryRow(`SELECT content FROM comment_revisions WHERE comment_id = ? AND revision = ?`, commentID, revision).
+		Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if _, err := tx.Exec(`UPDATE comments SET content = ?, content_html = ?, html_version = ?, updated_at = ? WHERE comment_id = ?`,
+		content, renderContent(content), markdown.Version, now, commentID); err != nil {
+		return nil, err
+	}
+	if err := recordCommentRevision(tx, commentID, now); err != nil {
+		return nil, err
+	}
+	var rev models.Revision
+	err = tx.QueryRow(`SELECT revision, content, created_at FROM comment_revisions
+		WHERE comment_id = ? ORDER BY revision DESC LIMIT 1`, commentID).
+		Scan(&rev.Revision, &rev.Content, &rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	rev.Current = true
+	return &rev, tx.Commit()
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// Search bounds
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 50
+	// maxSearchTerms caps the words in one query
+	maxSearchTerms = 10
+)
+
+// ErrEmptySearch is returned when a query has no words to match
+var ErrEmptySearch = errors.New("search query has no terms")
+
+// Match markers wrap hits in highlight() and snippet() output so the text can
+// be escaped before they become <mark> tags. Control characters typed into a
+// post could at worst add a stray <mark>.
+const (
+	markOpen  = "\x02"
+	markClose = "\x03"
+)
+
+// snippetTokens is roughly how many words a snippet shows around a match
+const snippetTokens = 16
+
+type SearchRepository struct {
+	db *sql.DB
+}
+
+func NewSearchRepository(db *sql.DB) *SearchRepository {
+	return &SearchRepository{db: db}
+}
+
+// Search runs q against the post and comment indexes and returns one page of
+// results ranked by bm25, with title matches weighted above content matches.
+// Deleted posts, deleted comments and comments on deleted posts never match.
+func (r *SearchRepository) Search(q models.SearchQuery) (*models.SearchPage, error) {
+	match, err := matchExpression(q.Terms)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	var parts []string
+	var args []interface{}
+	if q.Scope != models.SearchComments {
+		where, whereArgs := searchFilters(q, "p", "up")
+		parts = append(parts, `SELECT 'post' AS kind, p.post_id, NULL AS comment_id,
+			highlight(posts_fts, 1, '`+markOpen+`', '`+markClose+`') AS title,
+			snippet(posts_fts, 2, '`+markOpen+`', '`+markClose+`', '…', `+strconv.Itoa(snippetTokens)+`) AS snippet,
+			p.user_id, up.username, p.created_at, bm25(posts_fts, 0.0, 10.0, 1.0) AS score
+			FROM posts_fts
+			JOIN posts p ON p.post_id = posts_fts.post_id
+			JOIN user up ON up.user_id = p.user_id
+			WHERE posts_fts MATCH ? AND p.status = 'published'`+where)
+		args = append(append(args, match), whereArgs...)
+	}
+	if q.Scope != models.SearchPosts {
+		where, whereArgs := searchFilters(q, "c", "uc")
+		parts = append(parts, `SELECT 'comment' AS kind, c.post_id, c.comment_id,
+			COALESCE(p.title, '') AS title,
+			snippet(comments_fts, 2, '`+markOpen+`', '`+markClose+`', '…', `+strconv.Itoa(snippetTokens)+`) AS snippet,
+			c.user_id, uc.username, c.created_at, bm25(comments_fts, 0.0, 0.0, 1.0) AS score
+			FROM comments_fts
+			JOIN comments c ON c.comment_id = comments_fts.comment_id
+			JOIN posts p ON p.post_id = c.post_id
+			JOIN user uc ON uc.user_id = c.user_id
+			WHERE comments_fts MATCH ? AND p.status = 'published' AND (p.title IS NOT NULL OR p.content IS NOT NULL)`+where)
+		args = append(append(args, match), whereArgs...)
+	}
+	union := strings.Join(parts, " UNION ALL ")
+
+	page := &models.SearchPage{Results: []models.SearchResult{}}
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM (`+union+`)`, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	rows, err := r.db.Query(`SELECT kind, post_id, comment_id, title, snippet, user_id, username, created_at, score
+		FROM (`+union+`)
+		ORDER BY score ASC, created_at DESC
+		LIMIT ? OFFSET ?`, append(args, limit+1, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var res models.SearchResult
+		if err := rows.Scan(&res.Type, &res.PostID, &res.CommentID, &res.Title, &res.Snippet, &res.UserID, &res.Username, &res.CreatedAt, &res.Rank); err != nil {
+			return nil, err
+		}
+		res.Title = markHTML(res.Title)
+		res.Snippet = markHTML(res.Snippet)
+		page.Results = append(page.Results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Results) > limit {
+		page.Results = page.Results[:limit]
+		page.HasMore = true
+		page.NextOffset = offset + limit
+	}
+	return page, nil
+}
+
+// searchFilters builds the category, author and date conditions for one side
+// of the search. t is the post or comment alias and u the author's.
+func searchFilters(q models.SearchQuery, t, u string) (string, []interface{}) {
+	var where strings.Builder
+	var args []interface{}
+	if len(q.CategoryIDs) > 0 {
+		where.WriteString(` AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = ` + t + `.post_id
+			AND pc.category_id IN (?` + strings.Repeat(", ?", len(q.CategoryIDs)-1) + `))`)
+		for _, id := range q.CategoryIDs {
+			args = append(args, id)
+		}
+	}
+	if q.Author != "" {
+		where.WriteString(` AND ` + u + `.username = ? COLLATE NOCASE`)
+		args = append(args, q.Author)
+	}
+	// Stored timestamps use the server's zone; compare in the same one
+	if q.Since != nil {
+		where.WriteString(` AND ` + t + `.created_at >= ?`)
+		args = append(args, q.Since.In(time.Local))
+	}
+	if q.Until != nil {
+		where.WriteString(` AND ` + t + `.created_at < ?`)
+		args = append(args, q.Until.In(time.Local))
+	}
+	return where.String(), args
+}
+
+// matchExpression turns what a user typed into an FTS5 query. Every word is
+// quoted, so operators and punctuation are matched literally and never cause
+// syntax errors; a trailing * is kept as a prefix search.
+func matchExpression(terms string) (string, error) {
+	var out []string
+	for _, word := range strings.Fields(terms) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.Trim(word, `*"`)
+		if word == "" {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		out = append(out, term)
+		if len(out) == maxSearchTerms {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return "", ErrEmptySearch
+	}
+	return strings.Join(out, " "), nil
+}
+
+// markHTML escapes highlighted text and turns the match markers into <mark>
+func markHTML(s string) string {
+	return strings.NewReplacer(markOpen, "<mark>", markClose, "</mark>").Replace(html.EscapeString(s))
+}
@@ -0,0 +1,28 @@
+package user
+
+import (
+	"database/sql"
+
+	"forum/repository"
+)
+
+// GetLocale returns the locale the user chose for notifications and other
+// server-rendered text, or "" when they left it to the browser
+func (r *UserRepository) GetLocale(userID string) (string, error) {
+	var locale sql.NullString
+	err := r.DB.QueryRow("SELECT locale FROM user WHERE user_id = ?", userID).Scan(&locale)
+	if err == sql.ErrNoRows {
+		return "", repository.ErrUserNotFound
+	}
+	return locale.String, err
+}
+
+// SetLocale stores the user's locale; "" clears it
+func (r *UserRepository) SetLocale(userID, locale string) error {
+	var value interface{}
+	if locale != "" {
+		value = locale
+	}
+	_, err := r.DB.Exec("UPDATE user SET locale = ? WHERE user_id = ?", value, userID)
+	return err
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// WebhookRepository stores users' webhooks and their delivery log
+type WebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+const webhookColumns = `webhook_id, user_id, url, secret, events, active, created_at, updated_at`
+
+// Create stores a new webhook and fills in its ID and creation time
+func (r *WebhookRepository) Create(w *models.Webhook) error {
+	w.ID = utils.GenerateUUID()
+	w.CreatedAt = time.Now()
+	_, err := r.db.Exec(`INSERT INTO webhooks (`+webhookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, NULL)`,
+		w.ID, w.UserID, w.URL, w.Secret, strings.Join(w.Events, ","), w.Active, w.CreatedAt)
+	return err
+}
+
+// GetByID loads a webhook, returning ErrWebhookNotFound when it is missing
+func (r *WebhookRepository) GetByID(id string) (*models.Webhook, error) {
+	w, err := scanWebhook(r.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE webhook_id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// GetByUser lists a user's webhooks, newest first
+func (r *WebhookRepository) GetByUser(userID string) ([]models.Webhook, error) {
+	return r.list(`SELECT `+webhookColumns+` FROM webhooks WHERE user_id = ? ORDER BY created_at DESC`, userID)
+}
+
+// Subscribed lists the active webhooks whose filter includes the event
+func (r *WebhookRepository) Subscribed(event string) ([]models.Webhook, error) {
+	all, err := r.list(`SELECT ` + webhookColumns + ` FROM webhooks WHERE active = 1`)
+	if err != nil {
+		return nil, err
+	}
+	var out []models.Webhook
+	for _, w := range all {
+		for _, e := range w.Events {
+			if e == event {
+				out = append(out, w)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// Update saves a webhook's URL, secret, events and active flag
+func (r *WebhookRepository) Update(w *models.Webhook) error {
+	now := time.Now()
+	res, err := r.db.Exec(`UPDATE webhooks SET url = ?, secret = ?, events = ?, active = ?, updated_at = ?
+		WHERE webhook_id = ? AND user_id = ?`,
+		w.URL, w.Secret, strings.Join(w.Events, ","), w.Active, now, w.ID, w.UserID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	w.UpdatedAt = &now
+	return nil
+}
+
+// Delete removes a user's webhook along with its delivery log
+func (r *WebhookRepository) Delete(id, userID string) error {
+	res, err := r.db.Exec(`DELETE FROM webhooks WHERE webhook_id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r *WebhookRepository) list(query string, args ...interface{}) ([]models.Webhook, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *w)
+	}
+	return out, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var w models.Webhook
+	var events string
+	if err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return &w, nil
+}
+
+const webhookDeliveryColumns = `delivery_id, webhook_id, event_id, event, payload, status, attempts,
+	response_status, last_error, created_at, next_attempt_at, delivered_at`
+
+// CreateDelivery logs a delivery before its first attempt
+func (r *WebhookRepository) CreateDelivery(d *models.WebhookDelivery) error {
+	d.ID = utils.GenerateUUID()
+	d.CreatedAt = time.Now()
+	_, err := r.db.Exec(`INSERT INTO webhook_deliveries (`+webhookDeliveryColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.WebhookID, d.EventID, d.Event, d.Payload, d.Status, d.Attempts,
+		d.ResponseStatus, d.LastError, d.CreatedAt, d.NextAttemptAt, d.DeliveredAt)
+	return err
+}
+
+// UpdateDelivery records the outcome of an attempt
+func (r *WebhookRepository) UpdateDelivery(d *models.WebhookDelivery) error {
+	_, err := r.db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?,
+		last_error = ?, next_attempt_at = ?, delivered_at = ? WHERE delivery_id = ?`,
+		d.Status, d.Attempts, d.ResponseStatus, d.LastError, d.NextAttemptAt, d.DeliveredAt, d.ID)
+	return err
+}
+
+// GetDeliveries returns a webhook's most recent deliveries, newest first
+func (r *WebhookRepository) GetDeliveries(webhookID string, limit int) ([]models.WebhookDelivery, error) {
+	return r.listDeliveries(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ? ORDER BY created_at DESC LIMIT ?`, webhookID, limit)
+}
+
+// PendingDeliveries returns every delivery still waiting for an attempt,
+// so retries survive a restart
+func (r *WebhookRepository) PendingDeliveries() ([]models.WebhookDelivery, error) {
+	return r.listDeliveries(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? ORDER BY next_attempt_at`, models.WebhookDeliveryPending)
+}
+
+func (r *WebhookRepository) listDeliveries(query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
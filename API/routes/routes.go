@@ -2,14 +2,21 @@ package routes
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
 
+	"forum/email"
 	"forum/handlers"
+	"forum/imaging"
 	"forum/middleware"
+	"forum/push"
+	"forum/realtime"
 	"forum/repository"
 	"forum/repository/notification"
 	"forum/repository/session"
 	"forum/repository/user"
+	"forum/storage"
+	"forum/webhook"
 )
 
 func SetupRoutes(db *sql.DB) http.Handler {
@@ -20,21 +27,101 @@ func SetupRoutes(db *sql.DB) http.Handler {
 	postRepo := repository.NewPostRepository(db)
 	commentRepo := repository.NewCommentRepository(db)
 	reactionRepo := repository.NewReactionRepository(db)
-	imageRepo := repository.NewImageRepository(db)
+	// Uploads are kept in a local directory or an S3-compatible bucket
+	storageCfg := storage.ConfigFromEnv()
+	blob, err := storageCfg.Open()
+	if err != nil {
+		log.Fatalf("Routes [ERROR]: storage: %v", err)
+	}
+	if _, ok := blob.(storage.Signer); storageCfg.SignedURLTTL > 0 && !ok {
+		log.Printf("Routes [WARN]: %s cannot sign URLs; serving its files through the API", blob)
+	}
+	imageStore := storage.NewStore(blob)
+	imageRepo := repository.NewImageRepository(db, imageStore)
 	notificationRepo := notification.NewRepository(db)
+	preferenceRepo := notification.NewPreferenceRepository(db)
+	subscriptionRepo := notification.NewSubscriptionRepository(db)
+	deliveryRepo := notification.NewDeliveryRepository(db)
+	mentionRepo := repository.NewMentionRepository(db)
+	webhookRepo := repository.NewWebhookRepository(db)
+	pushRepo := notification.NewPushRepository(db)
+	retentionRepo := notification.NewRetentionRepository(db)
+	searchRepo := repository.NewSearchRepository(db)
+	feedRepo := repository.NewFeedRepository(db)
+
+	// Content without cached HTML, or rendered by an older version of the
+	// Markdown renderer, is rendered again before serving
+	if n, err := repository.RefreshContentHTML(db); err != nil {
+		log.Printf("Routes [ERROR]: render content: %v", err)
+	} else if n > 0 {
+		log.Printf("Routes: rendered %d posts and comments", n)
+	}
+
+	// Live notification streams are fed from the repository
+	hub := realtime.NewHub()
+	notificationRepo.SetPublisher(hub)
+	notificationRepo.SetLocales(userRepo)
+	deliveryRepo.SetLocales(userRepo)
+
+	// Handlers raise events; the dispatcher decides who hears about them
+	dispatcher := notification.NewDispatcher(postRepo, commentRepo, reactionRepo, userRepo, preferenceRepo, subscriptionRepo, notificationRepo, notification.DefaultWorkers, notification.DefaultQueueSize)
+
+	// Email goes out only when SMTP_HOST is configured
+	if cfg, ok := email.ConfigFromEnv(); ok {
+		dispatcher.AddSender(email.NewSender(cfg, userRepo, preferenceRepo, deliveryRepo))
+	}
+
+	// Web Push goes out only when VAPID_PRIVATE_KEY is configured
+	var vapidPublicKey string
+	if cfg, ok := push.ConfigFromEnv(); ok {
+		if sender, err := push.NewSender(cfg, pushRepo, deliveryRepo); err != nil {
+			log.Printf("Routes [ERROR]: web push disabled: %v", err)
+		} else {
+			dispatcher.AddSender(sender)
+			vapidPublicKey = sender.PublicKey()
+		}
+	}
+
+	// Webhooks observe every event, including ones that notify no one
+	dispatcher.AddHook(webhook.NewPublisher(webhook.ConfigFromEnv(), webhookRepo, postRepo, commentRepo, userRepo))
+
+	// Old notifications are deleted or archived in the background
+	retentionJob := notification.NewRetentionJob(retentionRepo, notification.RetentionPolicyFromEnv())
+
+	// Image files no row points at, and rows whose files are gone, are
+	// collected in the background
+	imageGC := repository.NewImageGC(imageRepo, repository.ImageGCPolicyFromEnv())
+
+	// Snoozed notifications come back unread when their time is up
+	notification.NewSnoozeWaker(notificationRepo, notification.SnoozeWakeInterval)
+
+	mentions := handlers.NewMentionTracker(mentionRepo, userRepo, dispatcher)
+
+	// Scheduled posts go out when their publish time comes
+	publisher := handlers.NewPostPublisher(postRepo, dispatcher, mentions, subscriptionRepo)
 
 	// Create handlers
-	authHandler := handlers.NewAuthHandler(userRepo, sessionRepo)
+	authHandler := handlers.NewAuthHandler(userRepo, sessionRepo, dispatcher)
 	oauthHandler := handlers.NewOAuthHandler(userRepo, sessionRepo, authHandler)
 	categoryHandler := handlers.NewCategoryHandler(categoryRepo, postRepo, imageRepo)
-	postHandler := handlers.NewPostHandler(postRepo)
+	postHandler := handlers.NewPostHandler(postRepo, dispatcher, mentions, subscriptionRepo, hub, publisher)
 	myPostsHandler := handlers.NewMyPostsHandler(postRepo, commentRepo, reactionRepo, imageRepo)
 	likedPostsHandler := handlers.NewLikedPostsHandler(postRepo, commentRepo, reactionRepo, imageRepo)
-	commentHandler := handlers.NewCommentHandler(commentRepo, postRepo, notificationRepo, userRepo)
-	reactionHandler := handlers.NewReactionHandler(reactionRepo, postRepo, notificationRepo, userRepo)
-	imageHandler := handlers.NewImageHandler(imageRepo)
-	notificationHandler := handlers.NewNotificationHandler(notificationRepo)
-	guestHandler := handlers.NewGuestHandler(categoryRepo, postRepo, commentRepo, reactionRepo, imageRepo)
+	commentHandler := handlers.NewCommentHandler(commentRepo, dispatcher, mentions, subscriptionRepo, hub)
+	reactionHandler := handlers.NewReactionHandler(reactionRepo, commentRepo, dispatcher, subscriptionRepo, hub)
+	imageHandler := handlers.NewImageHandler(imageRepo, postRepo, imageStore, handlers.MaxImagesPerPostFromEnv(), imaging.ConfigFromEnv())
+	notificationHandler := handlers.NewNotificationHandler(notificationRepo, hub, userRepo)
+	localeHandler := handlers.NewLocaleHandler(userRepo)
+	notificationPreferenceHandler := handlers.NewNotificationPreferenceHandler(preferenceRepo)
+	liveHandler := handlers.NewLiveHandler(hub)
+	subscriptionHandler := handlers.NewSubscriptionHandler(subscriptionRepo, postRepo)
+	webhookHandler := handlers.NewWebhookHandler(webhookRepo)
+	pushHandler := handlers.NewPushHandler(pushRepo, vapidPublicKey)
+	retentionHandler := handlers.NewRetentionHandler(retentionJob)
+	imageGCHandler := handlers.NewImageGCHandler(imageGC)
+	searchHandler := handlers.NewSearchHandler(searchRepo)
+	feedHandler := handlers.NewFeedHandler(feedRepo, categoryRepo, mentionRepo)
+	guestHandler := handlers.NewGuestHandler(categoryRepo, postRepo, commentRepo, reactionRepo, imageRepo, mentionRepo)
 
 	// Create middleware
 	registerLimiter := middleware.NewRateLimiter()
@@ -46,14 +133,23 @@ func SetupRoutes(db *sql.DB) http.Handler {
 	// Create router
 	mux := http.NewServeMux()
 
-	// Serve uploaded images from the API container
-	fs := http.FileServer(http.Dir("./uploads"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	// Serve uploaded images from the storage backend, or redirect to it
+	mux.Handle("/static/", http.StripPrefix("/static/", handlers.NewStaticHandler(blob, storageCfg.SignedURLTTL)))
 
 	// Public routes
 	mux.Handle("/forum/api/categories", corsMiddleware.Handler(http.HandlerFunc(categoryHandler.GetCategories)))
 	mux.Handle("/forum/api/category", corsMiddleware.Handler(http.HandlerFunc(categoryHandler.GetCategoryByID)))
 	mux.Handle("/forum/api/feed", corsMiddleware.Handler(http.HandlerFunc(guestHandler.GetGuestData)))
+	mux.Handle("/forum/api/feed/posts", corsMiddleware.Handler(http.HandlerFunc(feedHandler.Posts)))             // GET ?sort=&cursor=
+	mux.Handle("/forum/api/feed/category/", corsMiddleware.Handler(http.HandlerFunc(feedHandler.CategoryPosts))) // GET {id}?sort=&cursor=
+	mux.Handle("/forum/api/search", corsMiddleware.Handler(http.HandlerFunc(searchHandler.Search)))              // GET ?q=
+
+	// Revision history is public like the posts and comments themselves;
+	// restoring one is in the protected routes below
+	mux.Handle("/forum/api/posts/revisions/", corsMiddleware.Handler(http.HandlerFunc(postHandler.PostRevisions)))
+	mux.Handle("/forum/api/posts/revisions/diff/", corsMiddleware.Handler(http.HandlerFunc(postHandler.PostRevisionDiff))) // GET {id}?from=&to=&mode=
+	mux.Handle("/forum/api/comments/revisions/", corsMiddleware.Handler(http.HandlerFunc(commentHandler.CommentRevisions)))
+	mux.Handle("/forum/api/comments/revisions/diff/", corsMiddleware.Handler(http.HandlerFunc(commentHandler.CommentRevisionDiff))) // GET {id}?from=&to=&mode=
 
 	// Authentication routes (guest only)
 	guestOnly := func(h http.Handler) http.Handler {
@@ -85,24 +181,76 @@ func SetupRoutes(db *sql.DB) http.Handler {
 
 	// Protected user routes
 	mux.Handle("/forum/api/posts/create", protected(http.HandlerFunc(postHandler.CreatePost)))
-	mux.Handle("/forum/api/posts/delete/", protected(http.HandlerFunc(postHandler.DeletePost)))            // DELETE /forum/api/posts/delete/{id}
-	mux.Handle("/forum/api/posts/edit-title/", protected(http.HandlerFunc(postHandler.EditPostTitle)))     // PUT /forum/api/posts/edit-title/{id}
-	mux.Handle("/forum/api/posts/edit-content/", protected(http.HandlerFunc(postHandler.EditPostContent))) // PUT /forum/api/posts/edit-content/{id}
+	mux.Handle("/forum/api/posts/delete/", protected(http.HandlerFunc(postHandler.DeletePost)))                    // DELETE /forum/api/posts/delete/{id}
+	mux.Handle("/forum/api/posts/edit-title/", protected(http.HandlerFunc(postHandler.EditPostTitle)))             // PUT /forum/api/posts/edit-title/{id}
+	mux.Handle("/forum/api/posts/edit-content/", protected(http.HandlerFunc(postHandler.EditPostContent)))         // PUT /forum/api/posts/edit-content/{id}
+	mux.Handle("/forum/api/posts/subscription/", protected(http.HandlerFunc(subscriptionHandler.GetSubscription))) // GET /forum/api/posts/subscription/{id}
+	mux.Handle("/forum/api/posts/subscribe/", protected(http.HandlerFunc(subscriptionHandler.Subscribe)))          // POST /forum/api/posts/subscribe/{id}
+	mux.Handle("/forum/api/posts/unsubscribe/", protected(http.HandlerFunc(subscriptionHandler.Unsubscribe)))      // POST /forum/api/posts/unsubscribe/{id}
+	mux.Handle("/forum/api/posts/mute/", protected(http.HandlerFunc(subscriptionHandler.Mute)))                    // POST /forum/api/posts/mute/{id}
 	mux.Handle("/forum/api/user/posts", protected(http.HandlerFunc(myPostsHandler.GetMyPosts)))
 	mux.Handle("/forum/api/user/liked", protected(http.HandlerFunc(likedPostsHandler.GetLikedPosts)))
 	mux.Handle("/forum/api/user/disliked", protected(http.HandlerFunc(likedPostsHandler.GetDislikedPosts)))
 	mux.Handle("/forum/api/comments/create", protected(http.HandlerFunc(commentHandler.CreateComment)))
 	mux.Handle("/forum/api/comments/edit/", protected(http.HandlerFunc(commentHandler.EditComment)))     // PUT /forum/api/comments/edit/{id}
 	mux.Handle("/forum/api/comments/delete/", protected(http.HandlerFunc(commentHandler.DeleteComment))) // DELETE /forum/api/comments/delete/{id}
+	mux.Handle("/forum/api/posts/revisions/restore/", protected(http.HandlerFunc(postHandler.RestorePostRevision)))
+	mux.Handle("/forum/api/comments/revisions/restore/", protected(http.HandlerFunc(commentHandler.RestoreCommentRevision)))
+
+	// Drafts and scheduled posts, seen by their author alone
+	mux.Handle("/forum/api/drafts", protected(http.HandlerFunc(postHandler.Drafts)))                // GET
+	mux.Handle("/forum/api/drafts/create", protected(http.HandlerFunc(postHandler.CreateDraft)))    // POST {"category_ids", "title", "content", "publish_at"?}
+	mux.Handle("/forum/api/drafts/update/", protected(http.HandlerFunc(postHandler.UpdateDraft)))   // PUT /forum/api/drafts/update/{id}
+	mux.Handle("/forum/api/drafts/publish/", protected(http.HandlerFunc(postHandler.PublishDraft))) // POST /forum/api/drafts/publish/{id}
+
 	mux.Handle("/forum/api/react", protected(http.HandlerFunc(reactionHandler.CreateReact)))
 	mux.Handle("/forum/api/images/upload", protected(http.HandlerFunc(imageHandler.Upload)))
 	mux.Handle("/forum/api/user/commented", protected(http.HandlerFunc(myPostsHandler.GetCommentedPosts)))
 	mux.Handle("/forum/api/images/delete/", protected(http.HandlerFunc(imageHandler.DeleteImagesByPost))) // DELETE /forum/api/images/delete/{post_id}
 
+	// Gallery images, one at a time
+	mux.Handle("/forum/api/images/remove/", protected(http.HandlerFunc(imageHandler.DeleteImage)))    // DELETE /forum/api/images/remove/{image_id}
+	mux.Handle("/forum/api/images/edit/", protected(http.HandlerFunc(imageHandler.EditImage)))        // PUT /forum/api/images/edit/{image_id} {"caption", "alt_text"}
+	mux.Handle("/forum/api/images/reorder/", protected(http.HandlerFunc(imageHandler.ReorderImages))) // PUT /forum/api/images/reorder/{post_id} {"image_ids"}
+
 	// Notification routes
 	mux.Handle("/forum/api/user/notifications", protected(http.HandlerFunc(notificationHandler.GetUserNotifications)))
-	mux.Handle("/forum/api/notifications/read/", protected(http.HandlerFunc(notificationHandler.MarkRead))) // POST /forum/api/notifications/read/{id}
+	mux.Handle("/forum/api/user/notifications/stream", protected(http.HandlerFunc(notificationHandler.Stream))) // GET, text/event-stream
+	mux.Handle("/forum/api/notifications/read/", protected(http.HandlerFunc(notificationHandler.MarkRead)))     // POST /forum/api/notifications/read/{id}
 	mux.Handle("/forum/api/notifications/read-all", protected(http.HandlerFunc(notificationHandler.MarkAllRead)))
+	mux.Handle("/forum/api/user/notifications/unread-count", protected(http.HandlerFunc(notificationHandler.UnreadCount)))         // GET
+	mux.Handle("/forum/api/notifications/delete/", protected(http.HandlerFunc(notificationHandler.Delete)))                        // DELETE /forum/api/notifications/delete/{id}
+	mux.Handle("/forum/api/notifications/delete-all", protected(http.HandlerFunc(notificationHandler.DeleteAll)))                  // DELETE
+	mux.Handle("/forum/api/notifications/bulk", protected(http.HandlerFunc(notificationHandler.Bulk)))                             // POST {"action","ids"}
+	mux.Handle("/forum/api/notifications/snooze/", protected(http.HandlerFunc(notificationHandler.Snooze)))                        // POST /forum/api/notifications/snooze/{id} {"until"}
+	mux.Handle("/forum/api/notifications/unsnooze/", protected(http.HandlerFunc(notificationHandler.Unsnooze)))                    // POST /forum/api/notifications/unsnooze/{id}
+	mux.Handle("/forum/api/user/notification-preferences", protected(http.HandlerFunc(notificationPreferenceHandler.Preferences))) // GET, PUT
+	mux.Handle("/forum/api/user/locale", protected(http.HandlerFunc(localeHandler.Locale)))                                        // GET, PUT {"locale"}
+
+	// Web Push routes
+	mux.Handle("/forum/api/user/push/vapid-key", protected(http.HandlerFunc(pushHandler.VAPIDKey)))      // GET
+	mux.Handle("/forum/api/user/push/subscribe", protected(http.HandlerFunc(pushHandler.Subscribe)))     // POST PushSubscription JSON
+	mux.Handle("/forum/api/user/push/unsubscribe", protected(http.HandlerFunc(pushHandler.Unsubscribe))) // POST {"endpoint"}
+
+	// Webhook routes
+	mux.Handle("/forum/api/user/webhooks", protected(http.HandlerFunc(webhookHandler.Webhooks)))               // GET, POST
+	mux.Handle("/forum/api/user/webhooks/", protected(http.HandlerFunc(webhookHandler.Webhook)))               // GET, PUT, DELETE /forum/api/user/webhooks/{id}
+	mux.Handle("/forum/api/user/webhooks/deliveries/", protected(http.HandlerFunc(webhookHandler.Deliveries))) // GET /forum/api/user/webhooks/deliveries/{id}
+
+	// Admin routes; admins are listed in ADMIN_USERS
+	admins := middleware.AdminsFromEnv()
+	admin := func(h http.Handler) http.Handler {
+		return protected(admins.Require(h))
+	}
+	mux.Handle("/forum/api/admin/notifications/retention/preview", admin(http.HandlerFunc(retentionHandler.Preview))) // GET
+	mux.Handle("/forum/api/admin/notifications/retention/run", admin(http.HandlerFunc(retentionHandler.Run)))         // POST
+	mux.Handle("/forum/api/admin/notifications/retention/runs", admin(http.HandlerFunc(retentionHandler.Runs)))       // GET
+	mux.Handle("/forum/api/admin/images/gc/preview", admin(http.HandlerFunc(imageGCHandler.Preview)))                 // GET
+	mux.Handle("/forum/api/admin/images/gc/run", admin(http.HandlerFunc(imageGCHandler.Run)))                         // POST ?dry_run=true
+
+	// WebSocket: same session cookie as other protected routes; the CSRF token
+	// is passed as ?csrf_token= because the handshake cannot carry headers
+	mux.Handle("/forum/api/ws", corsMiddleware.Handler(authMiddleware.RequireAuth(authMiddleware.WebSocketCSRF(http.HandlerFunc(liveHandler.ServeWS)))))
 
 	// Additional protected routes for user management
 	mux.Handle("/forum/api/user/profile", protected(http.HandlerFunc(authHandler.GetProfile)))
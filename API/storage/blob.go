@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get when no file is stored under a key
+var ErrNotFound = errors.New("no file is stored under this key")
+
+// Blob is a place files are kept, addressed by slash separated keys such as
+// images/ab/cd/abcd....png. Drivers must let several API processes share
+// one Blob, and must make a file appear whole or not at all.
+type Blob interface {
+	// Put stores data under key, replacing any file already there
+	Put(key string, data []byte) error
+	// Get opens the file under key, or returns ErrNotFound
+	Get(key string) (io.ReadCloser, error)
+	Exists(key string) (bool, error)
+	// Delete removes the file under key. A file that is already gone is not
+	// an error.
+	Delete(key string) error
+	// Walk calls fn for every file whose key starts with prefix
+	Walk(prefix string, fn func(File) error) error
+	// String says where the files are, for logs
+	String() string
+}
+
+// Signer is a Blob that can hand out time-limited URLs, so that browsers
+// read files straight from it rather than through the API
+type Signer interface {
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// File is one file found by Walk
+type File struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// Config selects and sets up the Blob uploads are kept in, read from the
+// environment (see utils.LoadEnv)
+type Config struct {
+	Backend  string // STORAGE_BACKEND, "local" (default) or "s3"
+	LocalDir string // STORAGE_LOCAL_DIR for the local backend, default "uploads"
+	S3       S3Config
+
+	// SignedURLTTL, from STORAGE_SIGNED_URL_TTL, has /static/ redirect
+	// reads to URLs signed for this long instead of serving them through
+	// the API. 0, the default, serves them; only the s3 backend signs.
+	SignedURLTTL time.Duration
+}
+
+// ConfigFromEnv builds a Config from the environment
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Backend:  envString("STORAGE_BACKEND", "local"),
+		LocalDir: envString("STORAGE_LOCAL_DIR", "uploads"),
+		S3:       S3ConfigFromEnv(),
+	}
+	if d, err := time.ParseDuration(os.Getenv("STORAGE_SIGNED_URL_TTL")); err == nil && d > 0 {
+		cfg.SignedURLTTL = d
+	}
+	return cfg
+}
+
+// Open returns the Blob the config selects
+func (c Config) Open() (Blob, error) {
+	switch strings.ToLower(c.Backend) {
+	case "", "local":
+		return NewLocal(c.LocalDir), nil
+	case "s3":
+		return NewS3(c.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", c.Backend)
+	}
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPutGetDelete(t *testing.T) {
+	root := t.TempDir()
+	l := NewLocal(root)
+	key := "images/ab/cd/abcd.png"
+
+	if err := l.Put(key, []byte("one")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := l.Put(key, []byte("two")); err != nil {
+		t.Fatalf("Put over an existing file: %v", err)
+	}
+	r, err := l.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := readAll(t, r); string(got) != "two" {
+		t.Errorf("Get = %q, want the last Put", got)
+	}
+	if ok, err := l.Exists(key); err != nil || !ok {
+		t.Errorf("Exists = %v, %v; want true", ok, err)
+	}
+	if ok, _ := l.Exists("images/ab"); ok {
+		t.Error("a directory counts as a file")
+	}
+	if _, err := l.Get("images/ab"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a directory: err = %v, want ErrNotFound", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "images", "ab", "cd"))
+	if len(entries) != 1 {
+		t.Errorf("Put left %d files behind, want only the file itself", len(entries))
+	}
+
+	if err := l.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := l.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := l.Delete(key); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+}
+
+func TestLocalKeysStayInRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "uploads")
+	l := NewLocal(root)
+	if err := l.Put("../../escaped.txt", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); err == nil {
+		t.Fatal("a key with .. wrote outside the root")
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err != nil {
+		t.Errorf("the key was not kept inside the root: %v", err)
+	}
+}
+
+func TestLocalWalk(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	want := []string{"images/00/01/a.png", "images/00/02/b.png", "images/ff/ee/c.webp"}
+	for _, key := range append(want, "imagesque/x.png", "other/y.png") {
+		if err := l.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	err := l.Walk("images/", func(f File) error {
+		if f.Size != int64(len(f.Key)) || f.ModifiedAt.IsZero() {
+			t.Errorf("%s: Size %d, ModifiedAt %s", f.Key, f.Size, f.ModifiedAt)
+		}
+		got = append(got, f.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Walk found %v, want %v", got, want)
+	}
+	if err := NewLocal(filepath.Join(t.TempDir(), "missing")).Walk("images/", func(File) error { return nil }); err != nil {
+		t.Errorf("Walk of a missing root: %v", err)
+	}
+}
+
+func TestStorePutDedups(t *testing.T) {
+	blob := NewLocal(t.TempDir())
+	s := NewStore(blob)
+	key, err := s.Put([]byte("same"), ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, ImagesDir+"/") || key != Key([]byte("same"), ".png") {
+		t.Errorf("Put stored under %s, want %s", key, Key([]byte("same"), ".png"))
+	}
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || !strings.HasPrefix(parts[3], parts[1]+parts[2]) {
+		t.Errorf("key %s is not fanned out by its hash", key)
+	}
+	info, _ := os.Stat(blob.path(key))
+	again, err := s.Put([]byte("same"), ".png")
+	if err != nil || again != key {
+		t.Fatalf("second Put = %s, %v", again, err)
+	}
+	if info2, _ := os.Stat(blob.path(key)); !info2.ModTime().Equal(info.ModTime()) {
+		t.Error("second Put of the same content rewrote the file")
+	}
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxSignedURLExpiry is the longest a SigV4 presigned URL may live
+const maxSignedURLExpiry = 7 * 24 * time.Hour
+
+// S3Config holds the settings of an S3-compatible bucket, read from the
+// environment (see utils.LoadEnv)
+type S3Config struct {
+	Endpoint        string        // S3_ENDPOINT such as http://localhost:9000, default https://s3.<region>.amazonaws.com
+	PublicEndpoint  string        // S3_PUBLIC_ENDPOINT, the endpoint as browsers reach it, for signed URLs; default Endpoint
+	Region          string        // S3_REGION, default us-east-1
+	Bucket          string        // S3_BUCKET, required
+	Prefix          string        // S3_PREFIX put before every key, such as "forum/"; default none
+	AccessKeyID     string        // S3_ACCESS_KEY_ID, required
+	SecretAccessKey string        // S3_SECRET_ACCESS_KEY, required
+	Timeout         time.Duration // per request, 30s
+
+	// PathStyle puts the bucket in the path rather than the host name, as
+	// MinIO and most stand-ins expect (S3_PATH_STYLE). It defaults to true
+	// when S3_ENDPOINT is set and false for AWS.
+	PathStyle bool
+}
+
+// S3ConfigFromEnv builds an S3Config from the environment
+func S3ConfigFromEnv() S3Config {
+	cfg := S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		PublicEndpoint:  os.Getenv("S3_PUBLIC_ENDPOINT"),
+		Region:          envString("S3_REGION", "us-east-1"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Prefix:          os.Getenv("S3_PREFIX"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Timeout:         30 * time.Second,
+		PathStyle:       os.Getenv("S3_ENDPOINT") != "",
+	}
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		cfg.PathStyle = v == "true"
+	}
+	return cfg
+}
+
+// S3 keeps files in a bucket of S3 or a service speaking its API, signing
+// every request with SigV4. Each object is written whole by a single PUT.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	public   *url.URL
+	signer   signer
+	client   *http.Client
+}
+
+// NewS3 checks cfg and returns a driver for its bucket. It does not contact
+// the bucket.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3_BUCKET is required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.PublicEndpoint == "" {
+		cfg.PublicEndpoint = cfg.Endpoint
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	endpoint, err := parseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("S3_ENDPOINT: %w", err)
+	}
+	public, err := parseEndpoint(cfg.PublicEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("S3_PUBLIC_ENDPOINT: %w", err)
+	}
+	return &S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		public:   public,
+		signer:   signer{accessKey: cfg.AccessKeyID, secretKey: cfg.SecretAccessKey, region: cfg.Region, service: "s3"},
+		client:   &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+func parseEndpoint(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http or https URL", raw)
+	}
+	return u, nil
+}
+
+// Put uploads data with the content type its extension implies
+func (s *S3) Put(key string, data []byte) error {
+	sum := sha256.Sum256(data)
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(s.endpoint, key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	resp, err := s.do(req, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(req, resp)
+	}
+	return nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(s.endpoint, key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(req, resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Exists(key string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(s.endpoint, key).String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, s3Error(req, resp)
+	}
+}
+
+func (s *S3) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(s.endpoint, key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return s3Error(req, resp)
+	}
+}
+
+// listResult is the part of a ListObjectsV2 answer Walk reads
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// Walk lists the bucket a page at a time with ListObjectsV2
+func (s *S3) Walk(prefix string, fn func(File) error) error {
+	token := ""
+	for {
+		u := s.objectURL(s.endpoint, "")
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(query)
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := s.do(req, emptyPayload)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(req, resp)
+			resp.Body.Close()
+			return err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3 list: %w", err)
+		}
+		for _, obj := range page.Contents {
+			if err := fn(File{Key: strings.TrimPrefix(obj.Key, s.cfg.Prefix), Size: obj.Size, ModifiedAt: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// SignedURL returns a URL on the public endpoint that reads the file under
+// key until expiry, at most 7 days, has passed
+func (s *S3) SignedURL(key string, expiry time.Duration) (string, error) {
+	if expiry <= 0 || expiry > maxSignedURLExpiry {
+		return "", fmt.Errorf("signed URLs must expire within %s", maxSignedURLExpiry)
+	}
+	return s.signer.presign(http.MethodGet, s.objectURL(s.public, key), expiry, time.Now()).String(), nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + s.cfg.Bucket + "/" + s.cfg.Prefix
+}
+
+// objectURL is where the object under key lives on endpoint; an empty key
+// gives the bucket itself
+func (s *S3) objectURL(endpoint *url.URL, key string) *url.URL {
+	u := *endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		p += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	p += "/"
+	if key != "" {
+		p += s.cfg.Prefix + strings.TrimPrefix(key, "/")
+	}
+	u.Path = p
+	u.RawPath = uriEncode(p, false)
+	return &u
+}
+
+// do signs req and sends it
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.signer.signRequest(req, payloadHash, time.Now())
+	return s.client.Do(req)
+}
+
+// s3Error describes a failed request, with the code and message S3 put in
+// the body when it sent one
+func s3Error(req *http.Request, resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	detail := ""
+	if data, err := io.ReadAll(io.LimitReader(resp.Body, 4096)); err == nil && xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		detail = ": " + body.Code + " " + body.Message
+	}
+	return fmt.Errorf("s3 %s %s: %s%s", req.Method, req.URL.Path, resp.Status, detail)
+}
@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBucket    = "forum-test"
+	testAccessKey = "forum"
+	testSecretKey = "forumsecret"
+	testPageSize  = 2
+)
+
+// fakeS3 is a path-style bucket in memory. It checks every request's SigV4
+// signature, in the Authorization header or presigned query, by signing
+// what it received again.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	lists   int // ListObjectsV2 pages served
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	f := &fakeS3{objects: make(map[string]fakeObject)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if code := f.verify(r, body); code != "" {
+		f.fail(w, http.StatusForbidden, code)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify returns the S3 error code for a request whose signature does not
+// hold, or "" when it does
+func (f *fakeS3) verify(r *http.Request, body []byte) string {
+	s := signer{accessKey: testAccessKey, secretKey: testSecretKey, region: "us-east-1", service: "s3"}
+	query := r.URL.Query()
+	if sig := query.Get("X-Amz-Signature"); sig != "" {
+		at, err := time.Parse(sigTimeFormat, query.Get("X-Amz-Date"))
+		if err != nil {
+			return "AuthorizationQueryParametersError"
+		}
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || time.Now().After(at.Add(time.Duration(expires)*time.Second)) {
+			return "AccessDenied"
+		}
+		query.Del("X-Amz-Signature")
+		u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: query.Encode()}
+		if s.presign(r.Method, &u, time.Duration(expires)*time.Second, at).Query().Get("X-Amz-Signature") != sig {
+			return "SignatureDoesNotMatch"
+		}
+		return ""
+	}
+
+	at, err := time.Parse(sigTimeFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "AccessDenied"
+	}
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if sum := sha256.Sum256(body); payload != hex.EncodeToString(sum[:]) {
+		return "XAmzContentSHA256Mismatch"
+	}
+	again, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return "InvalidURI"
+	}
+	s.signRequest(again, payload, at)
+	if again.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+// list answers ListObjectsV2 a page of testPageSize keys at a time; the
+// continuation token is the last key of the previous page
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	f.lists++
+	prefix, after := query.Get("prefix"), query.Get("continuation-token")
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}
+	if len(keys) > testPageSize {
+		keys = keys[:testPageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{Key: key, Size: len(f.objects[key].data), LastModified: "2024-05-01T10:00:00.000Z"})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3(t *testing.T, endpoint string) *S3 {
+	t.Helper()
+	s, err := NewS3(S3Config{
+		Endpoint:        endpoint,
+		Bucket:          testBucket,
+		Prefix:          "forum/",
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: testSecretKey,
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func readAll(t *testing.T, r io.ReadCloser) []byte {
+	t.Helper()
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func getStatus(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestS3PutGetDelete(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s := newTestS3(t, srv.URL)
+	key := "images/ab/cd/a b+c=é.png"
+	data := []byte("\x89PNG not really")
+
+	if err := s.Put(key, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	obj, ok := fake.objects["forum/"+key]
+	if !ok {
+		t.Fatalf("object not stored under the prefixed key; have %v", fake.objects)
+	}
+	if obj.contentType != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", obj.contentType)
+	}
+	if ok, err := s.Exists(key); err != nil || !ok {
+		t.Errorf("Exists = %v, %v; want true", ok, err)
+	}
+	r, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := readAll(t, r); !bytes.Equal(got, data) {
+		t.Errorf("Get = %q, want %q", got, data)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if ok, err := s.Exists(key); err != nil || ok {
+		t.Errorf("Exists after Delete = %v, %v; want false", ok, err)
+	}
+	if err := s.Delete(key); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+}
+
+func TestS3Walk(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s := newTestS3(t, srv.URL)
+	want := []string{"images/00/01/a.png", "images/00/01/b.png", "images/ff/ee/c.webp", "images/ff/ee/d.jpg", "images/ff/ff/e.gif"}
+	for _, key := range append(want, "other/x.txt") {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.objects["elsewhere/images/z.png"] = fakeObject{data: []byte("z")}
+
+	var got []string
+	err := s.Walk("images/", func(f File) error {
+		if f.Size != int64(len(f.Key)) {
+			t.Errorf("%s: Size = %d, want %d", f.Key, f.Size, len(f.Key))
+		}
+		if f.ModifiedAt.IsZero() {
+			t.Errorf("%s: no ModifiedAt", f.Key)
+		}
+		got = append(got, f.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Walk found %v, want %v", got, want)
+	}
+	if fake.lists != 3 {
+		t.Errorf("Walk read %d pages, want 3", fake.lists)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	if err := s.Walk("images/", func(File) error { n++; return stop }); err != stop || n != 1 {
+		t.Errorf("Walk did not stop at the first error: err = %v after %d files", err, n)
+	}
+}
+
+func TestS3SignedURL(t *testing.T) {
+	_, srv := newFakeS3(t)
+	s := newTestS3(t, srv.URL)
+	key := "images/ab/cd/photo (1).jpg"
+	if err := s.Put(key, []byte("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := s.SignedURL(key, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET signed URL: %s", resp.Status)
+	}
+	if got := readAll(t, resp.Body); string(got) != "jpeg" {
+		t.Errorf("signed URL served %q", got)
+	}
+
+	// Another key under the same signature is refused
+	u, _ := url.Parse(signed)
+	u.Path = strings.Replace(u.Path, "photo", "other", 1)
+	u.RawPath = ""
+	if status := getStatus(t, u.String()); status != http.StatusForbidden {
+		t.Errorf("tampered signed URL: %d, want 403", status)
+	}
+
+	// So is one whose time is up
+	expired := s.signer.presign(http.MethodGet, s.objectURL(s.public, key), time.Hour, time.Now().Add(-2*time.Hour))
+	if status := getStatus(t, expired.String()); status != http.StatusForbidden {
+		t.Errorf("expired signed URL: %d, want 403", status)
+	}
+
+	for _, expiry := range []time.Duration{0, -time.Minute, maxSignedURLExpiry + time.Second} {
+		if _, err := s.SignedURL(key, expiry); err == nil {
+			t.Errorf("SignedURL accepted an expiry of %s", expiry)
+		}
+	}
+}
+
+func TestS3SignedURLUsesPublicEndpoint(t *testing.T) {
+	s, err := NewS3(S3Config{
+		Endpoint:        "http://minio:9000",
+		PublicEndpoint:  "https://files.forum.test/store/",
+		Bucket:          testBucket,
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: testSecretKey,
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := s.SignedURL("images/a.png", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "https" || u.Host != "files.forum.test" || u.Path != "/store/"+testBucket+"/images/a.png" {
+		t.Errorf("signed URL = %s, want it on the public endpoint", signed)
+	}
+}
+
+func TestS3RejectedSignature(t *testing.T) {
+	_, srv := newFakeS3(t)
+	s, err := NewS3(S3Config{
+		Endpoint:        srv.URL,
+		Bucket:          testBucket,
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: "wrong",
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put("images/a.png", []byte("x"))
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Put with a wrong secret: err = %v, want SignatureDoesNotMatch", err)
+	}
+	if _, err := s.Get("images/a.png"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get with a wrong secret: err = %v, want a 403", err)
+	}
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	virtual, err := NewS3(S3Config{Region: "eu-west-3", Bucket: "pics", Prefix: "forum/", AccessKeyID: "k", SecretAccessKey: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := virtual.objectURL(virtual.endpoint, "images/a b.png").String(), "https://pics.s3.eu-west-3.amazonaws.com/forum/images/a%20b.png"; got != want {
+		t.Errorf("virtual-hosted URL = %s, want %s", got, want)
+	}
+	if got, want := virtual.objectURL(virtual.endpoint, "").String(), "https://pics.s3.eu-west-3.amazonaws.com/"; got != want {
+		t.Errorf("bucket URL = %s, want %s", got, want)
+	}
+
+	for _, cfg := range []S3Config{
+		{AccessKeyID: "k", SecretAccessKey: "s"},
+		{Bucket: "b", AccessKeyID: "k"},
+		{Bucket: "b", AccessKeyID: "k", SecretAccessKey: "s", Endpoint: "ftp://host"},
+	} {
+		if _, err := NewS3(cfg); err == nil {
+			t.Errorf("NewS3(%+v) accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Package storage keeps uploaded files. Files are addressed by the SHA-256 of
+// their content, so identical files are stored once whoever uploads them,
+// in a Blob: a local directory or an S3-compatible bucket.
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+)
+
+// ImagesDir is the directory under the root that holds image files, and the
+// first element of every image key
+const ImagesDir = "images"
+
+// Store keeps content-addressed files in a Blob. Keys are the paths served
+// under /static/ and stored in the database.
+type Store struct {
+	Blob Blob
+}
+
+func NewStore(blob Blob) *Store {
+	return &Store{Blob: blob}
+}
+
+// Key returns where data with the given extension is stored:
+// images/ab/cd/abcd....ext, fanned out by the first bytes of its hash
+func Key(data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	return path.Join(ImagesDir, hash[:2], hash[2:4], hash+ext)
+}
+
+// Put stores data under its key unless a file is already there, and returns
+// the key
+func (s *Store) Put(data []byte, ext string) (string, error) {
+	key := Key(data, ext)
+	if ok, err := s.Blob.Exists(key); err != nil {
+		return "", err
+	} else if ok {
+		return key, nil
+	}
+	if err := s.Blob.Put(key, data); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Exists reports whether a file is stored under key
+func (s *Store) Exists(key string) (bool, error) {
+	return s.Blob.Exists(key)
+}
+
+// Delete removes the file under key. A file that is already gone is not an
+// error.
+func (s *Store) Delete(key string) error {
+	return s.Blob.Delete(key)
+}
+
+// Walk calls fn for every file under the images directory
+func (s *Store) Walk(fn func(File) error) error {
+	return s.Blob.Walk(ImagesDir+"/", fn)
+}
+
+func (s *Store) String() string {
+	return s.Blob.String()
+}
@@ -0,0 +1,50 @@
+package utils
+
+import "unicode/utf16"
+
+// MentionToken is an @username found in text. Start and End count UTF-16
+// code units, the same as JavaScript string indexes, and cover the "@".
+type MentionToken struct {
+	Username string
+	Start    int
+	End      int
+}
+
+// ParseMentions finds @username tokens in content. A token must start the
+// text or follow a character that cannot be part of a username or an email
+// address, and its name follows the UsernameRegex rules.
+func ParseMentions(content string) []MentionToken {
+	var tokens []MentionToken
+	runes := []rune(content)
+	pos := 0 // UTF-16 offset of runes[i]
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '@' && (i == 0 || !isMentionBoundary(runes[i-1])) {
+			j := i + 1
+			for j < len(runes) && isUsernameRune(runes[j]) {
+				j++
+			}
+			name := string(runes[i+1 : j])
+			if UsernameRegex.MatchString(name) {
+				// usernames are ASCII, so each rune is one UTF-16 unit
+				tokens = append(tokens, MentionToken{Username: name, Start: pos, End: pos + 1 + len(name)})
+				pos += 1 + len(name)
+				i = j - 1
+				continue
+			}
+		}
+		pos += utf16.RuneLen(r)
+	}
+	return tokens
+}
+
+// isMentionBoundary reports whether r directly before "@" stops a mention,
+// as in "bob@example.com" or "@@bob"
+func isMentionBoundary(r rune) bool {
+	return isUsernameRune(r) || r == '@' || r == '.'
+}
+
+func isUsernameRune(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+}
+
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrPrivateAddress is returned when an outgoing request would reach a
+// loopback, private or link-local address
+var ErrPrivateAddress = errors.New("target resolves to a private address")
+
+// PublicDialControl is a net.Dialer Control hook that refuses connections to
+// loopback, private, link-local and multicast addresses. It runs after DNS
+// resolution, so user-supplied URLs (webhooks, push endpoints) cannot reach
+// services inside the network through a hostname either.
+func PublicDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return ErrPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds webhook delivery settings, read from the environment (see
+// utils.LoadEnv)
+type Config struct {
+	MaxAttempts int           // WEBHOOK_MAX_ATTEMPTS, default 6
+	RetryDelay  time.Duration // WEBHOOK_RETRY_DELAY, first backoff step, default 10s; doubles per attempt
+	Timeout     time.Duration // WEBHOOK_TIMEOUT, per request, default 10s
+	Workers     int           // WEBHOOK_WORKERS, default 4
+
+	// AllowPrivate lets webhooks target loopback and private addresses
+	// (WEBHOOK_ALLOW_PRIVATE=true). Leave it off in production so users
+	// cannot point webhooks at services inside the network.
+	AllowPrivate bool
+}
+
+// ConfigFromEnv builds a Config from the environment
+func ConfigFromEnv() Config {
+	cfg := Config{
+		MaxAttempts:  envInt("WEBHOOK_MAX_ATTEMPTS", 6),
+		RetryDelay:   envDuration("WEBHOOK_RETRY_DELAY", 10*time.Second),
+		Timeout:      envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		Workers:      envInt("WEBHOOK_WORKERS", 4),
+		AllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	return cfg
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"fmt"
+	"time"
+
+	"forum/models"
+	"forum/repository/notification"
+	"forum/utils"
+)
+
+// Envelope is the JSON body of every webhook request. ID identifies the
+// event; retries of the same event keep it, so receivers can deduplicate.
+type Envelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Author is the user behind an event. Email addresses are never sent.
+type Author struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// PostData is the payload of post.created
+type PostData struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content"`
+	CategoryIDs []int     `json:"category_ids"`
+	Author      Author    `json:"author"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CommentData is the payload of comment.created
+type CommentData struct {
+	ID        string    `json:"id"`
+	PostID    string    `json:"post_id"`
+	Content   string    `json:"content"`
+	Author    Author    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReactionData is the payload of reaction.created. CommentID is set for
+// reactions on a comment.
+type ReactionData struct {
+	TargetType string `json:"target_type"`
+	PostID     string `json:"post_id"`
+	CommentID  string `json:"comment_id,omitempty"`
+	Reaction   string `json:"reaction"`
+	User       Author `json:"user"`
+}
+
+// UserData is the payload of user.registered
+type UserData struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PostLookup loads a post
+type PostLookup interface {
+	GetByID(id string) (*models.Post, error)
+}
+
+// CommentLookup loads a comment
+type CommentLookup interface {
+	GetByID(id string) (*models.Comment, error)
+}
+
+// envelope builds the payload for a dispatcher event. It returns nil for
+// events that are not forwarded to webhooks.
+func (p *Publisher) envelope(e notification.Event) (*Envelope, error) {
+	env := &Envelope{ID: utils.GenerateUUID(), CreatedAt: time.Now().UTC()}
+	switch e := e.(type) {
+	case notification.PostCreated:
+		post, err := p.posts.GetByID(e.PostID)
+		if err != nil {
+			return nil, fmt.Errorf("load post: %w", err)
+		}
+		author, err := p.author(post.UserID)
+		if err != nil {
+			return nil, err
+		}
+		env.Event = models.WebhookPostCreated
+		env.Data = PostData{
+			ID:          post.ID,
+			Title:       utils.DerefString(post.Title),
+			Content:     utils.DerefString(post.Content),
+			CategoryIDs: post.CategoryIDs,
+			Author:      author,
+			CreatedAt:   post.CreatedAt,
+		}
+	case notification.CommentCreated:
+		comment, err := p.comments.GetByID(e.CommentID)
+		if err != nil {
+			return nil, fmt.Errorf("load comment: %w", err)
+		}
+		author, err := p.author(comment.UserID)
+		if err != nil {
+			return nil, err
+		}
+		env.Event = models.WebhookCommentCreated
+		env.Data = CommentData{
+			ID:        comment.ID,
+			PostID:    comment.PostID,
+			Content:   utils.DerefString(comment.Content),
+			Author:    author,
+			CreatedAt: comment.CreatedAt,
+		}
+	case notification.PostReacted:
+		user, err := p.author(e.ActorID)
+		if err != nil {
+			return nil, err
+		}
+		env.Event = models.WebhookReactionCreated
+		env.Data = ReactionData{TargetType: "post", PostID: e.PostID, Reaction: reactionName(e.ReactionType), User: user}
+	case notification.CommentReacted:
+		user, err := p.author(e.ActorID)
+		if err != nil {
+			return nil, err
+		}
+		env.Event = models.WebhookReactionCreated
+		env.Data = ReactionData{TargetType: "comment", PostID: e.PostID, CommentID: e.CommentID, Reaction: reactionName(e.ReactionType), User: user}
+	case notification.UserRegistered:
+		user, err := p.users.GetByID(e.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("load user: %w", err)
+		}
+		env.Event = models.WebhookUserRegistered
+		env.Data = UserData{ID: user.ID, Username: user.Username, CreatedAt: user.CreatedAt}
+	default:
+		return nil, nil
+	}
+	return env, nil
+}
+
+func (p *Publisher) author(userID string) (Author, error) {
+	user, err := p.users.GetByID(userID)
+	if err != nil {
+		return Author{}, fmt.Errorf("load user: %w", err)
+	}
+	return Author{ID: user.ID, Username: user.Username}, nil
+}
+
+func reactionName(reactionType int) string {
+	if reactionType == 2 {
+		return "dislike"
+	}
+	return "like"
+}
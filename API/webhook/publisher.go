@@ -0,0 +1,225 @@
+package webhook
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"forum/models"
+	"forum/repository"
+	"forum/repository/notification"
+)
+
+// queueSize bounds events waiting to be turned into deliveries
+const queueSize = 256
+
+// Publisher forwards dispatcher events to the webhooks subscribed to them.
+// It is registered as a notification.Hook. Each delivery is logged in
+// webhook_deliveries and retried with exponential backoff until it succeeds
+// or runs out of attempts; pending retries are picked up again on restart.
+type Publisher struct {
+	cfg      Config
+	repo     *repository.WebhookRepository
+	posts    PostLookup
+	comments CommentLookup
+	users    notification.UserLookup
+	client   *http.Client
+
+	events   chan notification.Event
+	attempts chan models.WebhookDelivery
+	done     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// NewPublisher starts the delivery workers and reschedules deliveries left
+// pending by a previous run
+func NewPublisher(cfg Config, repo *repository.WebhookRepository, posts PostLookup, comments CommentLookup, users notification.UserLookup) *Publisher {
+	p := &Publisher{
+		cfg:      cfg,
+		repo:     repo,
+		posts:    posts,
+		comments: comments,
+		users:    users,
+		client:   newClient(cfg),
+		events:   make(chan notification.Event, queueSize),
+		attempts: make(chan models.WebhookDelivery),
+		done:     make(chan struct{}),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	p.resume()
+	return p
+}
+
+// Observe queues an event without blocking the dispatcher
+func (p *Publisher) Observe(e notification.Event) {
+	select {
+	case <-p.done:
+	case p.events <- e:
+	default:
+		log.Printf("Webhook [WARN]: queue full, dropping %T", e)
+	}
+}
+
+// Close stops the workers. Deliveries waiting for a retry stay pending in
+// the database and are resumed by the next Publisher.
+func (p *Publisher) Close() {
+	p.once.Do(func() { close(p.done) })
+	p.wg.Wait()
+}
+
+func (p *Publisher) worker() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.done:
+			return
+		case e := <-p.events:
+			p.publish(e)
+		case d := <-p.attempts:
+			p.attempt(d)
+		}
+	}
+}
+
+// publish logs a pending delivery for every webhook subscribed to the event
+// and schedules the first attempts
+func (p *Publisher) publish(e notification.Event) {
+	env, err := p.envelope(e)
+	if err != nil {
+		log.Printf("Webhook [ERROR]: build payload for %T: %v", e, err)
+		return
+	}
+	if env == nil {
+		return
+	}
+	hooks, err := p.repo.Subscribed(env.Event)
+	if err != nil {
+		log.Printf("Webhook [ERROR]: list webhooks for %s: %v", env.Event, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Webhook [ERROR]: encode %s: %v", env.Event, err)
+		return
+	}
+
+	now := time.Now()
+	for _, hook := range hooks {
+		d := models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventID:       env.ID,
+			Event:         env.Event,
+			Payload:       string(payload),
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := p.repo.CreateDelivery(&d); err != nil {
+			log.Printf("Webhook [ERROR]: log delivery of %s to %s: %v", env.Event, hook.ID, err)
+			continue
+		}
+		p.schedule(d, 0)
+	}
+}
+
+// attempt sends a delivery once and records the outcome, scheduling the
+// next attempt when it failed and attempts remain
+func (p *Publisher) attempt(d models.WebhookDelivery) {
+	hook, err := p.repo.GetByID(d.WebhookID)
+	if err == repository.ErrWebhookNotFound {
+		// Deleted since; its delivery log went with it
+		return
+	}
+	if err != nil {
+		log.Printf("Webhook [ERROR]: load webhook %s: %v", d.WebhookID, err)
+		p.schedule(d, p.cfg.RetryDelay)
+		return
+	}
+
+	now := time.Now()
+	if !hook.Active {
+		msg := "webhook disabled"
+		d.Status, d.LastError, d.NextAttemptAt = models.WebhookDeliveryFailed, &msg, nil
+		p.save(d)
+		return
+	}
+
+	d.Attempts++
+	status, sendErr := p.post(hook, &d)
+	if status != 0 {
+		d.ResponseStatus = &status
+	} else {
+		d.ResponseStatus = nil
+	}
+	switch {
+	case sendErr == nil:
+		d.Status, d.LastError, d.NextAttemptAt, d.DeliveredAt = models.WebhookDeliverySucceeded, nil, nil, &now
+	case d.Attempts >= p.cfg.MaxAttempts:
+		msg := sendErr.Error()
+		d.Status, d.LastError, d.NextAttemptAt = models.WebhookDeliveryFailed, &msg, nil
+		log.Printf("Webhook [ERROR]: %s to %s failed after %d attempts: %v", d.Event, hook.URL, d.Attempts, sendErr)
+	default:
+		msg := sendErr.Error()
+		delay := p.backoff(d.Attempts)
+		next := now.Add(delay)
+		d.LastError, d.NextAttemptAt = &msg, &next
+		log.Printf("Webhook [WARN]: %s to %s attempt %d failed, retrying in %s: %v", d.Event, hook.URL, d.Attempts, delay, sendErr)
+	}
+	p.save(d)
+	if d.Status == models.WebhookDeliveryPending {
+		p.schedule(d, time.Until(*d.NextAttemptAt))
+	}
+}
+
+func (p *Publisher) save(d models.WebhookDelivery) {
+	if err := p.repo.UpdateDelivery(&d); err != nil {
+		log.Printf("Webhook [ERROR]: record delivery %s: %v", d.ID, err)
+	}
+}
+
+// backoff is the wait after the given number of failed attempts:
+// RetryDelay, then doubling
+func (p *Publisher) backoff(attempts int) time.Duration {
+	return p.cfg.RetryDelay << (attempts - 1)
+}
+
+// schedule hands a delivery to a worker after the delay. Timers never block
+// a worker, and ones that fire after Close leave the row pending.
+func (p *Publisher) schedule(d models.WebhookDelivery, delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case <-p.done:
+		case p.attempts <- d:
+		}
+	})
+}
+
+// resume reschedules deliveries a previous run left pending
+func (p *Publisher) resume() {
+	pending, err := p.repo.PendingDeliveries()
+	if err != nil {
+		log.Printf("Webhook [ERROR]: load pending deliveries: %v", err)
+		return
+	}
+	for _, d := range pending {
+		delay := time.Duration(0)
+		if d.NextAttemptAt != nil {
+			delay = time.Until(*d.NextAttemptAt)
+		}
+		p.schedule(d, delay)
+	}
+	if len(pending) > 0 {
+		log.Printf("Webhook [INFO]: resumed %d pending deliveries", len(pending))
+	}
+}
@@ -74,6 +74,10 @@ func router(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		http.ServeFile(w, r, "./static/templates/register.html")
+	case "/sw.js":
+		// Served from the root so the service worker's scope is the whole site
+		w.Header().Set("Content-Type", "text/javascript")
+		http.ServeFile(w, r, "./static/js/sw.js")
 	case "/guest":
 		http.ServeFile(w, r, "./static/templates/guest/guest_mainpage.html")
 	case "/guest/feed":
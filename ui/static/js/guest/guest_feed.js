@@ -1,28 +1,32 @@
-const feedURL = 'http://localhost:8080/forum/api/feed';
+const feedURL = 'http://localhost:8080/forum/api/feed/posts';
 
-async function loadFeed() {
+const feedPageSize = 20;
+
+let feedSort = 'newest';
+let nextCursor = '';
+
+// loadFeed fetches one page of the feed; more=true appends the next page
+async function loadFeed(more = false) {
+  const params = new URLSearchParams({ sort: feedSort, limit: feedPageSize });
+  if (more && nextCursor) params.set('cursor', nextCursor);
   try {
-    const resp = await fetch(feedURL, { credentials: 'include' });
+    const resp = await fetch(`${feedURL}?${params}`, { credentials: 'include' });
     if (!resp.ok) throw new Error('Failed to load feed');
 
     const data = await resp.json();
-
-    renderFeed(data.categories || []);
+    nextCursor = data.next_cursor || '';
+    renderFeed(data.posts || [], more);
+    document.getElementById('load-more').hidden = !data.has_more;
   } catch (err) {
     console.error('Error loading feed:', err);
   }
 }
 
-
-
-function renderFeed(categories) {
+function renderFeed(posts, append) {
   const container = document.getElementById('forumContainer');
-  container.innerHTML = '';
-
-  // Merge posts from categories to avoid duplicates & collect categories per post
-  const posts = mergePostsFromCategories(categories);
+  if (!append) container.innerHTML = '';
 
-  if (posts.length === 0) {
+  if (posts.length === 0 && !append) {
     container.textContent = 'No posts available';
     return;
   }
@@ -64,15 +68,11 @@ function renderFeed(categories) {
     }
 
     // Reactions count
-    const likes = post.reactions?.filter(r => r.reaction_type === 1).length || 0;
-    const dislikes = post.reactions?.filter(r => r.reaction_type === 2).length || 0;
-
-    postNode.querySelector('.like-count').textContent = likes;
-    postNode.querySelector('.dislike-count').textContent = dislikes;
+    postNode.querySelector('.like-count').textContent = post.like_count || 0;
+    postNode.querySelector('.dislike-count').textContent = post.dislike_count || 0;
 
     // Comments
-    const commentCount =
-      post.comment_count || (post.comments ? post.comments.length : 0);
+    const commentCount = post.comment_count || 0;
     const commentContainer = document.createElement("span");
     commentContainer.className = "comment-count";
     commentContainer.innerHTML = `💬 ${commentCount}`;
@@ -115,33 +115,11 @@ function renderFeed(categories) {
 
 
 
-window.addEventListener('DOMContentLoaded', loadFeed);
-
-
-function mergePostsFromCategories(categories) {
-  const postsMap = new Map();
-
-  categories.forEach(category => {
-    const categoryId = category.id || category.ID;
-    const categoryName = category.name || category.Name;
-
-    category.posts.forEach(post => {
-      const postId = post.id || post.ID;
-      if (!postsMap.has(postId)) {
-        // Copy post and initialize categories array
-        postsMap.set(postId, {
-          ...post,
-          categories: [{ id: categoryId, name: categoryName }],
-        });
-      } else {
-        // Append category if not already added
-        const existingPost = postsMap.get(postId);
-        if (!existingPost.categories.some(c => c.id === categoryId)) {
-          existingPost.categories.push({ id: categoryId, name: categoryName });
-        }
-      }
-    });
+window.addEventListener('DOMContentLoaded', () => {
+  document.getElementById('feed-sort').addEventListener('change', e => {
+    feedSort = e.target.value;
+    loadFeed();
   });
-
-  return Array.from(postsMap.values());
-}
+  document.getElementById('load-more').addEventListener('click', () => loadFeed(true));
+  loadFeed();
+});
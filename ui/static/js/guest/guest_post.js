@@ -42,16 +42,17 @@ function renderSinglePost(post) {
   meta.className = 'post-meta';
   meta.textContent = `By ${post.username || post.user_id || 'Unknown'} on ${new Date(post.created_at).toLocaleString()}`;
 
+  // The post's images, in the order its author gave them
   let imageEl = null;
-  if (post.image_url) {
-    imageEl = document.createElement('img');
-    imageEl.src = post.image_url;
-    imageEl.className = 'post-image';
+  if (post.images?.length) {
+    imageEl = document.createElement('div');
+    imageEl.className = 'post-gallery';
+    post.images.forEach(image => imageEl.appendChild(galleryFigure(image)));
   }
 
   const content = document.createElement('div');
-  content.className = 'post-content';
-  content.textContent = post.content || '';
+  content.className = 'post-content markdown';
+  content.innerHTML = post.content_html || ''; // rendered and sanitized by the API
 
   const reactions = document.createElement('div');
   reactions.className = 'post-reactions';
@@ -99,6 +100,10 @@ function renderSinglePost(post) {
     post.comments.forEach(comment => {
       const commentEl = document.createElement('div');
       commentEl.className = 'comment';
+      if (comment.depth > 0) {
+        commentEl.classList.add('reply');
+        commentEl.style.setProperty('--depth', comment.depth);
+      }
       // Removed hardcoded border-top, padding-top, margin-top to let CSS handle it
       // commentEl.style.borderTop = '1px solid #ccc';
       // commentEl.style.paddingTop = '0.5rem';
@@ -115,7 +120,13 @@ function renderSinglePost(post) {
       // commentTime.style.color = '#666'; // Handled by CSS (var(--text-muted))
 
       const commentContent = document.createElement('div');
-      commentContent.textContent = comment.content || '';
+      commentContent.className = 'markdown';
+      commentContent.innerHTML = comment.content_html || ''; // rendered and sanitized by the API
+      if (comment.deleted) {
+        // Kept so its replies still read in context
+        commentContent.className = 'deleted-comment';
+        commentContent.textContent = 'This comment was deleted';
+      }
       // Color handled by .comment p (if you add a p tag) or by .comment itself in post.css (var(--text-secondary))
       // commentContent.style.margin = '0.25rem 0'; // Handled by CSS
 
@@ -156,6 +167,46 @@ function renderSinglePost(post) {
   container.appendChild(commentSection);
 }
 
+// galleryFigure shows one gallery image with its alt text and caption
+function galleryFigure(image) {
+  const figure = document.createElement('figure');
+  figure.className = 'gallery-item';
+  figure.appendChild(galleryPicture(image));
+  if (image.caption) {
+    const caption = document.createElement('figcaption');
+    caption.textContent = image.caption;
+    figure.appendChild(caption);
+  }
+  return figure;
+}
+
+// galleryPicture picks the smallest variant that fills the gallery cell,
+// preferring WebP where the browser takes it
+function galleryPicture(image) {
+  const img = document.createElement('img');
+  img.src = image.url;
+  img.alt = image.alt_text || '';
+  img.className = 'post-image';
+  if (image.width && image.height) {
+    img.width = image.width;
+    img.height = image.height;
+  }
+  const sizes = '(max-width: 600px) 100vw, 400px';
+  if (image.srcset) {
+    img.srcset = image.srcset;
+    img.sizes = sizes;
+  }
+  if (!image.srcset_webp) return img;
+  const picture = document.createElement('picture');
+  const source = document.createElement('source');
+  source.type = 'image/webp';
+  source.srcset = image.srcset_webp;
+  source.sizes = sizes;
+  picture.appendChild(source);
+  picture.appendChild(img);
+  return picture;
+}
+
 function mergePostsFromCategories(categories) {
   const postsMap = new Map();
 
@@ -0,0 +1,26 @@
+// Service worker for Web Push. It is served from /sw.js so its scope covers
+// the whole site and it can show notifications while no forum tab is open.
+
+self.addEventListener('push', (event) => {
+  let data = {};
+  try { data = event.data ? event.data.json() : {}; } catch (err) { console.error(err); }
+  const title = data.title || 'Forum';
+  event.waitUntil(self.registration.showNotification(title, {
+    body: data.body || 'You have a new notification',
+    tag: data.tag || data.id,
+    renotify: !!data.tag,
+    data: { url: data.url || '/user/feed' },
+  }));
+});
+
+self.addEventListener('notificationclick', (event) => {
+  event.notification.close();
+  const url = event.notification.data && event.notification.data.url;
+  event.waitUntil((async () => {
+    const tabs = await self.clients.matchAll({ type: 'window', includeUncontrolled: true });
+    for (const tab of tabs) {
+      if (tab.url === url && 'focus' in tab) return tab.focus();
+    }
+    return self.clients.openWindow(url || '/user/feed');
+  })());
+});
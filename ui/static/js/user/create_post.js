@@ -206,6 +206,9 @@ window.addEventListener("keydown", (e) => {
   }
 });
 
+// Draft saved by a submit whose image upload failed; see the submit handler
+let pendingDraftId = null;
+
 function clearModalInputs() {
   titleInput.value = "";
   contentInput.value = "";
@@ -251,9 +254,15 @@ submitPostBtn.addEventListener("click", async () => {
   submitPostBtn.disabled = true;
   submitPostBtn.textContent = "Submitting...";
 
+  // The post is saved as a draft, gets its image, and only then is published,
+  // so it never goes live (or notifies anyone) without its gallery. A draft
+  // left by a failed upload is reused when the user submits again.
   try {
-    const resp = await fetch("http://localhost:8080/forum/api/posts/create", {
-      method: "POST",
+    const draftURL = pendingDraftId
+      ? `http://localhost:8080/forum/api/drafts/update/${pendingDraftId}`
+      : "http://localhost:8080/forum/api/drafts/create";
+    const resp = await fetch(draftURL, {
+      method: pendingDraftId ? "PUT" : "POST",
       credentials: "include",
       headers: {
         "Content-Type": "application/json",
@@ -277,11 +286,12 @@ submitPostBtn.addEventListener("click", async () => {
       return;
     }
 
-    const createdPost = await resp.json();
+    const draft = await resp.json();
+    pendingDraftId = draft.id || draft.ID;
 
     if (imageInput.files.length > 0) {
       const formData = new FormData();
-      formData.append("post_id", createdPost.id || createdPost.ID);
+      formData.append("post_id", pendingDraftId);
       formData.append("image", imageInput.files[0]);
 
       const imgResp = await fetch(
@@ -299,10 +309,36 @@ submitPostBtn.addEventListener("click", async () => {
       if (!imgResp.ok) {
         const errImg = await imgResp.json().catch(() => ({}));
         console.error("Image upload failed:", errImg);
-        alert("Image upload failed");
+        alert(
+          "Image upload failed: " +
+            (errImg.message || `Status: ${imgResp.status}`) +
+            ". Your post has not been published yet.",
+        );
+        return;
       }
     }
 
+    const pubResp = await fetch(
+      `http://localhost:8080/forum/api/drafts/publish/${pendingDraftId}`,
+      {
+        method: "POST",
+        credentials: "include",
+        headers: {
+          "X-CSRF-Token": csrfTokenFromResponse,
+        },
+      },
+    );
+    if (!pubResp.ok) {
+      const errPub = await pubResp.json().catch(() => ({}));
+      console.error("Publish failed:", errPub);
+      alert(
+        "Error publishing post: " +
+          (errPub.message || `Status: ${pubResp.status} - ${pubResp.statusText}`),
+      );
+      return;
+    }
+    pendingDraftId = null;
+
     modal.classList.add("hidden");
     clearModalInputs();
     location.reload();
@@ -5,6 +5,7 @@ const badge = document.getElementById('notif-count');
 const markAllBtn = document.getElementById('mark-all-read');
 const clearAllBtn = document.getElementById('clear-all');
 const closeBtn = document.getElementById('close-notifications');
+const pushBtn = document.getElementById('push-toggle');
 
 // Hold CSRF token for requests to the API
 let csrfToken = null;
@@ -28,20 +29,119 @@ async function loadCSRFToken() {
 
 async function loadNotifications() {
   try {
-    const resp = await fetch('http://localhost:8080/forum/api/user/notifications', { credentials: 'include' });
+    const resp = await fetch('http://localhost:8080/forum/api/user/notifications?limit=50', { credentials: 'include' });
     if (!resp.ok) throw new Error('failed');
-    const data = await resp.json();
+    const page = await resp.json();
+    const data = page.notifications || [];
     render(data);
-    const unreadCount = data.filter(n => !n.read_at && n.message).length;
-    const hasUnread = unreadCount > 0;
-    bell.classList.toggle('lit', hasUnread);
-    if (badge) {
-      badge.textContent = unreadCount;
-      badge.classList.toggle('hidden', !hasUnread);
-    }
   } catch(e) { console.error(e); }
+  await loadUnread();
+}
+
+// The badge shows the server's count; the list is only the first page
+async function loadUnread() {
+  try {
+    const resp = await fetch('http://localhost:8080/forum/api/user/notifications/unread-count', { credentials: 'include' });
+    if (!resp.ok) throw new Error('failed');
+    setUnread((await resp.json()).unread);
+  } catch(e) { console.error(e); }
+}
+
+function setUnread(unreadCount) {
+  const hasUnread = unreadCount > 0;
+  bell.classList.toggle('lit', hasUnread);
+  if (badge) {
+    badge.textContent = unreadCount;
+    badge.classList.toggle('hidden', !hasUnread);
+  }
+}
+
+// Live updates pushed by the API. EventSource reconnects on its own and sends
+// Last-Event-ID so missed events are replayed.
+function connectStream() {
+  if (!window.EventSource) return;
+  const source = new EventSource('http://localhost:8080/forum/api/user/notifications/stream', { withCredentials: true });
+  source.addEventListener('notification', loadNotifications);
+  source.addEventListener('notification_removed', loadNotifications);
+  source.addEventListener('resync', loadNotifications);
+  source.addEventListener('unread', (e) => {
+    try { setUnread(JSON.parse(e.data).unread); } catch (err) { console.error(err); }
+  });
+}
+
+// Web Push: shown only when the browser supports it and the API has a VAPID
+// key. The subscription is re-sent on every load because it belongs to the
+// session, and a new login starts a new session.
+const pushURL = 'http://localhost:8080/forum/api/user/push';
+
+function base64ToBytes(b64) {
+  const padded = (b64 + '='.repeat((4 - b64.length % 4) % 4)).replace(/-/g, '+').replace(/_/g, '/');
+  return Uint8Array.from(atob(padded), c => c.charCodeAt(0));
+}
+
+async function pushSubscription() {
+  const reg = await navigator.serviceWorker.register('/sw.js');
+  return { reg, sub: await reg.pushManager.getSubscription() };
 }
 
+async function sendPushSubscription(action, body) {
+  const token = await loadCSRFToken();
+  const resp = await fetch(`${pushURL}/${action}`, {
+    method: 'POST',
+    credentials: 'include',
+    headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': token || '' },
+    body: JSON.stringify(body),
+  });
+  if (!resp.ok && resp.status !== 404) throw new Error(`push ${action} failed`);
+}
+
+function setPushLabel(enabled) {
+  pushBtn.textContent = enabled ? 'Disable push' : 'Enable push';
+  pushBtn.dataset.enabled = enabled ? '1' : '';
+}
+
+async function initPush() {
+  if (!pushBtn || !('serviceWorker' in navigator) || !('PushManager' in window)) return;
+  try {
+    const resp = await fetch(`${pushURL}/vapid-key`, { credentials: 'include' });
+    if (!resp.ok) return;
+    const { public_key: key } = await resp.json();
+    pushBtn.dataset.key = key;
+    pushBtn.classList.remove('hidden');
+
+    const { sub } = await pushSubscription();
+    if (sub && Notification.permission === 'granted') {
+      await sendPushSubscription('subscribe', sub.toJSON());
+      setPushLabel(true);
+    } else {
+      setPushLabel(false);
+    }
+  } catch (err) { console.warn('Push unavailable', err); }
+}
+
+async function togglePush() {
+  try {
+    const { reg, sub } = await pushSubscription();
+    if (pushBtn.dataset.enabled) {
+      if (sub) {
+        await sendPushSubscription('unsubscribe', { endpoint: sub.endpoint });
+        await sub.unsubscribe();
+      }
+      setPushLabel(false);
+      return;
+    }
+    if (await Notification.requestPermission() !== 'granted') return;
+    const created = sub || await reg.pushManager.subscribe({
+      userVisibleOnly: true,
+      applicationServerKey: base64ToBytes(pushBtn.dataset.key),
+    });
+    await sendPushSubscription('subscribe', created.toJSON());
+    setPushLabel(true);
+  } catch (err) { console.error(err); }
+}
+
+pushBtn?.addEventListener('click', togglePush);
+
 function render(nots) {
   list.innerHTML = '';
   const valid = nots.filter(n => n.message);
@@ -88,6 +188,10 @@ function getIcon(type) {
       return '❌';
     case 'reaction':
       return '👍';
+    case 'reply':
+      return '↩️';
+    case 'quote':
+      return '❝';
     default:
       return '🔔';
   }
@@ -140,4 +244,8 @@ clearAllBtn?.addEventListener('click', async () => {
   }
 });
 
-window.addEventListener('DOMContentLoaded', loadNotifications);
+window.addEventListener('DOMContentLoaded', () => {
+  loadNotifications();
+  connectStream();
+  initPush();
+});
@@ -122,21 +122,21 @@ function renderSinglePostWithEdit(post) {
     };
     if (isDeleted) contentEditBtn.style.display = 'none';
 
-          let imageEl = null;
-     if (post.image_url) {
-       imageEl = document.createElement('img');
-       imageEl.src = post.image_url;
-       imageEl.className = 'post-image';
-     }
-
-     // Add edit button for image
-     const imageEditBtn = document.createElement('button');
-     imageEditBtn.textContent = 'Edit Image';
-     imageEditBtn.className = 'edit-btn';
-     imageEditBtn.onclick = () => {
-         showEditImage();
-     };
-     if (isDeleted || !post.image_url) imageEditBtn.style.display = 'none';
+    // The post's images, in the order its author gave them
+    let imageEl = null;
+    if (post.images?.length) {
+        imageEl = document.createElement('div');
+        imageEl.className = 'post-gallery';
+        post.images.forEach(image => imageEl.appendChild(galleryFigure(image, !isDeleted && isOwner)));
+    }
+
+    // Add button for another gallery image
+    const imageEditBtn = document.createElement('button');
+    imageEditBtn.textContent = 'Add Image';
+    imageEditBtn.className = 'edit-btn image-add-btn';
+    imageEditBtn.onclick = () => {
+        showEditImage();
+    };
 
     // Wrap post content in a card
     const postContentCard = document.createElement('div');
@@ -323,7 +323,7 @@ function renderSinglePostWithEdit(post) {
     if (!isDeleted && isOwner) postBox.appendChild(titleEditBtn);
     postBox.appendChild(meta);
     if (imageEl) postBox.appendChild(imageEl);
-    if (imageEl && !isDeleted && isOwner) postBox.appendChild(imageEditBtn);
+    if (!isDeleted && isOwner) postBox.appendChild(imageEditBtn);
     if (!isDeleted) {
         postBox.appendChild(postContentCard);
         if (isOwner) postBox.appendChild(contentEditBtn);
@@ -565,9 +565,9 @@ async function saveContent() {
 }
 
 function showEditImage() {
-    // Find the image element and add upload interface right after it
-    const imageElement = document.querySelector('.post-image');
-    if (imageElement) {
+    // Add the upload interface right after the Add Image button
+    const imageElement = document.querySelector('.image-add-btn');
+    if (imageElement && !document.querySelector('.image-upload-interface')) {
         // Create upload interface
         const uploadContainer = document.createElement('div');
         uploadContainer.className = 'image-upload-interface';
@@ -580,6 +580,8 @@ function showEditImage() {
             <div id="imageStatus" class="image-status hidden"></div>
             <div id="imageError" class="image-error"></div>
             <img id="imagePreview" class="image-preview hidden" alt="Image preview" style="max-width: 150px; max-height: 150px; object-fit: cover;" />
+            <input type="text" id="imageCaption" placeholder="Caption (optional)" maxlength="300" />
+            <input type="text" id="imageAltText" placeholder="Describe the image for screen readers (optional)" maxlength="300" />
             <button type="button" id="uploadImageBtn" class="upload-btn hidden" disabled>Upload Image</button>
         `;
 
@@ -682,6 +684,8 @@ function showEditImage() {
                 const formData = new FormData();
                 formData.append('post_id', postId);
                 formData.append('image', imageInput.files[0]);
+                formData.append('caption', document.getElementById('imageCaption').value);
+                formData.append('alt_text', document.getElementById('imageAltText').value);
                 
                 const resp = await fetch('http://localhost:8080/forum/api/images/upload', {
                     method: 'POST',
@@ -708,6 +712,64 @@ function showEditImage() {
     }
 }
 
+// galleryFigure shows one gallery image with its alt text and caption. The
+// owner gets a button to remove it from the gallery.
+function galleryFigure(image, removable) {
+    const figure = document.createElement('figure');
+    figure.className = 'gallery-item';
+    figure.appendChild(galleryPicture(image));
+    if (image.caption) {
+        const caption = document.createElement('figcaption');
+        caption.textContent = image.caption;
+        figure.appendChild(caption);
+    }
+    if (removable) {
+        const removeBtn = document.createElement('button');
+        removeBtn.textContent = 'Remove Image';
+        removeBtn.className = 'edit-btn';
+        removeBtn.onclick = () => removeImage(image.id);
+        figure.appendChild(removeBtn);
+    }
+    return figure;
+}
+
+// galleryPicture picks the smallest variant that fills the gallery cell,
+// preferring WebP where the browser takes it
+function galleryPicture(image) {
+    const img = document.createElement('img');
+    img.src = image.url;
+    img.alt = image.alt_text || '';
+    img.className = 'post-image';
+    if (image.width && image.height) {
+        img.width = image.width;
+        img.height = image.height;
+    }
+    const sizes = '(max-width: 600px) 100vw, 400px';
+    if (image.srcset) {
+        img.srcset = image.srcset;
+        img.sizes = sizes;
+    }
+    if (!image.srcset_webp) return img;
+    const picture = document.createElement('picture');
+    const source = document.createElement('source');
+    source.type = 'image/webp';
+    source.srcset = image.srcset_webp;
+    source.sizes = sizes;
+    picture.appendChild(source);
+    picture.appendChild(img);
+    return picture;
+}
+
+async function removeImage(imageId) {
+    if (!confirm('Remove this image from the post?')) return;
+    await fetch(`http://localhost:8080/forum/api/images/remove/${imageId}`, {
+        method: 'DELETE',
+        headers: await getAuthHeaders(),
+        credentials: 'include'
+    });
+    loadPost();
+}
+
 async function deletePost() {
     if (!confirm('Are you sure you want to delete this post?')) return;
     try {
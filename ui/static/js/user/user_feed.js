@@ -1,28 +1,32 @@
-const feedURL = "http://localhost:8080/forum/api/feed";
+const feedURL = "http://localhost:8080/forum/api/feed/posts";
 
-async function loadFeed() {
+const feedPageSize = 20;
+
+let feedSort = "newest";
+let nextCursor = "";
+
+// loadFeed fetches one page of the feed; more=true appends the next page
+async function loadFeed(more = false) {
+  const params = new URLSearchParams({ sort: feedSort, limit: feedPageSize });
+  if (more && nextCursor) params.set("cursor", nextCursor);
   try {
-    const resp = await fetch(feedURL, { credentials: "include" });
+    const resp = await fetch(`${feedURL}?${params}`, { credentials: "include" });
     if (!resp.ok) throw new Error("Failed to load feed");
 
     const data = await resp.json();
-    renderFeed(data.categories || []);
+    nextCursor = data.next_cursor || "";
+    renderFeed(data.posts || [], more);
+    document.getElementById("load-more").hidden = !data.has_more;
   } catch (err) {
     console.error("Error loading feed:", err);
   }
 }
 
-function renderFeed(categories) {
+function renderFeed(posts, append) {
   const container = document.getElementById("forumContainer");
-  container.innerHTML = "";
-
-  const posts = mergePostsFromCategories(categories).sort((a, b) => {
-    const timeA = new Date(a.created_at).getTime();
-    const timeB = new Date(b.created_at).getTime();
-    return timeB - timeA; // descending (newest first)
-  });
+  if (!append) container.innerHTML = "";
 
-  if (posts.length === 0) {
+  if (posts.length === 0 && !append) {
     container.textContent = "No posts available";
     return;
   }
@@ -74,16 +78,11 @@ function renderFeed(categories) {
     }
 
     // Reactions
-    const likes =
-      post.reactions?.filter((r) => r.reaction_type === 1).length || 0;
-    const dislikes =
-      post.reactions?.filter((r) => r.reaction_type === 2).length || 0;
-    postNode.querySelector(".like-count").textContent = likes;
-    postNode.querySelector(".dislike-count").textContent = dislikes;
+    postNode.querySelector(".like-count").textContent = post.like_count || 0;
+    postNode.querySelector(".dislike-count").textContent = post.dislike_count || 0;
 
     // Comments
-    const commentCount =
-      post.comment_count || (post.comments ? post.comments.length : 0);
+    const commentCount = post.comment_count || 0;
     const commentContainer = document.createElement("span");
     commentContainer.className = "comment-count";
     commentContainer.innerHTML = `💬 ${commentCount}`;
@@ -119,32 +118,6 @@ function renderFeed(categories) {
   });
 }
 
-function mergePostsFromCategories(categories) {
-  const postsMap = new Map();
-
-  categories.forEach((category) => {
-    const categoryId = category.id || category.ID;
-    const categoryName = category.name || category.Name;
-
-    category.posts.forEach((post) => {
-      const postId = post.id || post.ID;
-      if (!postsMap.has(postId)) {
-        postsMap.set(postId, {
-          ...post,
-          categories: [{ id: categoryId, name: categoryName }],
-        });
-      } else {
-        const existingPost = postsMap.get(postId);
-        if (!existingPost.categories.some((c) => c.id === categoryId)) {
-          existingPost.categories.push({ id: categoryId, name: categoryName });
-        }
-      }
-    });
-  });
-
-  return Array.from(postsMap.values());
-}
-
 const logoutLink = document.getElementById("logout-link");
 
 if (logoutLink) {
@@ -169,4 +142,11 @@ if (logoutLink) {
   });
 }
 
-window.addEventListener("DOMContentLoaded", loadFeed);
+window.addEventListener("DOMContentLoaded", () => {
+  document.getElementById("feed-sort").addEventListener("change", (e) => {
+    feedSort = e.target.value;
+    loadFeed();
+  });
+  document.getElementById("load-more").addEventListener("click", () => loadFeed(true));
+  loadFeed();
+});
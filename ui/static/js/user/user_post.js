@@ -2,6 +2,13 @@ const params = new URLSearchParams(window.location.search);
 const postId = params.get('id');
 const lastReactions = new Map(); // key = `${type}:${id}`, value = 1 (like) or 2 (dislike)
 
+// The comment being answered and the comments quoted by the one being written;
+// their authors are notified when it is submitted
+const composer = { replyTo: null, quotes: [] };
+// Matches models.MaxCommentDepth in the API; deeper comments cannot be replied to
+const MAX_COMMENT_DEPTH = 4;
+let scrolledToHash = false;
+
 
 const postContainer = document.getElementById('postContainer');
 
@@ -62,6 +69,7 @@ async function loadPost() {
     }
 
     renderSinglePost(post);
+    scrollToLinkedComment();
   } catch (err) {
     console.error(err);
     postContainer.textContent = 'Error loading post.';
@@ -99,13 +107,18 @@ function renderSinglePost(post) {
 
   const content = document.createElement('div');
   content.className = isDeleted ? 'deleted-content' : 'post-content';
-  content.textContent = displayContent;
+  if (isDeleted) {
+    content.textContent = displayContent;
+  } else {
+    renderWithMentions(content, post.content_html || '', post.mentions);
+  }
 
-   let imageEl = null;
-  if (post.image_url) {
-    imageEl = document.createElement('img');
-    imageEl.src = post.image_url;
-    imageEl.className = 'post-image';
+  // The post's images, in the order its author gave them
+  let imageEl = null;
+  if (post.images?.length) {
+    imageEl = document.createElement('div');
+    imageEl.className = 'post-gallery';
+    post.images.forEach(image => imageEl.appendChild(galleryFigure(image)));
   }
 
   // Wrap post content in a card
@@ -209,7 +222,14 @@ function renderSinglePost(post) {
     errorMsg.classList.remove('visible');
   });
 
+  // Shows who is being replied to or quoted, with a way to cancel
+  const composerNote = document.createElement('div');
+  composerNote.id = 'composerNote';
+  composerNote.className = 'comment-reply-note';
+  updateComposerNote(composerNote);
+
   // Insert elements in the form
+  commentForm.appendChild(composerNote);
   commentForm.appendChild(errorMsg);
   commentForm.appendChild(commentTextarea);
   commentForm.appendChild(charCount);
@@ -250,6 +270,8 @@ function renderSinglePost(post) {
         body: JSON.stringify({
           post_id: post.id,
           content,
+          reply_to: composer.replyTo?.id,
+          quotes: composer.quotes.map(c => c.id),
         }),
       });
       if (!resp.ok) {
@@ -259,6 +281,8 @@ function renderSinglePost(post) {
         return;
       }
       commentTextarea.value = '';
+      composer.replyTo = null;
+      composer.quotes = [];
       errorMsg.classList.remove('visible');
       await loadPost();
     } catch (err) {
@@ -305,11 +329,90 @@ function renderSinglePost(post) {
   postContainer.appendChild(postBox);
 }
 
+// Fill el with the rendered Markdown of a post or comment, wrapping @mentions
+// in spans
+function renderWithMentions(el, html, mentions) {
+  el.classList.add('markdown');
+  el.innerHTML = html; // rendered and sanitized by the API
+
+  // Mention offsets point into the Markdown source, so in the HTML they are
+  // found again by name, outside code and links
+  const names = new Set((mentions || []).map(m => m.username.toLowerCase()));
+  if (names.size === 0) return;
+  const walker = document.createTreeWalker(el, NodeFilter.SHOW_TEXT);
+  const nodes = [];
+  while (walker.nextNode()) {
+    if (!walker.currentNode.parentElement.closest('code, a')) nodes.push(walker.currentNode);
+  }
+  nodes.forEach(node => {
+    const parts = node.textContent.split(/(@[A-Za-z0-9_]+)/);
+    if (parts.length === 1) return;
+    const frag = document.createDocumentFragment();
+    parts.forEach(part => {
+      if (part.startsWith('@') && names.has(part.slice(1).toLowerCase())) {
+        const span = document.createElement('span');
+        span.className = 'mention';
+        span.title = part.slice(1);
+        span.textContent = part;
+        frag.appendChild(span);
+      } else if (part) {
+        frag.appendChild(document.createTextNode(part));
+      }
+    });
+    node.replaceWith(frag);
+  });
+}
+
 // Helper: create comment element with reactions
+// galleryFigure shows one gallery image with its alt text and caption
+function galleryFigure(image) {
+  const figure = document.createElement('figure');
+  figure.className = 'gallery-item';
+  figure.appendChild(galleryPicture(image));
+  if (image.caption) {
+    const caption = document.createElement('figcaption');
+    caption.textContent = image.caption;
+    figure.appendChild(caption);
+  }
+  return figure;
+}
+
+// galleryPicture picks the smallest variant that fills the gallery cell,
+// preferring WebP where the browser takes it
+function galleryPicture(image) {
+  const img = document.createElement('img');
+  img.src = image.url;
+  img.alt = image.alt_text || '';
+  img.className = 'post-image';
+  if (image.width && image.height) {
+    img.width = image.width;
+    img.height = image.height;
+  }
+  const sizes = '(max-width: 600px) 100vw, 400px';
+  if (image.srcset) {
+    img.srcset = image.srcset;
+    img.sizes = sizes;
+  }
+  if (!image.srcset_webp) return img;
+  const picture = document.createElement('picture');
+  const source = document.createElement('source');
+  source.type = 'image/webp';
+  source.srcset = image.srcset_webp;
+  source.sizes = sizes;
+  picture.appendChild(source);
+  picture.appendChild(img);
+  return picture;
+}
+
 function createCommentElement(comment, isPostDeleted) {
   // Match guest style: compact, simple, but keep interactive buttons
   const commentEl = document.createElement('div');
   commentEl.className = 'comment';
+  commentEl.id = comment.id; // notifications link here
+  if (comment.depth > 0) {
+    commentEl.classList.add('reply');
+    commentEl.style.setProperty('--depth', comment.depth);
+  }
 
   const commentUser = document.createElement('strong');
   commentUser.textContent = comment.username || comment.user_id || 'Anonymous';
@@ -318,7 +421,13 @@ function createCommentElement(comment, isPostDeleted) {
   commentTime.textContent = ` (${new Date(comment.created_at).toLocaleString()})`;
 
   const commentContent = document.createElement('div');
-  commentContent.textContent = comment.content || '';
+  if (comment.deleted) {
+    // Kept so its replies still read in context
+    commentContent.className = 'deleted-comment';
+    commentContent.textContent = 'This comment was deleted';
+  } else {
+    renderWithMentions(commentContent, comment.content_html || '', comment.mentions);
+  }
 
   // Reactions: visually match guest (inline, compact, no extra box)
   const commentReactions = document.createElement('div');
@@ -347,6 +456,23 @@ function createCommentElement(comment, isPostDeleted) {
   commentReactions.appendChild(likeBtn);
   commentReactions.appendChild(dislikeBtn);
 
+  if (!isPostDeleted && comment.content) {
+    if ((comment.depth || 0) < MAX_COMMENT_DEPTH) {
+      const replyBtn = document.createElement('button');
+      replyBtn.textContent = 'Reply';
+      replyBtn.className = 'reply-btn';
+      replyBtn.addEventListener('click', () => startReply(comment));
+      commentReactions.appendChild(replyBtn);
+    }
+
+    const quoteBtn = document.createElement('button');
+    quoteBtn.textContent = 'Quote';
+    quoteBtn.className = 'quote-btn';
+    quoteBtn.addEventListener('click', () => startQuote(comment));
+
+    commentReactions.appendChild(quoteBtn);
+  }
+
   // Layout: username, time, content, reactions (all compact)
   commentEl.appendChild(commentUser);
   commentEl.appendChild(commentTime);
@@ -356,6 +482,61 @@ function createCommentElement(comment, isPostDeleted) {
   return commentEl;
 }
 
+function commentAuthor(comment) {
+  return comment.username || comment.user_id || 'Anonymous';
+}
+
+function startReply(comment) {
+  composer.replyTo = comment;
+  updateComposerNote();
+  document.querySelector('.comment-textarea')?.focus();
+}
+
+// Quoting copies the comment into the textarea as "> " lines
+function startQuote(comment) {
+  if (!composer.quotes.some(c => c.id === comment.id)) composer.quotes.push(comment);
+  const textarea = document.querySelector('.comment-textarea');
+  if (textarea) {
+    const quoted = comment.content.split('\n').map(line => `> ${line}`).join('\n');
+    textarea.value = `${textarea.value}${textarea.value ? '\n' : ''}${quoted}\n\n`.slice(0, 1000);
+    textarea.dispatchEvent(new Event('input'));
+    textarea.focus();
+  }
+  updateComposerNote();
+}
+
+function updateComposerNote(note = document.getElementById('composerNote')) {
+  if (!note) return;
+  note.textContent = '';
+  const parts = [];
+  if (composer.replyTo) parts.push(`Replying to ${commentAuthor(composer.replyTo)}`);
+  if (composer.quotes.length) parts.push(`quoting ${composer.quotes.map(commentAuthor).join(', ')}`);
+  note.classList.toggle('hidden', parts.length === 0);
+  if (!parts.length) return;
+  note.appendChild(document.createTextNode(parts.join(', ') + ' '));
+  const cancel = document.createElement('button');
+  cancel.type = 'button';
+  cancel.textContent = '✕';
+  cancel.title = 'Cancel';
+  cancel.addEventListener('click', () => {
+    composer.replyTo = null;
+    composer.quotes = [];
+    updateComposerNote();
+  });
+  note.appendChild(cancel);
+}
+
+// Notifications link to /user/post?id=...#<comment_id>; comments are rendered
+// after load, so scroll to the linked one by hand the first time
+function scrollToLinkedComment() {
+  if (scrolledToHash || !location.hash) return;
+  const el = document.getElementById(decodeURIComponent(location.hash.slice(1)));
+  if (!el) return;
+  scrolledToHash = true;
+  el.classList.add('highlight');
+  el.scrollIntoView({ behavior: 'smooth', block: 'center' });
+}
+
 // Handle like/dislike interaction
 async function handleReaction(targetId, targetType, reactionType, likeBtn, dislikeBtn) {
   const key = `${targetType}:${targetId}`;
@@ -448,5 +629,36 @@ function mergePostsFromCategories(categories) {
   return Array.from(postsMap.values());
 }
 
+// Live updates: re-render whenever someone comments, reacts or edits this post
+const liveEvents = ['post_updated', 'post_deleted', 'comment_created', 'comment_updated', 'comment_deleted', 'reactions_updated', 'resync'];
+// Last event seen, sent on resubscribe so the server replays what we missed
+let lastLiveEventId = 0;
+let liveReconnecting = false;
+
+async function connectLive() {
+  if (!postId || !window.WebSocket) return;
+  const token = csrfTokenFromResponse || await loadCSRFTokenFromSession();
+  if (!token) return;
+  const socket = new WebSocket(`ws://localhost:8080/forum/api/ws?csrf_token=${encodeURIComponent(token)}`);
+  socket.addEventListener('open', () => {
+    const subscribe = { action: 'subscribe', channel: `post:${postId}` };
+    if (lastLiveEventId) subscribe.last_event_id = lastLiveEventId;
+    else if (liveReconnecting) loadPost(); // nothing to resume from
+    socket.send(JSON.stringify(subscribe));
+  });
+  socket.addEventListener('message', (e) => {
+    try {
+      const frame = JSON.parse(e.data);
+      if (frame.id) lastLiveEventId = frame.id;
+      if (liveEvents.includes(frame.type)) loadPost();
+    } catch (err) { console.error(err); }
+  });
+  socket.addEventListener('close', () => {
+    liveReconnecting = true;
+    setTimeout(connectLive, 5000);
+  });
+}
+
 // Initial load
 loadPost();
+connectLive();